@@ -0,0 +1,45 @@
+package gproxy
+
+import (
+	"context"
+	"fmt"
+	"github.com/jiangshuai341/zbus/discovery"
+	"github.com/jiangshuai341/zbus/etcd"
+	"time"
+)
+
+const (
+	DiscoveryEtcd   = "etcd"
+	DiscoveryFile   = "file"
+	DiscoveryMemory = "memory"
+)
+
+type DiscoveryConfig struct {
+	Type      string   `json:"Type"`      // etcd / file / memory 默认etcd
+	AppName   string   `json:"AppName"`   // etcd 发现的范围
+	Endpoints []string `json:"Endpoints"` // etcd 集群地址
+	Path      string   `json:"Path"`      // file 静态服务列表 JSON
+}
+
+// NewDiscovery 按配置创建注册中心后端 memory 只在单进程内可见
+func NewDiscovery(cfg DiscoveryConfig) (discovery.Backend, error) {
+	switch cfg.Type {
+	case DiscoveryEtcd, "":
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		cli, err := etcd.NewClient(ctx, cfg.AppName, cfg.Endpoints)
+		if err != nil {
+			return nil, err
+		}
+		cli.SetRegisterStatusCallback(func(status etcd.RegisterStatus, err error) {
+			log.Infof("[gproxy] etcd register status:%s err:%v", status, err)
+		})
+		return cli, nil
+	case DiscoveryFile:
+		return discovery.NewFile(cfg.Path, 0)
+	case DiscoveryMemory:
+		return discovery.NewMemory().NewClient(), nil
+	default:
+		return nil, fmt.Errorf("unknown discovery type %q", cfg.Type)
+	}
+}
@@ -0,0 +1,388 @@
+package gproxy
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jiangshuai341/zbus/discovery"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/epoll"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zpool/slicepool"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/protocol"
+	"time"
+)
+
+// gproxy集群
+// 每对gproxy之间维持一条连接(link) serviceID较小的一方发起连接 建立后发送 ProxyHello
+// ProxyHello 携带 Config.ProxySecret 只接受服务发现中的gproxy 否则断开连接 见 checkHello
+// 双方先把本地的实体和无状态服务作为全量 RouteDelta 发给对方 之后本地路由变化时增量同步
+// 本地没有路由的请求按学到的路由转发给对应的gproxy 对方只在本地路由 不会再次转发
+// link断开或对方从服务发现中下线时 经它学到的路由全部失效 等待它响应的调用以 ErrCalleeClosed 结束
+// 对方draining时保留link 已有实体的路由不变 只是不再经它路由无状态服务
+
+var (
+	ErrBadRouteDelta = errors.New("gproxy: bad route delta")
+	ErrProxyDial     = errors.New("gproxy: dial proxy failed")
+	ErrProxyHello    = errors.New("gproxy: proxy hello rejected")
+)
+
+const (
+	minLinkBackoff = 100 * time.Millisecond
+	maxLinkBackoff = 5 * time.Second
+)
+
+type deltaOp uint8
+
+const (
+	opEntityAdd deltaOp = iota + 1
+	opEntityDel
+	opServiceAdd
+	opServiceDel
+)
+
+// deltaEntryLen op(1) serviceType(4) entityID(8) 小端
+const deltaEntryLen = 13
+
+type routeDelta struct {
+	op          deltaOp
+	serviceType int32
+	entityID    int64
+}
+
+// encodeDeltas 返回的切片来自 slicepool 发送完成后由连接归还
+func encodeDeltas(deltas []routeDelta) []byte {
+	buf := slicepool.GetBuffer2(len(deltas) * deltaEntryLen)
+	for i, d := range deltas {
+		b := buf[i*deltaEntryLen:]
+		b[0] = byte(d.op)
+		binary.LittleEndian.PutUint32(b[1:], uint32(d.serviceType))
+		binary.LittleEndian.PutUint64(b[5:], uint64(d.entityID))
+	}
+	return buf
+}
+
+func decodeDeltas(body []byte) ([]routeDelta, error) {
+	if len(body)%deltaEntryLen != 0 {
+		return nil, ErrBadRouteDelta
+	}
+	ret := make([]routeDelta, 0, len(body)/deltaEntryLen)
+	for b := body; len(b) > 0; b = b[deltaEntryLen:] {
+		d := routeDelta{
+			op:          deltaOp(b[0]),
+			serviceType: int32(binary.LittleEndian.Uint32(b[1:])),
+			entityID:    int64(binary.LittleEndian.Uint64(b[5:])),
+		}
+		if d.op < opEntityAdd || d.op > opServiceDel {
+			return nil, ErrBadRouteDelta
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+func sendDeltas(link *entity, deltas []routeDelta) {
+	if len(deltas) == 0 {
+		return
+	}
+	h := protocol.Header{Flags: protocol.FlagOneway, Cmd: zrpc.RouteDelta}
+	if err := link.c.SendSafeZeroCopy(protocol.EncodeFrame(h, encodeDeltas(deltas))...); err != nil {
+		log.Warnf("[gproxy] send route delta to %s failed err:%s", link.id, err.Error())
+	}
+}
+
+// announce 本地路由变化 同步给所有link
+func (r *router) announce(deltas ...routeDelta) {
+	if len(deltas) == 0 {
+		return
+	}
+	for _, link := range r.links {
+		sendDeltas(link, deltas)
+	}
+}
+
+// snapshot 本地的全部实体和无状态服务
+func (r *router) snapshot() []routeDelta {
+	ret := make([]routeDelta, 0, len(r.entities)+len(r.services))
+	for key, e := range r.entities {
+		if !e.link {
+			ret = append(ret, routeDelta{opEntityAdd, key.serviceType, key.entityID})
+		}
+	}
+	for serviceType := range r.services {
+		ret = append(ret, routeDelta{opServiceAdd, serviceType, 0})
+	}
+	return ret
+}
+
+// addLink 连接确认为到 proxyID 的link 把本地路由全量发给对方
+func (r *router) addLink(e *entity, proxyID int32) {
+	e.link, e.proxyID = true, proxyID
+	if old, ok := r.links[proxyID]; ok && old != e {
+		log.Warnf("[gproxy] proxy %d linked again by %s, replace %s", proxyID, e.id, old.id)
+	}
+	r.links[proxyID] = e
+	sendDeltas(e, r.snapshot())
+	log.Infof("[gproxy] linked to proxy %d by %s", proxyID, e.id)
+}
+
+// checkHello 在IO线程中执行 任意连接都可以发送 ProxyHello 成为link后能改写集群路由
+// 只接受密钥一致且在服务发现中的gproxy 已经是link或注册过实体、服务的连接不能再成为link
+func (s *Server) checkHello(e *entity, proxyID int32, secret []byte) error {
+	if e.link || len(e.owned) > 0 || len(e.services) > 0 {
+		return fmt.Errorf("%w: connection already in use", ErrProxyHello)
+	}
+	want := s.config.Get().ProxySecret
+	if want == "" || subtle.ConstantTimeCompare([]byte(want), secret) != 1 {
+		return fmt.Errorf("%w: bad secret", ErrProxyHello)
+	}
+	if proxyID == s.serviceID {
+		return fmt.Errorf("%w: proxy %d is self", ErrProxyHello, proxyID)
+	}
+	if _, ok := s.registry.Get(ServiceName, proxyID); !ok {
+		return fmt.Errorf("%w: proxy %d not discovered", ErrProxyHello, proxyID)
+	}
+	return nil
+}
+
+func (r *router) removeLink(e *entity) {
+	if r.links[e.proxyID] == e {
+		delete(r.links, e.proxyID)
+	}
+	log.Infof("[gproxy] link to proxy %d closed, %d routes invalidated", e.proxyID, len(e.owned)+len(e.services))
+}
+
+// applyDeltas 以link为下一跳更新路由
+// 不同gproxy上并发创建了同一实体时 所在gproxy的serviceID较小的拥有者胜出 每个gproxy按同样的规则选择 结果一致
+// 本地拥有者落败时收到 RevokeEntity
+func (r *router) applyDeltas(link *entity, deltas []routeDelta) {
+	for _, d := range deltas {
+		key := routeKey{d.serviceType, d.entityID}
+		switch d.op {
+		case opEntityAdd:
+			if owner, ok := r.entities[key]; ok && owner != link {
+				if !owner.link {
+					if link.proxyID > link.proxy.serviceID {
+						log.Warnf("[gproxy] entity service:%d id:%d also created on proxy %d, keep local owner %s", d.serviceType, d.entityID, link.proxyID, owner.id)
+						continue
+					}
+					log.Warnf("[gproxy] entity service:%d id:%d also created on proxy %d, revoke local owner %s", d.serviceType, d.entityID, link.proxyID, owner.id)
+					revoke(owner, key)
+					r.announce(routeDelta{opEntityDel, d.serviceType, d.entityID})
+				} else if link.proxyID > owner.proxyID {
+					continue
+				}
+				delete(owner.owned, key)
+			}
+			r.entities[key] = link
+			link.owned[key] = struct{}{}
+		case opEntityDel:
+			if r.entities[key] == link {
+				delete(r.entities, key)
+			}
+			delete(link.owned, key)
+		case opServiceAdd:
+			if !r.proxyDraining[link.proxyID] {
+				r.addRemoteService(link, d.serviceType)
+			}
+			link.services[d.serviceType] = struct{}{}
+		case opServiceDel:
+			r.delRemoteService(link, d.serviceType)
+			delete(link.services, d.serviceType)
+		}
+	}
+}
+
+// revoke 通知本地拥有者实体已被收回
+func revoke(owner *entity, key routeKey) {
+	h := protocol.Header{Flags: protocol.FlagOneway, Cmd: zrpc.RevokeEntity, ServiceType: key.serviceType, EntityID: key.entityID}
+	if err := owner.c.SendSafeZeroCopy(protocol.EncodeFrame(h)...); err != nil {
+		log.Warnf("[gproxy] revoke entity service:%d id:%d from %s failed err:%s", key.serviceType, key.entityID, owner.id, err.Error())
+	}
+}
+
+func (r *router) addRemoteService(link *entity, serviceType int32) {
+	ring, ok := r.remoteServices[serviceType]
+	if !ok {
+		ring = newServiceRing(serviceType)
+		r.remoteServices[serviceType] = ring
+	}
+	ring.Add(link)
+}
+
+func (r *router) delRemoteService(link *entity, serviceType int32) {
+	if ring, ok := r.remoteServices[serviceType]; ok {
+		ring.Del(link)
+		if ring.Len() == 0 {
+			delete(r.remoteServices, serviceType)
+		}
+	}
+}
+
+// setProxyServing 对端gproxy的健康状态变化 不是serving时把link移出无状态服务的哈希环 恢复后重新加入
+// 经它学到的实体路由不受影响
+func (r *router) setProxyServing(proxyID int32, serving bool) {
+	if serving != r.proxyDraining[proxyID] {
+		return
+	}
+	if serving {
+		delete(r.proxyDraining, proxyID)
+	} else {
+		r.proxyDraining[proxyID] = true
+	}
+	link, ok := r.links[proxyID]
+	if !ok {
+		return
+	}
+	for serviceType := range link.services {
+		if serving {
+			r.addRemoteService(link, serviceType)
+		} else {
+			r.delRemoteService(link, serviceType)
+		}
+	}
+}
+
+type linkDialer struct {
+	url  string
+	stop chan struct{}
+}
+
+// OnServiceStatusChange 发现其他gproxy时建立link 对方下线时断开
+// 健康状态只影响无状态服务的路由 draining的gproxy仍保留link 直到它从服务发现中删除
+func (s *Server) OnServiceStatusChange(instance *discovery.ServiceInstance, eventType discovery.EventType) {
+	if instance.ServiceName != ServiceName || instance.ServiceID == s.serviceID {
+		return
+	}
+	id := instance.ServiceID
+	switch eventType {
+	case discovery.EventPut:
+		if s.serviceID < id && len(instance.Urls) > 0 {
+			if s.config.Get().ProxySecret == "" {
+				log.Warnf("[gproxy] ProxySecret not configured, skip link to proxy %d", id)
+			} else {
+				s.dialProxy(id, instance.Urls[0])
+			}
+		}
+		serving := instance.Serving()
+		_ = s.reactor.DoTaskInIoThread(func(*epoll.Epoller) {
+			s.router.setProxyServing(id, serving)
+		})
+	case discovery.EventDelete:
+		s.stopDialer(id)
+		_ = s.reactor.DoTaskInIoThread(func(*epoll.Epoller) {
+			delete(s.router.proxyDraining, id)
+			if link, ok := s.router.links[id]; ok {
+				link.c.Close()
+			}
+		})
+	}
+}
+
+func (s *Server) dialProxy(id int32, url string) {
+	s.dialMu.Lock()
+	defer s.dialMu.Unlock()
+	select {
+	case <-s.stop:
+		return
+	default:
+	}
+	if d, ok := s.dialers[id]; ok {
+		if d.url == url {
+			return
+		}
+		close(d.stop)
+	}
+	d := &linkDialer{url: url, stop: make(chan struct{})}
+	s.dialers[id] = d
+	go s.keepLink(id, d)
+}
+
+func (s *Server) stopDialer(id int32) {
+	s.dialMu.Lock()
+	defer s.dialMu.Unlock()
+	if d, ok := s.dialers[id]; ok {
+		close(d.stop)
+		delete(s.dialers, id)
+	}
+}
+
+// keepLink 维持到 id 的link 断开后按退避重连 直到对方下线或本gproxy关闭
+func (s *Server) keepLink(id int32, d *linkDialer) {
+	backoff := minLinkBackoff
+	for {
+		link, closed, err := s.connectProxy(id, d.url)
+		if err == nil {
+			backoff = minLinkBackoff
+			select {
+			case <-closed:
+			case <-d.stop:
+				_ = s.reactor.DoTaskInIoThread(func(*epoll.Epoller) {
+					link.c.Close()
+				})
+				return
+			}
+		} else {
+			log.Warnf("[gproxy] link to proxy %d %s failed, retry after %v err:%s", id, d.url, backoff, err.Error())
+		}
+		select {
+		case <-d.stop:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxLinkBackoff {
+			backoff = maxLinkBackoff
+		}
+	}
+}
+
+// connectProxy 返回的channel在link断开时关闭
+func (s *Server) connectProxy(id int32, url string) (*entity, chan struct{}, error) {
+	conn := reactor.Dial(url)
+	if conn == nil {
+		return nil, nil, ErrProxyDial
+	}
+	closed := make(chan struct{})
+	link := s.newEntity(conn)
+	link.link, link.proxyID = true, id
+	link.onClose = func() {
+		close(closed)
+	}
+	conn.INetHandle = link
+	if err := s.reactor.AddConn(conn); err != nil {
+		return nil, nil, err
+	}
+	err := s.reactor.DoTaskInIoThread(func(*epoll.Epoller) {
+		if link.closed {
+			return
+		}
+		secret := s.config.Get().ProxySecret
+		body := slicepool.GetBuffer2(len(secret))
+		copy(body, secret)
+		h := protocol.Header{Flags: protocol.FlagOneway, Cmd: zrpc.ProxyHello, EntityID: int64(s.serviceID)}
+		if err := conn.SendSafeZeroCopy(protocol.EncodeFrame(h, body)...); err != nil {
+			log.Warnf("[gproxy] hello to proxy %d failed err:%s", id, err.Error())
+		}
+		s.router.addLink(link, id)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return link, closed, nil
+}
+
+// closeLinks 在IO线程中断开所有link
+func (s *Server) closeLinks() {
+	s.dialMu.Lock()
+	for id, d := range s.dialers {
+		close(d.stop)
+		delete(s.dialers, id)
+	}
+	s.dialMu.Unlock()
+	_ = s.reactor.DoTaskInIoThread(func(*epoll.Epoller) {
+		for _, link := range s.router.links {
+			link.c.Close()
+		}
+	})
+}
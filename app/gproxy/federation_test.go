@@ -0,0 +1,371 @@
+package gproxy
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/config"
+	"github.com/jiangshuai341/zbus/discovery"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/client"
+	"github.com/jiangshuai341/zbus/zrpc/protocol"
+	"github.com/jiangshuai341/zbus/zrpc/server"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteDelta_Codec(t *testing.T) {
+	deltas := []routeDelta{
+		{opEntityAdd, 1, 1001},
+		{opEntityDel, -2, -1},
+		{opServiceAdd, 3, 0},
+		{opServiceDel, 3, 0},
+	}
+	got, err := decodeDeltas(encodeDeltas(deltas))
+	if err != nil || !reflect.DeepEqual(got, deltas) {
+		t.Fatalf("got %v %v", got, err)
+	}
+	if _, err = decodeDeltas(make([]byte, deltaEntryLen-1)); err != ErrBadRouteDelta {
+		t.Fatalf("want ErrBadRouteDelta got %v", err)
+	}
+	if _, err = decodeDeltas(make([]byte, deltaEntryLen)); err != ErrBadRouteDelta {
+		t.Fatalf("want ErrBadRouteDelta for op 0 got %v", err)
+	}
+}
+
+const testSecret = "zbus-test"
+
+// startClusterProxy 共享同一个注册中心的gproxy
+func startClusterProxy(t *testing.T, memory *discovery.Memory, id int32) (*Server, *discovery.MemoryClient) {
+	cfg, err := config.New[Config]([]byte(`{"ProxySecret":"` + testSecret + `","ServiceID":` + strconv.Itoa(int(id)) + `}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := memory.NewClient()
+	s := NewServer(cfg, registry)
+	t.Cleanup(func() {
+		s.Close()
+		registry.Close()
+	})
+	return s, registry
+}
+
+func waitFor(t *testing.T, what string, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFederation(t *testing.T) {
+	memory := discovery.NewMemory()
+	proxyA, registryA := startClusterProxy(t, memory, 1)
+	proxyB, _ := startClusterProxy(t, memory, 2)
+	addrA, addrB := proxyA.ListenAddr()[0], proxyB.ListenAddr()[0]
+	r := newReactor(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lobby, whoami := zrpc.ServiceType("Lobby"), zrpc.FuncHash("Lobby", "Whoami")
+	hpChange := zrpc.FuncHash("Lobby", "HpChange")
+	svcA := startService(t, r, addrA, "a")
+	svcB := startService(t, r, addrB, "b")
+	if err := svcA.CreateEntity(ctx, lobby, 1001); err != nil {
+		t.Fatal(err)
+	}
+	callerB, err := client.Dial(r, addrB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 实体在A上 经B调用
+	waitFor(t, "route learned from proxy A", func() bool {
+		resp, err := callerB.RemoteInvokeSync(ctx, lobby, whoami, 1001, nil)
+		return err == nil && string(resp) == "a:1001"
+	})
+	// 实体在集群内只有一个拥有者
+	if err = svcB.CreateEntity(ctx, lobby, 1001); err == nil || !strings.Contains(err.Error(), ErrEntityOwned.Error()) {
+		t.Fatalf("want ErrEntityOwned got %v", err)
+	}
+
+	// 订阅方在B上 实体在A上广播
+	got := make(chan string, 1)
+	_ = callerB.BindDelegate(lobby, hpChange, 1001, func(arg []byte, err error) {
+		if err == nil {
+			got <- string(arg)
+		}
+	})
+	_, _ = callerB.RemoteInvokeSync(ctx, lobby, whoami, 1001, nil)
+	if err = svcA.Broadcast(lobby, hpChange, 1001, []byte("hp=90")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-got:
+		if v != "hp=90" {
+			t.Fatalf("got %q", v)
+		}
+	case <-ctx.Done():
+		t.Fatal("subscriber on proxy B not notified")
+	}
+
+	// 删除实体的增量同步到B
+	if err = svcA.DestroyEntity(ctx, lobby, 1001); err != nil {
+		t.Fatal(err)
+	}
+	var remote *client.RemoteError
+	waitFor(t, "entity removed on proxy B", func() bool {
+		_, err := callerB.RemoteInvokeSync(ctx, lobby, whoami, 1001, nil)
+		return errors.As(err, &remote) && remote.Msg == ErrNoRoute.Error()
+	})
+	if err = svcA.CreateEntity(ctx, lobby, 1001); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "entity recreated on proxy A", func() bool {
+		resp, err := callerB.RemoteInvokeSync(ctx, lobby, whoami, 1001, nil)
+		return err == nil && string(resp) == "a:1001"
+	})
+
+	// 只在A上注册的无状态服务
+	if err = svcA.RegistService(ctx, lobby); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "stateless service learned from proxy A", func() bool {
+		resp, err := callerB.RemoteInvokeSync(ctx, lobby, whoami, 5, nil)
+		return err == nil && string(resp) == "a:5"
+	})
+
+	// A下线 经A学到的路由失效 实体可以在B上重新创建
+	proxyA.Close()
+	registryA.Close()
+	waitFor(t, "routes through proxy A invalidated", func() bool {
+		_, err := callerB.RemoteInvokeSync(ctx, lobby, whoami, 5, nil)
+		return errors.As(err, &remote) && remote.Msg == ErrNoRoute.Error()
+	})
+	if err = svcB.CreateEntity(ctx, lobby, 1001); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := callerB.RemoteInvokeSync(ctx, lobby, whoami, 1001, nil)
+	if err != nil || string(resp) != "b:1001" {
+		t.Fatalf("got %q %v", resp, err)
+	}
+}
+
+// TestFederation_Drain draining的gproxy保留link 经它学到的实体路由不变 不再经它路由无状态服务
+func TestFederation_Drain(t *testing.T) {
+	memory := discovery.NewMemory()
+	proxyA, registryA := startClusterProxy(t, memory, 1)
+	proxyB, _ := startClusterProxy(t, memory, 2)
+	r := newReactor(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lobby, whoami := zrpc.ServiceType("Lobby"), zrpc.FuncHash("Lobby", "Whoami")
+	svcA := startService(t, r, proxyA.ListenAddr()[0], "a")
+	if err := svcA.CreateEntity(ctx, lobby, 1001); err != nil {
+		t.Fatal(err)
+	}
+	if err := svcA.RegistService(ctx, lobby); err != nil {
+		t.Fatal(err)
+	}
+	callerB, err := client.Dial(r, proxyB.ListenAddr()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(callerB.Close)
+	waitFor(t, "routes learned from proxy A", func() bool {
+		entity, err1 := callerB.RemoteInvokeSync(ctx, lobby, whoami, 1001, nil)
+		stateless, err2 := callerB.RemoteInvokeSync(ctx, lobby, whoami, 5, nil)
+		return err1 == nil && string(entity) == "a:1001" && err2 == nil && string(stateless) == "a:5"
+	})
+
+	if err = registryA.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var remote *client.RemoteError
+	waitFor(t, "stateless service through proxy A stopped", func() bool {
+		_, err := callerB.RemoteInvokeSync(ctx, lobby, whoami, 5, nil)
+		return errors.As(err, &remote) && remote.Msg == ErrNoRoute.Error()
+	})
+	for i := 0; i < 10; i++ {
+		resp, err := callerB.RemoteInvokeSync(ctx, lobby, whoami, 1001, nil)
+		if err != nil || string(resp) != "a:1001" {
+			t.Fatalf("entity on draining proxy A got %q %v", resp, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// rawConn 直接收发帧的连接 用于伪造 ProxyHello
+type rawConn struct {
+	t *testing.T
+	net.Conn
+}
+
+func dialRaw(t *testing.T, addr string) *rawConn {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(addr, "tcp://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return &rawConn{t, conn}
+}
+
+// frame 编码后的一帧 多帧一次写入 避免对端断开后写失败
+func frame(h protocol.Header, body []byte) []byte {
+	var ret []byte
+	for _, b := range protocol.EncodeFrame(h, body) {
+		ret = append(ret, b...)
+	}
+	return ret
+}
+
+func (c *rawConn) send(frames ...[]byte) {
+	var buf []byte
+	for _, f := range frames {
+		buf = append(buf, f...)
+	}
+	if _, err := c.Write(buf); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// closedByPeer 对端断开连接前收到的数据全部丢弃
+func (c *rawConn) closedByPeer() bool {
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err := io.Copy(io.Discard, c)
+	return err == nil
+}
+
+// TestFederation_ForgedHello 伪造的 ProxyHello 被拒绝并断开 不能接管路由
+func TestFederation_ForgedHello(t *testing.T) {
+	memory := discovery.NewMemory()
+	proxyA, _ := startClusterProxy(t, memory, 1)
+	proxyB, _ := startClusterProxy(t, memory, 2)
+	addrA := proxyA.ListenAddr()[0]
+	r := newReactor(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lobby, whoami := zrpc.ServiceType("Lobby"), zrpc.FuncHash("Lobby", "Whoami")
+	svcB := startService(t, r, proxyB.ListenAddr()[0], "b")
+	if err := svcB.CreateEntity(ctx, lobby, 1001); err != nil {
+		t.Fatal(err)
+	}
+	callerA, err := client.Dial(r, addrA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(callerA.Close)
+	waitFor(t, "route learned from proxy B", func() bool {
+		resp, err := callerA.RemoteInvokeSync(ctx, lobby, whoami, 1001, nil)
+		return err == nil && string(resp) == "b:1001"
+	})
+
+	hello := protocol.Header{Flags: protocol.FlagOneway, Cmd: zrpc.ProxyHello, EntityID: 2}
+	takeover := encodeDeltas([]routeDelta{{opEntityAdd, lobby, 1001}})
+	cases := []struct {
+		name   string
+		id     int64
+		secret string
+		create bool
+	}{
+		{"wrong secret", 2, "guess", false},
+		{"no secret", 2, "", false},
+		{"unknown proxy", 99, testSecret, false},
+		{"self", 1, testSecret, false},
+		{"connection owns entity", 2, testSecret, true},
+	}
+	for _, c := range cases {
+		conn := dialRaw(t, addrA)
+		var frames [][]byte
+		if c.create {
+			frames = append(frames, frame(protocol.Header{Cmd: zrpc.CreateEntity, ServiceType: lobby, EntityID: 2002, Sequence: 1}, nil))
+		}
+		hello.EntityID = c.id
+		frames = append(frames, frame(hello, []byte(c.secret)), frame(protocol.Header{Flags: protocol.FlagOneway, Cmd: zrpc.RouteDelta}, takeover))
+		conn.send(frames...)
+		if !conn.closedByPeer() {
+			t.Fatalf("%s: forged hello accepted", c.name)
+		}
+		resp, err := callerA.RemoteInvokeSync(ctx, lobby, whoami, 1001, nil)
+		if err != nil || string(resp) != "b:1001" {
+			t.Fatalf("%s: route taken over got %q %v", c.name, resp, err)
+		}
+	}
+}
+
+// TestFederation_CreateConflict 两个gproxy在建立link之前各自创建了同一实体 link建立后serviceID较小的一方胜出
+// 落败的拥有者收到 RevokeEntity 两边的路由一致
+func TestFederation_CreateConflict(t *testing.T) {
+	// 各用一个注册中心 先互相看不到 创建完实体后再互相登记
+	memoryA, memoryB := discovery.NewMemory(), discovery.NewMemory()
+	proxyA, registryA := startClusterProxy(t, memoryA, 1)
+	proxyB, registryB := startClusterProxy(t, memoryB, 2)
+	r := newReactor(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lobby, whoami := zrpc.ServiceType("Lobby"), zrpc.FuncHash("Lobby", "Whoami")
+	svcA := startService(t, r, proxyA.ListenAddr()[0], "a")
+	svcB := startService(t, r, proxyB.ListenAddr()[0], "b")
+	revoked := make(chan int64, 16)
+	svcA.SetRevokeCallback(func(serviceType int32, entityID int64) { t.Errorf("winner revoked entity %d", entityID) })
+	svcB.SetRevokeCallback(func(serviceType int32, entityID int64) { revoked <- entityID })
+	for _, svc := range []*server.ProxyConn{svcA, svcB} {
+		if err := svc.CreateEntity(ctx, lobby, 1001); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, cross := range []struct {
+		memory *discovery.Memory
+		from   *discovery.MemoryClient
+	}{{memoryA, registryB}, {memoryB, registryA}} {
+		other := cross.memory.NewClient()
+		t.Cleanup(other.Close)
+		if err := other.Register(ctx, *cross.from.List(ServiceName)[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case id := <-revoked:
+		if id != 1001 {
+			t.Fatalf("revoked entity %d", id)
+		}
+	case <-ctx.Done():
+		t.Fatal("loser not revoked")
+	}
+	callers := []*client.Client{}
+	for _, addr := range []string{proxyA.ListenAddr()[0], proxyB.ListenAddr()[0]} {
+		c, err := client.Dial(r, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(c.Close)
+		callers = append(callers, c)
+	}
+	waitFor(t, "both proxies route to the winner", func() bool {
+		for _, c := range callers {
+			resp, err := c.RemoteInvokeSync(ctx, lobby, whoami, 1001, nil)
+			if err != nil || string(resp) != "a:1001" {
+				return false
+			}
+		}
+		return true
+	})
+	if owned, err := svcB.OwnsEntity(ctx, lobby, 1001); err != nil || owned {
+		t.Fatalf("loser still owns entity %v %v", owned, err)
+	}
+	if owned, err := svcA.OwnsEntity(ctx, lobby, 1001); err != nil || !owned {
+		t.Fatalf("winner lost entity %v %v", owned, err)
+	}
+}
@@ -0,0 +1,303 @@
+package gproxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/jiangshuai341/zbus/hash"
+	"github.com/jiangshuai341/zbus/zpool/slicepool"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/protocol"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNoRoute        = errors.New("gproxy: no route to entity")
+	ErrEntityOwned    = errors.New("gproxy: entity owned by another connection")
+	ErrCalleeClosed   = errors.New("gproxy: callee connection closed")
+	ErrCallExpired    = errors.New("gproxy: call expired")
+	ErrNotEntityOwner = errors.New("gproxy: entity not owned by this connection")
+	ErrBadTransfer    = errors.New("gproxy: bad transfer target")
+	ErrRemoteTarget   = errors.New("gproxy: transfer target attached to another proxy")
+	ErrDraining       = errors.New("gproxy: connection is draining")
+)
+
+const (
+	// pendingTTL 被调方一直不响应时 转发记录保留的时长 调用方通常早已超时
+	pendingTTL    = 30 * time.Second
+	sweepInterval = 5 * time.Second
+	ringVirtual   = 100
+)
+
+type routeKey struct {
+	serviceType int32
+	entityID    int64
+}
+
+type pendingCall struct {
+	caller   *entity
+	callee   *entity
+	sequence uint64 // 调用方的sequence
+	deadline time.Time
+}
+
+// router 总线模式的路由表 只在IO线程中访问
+// 有状态实体按 (serviceType, entityID) 精确路由 无状态服务按entityID在实例间一致性哈希
+// 转发请求时使用gproxy自己的sequence 不同调用方的sequence可能重复
+// 其他gproxy上的实体也记录在 entities 中 值为到该gproxy的连接(link) 见 federation.go
+type router struct {
+	entities       map[routeKey]*entity
+	services       map[int32]*hash.HashRing[*entity]
+	remoteServices map[int32]*hash.HashRing[*entity] // 其他gproxy上的无状态服务 节点为link
+	links          map[int32]*entity                 // gproxy的serviceID -> link
+	proxyDraining  map[int32]bool                    // 服务发现中不是serving状态的gproxy 不再经它路由无状态服务
+	pending        map[uint64]pendingCall
+	seq            uint64
+}
+
+func newRouter() *router {
+	return &router{
+		entities:       make(map[routeKey]*entity),
+		services:       make(map[int32]*hash.HashRing[*entity]),
+		remoteServices: make(map[int32]*hash.HashRing[*entity]),
+		links:          make(map[int32]*entity),
+		proxyDraining:  make(map[int32]bool),
+		pending:        make(map[uint64]pendingCall),
+	}
+}
+
+// route fromLink 为true时只路由到本gproxy的连接 请求最多经过一次gproxy间的转发
+// 本地有无状态服务的实例时不转发给其他gproxy
+func (r *router) route(serviceType int32, entityID int64, fromLink bool) *entity {
+	if e, ok := r.entities[routeKey{serviceType, entityID}]; ok && !(fromLink && e.link) {
+		return e
+	}
+	var key [8]byte
+	binary.LittleEndian.PutUint64(key[:], uint64(entityID))
+	if ring, ok := r.services[serviceType]; ok {
+		return ring.Get(key[:])
+	}
+	if ring, ok := r.remoteServices[serviceType]; ok && !fromLink {
+		return ring.Get(key[:])
+	}
+	return nil
+}
+
+func (r *router) createEntity(from *entity, serviceType int32, entityID int64) error {
+	key := routeKey{serviceType, entityID}
+	if owner, ok := r.entities[key]; ok && owner != from {
+		return ErrEntityOwned
+	}
+	r.entities[key] = from
+	from.owned[key] = struct{}{}
+	r.announce(routeDelta{opEntityAdd, serviceType, entityID})
+	return nil
+}
+
+func (r *router) destroyEntity(from *entity, serviceType int32, entityID int64) error {
+	key := routeKey{serviceType, entityID}
+	if owner, ok := r.entities[key]; !ok || owner != from {
+		return ErrNotEntityOwner
+	}
+	delete(r.entities, key)
+	delete(from.owned, key)
+	r.announce(routeDelta{opEntityDel, serviceType, entityID})
+	return nil
+}
+
+// transferEntity 把实体转给拥有 target 的连接 路由一次切换 不会出现无人拥有的间隙
+// 目标需要连在同一个gproxy上 其他gproxy看到的下一跳不变 不需要同步
+func (r *router) transferEntity(from *entity, serviceType int32, entityID int64, target []byte) error {
+	key := routeKey{serviceType, entityID}
+	if owner, ok := r.entities[key]; !ok || owner != from {
+		return ErrNotEntityOwner
+	}
+	if len(target) != 12 {
+		return ErrBadTransfer
+	}
+	to, ok := r.entities[routeKey{int32(binary.LittleEndian.Uint32(target)), int64(binary.LittleEndian.Uint64(target[4:]))}]
+	if !ok {
+		return ErrNoRoute
+	}
+	if to.link {
+		return ErrRemoteTarget
+	}
+	r.entities[key] = to
+	delete(from.owned, key)
+	to.owned[key] = struct{}{}
+	return nil
+}
+
+// queryEntity 见 QueryEntity 1 由 from 拥有 2 由本gproxy上的其他连接拥有 0 没有拥有者或在其他gproxy上
+// 迁移的源实例在 TransferEntity 结果不确定时查询 迁移前查询目标是否在同一gproxy上
+func (r *router) queryEntity(from *entity, serviceType int32, entityID int64) byte {
+	owner, ok := r.entities[routeKey{serviceType, entityID}]
+	switch {
+	case !ok || owner.link:
+		return 0
+	case owner == from:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (r *router) registService(from *entity, serviceType int32) error {
+	if from.draining {
+		return ErrDraining
+	}
+	ring, ok := r.services[serviceType]
+	if !ok {
+		ring = newServiceRing(serviceType)
+		r.services[serviceType] = ring
+		r.announce(routeDelta{opServiceAdd, serviceType, 0})
+	}
+	ring.Add(from)
+	from.services[serviceType] = struct{}{}
+	return nil
+}
+
+// drain 把连接移出所有无状态服务的哈希环 它拥有的实体和正在等待的响应不受影响
+// link上学到的服务由对端gproxy在其本地实例全部移除时同步删除
+func (r *router) drain(from *entity) {
+	if from.link {
+		return
+	}
+	from.draining = true
+	var deltas []routeDelta
+	for serviceType := range from.services {
+		if ring, ok := r.services[serviceType]; ok {
+			ring.Del(from)
+			if ring.Len() == 0 {
+				delete(r.services, serviceType)
+				deltas = append(deltas, routeDelta{opServiceDel, serviceType, 0})
+			}
+		}
+		delete(from.services, serviceType)
+	}
+	r.announce(deltas...)
+}
+
+func newServiceRing(serviceType int32) *hash.HashRing[*entity] {
+	return hash.NewHashRing[*entity]("service-"+strconv.Itoa(int(serviceType)), ringVirtual)
+}
+
+// invoke 转发请求 body 的所有权交给被调方连接
+func (r *router) invoke(from *entity, h protocol.Header, body []byte) {
+	to := r.route(h.ServiceType, h.EntityID, from.link)
+	if to == nil {
+		log.Debugf("[gproxy] no route service:%d entity:%d", h.ServiceType, h.EntityID)
+		slicepool.PutBuffer(body)
+		reply(from, h, ErrNoRoute)
+		return
+	}
+	if !h.Flags.Has(protocol.FlagOneway) {
+		r.seq++
+		r.pending[r.seq] = pendingCall{
+			caller:   from,
+			callee:   to,
+			sequence: h.Sequence,
+			deadline: time.Now().Add(pendingTTL),
+		}
+		h.Sequence = r.seq
+	}
+	if err := to.c.SendSafeZeroCopy(protocol.EncodeFrame(h, body)...); err != nil {
+		log.Warnf("[gproxy] forward to %s failed err:%s", to.id, err.Error())
+	}
+}
+
+// respond 把被调方的响应按sequence送回调用方
+func (r *router) respond(from *entity, h protocol.Header, body []byte) {
+	call, ok := r.pending[h.Sequence]
+	if !ok || call.callee != from {
+		log.Debugf("[gproxy] response seq:%d from %s has no pending call", h.Sequence, from.id)
+		slicepool.PutBuffer(body)
+		return
+	}
+	delete(r.pending, h.Sequence)
+	h.Sequence = call.sequence
+	if err := call.caller.c.SendSafeZeroCopy(protocol.EncodeFrame(h, body)...); err != nil {
+		log.Warnf("[gproxy] response to %s failed err:%s", call.caller.id, err.Error())
+	}
+}
+
+// sweep 清理被调方一直不响应的转发记录
+func (r *router) sweep(now time.Time) {
+	for seq, call := range r.pending {
+		if now.After(call.deadline) {
+			delete(r.pending, seq)
+			reply(call.caller, protocol.Header{Cmd: zrpc.RemoteInvoke, Sequence: call.sequence}, ErrCallExpired)
+		}
+	}
+}
+
+// remove 连接断开 清理它拥有的实体和服务 正在等待它响应的调用立即失败
+// link断开时清理经它学到的路由
+func (r *router) remove(e *entity) {
+	if e.link {
+		r.removeLink(e)
+	}
+	var deltas []routeDelta
+	for key := range e.owned {
+		if r.entities[key] == e {
+			delete(r.entities, key)
+			deltas = append(deltas, routeDelta{opEntityDel, key.serviceType, key.entityID})
+		}
+	}
+	rings := r.services
+	if e.link {
+		rings = r.remoteServices
+	}
+	for serviceType := range e.services {
+		if ring, ok := rings[serviceType]; ok {
+			ring.Del(e)
+			if ring.Len() == 0 {
+				delete(rings, serviceType)
+				deltas = append(deltas, routeDelta{opServiceDel, serviceType, 0})
+			}
+		}
+	}
+	if !e.link {
+		r.announce(deltas...)
+	}
+	for seq, call := range r.pending {
+		switch {
+		case call.caller == e:
+			delete(r.pending, seq)
+		case call.callee == e:
+			delete(r.pending, seq)
+			reply(call.caller, protocol.Header{Cmd: zrpc.RemoteInvoke, Sequence: call.sequence}, ErrCalleeClosed)
+		}
+	}
+}
+
+// reply 回复 req 单向请求不回复 err 为nil时回复空的成功响应
+func reply(to *entity, req protocol.Header, err error) {
+	if err != nil {
+		sendResponse(to, req, protocol.FlagError, []byte(err.Error()))
+		return
+	}
+	sendResponse(to, req, 0, nil)
+}
+
+// replyData 以 data 作为成功响应回复 req
+func replyData(to *entity, req protocol.Header, data []byte) {
+	sendResponse(to, req, 0, data)
+}
+
+func sendResponse(to *entity, req protocol.Header, flags protocol.Flags, body []byte) {
+	if req.Flags.Has(protocol.FlagOneway) {
+		return
+	}
+	h := protocol.Header{
+		Flags:       protocol.FlagResponse | flags,
+		Cmd:         req.Cmd,
+		ServiceType: req.ServiceType,
+		FuncHash:    req.FuncHash,
+		EntityID:    req.EntityID,
+		Sequence:    req.Sequence,
+	}
+	if sendErr := to.c.SendSafeZeroCopy(protocol.EncodeFrame(h, body)...); sendErr != nil {
+		log.Warnf("[gproxy] reply to %s failed err:%s", to.id, sendErr.Error())
+	}
+}
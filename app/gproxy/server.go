@@ -1,65 +1,172 @@
 package gproxy
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/jiangshuai341/zbus/config"
+	"github.com/jiangshuai341/zbus/discovery"
 	"github.com/jiangshuai341/zbus/etcd"
+	"github.com/jiangshuai341/zbus/hash"
+	"github.com/jiangshuai341/zbus/logger"
 	"github.com/jiangshuai341/zbus/toolkit"
 	"github.com/jiangshuai341/zbus/zbuffer"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/epoll"
 	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zpool/slicepool"
 	"github.com/jiangshuai341/zbus/zrpc"
-	"go.etcd.io/etcd/api/v3/mvccpb"
+	"github.com/jiangshuai341/zbus/zrpc/protocol"
+	"github.com/jiangshuai341/zbus/zrpc/server"
+	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-/*
-0       2       4       6       8 (BYTE)
-+-------+-------+-------+-------+
-|     pakLen    |       cmd     |
-+---------------+---------------+   8
-|  serviceType  |    funcHash   |
-+---------------+---------------+  16
-|            entityID           |
-+---------------+---------------+  24
-|                               |
-|              DATA             |
-|                               |
-+-------------------------------+
-*/
+var log = logger.GetLogger("gproxy")
+
+// ServiceName gproxy在服务发现中的服务名
+const ServiceName = "gproxy"
 
 type Server struct {
-	reactor  *reactor.Reactor
-	accepter *reactor.Accepter
-	etcd     *etcd.Client
+	reactor    *reactor.Reactor
+	accepter   *reactor.Accepter
+	listenAddr []string
+	serviceID  int32
+	registry   discovery.Backend
+	watcher    discovery.Watcher
+	config     *config.Config[Config]
+	delegates  *server.DelegateHub
+	router     *router // 只在IO线程中访问
+	connSeq    uint64
+	stop       chan struct{}
+	stopOnce   sync.Once
+
+	dialMu  sync.Mutex
+	dialers map[int32]*linkDialer // 由本gproxy发起的link
 }
 
 type Config struct {
-	ListenPort []string `json:"ListenPort"`
+	ListenPort []string `json:"ListenPort"` // 为空时随机选一个空闲端口
 	ListenUds  string   `json:"ListenUds"`
-	ReportAddr []string `json:"ReportAddr"`
+	ReportAddr []string `json:"ReportAddr"` // 注册到服务发现的地址 为空时使用监听地址
+	ServiceID  int32    `json:"ServiceID"`  // 集群内唯一 为0时由ReportAddr计算 未配置ReportAddr时随机生成
+	LogLevel   string   `json:"LogLevel"`   // 支持热更新
+	// ProxySecret gproxy之间建立link时校验的共享密钥 集群内需一致 为空时不与其他gproxy组成集群
+	// 密钥以明文发送 link需要在可信网络中
+	ProxySecret string `json:"ProxySecret"`
+
+	Discovery DiscoveryConfig `json:"Discovery"`
+}
+
+func (c *Config) Validate() error {
+	for _, port := range c.ListenPort {
+		if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+			return fmt.Errorf("invalid ListenPort %q", port)
+		}
+	}
+	if c.LogLevel != "" {
+		if _, ok := logger.ParseLogLevel(c.LogLevel); !ok {
+			return fmt.Errorf("invalid LogLevel %q", c.LogLevel)
+		}
+	}
+	return nil
 }
 
-func NewServer() *Server {
+// NewServer cfg 通常由 config.Load 加载 registry 由调用方创建 gproxy关闭时负责Close
+func NewServer(cfg *config.Config[Config], registry discovery.Backend) *Server {
 	gproxy := &Server{
-		reactor: NewReactor(),
+		reactor:   NewReactor(),
+		registry:  registry,
+		config:    cfg,
+		delegates: server.NewDelegateHub(),
+		router:    newRouter(),
+		stop:      make(chan struct{}),
+		dialers:   make(map[int32]*linkDialer),
 	}
-	var serviceId int32
-	var listenAddr []string
-	gproxy.accepter, serviceId, listenAddr = NewAccepter(gproxy)
+	gproxy.applyLogLevel(cfg.Get().LogLevel)
+	cfg.OnConfigChange(gproxy.onConfigChange)
 
-	etcd.NewClient([]string{"localhost:2379"})
-	gproxy.etcd = etcd.NewClient([]string{"localhost:2379"})
+	gproxy.accepter, gproxy.serviceID, gproxy.listenAddr = NewAccepter(gproxy, cfg.Get())
 
-	gproxy.etcd.RegisterAndKeepaliveToETCD(
-		"zbus",
-		"gproxy",
-		1,
-		serviceId,
-		PortMapping(listenAddr),
-	)
+	// AppName 为空时由注册中心填充 etcd为 NewClient 时的appName
+	appName := cfg.Get().Discovery.AppName
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := gproxy.registry.Register(ctx, discovery.ServiceInstance{
+		AppName:        appName,
+		ServiceName:    ServiceName,
+		ServiceVersion: 1,
+		ServiceID:      gproxy.serviceID,
+		Urls:           PortMapping(gproxy.listenAddr, cfg.Get().ReportAddr),
+		Protocol:       "zrpc",
+	})
+	if err != nil {
+		panic("rpc proxy service register failed Err:" + err.Error())
+	}
 
-	gproxy.etcd.WatchAllService(gproxy)
+	// 使用etcd做服务发现时 同时从etcd热更新配置
+	if cli, ok := registry.(*etcd.Client); ok {
+		if appName == "" {
+			appName = cli.AppName()
+		}
+		if err = cfg.WatchEtcd(ctx, cli.EtcdClient(), appName, ServiceName); err != nil {
+			panic("rpc proxy service watch config failed Err:" + err.Error())
+		}
+	}
+
+	// 关注所有状态的gproxy draining的gproxy仍需保留link 见 OnServiceStatusChange
+	gproxy.watcher = gproxy
+	gproxy.registry.WatchAll(gproxy.watcher)
+	go gproxy.sweepLoop()
 	return gproxy
 }
+
+// ListenAddr 实际监听的地址
+func (s *Server) ListenAddr() []string {
+	return s.listenAddr
+}
+
+// ServiceID 本gproxy在集群中的ID
+func (s *Server) ServiceID() int32 {
+	return s.serviceID
+}
+
+// Close 停止监听并断开与其他gproxy的link 已建立的服务和调用方连接不受影响
+func (s *Server) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.registry.Unwatch("", s.watcher)
+		if err := s.accepter.Close(); err != nil {
+			log.Errorf("[gproxy] close accepter failed Err:%s", err.Error())
+		}
+		s.closeLinks()
+	})
+}
+
+func (s *Server) sweepLoop() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			_ = s.reactor.DoTaskInIoThread(func(*epoll.Epoller) {
+				s.router.sweep(now)
+			})
+		}
+	}
+}
+
+// Drain 停机前调用 其他gproxy不再把新实体路由到本实例
+func (s *Server) Drain(ctx context.Context) error {
+	return s.registry.Drain(ctx)
+}
 func NewReactor() (ret *reactor.Reactor) {
 	ret, err := reactor.NewReactor()
 	if err != nil {
@@ -67,83 +174,193 @@ func NewReactor() (ret *reactor.Reactor) {
 	}
 	return
 }
-func PortMapping(listenAddr []string) (reportAddr []string) {
+func (s *Server) onConfigChange(old *Config, new *Config) {
+	if old.LogLevel != new.LogLevel {
+		s.applyLogLevel(new.LogLevel)
+	}
+	if !reflect.DeepEqual(old.ListenPort, new.ListenPort) || old.ListenUds != new.ListenUds {
+		log.Warnf("[gproxy] listen address changed, takes effect after restart")
+	}
+}
+
+func (s *Server) applyLogLevel(level string) {
+	if lv, ok := logger.ParseLogLevel(level); ok {
+		logger.SetLogLevel(lv)
+		log.Infof("[gproxy] log level set to %s", level)
+	}
+}
+
+func PortMapping(listenAddr []string, reportAddr []string) []string {
+	if len(reportAddr) > 0 {
+		return reportAddr
+	}
 	return listenAddr
 }
-func NewAccepter(iAccepter reactor.IAccepter) (ret *reactor.Accepter, serviceId int32, listenAddr []string) {
+func NewAccepter(iAccepter reactor.IAccepter, cfg *Config) (ret *reactor.Accepter, serviceId int32, listenAddr []string) {
 	ret, err := reactor.NewListener(iAccepter)
 	if err != nil {
 		panic("rpc proxy service reactor.NewListener failed Err:" + err.Error())
 	}
-	port, err := toolkit.GetFreePort()
-	if err != nil {
-		panic("rpc proxy service toolkit.GetFreePort failed Err:" + err.Error())
+	ports := cfg.ListenPort
+	if len(ports) == 0 {
+		port, err := toolkit.GetFreePort()
+		if err != nil {
+			panic("rpc proxy service toolkit.GetFreePort failed Err:" + err.Error())
+		}
+		ports = []string{strconv.Itoa(port)}
 	}
-	if err = ret.ListenUrl("tcp://" + "0.0.0.0:" + strconv.Itoa(port)); err != nil {
-		panic("rpc proxy service accepter.ListenUrl failed Err:" + err.Error())
+	for _, port := range ports {
+		url := "tcp://" + "0.0.0.0:" + port
+		if err = ret.ListenUrl(url); err != nil {
+			panic("rpc proxy service accepter.ListenUrl failed Err:" + err.Error())
+		}
+		listenAddr = append(listenAddr, url)
 	}
-	if runtime.GOOS == "linux" {
-		if err = ret.ListenUrl("unix://" + "/tmp/hhhhh.sock"); err != nil {
+	if runtime.GOOS == "linux" && cfg.ListenUds != "" {
+		url := "unix://" + cfg.ListenUds
+		if err = ret.ListenUrl(url); err != nil {
 			panic("rpc proxy service listen unix socket failed Err:" + err.Error())
 		}
+		listenAddr = append(listenAddr, url)
 	}
-	return
+	return ret, newServiceID(cfg), listenAddr
 }
-func (s *Server) OnServiceStatusChange(name string, version int32, serviceId int32, urls []string, eventType mvccpb.Event_EventType) {
 
+// newServiceID 监听地址是 0.0.0.0 不同主机上相同端口的gproxy会算出相同的ID 只能用上报地址计算
+func newServiceID(cfg *Config) int32 {
+	if cfg.ServiceID != 0 {
+		return cfg.ServiceID
+	}
+	var id uint32
+	if len(cfg.ReportAddr) > 0 {
+		id = hash.FNV1a([]byte(strings.Join(cfg.ReportAddr, ","))) & 0x7fffffff
+	} else {
+		var b [4]byte
+		_, _ = rand.Read(b[:])
+		id = binary.LittleEndian.Uint32(b[:]) & 0x7fffffff
+	}
+	if id == 0 {
+		id = 1
+	}
+	return int32(id)
 }
 
 func (s *Server) OnAccept(conn *reactor.Connection) {
-	conn.INetHandle = &entity{c: conn}
+	conn.INetHandle = s.newEntity(conn)
 	err := s.reactor.AddConn(conn)
 	if err != nil {
 		return
 	}
 }
 
-type entity struct {
-	serviceType int32
-	entityID    int64
-	serviceMap  map[int32]string
-	delegateMap map[int32]string
-	c           *reactor.Connection
-}
-
-func (e *entity) OnTraffic(inboundBuffer *zbuffer.CombinesBuffer) {
-	pakSize, err := inboundBuffer.PeekInt(0, 4)
-
-	if err != nil {
-		return
+func (s *Server) newEntity(conn *reactor.Connection) *entity {
+	return &entity{
+		id:       "conn-" + strconv.FormatUint(atomic.AddUint64(&s.connSeq, 1), 10),
+		c:        conn,
+		proxy:    s,
+		owned:    make(map[routeKey]struct{}),
+		services: make(map[int32]struct{}),
 	}
-	dataLen := inboundBuffer.LengthData()
-	if int(pakSize) > dataLen-4 {
-		return
-	}
-
-	cmd, err := inboundBuffer.PeekInt(4, 4)
-	if err != nil {
-		return
-	}
-
-	switch zrpc.Cmd(cmd) {
-	case zrpc.BindDelegate:
-
-	case zrpc.RemoteInvoke:
-
-	case zrpc.CreateEntity:
+}
 
-	case zrpc.DeclareDelegate:
+// entity gproxy上的一条连接 可能是调用方、注册了实体或服务的服务进程 或到其他gproxy的link
+// link的 owned/services 为经它学到的路由
+type entity struct {
+	id       string
+	c        *reactor.Connection
+	proxy    *Server
+	owned    map[routeKey]struct{} // CreateEntity 注册的实体
+	services map[int32]struct{}    // RegistService 注册的无状态服务
+	link     bool
+	draining bool // 已移出无状态服务的哈希环
+	proxyID  int32
+	closed   bool
+	onClose  func()
+}
 
-	case zrpc.RegistService:
+// GetID 实现 hash.INode
+func (e *entity) GetID() []byte {
+	return []byte(e.id)
+}
 
-	case zrpc.ExecuteDelegate:
+func (e *entity) OnTraffic(inboundBuffer *zbuffer.CombinesBuffer) {
+	router := e.proxy.router
+	for {
+		header, body, err := protocol.ReadFrame(inboundBuffer)
+		if err == protocol.ErrIncompleteFrame {
+			return
+		}
+		if err != nil {
+			log.Errorf("[gproxy] bad frame from %s err:%s", e.id, err.Error())
+			e.c.Close()
+			return
+		}
+		if header.Flags.Has(protocol.FlagResponse) {
+			router.respond(e, header, body)
+			continue
+		}
 
-	case zrpc.BroadcastDelegate:
+		switch header.Cmd {
+		case zrpc.RemoteInvoke:
+			router.invoke(e, header, body)
+			continue
+		case zrpc.CreateEntity:
+			reply(e, header, router.createEntity(e, header.ServiceType, header.EntityID))
+		case zrpc.DestroyEntity:
+			reply(e, header, router.destroyEntity(e, header.ServiceType, header.EntityID))
+		case zrpc.TransferEntity:
+			reply(e, header, router.transferEntity(e, header.ServiceType, header.EntityID, body))
+		case zrpc.RegistService:
+			reply(e, header, router.registService(e, header.ServiceType))
+		case zrpc.DrainService:
+			router.drain(e)
+			reply(e, header, nil)
+		case zrpc.QueryEntity:
+			replyData(e, header, []byte{router.queryEntity(e, header.ServiceType, header.EntityID)})
+		case zrpc.BindDelegate:
+			e.proxy.delegates.Bind(header.ServiceType, header.FuncHash, header.EntityID, e.c)
+		case zrpc.UnbindDelegate:
+			e.proxy.delegates.Unbind(header.ServiceType, header.FuncHash, header.EntityID, e.c)
+		case zrpc.BroadcastDelegate:
+			// 实体发起广播 扇出给所有订阅的连接 hub为每个连接拷贝数据
+			// 订阅方可能连在其他gproxy上 本地实体的广播同时转发给所有link
+			_ = e.proxy.delegates.Broadcast(header.ServiceType, header.FuncHash, header.EntityID, body)
+			if !e.link {
+				for _, link := range router.links {
+					data := slicepool.GetBuffer2(len(body))
+					copy(data, body)
+					if err = link.c.SendSafeZeroCopy(protocol.EncodeFrame(header, data)...); err != nil {
+						log.Warnf("[gproxy] forward broadcast to %s failed err:%s", link.id, err.Error())
+					}
+				}
+			}
+		case zrpc.ProxyHello:
+			if err = e.proxy.checkHello(e, int32(header.EntityID), body); err != nil {
+				log.Warnf("[gproxy] %s from %s err:%s", header.Cmd, e.id, err.Error())
+				e.c.Close()
+				break
+			}
+			router.addLink(e, int32(header.EntityID))
+		case zrpc.RouteDelta:
+			if deltas, err := decodeDeltas(body); err == nil && e.link {
+				router.applyDeltas(e, deltas)
+			} else {
+				log.Warnf("[gproxy] bad route delta from %s link:%v err:%v", e.id, e.link, err)
+			}
+		case zrpc.DeclareDelegate:
+			// 订阅关系只记录在gproxy 不需要预先声明
+		default:
+			log.Warnf("[gproxy] unexpected cmd:%s from %s", header.Cmd, e.id)
+		}
+		slicepool.PutBuffer(body)
 	}
-
-	//t.c.SendUnsafeNoCopy(*inboundBuffer.PopData(int(pakSize + 4)))
 }
 
 func (e *entity) OnClose() {
-
+	e.closed = true
+	e.proxy.delegates.RemoveSubscriber(e.c)
+	e.proxy.router.remove(e)
+	if e.onClose != nil {
+		e.onClose()
+	}
 }
@@ -0,0 +1,233 @@
+package gproxy
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/config"
+	"github.com/jiangshuai341/zbus/discovery"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/client"
+	"github.com/jiangshuai341/zbus/zrpc/server"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startProxy(t *testing.T) string {
+	cfg, err := config.New[Config]([]byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := discovery.NewMemory().NewClient()
+	s := NewServer(cfg, registry)
+	t.Cleanup(func() {
+		s.Close()
+		registry.Close()
+	})
+	return s.ListenAddr()[0]
+}
+
+func TestNewServer_AppName(t *testing.T) {
+	cfg, err := config.New[Config]([]byte(`{"Discovery":{"AppName":"game"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := discovery.NewMemory().NewClient()
+	s := NewServer(cfg, registry)
+	defer registry.Close()
+	defer s.Close()
+	instances := registry.List(ServiceName)
+	if len(instances) != 1 || instances[0].AppName != "game" {
+		t.Fatalf("registered %+v", instances)
+	}
+}
+
+func TestNewServiceID(t *testing.T) {
+	a := newServiceID(&Config{ListenPort: []string{"8883"}, ReportAddr: []string{"tcp://10.0.0.1:8883"}})
+	b := newServiceID(&Config{ListenPort: []string{"8883"}, ReportAddr: []string{"tcp://10.0.0.2:8883"}})
+	if a == b || a <= 0 || b <= 0 {
+		t.Fatalf("report addr ids %d %d", a, b)
+	}
+	if again := newServiceID(&Config{ReportAddr: []string{"tcp://10.0.0.1:8883"}}); again != a {
+		t.Fatalf("id not stable: %d %d", a, again)
+	}
+	// 没有上报地址时不能由 0.0.0.0:port 计算 两个实例需要不同的ID
+	c, d := newServiceID(&Config{ListenPort: []string{"8883"}}), newServiceID(&Config{ListenPort: []string{"8883"}})
+	if c == d || c <= 0 || d <= 0 {
+		t.Fatalf("random ids %d %d", c, d)
+	}
+	if id := newServiceID(&Config{ServiceID: 7, ReportAddr: []string{"tcp://10.0.0.1:8883"}}); id != 7 {
+		t.Fatalf("configured id %d", id)
+	}
+}
+
+func newReactor(t *testing.T) *reactor.Reactor {
+	r, err := reactor.NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// startService 注册 Lobby.Whoami 返回服务名和entityID
+func startService(t *testing.T, r *reactor.Reactor, addr string, name string) *server.ProxyConn {
+	s := server.NewServer(nil)
+	_ = s.Register("Lobby", "Whoami", func(entityID int64, arg []byte) ([]byte, error) {
+		return []byte(name + ":" + strconv.FormatInt(entityID, 10)), nil
+	})
+	p, err := s.ConnectProxy(r, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestBusMode(t *testing.T) {
+	addr := startProxy(t)
+	r := newReactor(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lobby, whoami := zrpc.ServiceType("Lobby"), zrpc.FuncHash("Lobby", "Whoami")
+	svcA := startService(t, r, addr, "a")
+	svcB := startService(t, r, addr, "b")
+	if err := svcA.CreateEntity(ctx, lobby, 1001); err != nil {
+		t.Fatal(err)
+	}
+	if err := svcB.CreateEntity(ctx, lobby, 1001); err == nil || !strings.Contains(err.Error(), ErrEntityOwned.Error()) {
+		t.Fatalf("want ErrEntityOwned got %v", err)
+	}
+
+	caller, err := client.Dial(r, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 有状态实体精确路由
+	resp, err := caller.RemoteInvokeSync(ctx, lobby, whoami, 1001, nil)
+	if err != nil || string(resp) != "a:1001" {
+		t.Fatalf("got %q %v", resp, err)
+	}
+	var remote *client.RemoteError
+	if _, err = caller.RemoteInvokeSync(ctx, lobby, whoami, 1002, nil); !errors.As(err, &remote) || remote.Msg != ErrNoRoute.Error() {
+		t.Fatalf("want ErrNoRoute got %v", err)
+	}
+
+	// 无状态服务按entityID一致性哈希 同一entityID总是落在同一实例
+	stateless := zrpc.ServiceType("Lobby")
+	_ = svcA.RegistService(ctx, stateless)
+	_ = svcB.RegistService(ctx, stateless)
+	seen := make(map[string]bool)
+	for id := int64(1); id <= 32; id++ {
+		first, err := caller.RemoteInvokeSync(ctx, stateless, whoami, id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, _ := caller.RemoteInvokeSync(ctx, stateless, whoami, id, nil)
+		if string(first) != string(again) {
+			t.Fatalf("entity %d routed to %q then %q", id, first, again)
+		}
+		seen[string(first[:1])] = true
+	}
+	if !seen["a"] || !seen["b"] {
+		t.Fatalf("stateless calls not spread over instances: %v", seen)
+	}
+
+	// 服务断开后路由被清理 实体可以由其他服务接管
+	svcA.Close()
+	deadline := time.Now().Add(3 * time.Second)
+	for svcB.CreateEntity(ctx, lobby, 1001) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("entity not released after owner disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp, err = caller.RemoteInvokeSync(ctx, lobby, whoami, 1001, nil)
+	if err != nil || string(resp) != "b:1001" {
+		t.Fatalf("got %q %v", resp, err)
+	}
+}
+
+// draining的服务不再收到无状态服务的新请求 已拥有的实体照常路由
+func TestBusMode_Drain(t *testing.T) {
+	addr := startProxy(t)
+	r := newReactor(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lobby, whoami := zrpc.ServiceType("Lobby"), zrpc.FuncHash("Lobby", "Whoami")
+	svcA := startService(t, r, addr, "a")
+	svcB := startService(t, r, addr, "b")
+	if err := svcA.CreateEntity(ctx, lobby, 1001); err != nil {
+		t.Fatal(err)
+	}
+	_ = svcA.RegistService(ctx, lobby)
+	_ = svcB.RegistService(ctx, lobby)
+	caller, err := client.Dial(r, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = svcA.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for id := int64(1); id <= 32; id++ {
+		resp, err := caller.RemoteInvokeSync(ctx, lobby, whoami, id, nil)
+		if err != nil || string(resp[:1]) != "b" {
+			t.Fatalf("entity %d routed to %q after drain err:%v", id, resp, err)
+		}
+	}
+	resp, err := caller.RemoteInvokeSync(ctx, lobby, whoami, 1001, nil)
+	if err != nil || string(resp) != "a:1001" {
+		t.Fatalf("owned entity got %q %v", resp, err)
+	}
+	var remote *client.RemoteError
+	if err = svcA.RegistService(ctx, lobby); !errors.As(err, &remote) || remote.Msg != ErrDraining.Error() {
+		t.Fatalf("want ErrDraining got %v", err)
+	}
+}
+
+func TestBusMode_Delegate(t *testing.T) {
+	addr := startProxy(t)
+	r := newReactor(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lobby, hpChange := zrpc.ServiceType("Lobby"), zrpc.FuncHash("Lobby", "HpChange")
+	svc := startService(t, r, addr, "a")
+	if err := svc.CreateEntity(ctx, lobby, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan string, 4)
+	for i := 0; i < 2; i++ {
+		caller, err := client.Dial(r, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = caller.BindDelegate(lobby, hpChange, 7, func(arg []byte, err error) {
+			if err == nil {
+				got <- string(arg)
+			}
+		})
+		// 同一连接上的请求按序处理 响应返回时订阅已经生效
+		_, _ = caller.RemoteInvokeSync(ctx, lobby, zrpc.FuncHash("Lobby", "Whoami"), 7, nil)
+	}
+
+	if err := svc.Broadcast(lobby, hpChange, 7, []byte("hp=90")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case v := <-got:
+			if v != "hp=90" {
+				t.Fatalf("got %q", v)
+			}
+		case <-ctx.Done():
+			t.Fatalf("subscriber %d not notified", i)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jiangshuai341/zbus/logger"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 配置中心
+// 先从本地文件加载 再用etcd中 appName/config/serviceName/ 前缀下的key覆盖同名的顶层字段
+// value为JSON 例如 key zbus/config/gproxy/LogLevel value "WARN"(带引号) 覆盖文件中的 LogLevel
+// etcd中的配置变化后重新合并 校验通过才会生效
+
+var log = logger.GetLogger("config")
+
+// ServiceName etcd中配置目录占用的服务名 服务注册时不可使用
+const ServiceName = "config"
+
+const (
+	requestTimeout = 5 * time.Second
+	minBackoff     = 100 * time.Millisecond
+	maxBackoff     = 10 * time.Second
+)
+
+var ErrAlreadyWatching = errors.New("config already watching etcd")
+
+// Validator 配置结构体可选实现 返回错误时新配置不生效
+type Validator interface {
+	Validate() error
+}
+
+// Config 类型安全的配置 Get 无锁读取当前生效的配置
+type Config[T any] struct {
+	value atomic.Value // *T
+
+	mu        sync.Mutex
+	base      map[string]json.RawMessage // 文件中的配置
+	overrides map[string]json.RawMessage // etcd中的覆盖项
+	hooks     []func(old *T, new *T)
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// Load 从JSON文件加载配置 并做一次校验
+func Load[T any](path string) (*Config[T], error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New[T](data)
+}
+
+// New 从JSON内容创建配置
+func New[T any](data []byte) (*Config[T], error) {
+	c := &Config[T]{
+		base:      make(map[string]json.RawMessage),
+		overrides: make(map[string]json.RawMessage),
+	}
+	if err := json.Unmarshal(data, &c.base); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	v, err := c.merge()
+	if err != nil {
+		return nil, err
+	}
+	c.value.Store(v)
+	return c, nil
+}
+
+// Get 当前生效的配置 返回值只读 不要修改
+func (c *Config[T]) Get() *T {
+	return c.value.Load().(*T)
+}
+
+// OnConfigChange 配置生效后回调 在watch协程中按注册顺序同步执行 回调中不要再注册hook
+func (c *Config[T]) OnConfigChange(fn func(old *T, new *T)) {
+	c.mu.Lock()
+	c.hooks = append(c.hooks, fn)
+	c.mu.Unlock()
+}
+
+// Prefix etcd中 serviceName 的配置目录
+func Prefix(appName string, serviceName string) string {
+	return appName + "/" + ServiceName + "/" + serviceName + "/"
+}
+
+// WatchEtcd 拉取etcd中的覆盖项并持续watch 首次拉取失败时返回错误
+// ctx 只控制首次拉取 调用 Close 停止watch
+func (c *Config[T]) WatchEtcd(ctx context.Context, cli *clientv3.Client, appName string, serviceName string) error {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.mu.Unlock()
+		return ErrAlreadyWatching
+	}
+	watchCtx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	c.mu.Unlock()
+
+	prefix := Prefix(appName, serviceName)
+	rev, err := c.resync(ctx, cli, prefix)
+	if err != nil {
+		cancel()
+		close(c.done)
+		return err
+	}
+	go c.run(watchCtx, cli, prefix, rev)
+	return nil
+}
+
+// Close 停止watch 已生效的配置不变
+func (c *Config[T]) Close() {
+	c.mu.Lock()
+	cancel, done := c.cancel, c.done
+	c.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (c *Config[T]) run(ctx context.Context, cli *clientv3.Client, prefix string, rev int64) {
+	defer close(c.done)
+	backoff := minBackoff
+	for {
+		err := c.watch(ctx, cli, prefix, rev+1)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Errorf("[Config] watch %s interrupted, resync after %v err:%v", prefix, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if rev, err = c.resync(ctx, cli, prefix); err != nil {
+			backoff = nextBackoff(backoff)
+			continue
+		}
+		backoff = minBackoff
+	}
+}
+
+func (c *Config[T]) resync(ctx context.Context, cli *clientv3.Client, prefix string) (int64, error) {
+	opCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	resp, err := cli.Get(opCtx, prefix, clientv3.WithPrefix())
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+	overrides := make(map[string]json.RawMessage, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		overrides[strings.TrimPrefix(string(kv.Key), prefix)] = kv.Value
+	}
+	c.mu.Lock()
+	c.overrides = overrides
+	c.mu.Unlock()
+	c.apply()
+	return resp.Header.Revision, nil
+}
+
+func (c *Config[T]) watch(ctx context.Context, cli *clientv3.Client, prefix string, fromRev int64) error {
+	watchCtx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+	for w := range cli.Watch(watchCtx, prefix, clientv3.WithPrefix(), clientv3.WithRev(fromRev)) {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		for _, e := range w.Events {
+			name := strings.TrimPrefix(string(e.Kv.Key), prefix)
+			if e.Type == clientv3.EventTypeDelete {
+				delete(c.overrides, name)
+			} else {
+				c.overrides[name] = e.Kv.Value
+			}
+		}
+		c.mu.Unlock()
+		c.apply()
+	}
+	return ctx.Err()
+}
+
+// apply 合并并校验 失败时保留当前配置
+func (c *Config[T]) apply() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, err := c.merge()
+	if err != nil {
+		log.Errorf("[Config] new config rejected, keep current err:%s", err.Error())
+		return
+	}
+	old := c.Get()
+	c.value.Store(v)
+	for _, fn := range c.hooks {
+		fn(old, v)
+	}
+}
+
+// merge 需持有c.mu 覆盖项按key排序后依次合并
+func (c *Config[T]) merge() (*T, error) {
+	merged := make(map[string]json.RawMessage, len(c.base)+len(c.overrides))
+	for k, v := range c.base {
+		merged[k] = v
+	}
+	keys := make([]string, 0, len(c.overrides))
+	for k := range c.overrides {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !json.Valid(c.overrides[k]) {
+			return nil, fmt.Errorf("override %s is not valid json: %s", k, string(c.overrides[k]))
+		}
+		merged[k] = c.overrides[k]
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	v := new(T)
+	if err = json.Unmarshal(data, v); err != nil {
+		return nil, fmt.Errorf("decode config: %w", err)
+	}
+	if validator, ok := any(v).(Validator); ok {
+		if err = validator.Validate(); err != nil {
+			return nil, fmt.Errorf("validate config: %w", err)
+		}
+	}
+	return v, nil
+}
+
+func nextBackoff(cur time.Duration) time.Duration {
+	next := cur * 2
+	if next > maxBackoff {
+		next = maxBackoff
+	}
+	return next
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/toolkit"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	LogLevel string `json:"LogLevel"`
+	MaxConn  int    `json:"MaxConn"`
+}
+
+func (c *testConfig) Validate() error {
+	if c.MaxConn <= 0 {
+		return errors.New("MaxConn must be positive")
+	}
+	return nil
+}
+
+func startEmbedEtcd(t *testing.T) *clientv3.Client {
+	t.Helper()
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientPort, _ := toolkit.GetFreePort()
+	peerPort, _ := toolkit.GetFreePort()
+	clientUrl, _ := url.Parse("http://127.0.0.1:" + strconv.Itoa(clientPort))
+	peerUrl, _ := url.Parse("http://127.0.0.1:" + strconv.Itoa(peerPort))
+	cfg.LCUrls, cfg.ACUrls = []url.URL{*clientUrl}, []url.URL{*clientUrl}
+	cfg.LPUrls, cfg.APUrls = []url.URL{*peerUrl}, []url.URL{*peerUrl}
+	cfg.InitialCluster = cfg.Name + "=" + peerUrl.String()
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	<-e.Server.ReadyNotify()
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{clientUrl.String()}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cli.Close() })
+	return cli
+}
+
+func TestNew_Validate(t *testing.T) {
+	if _, err := New[testConfig]([]byte(`{"MaxConn":0}`)); err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	c, err := New[testConfig]([]byte(`{"LogLevel":"INFO","MaxConn":10}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Get().MaxConn != 10 {
+		t.Fatalf("unexpected %+v", *c.Get())
+	}
+}
+
+func TestWatchEtcd(t *testing.T) {
+	cli := startEmbedEtcd(t)
+	ctx := context.Background()
+	prefix := Prefix("zbus", "gproxy")
+	if _, err := cli.Put(ctx, prefix+"MaxConn", "20"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New[testConfig]([]byte(`{"LogLevel":"INFO","MaxConn":10}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan *testConfig, 8)
+	c.OnConfigChange(func(old *testConfig, new *testConfig) { changes <- new })
+	if err = c.WatchEtcd(ctx, cli, "zbus", "gproxy"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Get().MaxConn != 20 || c.Get().LogLevel != "INFO" {
+		t.Fatalf("etcd override not applied %+v", *c.Get())
+	}
+	<-changes
+
+	wait := func() *testConfig {
+		select {
+		case v := <-changes:
+			return v
+		case <-time.After(5 * time.Second):
+			t.Fatal("config change not received")
+			return nil
+		}
+	}
+	// 校验失败的配置不生效
+	_, _ = cli.Put(ctx, prefix+"MaxConn", "-1")
+	_, _ = cli.Put(ctx, prefix+"LogLevel", `"WARN"`)
+	select {
+	case v := <-changes:
+		t.Fatalf("invalid config applied %+v", *v)
+	case <-time.After(200 * time.Millisecond):
+	}
+	_, _ = cli.Put(ctx, prefix+"MaxConn", "30")
+	if v := wait(); v.MaxConn != 30 || v.LogLevel != "WARN" {
+		t.Fatalf("unexpected %+v", *v)
+	}
+	_, _ = cli.Delete(ctx, prefix, clientv3.WithPrefix())
+	if v := wait(); v.MaxConn != 10 || v.LogLevel != "INFO" {
+		t.Fatalf("file config not restored %+v", *v)
+	}
+}
@@ -0,0 +1,91 @@
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordWatcher struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordWatcher) OnServiceStatusChange(instance *ServiceInstance, eventType EventType) {
+	r.mu.Lock()
+	r.events = append(r.events, eventType.String()+" "+instance.Key())
+	r.mu.Unlock()
+}
+
+func (r *recordWatcher) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	lobby, proxy := m.NewClient(), m.NewClient()
+	ctx := context.Background()
+	if err := lobby.Register(ctx, ServiceInstance{AppName: "zbus", ServiceName: "lobby", ServiceVersion: 1, ServiceID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lobby.Register(ctx, ServiceInstance{AppName: "zbus", ServiceName: "lobby", ServiceVersion: 1, ServiceID: 2}); err != ErrAlreadyRegistered {
+		t.Fatalf("want ErrAlreadyRegistered got %v", err)
+	}
+
+	w := &recordWatcher{}
+	proxy.WatchAll(w)
+	_ = proxy.Register(ctx, ServiceInstance{AppName: "zbus", ServiceName: "gproxy", ServiceVersion: 1, ServiceID: 9})
+	lobby.Close()
+	if len(proxy.List("lobby")) != 0 || len(proxy.List("gproxy")) != 1 {
+		t.Fatalf("unexpected list lobby:%v gproxy:%v", proxy.List("lobby"), proxy.List("gproxy"))
+	}
+	m.Cache.Close()
+
+	want := []string{"PUT zbus/lobby/1/1", "PUT zbus/gproxy/1/9", "DELETE zbus/lobby/1/1"}
+	if got := w.get(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.json")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`[{"app":"zbus","name":"lobby","version":1,"id":1,"urls":["tcp://127.0.0.1:9000"]}]`)
+
+	f, err := NewFile(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &recordWatcher{}
+	f.Watch("lobby", w)
+	if v, ok := f.Get("lobby", 1); !ok || v.Urls[0] != "tcp://127.0.0.1:9000" {
+		t.Fatalf("unexpected %v %v", v, ok)
+	}
+
+	write(`[{"app":"zbus","name":"lobby","version":1,"id":2}]`)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.List("lobby")) != 1 || f.List("lobby")[0].ServiceID != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("file change not reloaded %v", f.List("lobby"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	write(`not json`)
+	time.Sleep(50 * time.Millisecond)
+	f.Close()
+
+	want := []string{"PUT zbus/lobby/1/1", "DELETE zbus/lobby/1/1", "PUT zbus/lobby/1/2"}
+	if got := w.get(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
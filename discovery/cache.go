@@ -0,0 +1,221 @@
+package discovery
+
+import (
+	"github.com/jiangshuai341/zbus/zpool/coroutinepool"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// 回调执行器的worker数 同一个watcher的回调总是串行且有序的
+const watcherExecutorWorkers = 4
+
+type watcherEntry struct {
+	serviceName string // 空字符串表示关注所有服务
+}
+
+// watcherState 同一个watcher的多次Watch共用一个id 回调都在执行器的同一条队列中 串行且有序
+// 没有entries且回调都执行完后才删除 Unwatch后立即重新Watch时不会换到另一条队列
+type watcherState struct {
+	id      int64
+	entries []*watcherEntry
+	pending int // 已投递但还没执行完的回调
+}
+
+// Cache 服务实例的本地缓存 实现 Discovery
+// 缓存变更与回调投递在同一把锁内完成 保证每个watcher看到的事件顺序与缓存变更顺序一致
+type Cache struct {
+	mu       sync.RWMutex
+	services map[string]map[int32]*ServiceInstance
+	watchers map[Watcher]*watcherState
+	nextID   int64
+	executor *coroutinepool.KeyedExecutor
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		services: make(map[string]map[int32]*ServiceInstance),
+		watchers: make(map[Watcher]*watcherState),
+		executor: coroutinepool.NewKeyedExecutor(watcherExecutorWorkers, 0, nil),
+	}
+}
+
+// List 返回服务的所有实例 按serviceID排序
+func (c *Cache) List(serviceName string) []*ServiceInstance {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := make([]*ServiceInstance, 0, len(c.services[serviceName]))
+	for _, v := range c.services[serviceName] {
+		ret = append(ret, v)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ServiceID < ret[j].ServiceID })
+	return ret
+}
+
+func (c *Cache) Get(serviceName string, serviceID int32) (*ServiceInstance, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret, ok := c.services[serviceName][serviceID]
+	return ret, ok
+}
+
+// Watch 先把当前缓存中的实例作为 EventPut 回放给watcher 之后推送增量
+// watcher 以自身为key 需要是可比较的类型(通常为指针) 不可比较的watcher会被忽略并记录错误
+func (c *Cache) Watch(serviceName string, watcher Watcher) {
+	if !comparable(watcher) {
+		log.Errorf("[discovery] watcher %T is not comparable, ignored", watcher)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.watchers[watcher]
+	if !ok {
+		c.nextID++
+		s = &watcherState{id: c.nextID}
+		c.watchers[watcher] = s
+	}
+	s.entries = append(s.entries, &watcherEntry{serviceName: serviceName})
+
+	for name, instances := range c.services {
+		if serviceName != "" && serviceName != name {
+			continue
+		}
+		for _, v := range instances {
+			c.deliver(watcher, s, v, EventPut)
+		}
+	}
+}
+
+func comparable(watcher Watcher) bool {
+	return watcher != nil && reflect.TypeOf(watcher).Comparable()
+}
+
+// WatchAll 关注所有服务 对应的 Unwatch serviceName 传空字符串
+func (c *Cache) WatchAll(watcher Watcher) {
+	c.Watch("", watcher)
+}
+
+func (c *Cache) Unwatch(serviceName string, watcher Watcher) {
+	if !comparable(watcher) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.watchers[watcher]
+	if !ok {
+		return
+	}
+	for i, e := range s.entries {
+		if e.serviceName == serviceName {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			break
+		}
+	}
+	c.release(watcher, s)
+}
+
+// release 持有 mu 时调用
+func (c *Cache) release(watcher Watcher, s *watcherState) {
+	if len(s.entries) == 0 && s.pending == 0 && c.watchers[watcher] == s {
+		delete(c.watchers, watcher)
+	}
+}
+
+// Put 新增或更新实例 信息没有变化时不通知
+func (c *Cache) Put(instance *ServiceInstance) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(instance)
+}
+
+// Delete 删除实例 返回删除前的实例信息
+func (c *Cache) Delete(serviceName string, serviceID int32) (*ServiceInstance, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.delete(serviceName, serviceID)
+}
+
+// Replace 用全量数据替换缓存 差异作为事件通知watcher
+func (c *Cache) Replace(instances []*ServiceInstance) {
+	fresh := make(map[string]map[int32]*ServiceInstance)
+	for _, v := range instances {
+		if fresh[v.ServiceName] == nil {
+			fresh[v.ServiceName] = make(map[int32]*ServiceInstance)
+		}
+		fresh[v.ServiceName][v.ServiceID] = v
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, old := range c.services {
+		for id := range old {
+			if _, ok := fresh[name][id]; !ok {
+				c.delete(name, id)
+			}
+		}
+	}
+	for _, v := range instances {
+		c.put(v)
+	}
+}
+
+// Close 等待已投递的回调执行完
+func (c *Cache) Close() {
+	c.executor.Close()
+}
+
+func (c *Cache) put(instance *ServiceInstance) {
+	if old, ok := c.services[instance.ServiceName][instance.ServiceID]; ok && Equal(old, instance) {
+		return
+	}
+	if c.services[instance.ServiceName] == nil {
+		c.services[instance.ServiceName] = make(map[int32]*ServiceInstance)
+	}
+	c.services[instance.ServiceName][instance.ServiceID] = instance
+	c.notify(instance, EventPut)
+}
+
+func (c *Cache) delete(serviceName string, serviceID int32) (*ServiceInstance, bool) {
+	old, ok := c.services[serviceName][serviceID]
+	if !ok {
+		return nil, false
+	}
+	delete(c.services[serviceName], serviceID)
+	if len(c.services[serviceName]) == 0 {
+		delete(c.services, serviceName)
+	}
+	c.notify(old, EventDelete)
+	return old, true
+}
+
+func (c *Cache) notify(instance *ServiceInstance, eventType EventType) {
+	for watcher, s := range c.watchers {
+		for _, e := range s.entries {
+			if e.serviceName == "" || e.serviceName == instance.ServiceName {
+				c.deliver(watcher, s, instance, eventType)
+			}
+		}
+	}
+}
+
+// deliver 持有 mu 时调用
+func (c *Cache) deliver(watcher Watcher, s *watcherState, instance *ServiceInstance, eventType EventType) {
+	s.pending++
+	err := c.executor.SubmitKeyed(s.id, func() {
+		watcher.OnServiceStatusChange(instance, eventType)
+		c.mu.Lock()
+		s.pending--
+		c.release(watcher, s)
+		c.mu.Unlock()
+	})
+	if err != nil {
+		s.pending--
+	}
+}
+
+// Equal 比较两个实例的注册信息是否一致
+func Equal(a *ServiceInstance, b *ServiceInstance) bool {
+	va, _ := a.Value()
+	vb, _ := b.Value()
+	return a.Key() == b.Key() && va == vb
+}
@@ -0,0 +1,96 @@
+package discovery
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serialWatcher 记录同时执行的回调数
+type serialWatcher struct {
+	recordWatcher
+	running    int32
+	concurrent int32
+}
+
+func (s *serialWatcher) OnServiceStatusChange(instance *ServiceInstance, eventType EventType) {
+	if atomic.AddInt32(&s.running, 1) > 1 {
+		atomic.StoreInt32(&s.concurrent, 1)
+	}
+	time.Sleep(time.Millisecond)
+	s.recordWatcher.OnServiceStatusChange(instance, eventType)
+	atomic.AddInt32(&s.running, -1)
+}
+
+// TestCache_SameWatcherSerial 同一个watcher多次Watch 回调仍然串行且有序
+func TestCache_SameWatcherSerial(t *testing.T) {
+	c := NewCache()
+	w := &serialWatcher{}
+	c.Watch("lobby", w)
+	c.WatchAll(w)
+	const n = 20
+	for i := 1; i <= n; i++ {
+		c.Put(&ServiceInstance{AppName: "zbus", ServiceName: "lobby", ServiceVersion: 1, ServiceID: 1, Weight: i})
+	}
+	c.Close()
+
+	if atomic.LoadInt32(&w.concurrent) != 0 {
+		t.Fatal("callbacks of the same watcher ran concurrently")
+	}
+	events := w.get()
+	if len(events) != 2*n {
+		t.Fatalf("got %d events want %d", len(events), 2*n)
+	}
+	for i := range events {
+		if want := "PUT zbus/lobby/1/1"; events[i] != want {
+			t.Fatalf("event %d got %q", i, events[i])
+		}
+	}
+}
+
+// TestCache_Order 事件按缓存变更的顺序到达
+func TestCache_Order(t *testing.T) {
+	c := NewCache()
+	w := &recordWatcher{}
+	c.WatchAll(w)
+	var want []string
+	for i := int32(1); i <= 20; i++ {
+		c.Put(&ServiceInstance{AppName: "zbus", ServiceName: "lobby", ServiceVersion: 1, ServiceID: i})
+		want = append(want, "PUT zbus/lobby/1/"+strconv.Itoa(int(i)))
+		if i%2 == 0 {
+			c.Delete("lobby", i-1)
+			want = append(want, "DELETE zbus/lobby/1/"+strconv.Itoa(int(i-1)))
+		}
+	}
+	c.Close()
+	got := w.get()
+	if len(got) != len(want) {
+		t.Fatalf("got %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("event %d got %q want %q", i, got[i], want[i])
+		}
+	}
+}
+
+type funcWatcher func(instance *ServiceInstance, eventType EventType)
+
+func (f funcWatcher) OnServiceStatusChange(instance *ServiceInstance, eventType EventType) {
+	f(instance, eventType)
+}
+
+// TestCache_NotComparable 不可比较的watcher被忽略 不会panic
+func TestCache_NotComparable(t *testing.T) {
+	c := NewCache()
+	called := int32(0)
+	w := funcWatcher(func(*ServiceInstance, EventType) { atomic.StoreInt32(&called, 1) })
+	c.WatchAll(w)
+	c.Put(&ServiceInstance{AppName: "zbus", ServiceName: "lobby", ServiceVersion: 1, ServiceID: 1})
+	c.Unwatch("", w)
+	c.Close()
+	if atomic.LoadInt32(&called) != 0 {
+		t.Fatal("not comparable watcher called")
+	}
+}
@@ -0,0 +1,72 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/logger"
+)
+
+// 服务注册与发现的抽象 etcd/内存/文件 等后端实现同一组接口
+
+var log = logger.GetLogger("discovery")
+
+var (
+	ErrAlreadyRegistered = errors.New("service already registered")
+	ErrNotRegistered     = errors.New("service not registered")
+	ErrClosed            = errors.New("discovery backend closed")
+)
+
+type EventType int32
+
+const (
+	EventPut    EventType = iota // 实例新增或信息变更
+	EventDelete                  // 实例下线
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventPut:
+		return "PUT"
+	case EventDelete:
+		return "DELETE"
+	default:
+		return "Unknown"
+	}
+}
+
+type Watcher interface {
+	// OnServiceStatusChange 同一个watcher的回调串行有序 不要长时间阻塞
+	// EventDelete 时instance为下线前的实例信息
+	OnServiceStatusChange(instance *ServiceInstance, eventType EventType)
+}
+
+// Registry 注册本进程的服务实例 一个Registry只注册一个实例
+type Registry interface {
+	// Register ctx 只控制首次注册
+	Register(ctx context.Context, instance ServiceInstance) error
+	// Deregister 注销实例 其他进程会收到 EventDelete
+	Deregister(ctx context.Context) error
+	// SetHealthState 修改并发布本实例的健康状态
+	SetHealthState(ctx context.Context, state HealthState) error
+	// Drain 停机前调用 标记为draining 其他进程不再向本实例路由新的实体
+	// 总线模式下服务连接的是gproxy 还需调用 server.ProxyConn.Drain 通知gproxy
+	Drain(ctx context.Context) error
+}
+
+// Discovery 查询和关注同一appName下的服务实例
+type Discovery interface {
+	List(serviceName string) []*ServiceInstance
+	ListServing(serviceName string) []*ServiceInstance
+	Get(serviceName string, serviceID int32) (*ServiceInstance, bool)
+	// Watch 先把已存在的实例作为 EventPut 回放 再推送增量
+	// watcher 需要是可比较的类型(通常为指针) 同一个watcher多次Watch时回调仍然串行有序
+	Watch(serviceName string, watcher Watcher)
+	WatchAll(watcher Watcher)
+	Unwatch(serviceName string, watcher Watcher)
+}
+
+type Backend interface {
+	Registry
+	Discovery
+	Close()
+}
@@ -0,0 +1,138 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultFilePollInterval = time.Second
+
+// File 静态文件作为注册中心 用于本地开发
+// 文件内容为 ServiceInstance 的JSON数组 定时检查文件变化并重新加载
+// Register 只在本进程内可见 不会写回文件
+type File struct {
+	*Cache
+	path     string
+	interval time.Duration
+
+	mu      sync.Mutex
+	content []byte
+	self    *ServiceInstance
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewFile 首次加载失败时返回错误 之后加载失败只打印日志并保留上一次的数据
+// interval <=0 时默认1秒
+func NewFile(path string, interval time.Duration) (*File, error) {
+	if interval <= 0 {
+		interval = defaultFilePollInterval
+	}
+	f := &File{
+		Cache:    NewCache(),
+		path:     path,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	if err := f.reload(); err != nil {
+		f.Cache.Close()
+		return nil, err
+	}
+	var ctx context.Context
+	ctx, f.cancel = context.WithCancel(context.Background())
+	go f.poll(ctx)
+	return f, nil
+}
+
+func (f *File) Register(_ context.Context, instance ServiceInstance) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.self != nil {
+		return ErrAlreadyRegistered
+	}
+	instance.FormatVersion = InstanceFormatVersion
+	f.self = &instance
+	f.Cache.Put(f.self)
+	return nil
+}
+
+func (f *File) Deregister(_ context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.self == nil {
+		return ErrNotRegistered
+	}
+	f.Cache.Delete(f.self.ServiceName, f.self.ServiceID)
+	f.self = nil
+	return nil
+}
+
+func (f *File) SetHealthState(_ context.Context, state HealthState) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.self == nil {
+		return ErrNotRegistered
+	}
+	instance := *f.self
+	instance.State = state
+	f.self = &instance
+	f.Cache.Put(f.self)
+	return nil
+}
+
+func (f *File) Drain(ctx context.Context) error {
+	return f.SetHealthState(ctx, StateDraining)
+}
+
+func (f *File) Close() {
+	f.cancel()
+	<-f.done
+	f.Cache.Close()
+}
+
+func (f *File) poll(ctx context.Context) {
+	defer close(f.done)
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := f.reload(); err != nil {
+			log.Errorf("[File] reload %s failed err:%s", f.path, err.Error())
+		}
+	}
+}
+
+func (f *File) reload() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.content != nil && bytes.Equal(f.content, data) {
+		return nil
+	}
+	var instances []*ServiceInstance
+	if err = json.Unmarshal(data, &instances); err != nil {
+		return fmt.Errorf("parse %s: %w", f.path, err)
+	}
+	for _, v := range instances {
+		v.FormatVersion = InstanceFormatVersion
+	}
+	if f.self != nil {
+		instances = append(instances, f.self)
+	}
+	f.content = data
+	f.Cache.Replace(instances)
+	return nil
+}
@@ -0,0 +1,104 @@
+package discovery
+
+import (
+	"github.com/jiangshuai341/zbus/hash"
+	"sync"
+)
+
+// HealthState 实例的健康状态 随注册信息一起发布
+//
+//	starting  --检查通过--> serving
+//	serving   --检查失败--> unhealthy --检查通过--> serving
+//	任意状态  --Drain-->    draining(终态 等待进程退出)
+type HealthState string
+
+const (
+	StateStarting  HealthState = "starting"
+	StateServing   HealthState = "serving"
+	StateDraining  HealthState = "draining"
+	StateUnhealthy HealthState = "unhealthy"
+)
+
+// Serving 是否可以接收新的流量
+func (s *ServiceInstance) Serving() bool {
+	return s.State == "" || s.State == StateServing
+}
+
+// NextState 根据健康检查结果计算下一个状态 draining 不受检查结果影响
+func NextState(cur HealthState, healthy bool) HealthState {
+	if cur == StateDraining {
+		return cur
+	}
+	if healthy {
+		return StateServing
+	}
+	return StateUnhealthy
+}
+
+// ListServing 只返回serving状态的实例
+func (c *Cache) ListServing(serviceName string) []*ServiceInstance {
+	all := c.List(serviceName)
+	ret := all[:0]
+	for _, v := range all {
+		if v.Serving() {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// servingWatcher 只关心serving实例的watcher适配器
+// 实例离开serving状态时转换为 EventDelete 恢复时转换为 EventPut
+type servingWatcher struct {
+	mu      sync.Mutex
+	watcher Watcher
+	serving map[string]struct{}
+}
+
+// ServingOnly 包装watcher 使其只看到serving状态的实例
+func ServingOnly(watcher Watcher) Watcher {
+	return &servingWatcher{watcher: watcher, serving: make(map[string]struct{})}
+}
+
+func (w *servingWatcher) OnServiceStatusChange(instance *ServiceInstance, eventType EventType) {
+	key := instance.Key()
+	w.mu.Lock()
+	_, was := w.serving[key]
+	is := eventType == EventPut && instance.Serving()
+	if is {
+		w.serving[key] = struct{}{}
+	} else {
+		delete(w.serving, key)
+	}
+	w.mu.Unlock()
+
+	switch {
+	case is:
+		w.watcher.OnServiceStatusChange(instance, EventPut)
+	case was:
+		w.watcher.OnServiceStatusChange(instance, EventDelete)
+	}
+}
+
+// balancerWatcher 把serving实例同步到负载均衡器
+type balancerWatcher struct {
+	balancer hash.Balancer[*ServiceInstance]
+}
+
+// NewBalancerWatcher 用于 Watch(serviceName, ...) 非serving的实例会从balancer中移除
+// balancer 为 *hash.HashRing 时按实例权重添加
+func NewBalancerWatcher(balancer hash.Balancer[*ServiceInstance]) Watcher {
+	return ServingOnly(&balancerWatcher{balancer: balancer})
+}
+
+func (w *balancerWatcher) OnServiceStatusChange(instance *ServiceInstance, eventType EventType) {
+	if eventType == EventDelete {
+		w.balancer.Del(instance)
+		return
+	}
+	if ring, ok := w.balancer.(*hash.HashRing[*ServiceInstance]); ok {
+		ring.AddWeighted(instance, instance.GetWeight())
+		return
+	}
+	w.balancer.Add(instance)
+}
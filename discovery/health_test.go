@@ -0,0 +1,49 @@
+package discovery
+
+import (
+	"context"
+	"github.com/jiangshuai341/zbus/hash"
+	"testing"
+)
+
+func TestNextState(t *testing.T) {
+	cases := []struct {
+		cur     HealthState
+		healthy bool
+		want    HealthState
+	}{
+		{StateStarting, true, StateServing},
+		{StateStarting, false, StateUnhealthy},
+		{StateServing, false, StateUnhealthy},
+		{StateUnhealthy, true, StateServing},
+		{StateDraining, true, StateDraining},
+		{StateDraining, false, StateDraining},
+	}
+	for _, c := range cases {
+		if got := NextState(c.cur, c.healthy); got != c.want {
+			t.Errorf("NextState(%s, %v) = %s want %s", c.cur, c.healthy, got, c.want)
+		}
+	}
+}
+
+func TestBalancerWatcher_Drain(t *testing.T) {
+	m := NewMemory()
+	a, b := m.NewClient(), m.NewClient()
+	ctx := context.Background()
+	_ = a.Register(ctx, ServiceInstance{AppName: "zbus", ServiceName: "lobby", ServiceVersion: 1, ServiceID: 1})
+	_ = b.Register(ctx, ServiceInstance{AppName: "zbus", ServiceName: "lobby", ServiceVersion: 1, ServiceID: 2, State: StateStarting})
+
+	ring := hash.NewHashRingWithHasher[*ServiceInstance]("lobby", 32, hash.XXHash32)
+	m.Watch("lobby", NewBalancerWatcher(ring))
+	_ = b.SetHealthState(ctx, StateServing)
+	_ = a.Drain(ctx)
+	m.Cache.Close()
+
+	nodes := ring.Nodes()
+	if len(nodes) != 1 || nodes[0].ServiceID != 2 {
+		t.Fatalf("ring should only contain serving instance 2, got %v", nodes)
+	}
+	if serving := m.ListServing("lobby"); len(serving) != 1 || serving[0].ServiceID != 2 {
+		t.Fatalf("unexpected serving list %v", serving)
+	}
+}
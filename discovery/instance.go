@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// InstanceFormatVersion 注册信息的格式版本
+// 0: 早期版本 value为逗号分隔的url
+// 1: JSON编码的ServiceInstance
+const InstanceFormatVersion = 1
+
+var ErrInvalidKey = errors.New("invalid service key, want appName/serviceName/version/id")
+
+// ServiceInstance 注册中心中的服务实例信息 key为 appName/serviceName/serviceVersion/serviceID
+type ServiceInstance struct {
+	FormatVersion  int               `json:"v"`
+	AppName        string            `json:"app"`
+	ServiceName    string            `json:"name"`
+	ServiceVersion int32             `json:"version"`
+	ServiceID      int32             `json:"id"`
+	Urls           []string          `json:"urls"`
+	Weight         int               `json:"weight,omitempty"`   // 负载权重 0视为1
+	Zone           string            `json:"zone,omitempty"`     // 机房/可用区
+	Protocol       string            `json:"protocol,omitempty"` // 如 zrpc
+	BuildVersion   string            `json:"build,omitempty"`    // 构建版本 灰度发布时区分
+	Tags           map[string]string `json:"tags,omitempty"`
+	State          HealthState       `json:"state,omitempty"` // 为空视为serving(兼容没有健康状态的旧实例)
+}
+
+func (s *ServiceInstance) Key() string {
+	return s.AppName + "/" + s.ServiceName + "/" + strconv.Itoa(int(s.ServiceVersion)) + "/" + strconv.Itoa(int(s.ServiceID))
+}
+
+func (s *ServiceInstance) Value() (string, error) {
+	temp := *s
+	temp.FormatVersion = InstanceFormatVersion
+	data, err := json.Marshal(&temp)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (s *ServiceInstance) GetWeight() int {
+	if s.Weight <= 0 {
+		return 1
+	}
+	return s.Weight
+}
+
+// GetID 实现 hash.INode 同一服务内以serviceID区分实例
+func (s *ServiceInstance) GetID() []byte {
+	return []byte(strconv.Itoa(int(s.ServiceID)))
+}
+
+// ParseServiceInstance 解析注册中心中的key/value 兼容早期的逗号分隔格式
+// 身份信息(app/name/version/id)以key为准 value为空时(如DELETE事件)只填充身份信息
+func ParseServiceInstance(key []byte, value []byte) (*ServiceInstance, error) {
+	appName, serviceName, serviceVersion, serviceID, ok := parseKey(key)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	ret := &ServiceInstance{}
+	if len(value) > 0 && value[0] == '{' {
+		if err := json.Unmarshal(value, ret); err != nil {
+			return nil, err
+		}
+	} else if len(value) > 0 {
+		ret.Urls = parseValue(value)
+	}
+	ret.AppName = appName
+	ret.ServiceName = serviceName
+	ret.ServiceVersion = int32(serviceVersion)
+	ret.ServiceID = int32(serviceID)
+	return ret, nil
+}
+
+// parseKey 从右往左解析 appName 中允许包含'/'
+func parseKey(key []byte) (appName string, serviceName string, serviceVersion int, serviceID int, succ bool) {
+	rest, idStr, ok := cutLast(string(key))
+	if !ok {
+		return
+	}
+	rest, versionStr, ok := cutLast(rest)
+	if !ok {
+		return
+	}
+	app, name, ok := cutLast(rest)
+	if !ok || app == "" || name == "" {
+		return
+	}
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return
+	}
+	return app, name, version, id, true
+}
+
+func cutLast(s string) (before string, after string, ok bool) {
+	i := strings.LastIndexByte(s, '/')
+	if i < 0 {
+		return
+	}
+	return s[:i], s[i+1:], true
+}
+
+func parseValue(value []byte) (urls []string) {
+	return strings.Split(string(value), ",")
+}
@@ -0,0 +1,62 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseServiceInstance(t *testing.T) {
+	origin := ServiceInstance{
+		AppName:        "zbus",
+		ServiceName:    "lobby",
+		ServiceVersion: 2,
+		ServiceID:      7,
+		Urls:           []string{"tcp://10.0.0.1:9000", "unix:///tmp/a,b.sock"},
+		Weight:         3,
+		Zone:           "sh-1",
+		Tags:           map[string]string{"canary": "true"},
+	}
+	val, err := origin.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseServiceInstance([]byte(origin.Key()), []byte(val))
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin.FormatVersion = InstanceFormatVersion
+	if !reflect.DeepEqual(*got, origin) {
+		t.Fatalf("got %+v want %+v", *got, origin)
+	}
+}
+
+func TestParseServiceInstance_Legacy(t *testing.T) {
+	got, err := ParseServiceInstance([]byte("zbus/gproxy/1/3"), []byte("tcp://0.0.0.0:1,unix:///tmp/hhhhh.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FormatVersion != 0 || got.ServiceName != "gproxy" || got.ServiceID != 3 || len(got.Urls) != 2 || got.GetWeight() != 1 {
+		t.Fatalf("unexpected %+v", *got)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	cases := []struct {
+		key     string
+		app     string
+		service string
+		ok      bool
+	}{
+		{"zbus/lobby/1/2", "zbus", "lobby", true},
+		{"team/zbus/lobby/1/2", "team/zbus", "lobby", true},
+		{"zbus/lobby/1", "", "", false},
+		{"zbus/lobby/x/2", "", "", false},
+		{"/lobby/1/2", "", "", false},
+	}
+	for _, c := range cases {
+		app, service, _, _, ok := parseKey([]byte(c.key))
+		if ok != c.ok || app != c.app || service != c.service {
+			t.Errorf("parseKey(%q) = %q %q %v", c.key, app, service, ok)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory 进程内的注册中心 用于单元测试和单进程集成测试
+type Memory struct {
+	*Cache
+}
+
+func NewMemory() *Memory {
+	return &Memory{Cache: NewCache()}
+}
+
+// NewClient 每个服务(进程)使用独立的client注册 所有client共享同一份数据
+func (m *Memory) NewClient() *MemoryClient {
+	return &MemoryClient{Discovery: m.Cache, cache: m.Cache}
+}
+
+// MemoryClient 实现 Backend
+type MemoryClient struct {
+	Discovery
+	cache *Cache
+
+	mu       sync.Mutex
+	instance *ServiceInstance
+	closed   bool
+}
+
+func (c *MemoryClient) Register(_ context.Context, instance ServiceInstance) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if c.instance != nil {
+		return ErrAlreadyRegistered
+	}
+	instance.FormatVersion = InstanceFormatVersion
+	c.instance = &instance
+	c.cache.Put(c.instance)
+	return nil
+}
+
+func (c *MemoryClient) Deregister(_ context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.instance == nil {
+		return ErrNotRegistered
+	}
+	c.cache.Delete(c.instance.ServiceName, c.instance.ServiceID)
+	c.instance = nil
+	return nil
+}
+
+func (c *MemoryClient) SetHealthState(_ context.Context, state HealthState) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.instance == nil {
+		return ErrNotRegistered
+	}
+	instance := *c.instance
+	instance.State = state
+	c.instance = &instance
+	c.cache.Put(c.instance)
+	return nil
+}
+
+func (c *MemoryClient) Drain(ctx context.Context) error {
+	return c.SetHealthState(ctx, StateDraining)
+}
+
+// Close 注销本client注册的实例 不影响共享的 Memory
+func (c *MemoryClient) Close() {
+	_ = c.Deregister(context.Background())
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+}
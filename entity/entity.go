@@ -1,5 +1,16 @@
 package entity
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jiangshuai341/zbus/zpool/slicepool"
+	"github.com/jiangshuai341/zbus/zrpc/client"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
 type Type uint8
 
 const (
@@ -11,15 +22,224 @@ const (
 	DsServer
 )
 
+func (t Type) String() string {
+	switch t {
+	case Service:
+		return "Service"
+	case Player:
+		return "Player"
+	case MatchTeam:
+		return "MatchTeam"
+	case MatchRoom:
+		return "MatchRoom"
+	case DsAgent:
+		return "DsAgent"
+	case DsServer:
+		return "DsServer"
+	default:
+		return "Type(" + strconv.Itoa(int(t)) + ")"
+	}
+}
+
+var (
+	ErrDestroyed     = errors.New("entity: destroyed")
+	ErrNotMigratable = errors.New("entity: implementation does not support migration")
+)
+
+// Dispatcher 实体的rpc实现 rpcgen生成的 XxxEntity 实现了该接口
+type Dispatcher interface {
+	Dispatch(funcHash int32, arg []byte) ([]byte, error)
+}
+
+// Migratable 支持迁移的实体实现 迁移时在冻结状态下调用 状态的编码由业务决定
+type Migratable interface {
+	MarshalState() ([]byte, error)
+}
+
+// Factory 在迁移的目标实例上由 MarshalState 的结果恢复实体
+type Factory func(entityID int64, state []byte) (Dispatcher, error)
+
+type state uint8
+
+const (
+	stateActive   state = iota
+	stateFrozen         // 迁移中 新到的调用暂存在 parked 中 迁移结束后按顺序处理
+	stateMigrated       // 已迁走 调用转发给新的拥有者
+	stateDestroyed
+)
+
+// Entity 本实例拥有的实体 调用按到达顺序串行执行
 type Entity struct {
-	entityType Type
+	entityType  Type
+	serviceType int32
+	id          int64
+	mgr         *Manager
+
+	mu     sync.Mutex
+	imp    Dispatcher
+	state  state
+	parked []parkedCall // 冻结期间到达的调用 不为空时新到的调用也排在后面
+	left   int32        // 已迁走或已销毁 不持有mu也可以读取
+}
+
+type parkedCall struct {
+	funcHash int32
+	arg      []byte
+	done     func(resp []byte, err error)
+}
+
+func newEntity(mgr *Manager, t Type, serviceType int32, id int64, imp Dispatcher) *Entity {
+	return &Entity{
+		entityType:  t,
+		serviceType: serviceType,
+		id:          id,
+		mgr:         mgr,
+		imp:         imp,
+	}
+}
+
+func (e *Entity) Type() Type {
+	return e.entityType
+}
+
+func (e *Entity) ServiceType() int32 {
+	return e.serviceType
+}
+
+func (e *Entity) ID() int64 {
+	return e.id
 }
 
-func CreateEntity(t Type, key int64, rpcImp any) *Entity {
+// Imp 实体的rpc实现
+func (e *Entity) Imp() Dispatcher {
+	return e.imp
+}
+
+// RPC 调用该实体的句柄 经集群路由 实体迁移后仍然有效
+func (e *Entity) RPC() *RPC {
+	return NewRPC(e.mgr.caller, e.serviceType, e.id)
+}
+
+// call 结果通过 done 返回 冻结期间暂存 不占用执行它的协程 迁走后异步转发给新的拥有者
+func (e *Entity) call(funcHash int32, arg []byte, done func(resp []byte, err error)) {
+	e.mu.Lock()
+	if e.state == stateFrozen || len(e.parked) > 0 {
+		e.parked = append(e.parked, parkedCall{funcHash, arg, done})
+		e.mu.Unlock()
+		return
+	}
+	e.run(parkedCall{funcHash, arg, done})
+}
+
+// run 持有mu时调用 返回前释放mu
+func (e *Entity) run(c parkedCall) {
+	switch e.state {
+	case stateActive:
+		resp, err := e.dispatch(c.funcHash, c.arg)
+		e.mu.Unlock()
+		c.done(resp, err)
+	case stateMigrated:
+		e.mu.Unlock()
+		// arg 发送完成后会被连接归还 slicepool 不能直接转发收到的数据
+		data := slicepool.GetBuffer2(len(c.arg))
+		copy(data, c.arg)
+		ctx, cancel := context.WithTimeout(context.Background(), client.DefaultTimeout)
+		e.mgr.caller.RemoteInvokeAsync(ctx, e.serviceType, c.funcHash, e.id, data, func(resp []byte, err error) {
+			cancel()
+			c.done(resp, err)
+		})
+	default:
+		e.mu.Unlock()
+		c.done(nil, ErrDestroyed)
+	}
+}
 
+// dispatch 持有mu时调用 业务代码panic时释放mu由调用方的recover处理
+func (e *Entity) dispatch(funcHash int32, arg []byte) (resp []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.mu.Unlock()
+			panic(r)
+		}
+	}()
+	return e.imp.Dispatch(funcHash, arg)
+}
+
+// freeze 等待正在执行的调用结束后冻结
+func (e *Entity) freeze() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.state != stateActive {
+		return ErrDestroyed
+	}
+	e.state = stateFrozen
 	return nil
 }
 
-func (e *Entity) RPC() {
+// gone 已迁走或已销毁 本实例不再拥有
+func (e *Entity) gone() bool {
+	return atomic.LoadInt32(&e.left) == 1
+}
+
+// thaw 结束冻结 在调用方协程中按到达顺序处理暂存的调用 处理完之前新到的调用继续排队
+func (e *Entity) thaw(s state) {
+	e.mu.Lock()
+	e.state = s
+	if s == stateMigrated || s == stateDestroyed {
+		atomic.StoreInt32(&e.left, 1)
+	}
+	for len(e.parked) > 0 && e.state != stateFrozen {
+		c := e.parked[0]
+		e.parked[0] = parkedCall{}
+		e.parked = e.parked[1:]
+		e.runParked(c)
+		e.mu.Lock()
+	}
+	e.mu.Unlock()
+}
+
+// runParked 业务代码panic时以错误完成 继续处理后面的调用
+func (e *Entity) runParked(c parkedCall) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("[entity] %s service:%d id:%d func:%d panic:%v", e.entityType, e.serviceType, e.id, c.funcHash, r)
+			c.done(nil, fmt.Errorf("entity %d func %d panic: %v", e.id, c.funcHash, r))
+		}
+	}()
+	e.run(c)
+}
+
+// RPC 按 (serviceType, entityID) 调用实体 生成的代理可以用 Caller 和 EntityID 构造
+type RPC struct {
+	cli         client.Caller
+	serviceType int32
+	entityID    int64
+}
+
+func NewRPC(cli client.Caller, serviceType int32, entityID int64) *RPC {
+	return &RPC{
+		cli:         cli,
+		serviceType: serviceType,
+		entityID:    entityID,
+	}
+}
+
+func (r *RPC) Caller() client.Caller {
+	return r.cli
+}
+
+func (r *RPC) EntityID() int64 {
+	return r.entityID
+}
+
+func (r *RPC) Call(ctx context.Context, funcHash int32, arg []byte) ([]byte, error) {
+	return r.cli.RemoteInvokeSync(ctx, r.serviceType, funcHash, r.entityID, arg)
+}
+
+func (r *RPC) CallAsync(ctx context.Context, funcHash int32, arg []byte, callback func(resp []byte, err error)) {
+	r.cli.RemoteInvokeAsync(ctx, r.serviceType, funcHash, r.entityID, arg, callback)
+}
 
+func (r *RPC) Notify(funcHash int32, arg []byte) error {
+	return r.cli.Notify(r.serviceType, funcHash, r.entityID, arg)
 }
@@ -0,0 +1,440 @@
+package entity
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/jiangshuai341/zbus/app/gproxy"
+	"github.com/jiangshuai341/zbus/config"
+	"github.com/jiangshuai341/zbus/discovery"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/client"
+	"github.com/jiangshuai341/zbus/zrpc/server"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	playerType = zrpc.ServiceType("Player")
+	incr       = zrpc.FuncHash("Player", "Incr")
+)
+
+// counter Player.Incr 返回自增后的值
+type counter struct {
+	n uint64
+}
+
+func (c *counter) Dispatch(funcHash int32, _ []byte) ([]byte, error) {
+	if funcHash != incr {
+		return nil, server.ErrUnknownFunc
+	}
+	c.n++
+	return encodeCount(c.n), nil
+}
+
+func (c *counter) MarshalState() ([]byte, error) {
+	return encodeCount(c.n), nil
+}
+
+func encodeCount(n uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, n)
+	return buf
+}
+
+func restoreCounter(_ int64, state []byte) (Dispatcher, error) {
+	if len(state) != 8 {
+		return nil, errors.New("bad counter state")
+	}
+	return &counter{n: binary.LittleEndian.Uint64(state)}, nil
+}
+
+func startNode(t *testing.T, ctx context.Context, r *reactor.Reactor, url string, nodeID int64) *Manager {
+	return startNodeWith(t, ctx, r, url, nodeID, nil, restoreCounter)
+}
+
+// startNodeWith wrap 不为nil时包装 Manager 使用的 Directory
+func startNodeWith(t *testing.T, ctx context.Context, r *reactor.Reactor, url string, nodeID int64, wrap func(Directory) Directory, factory Factory) *Manager {
+	s := server.NewServer(server.NewKeyedExecutor(4))
+	p, err := s.ConnectProxy(r, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	var dir Directory = p
+	if wrap != nil {
+		dir = wrap(p)
+	}
+	m := NewManager(dir, s, p, nodeID)
+	if err = m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.RegisterType(Player, playerType, factory); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func startDirectory(t *testing.T) (*reactor.Reactor, string) {
+	cfg, err := config.New[gproxy.Config]([]byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := discovery.NewMemory().NewClient()
+	proxy := gproxy.NewServer(cfg, registry)
+	t.Cleanup(func() {
+		proxy.Close()
+		registry.Close()
+	})
+	r, err := reactor.NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, proxy.ListenAddr()[0]
+}
+
+func TestManager(t *testing.T) {
+	r, url := startDirectory(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	node1 := startNode(t, ctx, r, url, 1)
+	node2 := startNode(t, ctx, r, url, 2)
+	cli, err := client.Dial(r, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := node1.CreateEntity(ctx, playerType, 1001, &counter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = node2.CreateEntity(ctx, playerType, 1001, &counter{}); err == nil || !strings.Contains(err.Error(), gproxy.ErrEntityOwned.Error()) {
+		t.Fatalf("want ErrEntityOwned got %v", err)
+	}
+	if _, err = node1.CreateEntity(ctx, zrpc.ServiceType("Unknown"), 1, &counter{}); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("want ErrUnknownType got %v", err)
+	}
+
+	// 迁移期间持续调用 不出错且计数连续
+	rpc := NewRPC(cli, playerType, 1001)
+	var (
+		wg      sync.WaitGroup
+		callErr error
+		last    uint64
+	)
+	migrated := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			resp, err := rpc.Call(ctx, incr, nil)
+			if err != nil {
+				callErr = err
+				return
+			}
+			if n := binary.LittleEndian.Uint64(resp); n != last+1 {
+				callErr = errors.New("counter not continuous")
+				return
+			}
+			last++
+			select {
+			case <-migrated:
+				if i > 0 {
+					return
+				}
+			default:
+			}
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err = node1.Migrate(ctx, e, 2); err != nil {
+		t.Fatal(err)
+	}
+	close(migrated)
+	wg.Wait()
+	if callErr != nil {
+		t.Fatal(callErr)
+	}
+	if _, ok := node1.Entity(playerType, 1001); ok {
+		t.Fatal("entity still owned by node 1")
+	}
+	moved, ok := node2.Entity(playerType, 1001)
+	if !ok {
+		t.Fatal("entity not owned by node 2")
+	}
+	if err = node1.Migrate(ctx, e, 2); err != ErrDestroyed {
+		t.Fatalf("want ErrDestroyed got %v", err)
+	}
+
+	// 目标不存在时迁移失败 实体仍可调用
+	if err = node2.Migrate(ctx, moved, 3); err == nil {
+		t.Fatal("migrate to missing node should fail")
+	}
+	resp, err := moved.RPC().Call(ctx, incr, nil)
+	if err != nil || binary.LittleEndian.Uint64(resp) != last+1 {
+		t.Fatalf("got %v %v", resp, err)
+	}
+
+	// 销毁后路由失效 可以重新创建
+	if err = node2.DestroyEntity(ctx, moved); err != nil {
+		t.Fatal(err)
+	}
+	var remote *client.RemoteError
+	if _, err = rpc.Call(ctx, incr, nil); !errors.As(err, &remote) || remote.Msg != gproxy.ErrNoRoute.Error() {
+		t.Fatalf("want ErrNoRoute got %v", err)
+	}
+	if _, err = node1.CreateEntity(ctx, playerType, 1001, &counter{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// lostReply TransferEntity 的响应丢失 apply 为true时请求已经生效
+type lostReply struct {
+	Directory
+	apply bool
+}
+
+func (d lostReply) TransferEntity(ctx context.Context, serviceType int32, entityID int64, toServiceType int32, toEntityID int64) error {
+	if d.apply {
+		if err := d.Directory.TransferEntity(ctx, serviceType, entityID, toServiceType, toEntityID); err != nil {
+			return err
+		}
+	}
+	return context.DeadlineExceeded
+}
+
+// TestManager_AmbiguousTransfer 切换结果不确定时以 Directory 中的拥有者为准
+func TestManager_AmbiguousTransfer(t *testing.T) {
+	r, url := startDirectory(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	lost := func(apply bool) func(Directory) Directory {
+		return func(dir Directory) Directory {
+			return lostReply{Directory: dir, apply: apply}
+		}
+	}
+	node1 := startNodeWith(t, ctx, r, url, 1, lost(false), restoreCounter)
+	node2 := startNodeWith(t, ctx, r, url, 2, lost(true), restoreCounter)
+	node3 := startNode(t, ctx, r, url, 3)
+
+	// 没有生效 实体留在源实例 目标实例丢弃恢复的实体
+	e, err := node1.CreateEntity(ctx, playerType, 1001, &counter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = node1.Migrate(ctx, e, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want DeadlineExceeded got %v", err)
+	}
+	if _, ok := node1.Entity(playerType, 1001); !ok {
+		t.Fatal("entity not kept by node 1")
+	}
+	if _, ok := node2.Entity(playerType, 1001); ok {
+		t.Fatal("entity not discarded by node 2")
+	}
+	resp, err := e.RPC().Call(ctx, incr, nil)
+	if err != nil || binary.LittleEndian.Uint64(resp) != 1 {
+		t.Fatalf("got %v %v", resp, err)
+	}
+
+	// 已经生效 迁移成功 目标实例保留实体
+	moved, err := node2.CreateEntity(ctx, playerType, 1002, &counter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = node2.Migrate(ctx, moved, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := node2.Entity(playerType, 1002); ok {
+		t.Fatal("entity still owned by node 2")
+	}
+	if _, ok := node3.Entity(playerType, 1002); !ok {
+		t.Fatal("entity not owned by node 3")
+	}
+	resp, err = moved.RPC().Call(ctx, incr, nil)
+	if err != nil || binary.LittleEndian.Uint64(resp) != 1 {
+		t.Fatalf("got %v %v", resp, err)
+	}
+}
+
+// TestManager_FactoryReentrant factory 中可以调用 Manager
+func TestManager_FactoryReentrant(t *testing.T) {
+	r, url := startDirectory(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var node2 *Manager
+	restore := func(entityID int64, state []byte) (Dispatcher, error) {
+		if _, ok := node2.Entity(playerType, entityID); ok {
+			return nil, ErrExists
+		}
+		return restoreCounter(entityID, state)
+	}
+	node1 := startNode(t, ctx, r, url, 1)
+	node2 = startNodeWith(t, ctx, r, url, 2, nil, restore)
+
+	e, err := node1.CreateEntity(ctx, playerType, 1001, &counter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = node1.Migrate(ctx, e, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := node2.Entity(playerType, 1001); !ok {
+		t.Fatal("entity not owned by node 2")
+	}
+}
+
+// Directory 收回实体后本地按已销毁处理 集群内冲突的端到端场景见 gproxy 的 TestFederation_CreateConflict
+func TestManager_Revoked(t *testing.T) {
+	r, url := startDirectory(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	node := startNode(t, ctx, r, url, 1)
+	e, err := node.CreateEntity(ctx, playerType, 1001, &counter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.revoked(playerType, 1001)
+	deadline := time.Now().Add(5 * time.Second)
+	for !e.gone() {
+		if time.Now().After(deadline) {
+			t.Fatal("entity not revoked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := node.Entity(playerType, 1001); ok {
+		t.Fatal("revoked entity still on node")
+	}
+	e.call(incr, nil, func(_ []byte, callErr error) { err = callErr })
+	if err != ErrDestroyed {
+		t.Fatalf("want ErrDestroyed got %v", err)
+	}
+}
+
+// TestManager_FrozenCallsParked 冻结期间到达的调用暂存在实体中 不占用executor的协程 解冻后按顺序执行
+func TestManager_FrozenCallsParked(t *testing.T) {
+	r, url := startDirectory(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// 只有一个worker 冻结的调用占用它时其他实体的调用都无法执行
+	s := server.NewServer(server.NewKeyedExecutor(1))
+	p, err := s.ConnectProxy(r, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	node := NewManager(p, s, p, 1)
+	if err = node.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = node.RegisterType(Player, playerType, restoreCounter); err != nil {
+		t.Fatal(err)
+	}
+	frozen, err := node.CreateEntity(ctx, playerType, 1001, &counter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := node.CreateEntity(ctx, playerType, 1002, &counter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = frozen.freeze(); err != nil {
+		t.Fatal(err)
+	}
+	const n = 3
+	results := make(chan uint64, n)
+	for i := 0; i < n; i++ {
+		frozen.RPC().CallAsync(ctx, incr, nil, func(resp []byte, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			results <- binary.LittleEndian.Uint64(resp)
+		})
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		frozen.mu.Lock()
+		parked := len(frozen.parked)
+		frozen.mu.Unlock()
+		if parked == n {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d calls parked", parked)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	callCtx, callCancel := context.WithTimeout(ctx, time.Second)
+	defer callCancel()
+	if _, err = other.RPC().Call(callCtx, incr, nil); err != nil {
+		t.Fatalf("worker blocked by frozen entity: %v", err)
+	}
+
+	frozen.thaw(stateActive)
+	for i := 1; i <= n; i++ {
+		if got := <-results; got != uint64(i) {
+			t.Fatalf("call %d got %d", i, got)
+		}
+	}
+}
+
+func startClusterDirectory(t *testing.T, memory *discovery.Memory, id int) string {
+	cfg, err := config.New[gproxy.Config]([]byte(`{"ProxySecret":"zbus-test","ServiceID":` + strconv.Itoa(id) + `}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := memory.NewClient()
+	proxy := gproxy.NewServer(cfg, registry)
+	t.Cleanup(func() {
+		proxy.Close()
+		registry.Close()
+	})
+	return proxy.ListenAddr()[0]
+}
+
+// TestManager_MigrateRemoteNode 目标实例连在其他gproxy上时在冻结实体之前拒绝迁移
+func TestManager_MigrateRemoteNode(t *testing.T) {
+	memory := discovery.NewMemory()
+	urlA, urlB := startClusterDirectory(t, memory, 1), startClusterDirectory(t, memory, 2)
+	r, err := reactor.NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	node1 := startNode(t, ctx, r, urlA, 1)
+	node2 := startNode(t, ctx, r, urlB, 2)
+	if _, err = node2.CreateEntity(ctx, playerType, 2002, &counter{}); err != nil {
+		t.Fatal(err)
+	}
+	e, err := node1.CreateEntity(ctx, playerType, 1001, &counter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 等待gproxy A学到node 2上的实体
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err = NewRPC(node1.caller, playerType, 2002).Call(ctx, incr, nil); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("route to node 2 not learned: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err = node1.Migrate(ctx, e, 2); !errors.Is(err, ErrRemoteNode) {
+		t.Fatalf("want ErrRemoteNode got %v", err)
+	}
+	if _, ok := node2.Entity(playerType, 1001); ok {
+		t.Fatal("entity restored on remote node")
+	}
+	resp, err := e.RPC().Call(ctx, incr, nil)
+	if err != nil || binary.LittleEndian.Uint64(resp) != 1 {
+		t.Fatalf("got %v %v", resp, err)
+	}
+}
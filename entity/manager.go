@@ -0,0 +1,351 @@
+package entity
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jiangshuai341/zbus/logger"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/client"
+	"github.com/jiangshuai341/zbus/zrpc/server"
+	"sync"
+	"time"
+)
+
+// 实体的生命周期
+// 实体由某个服务实例创建并在Directory(gproxy)中登记 集群内同一实体只有一个拥有者
+// 不同gproxy上并发创建同一实体时都会成功 随后Directory收回落败一方的实体 之后的调用返回 ErrDestroyed
+// 迁移时源实例先冻结实体 把状态交给目标实例恢复 再在Directory中把实体转给目标实例
+// 路由切换是原子的 冻结期间到达的调用暂存在实体中 不占用executor的协程
+// 迁移结束后按到达顺序转发给新的拥有者 迁移失败时在本地执行
+//
+// 限制
+//   不能在实体自己的调用中迁移或销毁该实体
+//   迁移期间发出的异步调用可能乱序 同步调用的顺序不变
+//   源实例和目标实例需要连在同一个gproxy上 Migrate 在冻结实体之前检查 不满足时返回 ErrRemoteNode
+//   gproxy之间不转移实体 网格模式没有Directory 都不支持迁移
+
+var log = logger.GetLogger("entity")
+
+var (
+	ErrUnknownType   = errors.New("entity: service type not registered")
+	ErrDuplicateType = errors.New("entity: service type already registered")
+	ErrExists        = errors.New("entity: already exists on this node")
+	ErrNoEntity      = errors.New("entity: not found on this node")
+	ErrBadMigration  = errors.New("entity: bad migration request")
+	ErrRemoteNode    = errors.New("entity: target node not attached to the same directory")
+)
+
+// ManagerService 每个 Manager 以 (ManagerService, nodeID) 登记为实体 接收迁移请求
+const ManagerService = "EntityManager"
+
+var (
+	managerType  = zrpc.ServiceType(ManagerService)
+	migrateFunc  = zrpc.FuncHash(ManagerService, "Migrate")
+	abortFunc    = zrpc.FuncHash(ManagerService, "Abort")
+	migrateGrace = client.DefaultTimeout
+)
+
+// Directory 实体的登记处 *server.ProxyConn 实现了该接口
+type Directory interface {
+	CreateEntity(ctx context.Context, serviceType int32, entityID int64) error
+	DestroyEntity(ctx context.Context, serviceType int32, entityID int64) error
+	TransferEntity(ctx context.Context, serviceType int32, entityID int64, toServiceType int32, toEntityID int64) error
+	// OwnsEntity 实体当前是否由本实例拥有
+	OwnsEntity(ctx context.Context, serviceType int32, entityID int64) (bool, error)
+	// LocalEntity 实体是否由同一Directory上的实例拥有 包括本实例 只能在这些实例之间迁移
+	LocalEntity(ctx context.Context, serviceType int32, entityID int64) (bool, error)
+}
+
+// revoker Directory 可能在登记成功之后收回实体 如gproxy集群内并发创建了同一实体 *server.ProxyConn 实现了该接口
+type revoker interface {
+	SetRevokeCallback(fn func(serviceType int32, entityID int64))
+}
+
+type entityKey struct {
+	serviceType int32
+	entityID    int64
+}
+
+type typeEntry struct {
+	t       Type
+	factory Factory
+}
+
+// Manager 管理本服务实例拥有的实体
+type Manager struct {
+	dir    Directory
+	server *server.Server
+	caller client.Caller
+	nodeID int64
+
+	mu       sync.Mutex
+	types    map[int32]typeEntry
+	entities map[entityKey]*Entity
+}
+
+// NewManager nodeID 在集群内唯一 caller 用于迁移和转发 总线模式下 dir 和 caller 都是同一个 *server.ProxyConn
+func NewManager(dir Directory, s *server.Server, caller client.Caller, nodeID int64) *Manager {
+	m := &Manager{
+		dir:      dir,
+		server:   s,
+		caller:   caller,
+		nodeID:   nodeID,
+		types:    make(map[int32]typeEntry),
+		entities: make(map[entityKey]*Entity),
+	}
+	if r, ok := dir.(revoker); ok {
+		r.SetRevokeCallback(m.revoked)
+	}
+	return m
+}
+
+func (m *Manager) NodeID() int64 {
+	return m.nodeID
+}
+
+// Start 登记本 Manager 之后才能作为迁移的目标
+func (m *Manager) Start(ctx context.Context) error {
+	if err := m.server.RegisterService(managerType, m.serveManager); err != nil {
+		return err
+	}
+	if err := m.dir.CreateEntity(ctx, managerType, m.nodeID); err != nil {
+		m.server.UnregisterService(managerType)
+		return err
+	}
+	return nil
+}
+
+// RegisterType serviceType 的调用都交给本 Manager 的实体 factory 为nil时该类实体不能迁入
+func (m *Manager) RegisterType(t Type, serviceType int32, factory Factory) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.types[serviceType]; ok {
+		return fmt.Errorf("%w: %d", ErrDuplicateType, serviceType)
+	}
+	if err := m.server.RegisterAsyncService(serviceType, m.serve(serviceType)); err != nil {
+		return err
+	}
+	m.types[serviceType] = typeEntry{t: t, factory: factory}
+	return nil
+}
+
+// CreateEntity Directory中已有拥有者时失败
+func (m *Manager) CreateEntity(ctx context.Context, serviceType int32, entityID int64, imp Dispatcher) (*Entity, error) {
+	key := entityKey{serviceType, entityID}
+	m.mu.Lock()
+	te, ok := m.types[serviceType]
+	if !ok {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: %d", ErrUnknownType, serviceType)
+	}
+	if old, ok := m.entities[key]; ok && !old.gone() {
+		m.mu.Unlock()
+		return nil, ErrExists
+	}
+	m.mu.Unlock()
+
+	if err := m.dir.CreateEntity(ctx, serviceType, entityID); err != nil {
+		return nil, err
+	}
+	e := newEntity(m, te.t, serviceType, entityID, imp)
+	m.mu.Lock()
+	m.entities[key] = e
+	m.mu.Unlock()
+	return e, nil
+}
+
+// Entity 本实例拥有的实体
+func (m *Manager) Entity(serviceType int32, entityID int64) (*Entity, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entities[entityKey{serviceType, entityID}]
+	if !ok || e.gone() {
+		return nil, false
+	}
+	return e, true
+}
+
+// DestroyEntity 等待正在执行的调用结束 之后的调用返回 ErrDestroyed
+func (m *Manager) DestroyEntity(ctx context.Context, e *Entity) error {
+	if err := e.freeze(); err != nil {
+		return err
+	}
+	if err := m.dir.DestroyEntity(ctx, e.serviceType, e.id); err != nil {
+		e.thaw(stateActive)
+		return err
+	}
+	e.thaw(stateDestroyed)
+	m.remove(e)
+	return nil
+}
+
+// Migrate 把实体迁到 toNode 的 Manager 实现需要实现 Migratable
+// toNode 需要连在同一个Directory上 否则返回 ErrRemoteNode
+// 路由切换的结果不确定时(如超时)以 Directory 中的拥有者为准 见 transferred
+func (m *Manager) Migrate(ctx context.Context, e *Entity, toNode int64) error {
+	if _, ok := e.imp.(Migratable); !ok {
+		return ErrNotMigratable
+	}
+	local, err := m.dir.LocalEntity(ctx, managerType, toNode)
+	if err != nil {
+		return err
+	}
+	if !local {
+		return fmt.Errorf("%w: node %d", ErrRemoteNode, toNode)
+	}
+	if err := e.freeze(); err != nil {
+		return err
+	}
+	state, err := e.imp.(Migratable).MarshalState()
+	if err != nil {
+		e.thaw(stateActive)
+		return err
+	}
+	if _, err = m.caller.RemoteInvokeSync(ctx, managerType, migrateFunc, toNode, encodeMigration(e.serviceType, e.id, state)); err != nil {
+		m.abort(e, toNode)
+		return err
+	}
+	if err = m.dir.TransferEntity(ctx, e.serviceType, e.id, managerType, toNode); err != nil {
+		if !m.transferred(e, err) {
+			m.abort(e, toNode)
+			return err
+		}
+		log.Warnf("[entity] transfer service:%d id:%d to node %d returned err:%s but routed to target", e.serviceType, e.id, toNode, err.Error())
+	}
+	e.thaw(stateMigrated)
+	// 路由切换前已经发给本实例的调用可能还没执行 保留一段时间用于转发
+	time.AfterFunc(migrateGrace, func() {
+		m.remove(e)
+	})
+	log.Infof("[entity] %s service:%d id:%d migrated to node %d", e.entityType, e.serviceType, e.id, toNode)
+	return nil
+}
+
+// transferred TransferEntity 返回错误后判断路由是否已经切换
+// Directory 明确拒绝(RemoteError)时没有切换 超时或连接断开时请求可能已经生效 向 Directory 查询拥有者
+// 查询也失败时按已切换处理 目标实例保留实体 避免 abort 之后实体在集群中丢失
+func (m *Manager) transferred(e *Entity, err error) bool {
+	var remote *client.RemoteError
+	if errors.As(err, &remote) {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), client.DefaultTimeout)
+	defer cancel()
+	owned, err := m.dir.OwnsEntity(ctx, e.serviceType, e.id)
+	if err != nil {
+		log.Errorf("[entity] query owner service:%d id:%d failed err:%s", e.serviceType, e.id, err.Error())
+		return true
+	}
+	return !owned
+}
+
+// abort 通知目标实例丢弃已恢复的实体 本地恢复原状
+func (m *Manager) abort(e *Entity, toNode int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.DefaultTimeout)
+	defer cancel()
+	if _, err := m.caller.RemoteInvokeSync(ctx, managerType, abortFunc, toNode, encodeMigration(e.serviceType, e.id, nil)); err != nil {
+		log.Warnf("[entity] abort migration service:%d id:%d to node %d failed err:%s", e.serviceType, e.id, toNode, err.Error())
+	}
+	e.thaw(stateActive)
+}
+
+// revoked Directory 收回了实体 等正在执行的调用结束后按已销毁处理 在IO线程中回调 不能阻塞
+func (m *Manager) revoked(serviceType int32, entityID int64) {
+	m.mu.Lock()
+	e, ok := m.entities[entityKey{serviceType, entityID}]
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	go func() {
+		if err := e.freeze(); err != nil {
+			return
+		}
+		e.thaw(stateDestroyed)
+		m.remove(e)
+		log.Warnf("[entity] %s service:%d id:%d revoked by directory", e.entityType, e.serviceType, e.id)
+	}()
+}
+
+func (m *Manager) remove(e *Entity) {
+	key := entityKey{e.serviceType, e.id}
+	m.mu.Lock()
+	if m.entities[key] == e {
+		delete(m.entities, key)
+	}
+	m.mu.Unlock()
+}
+
+func (m *Manager) serve(serviceType int32) server.AsyncServiceHandler {
+	return func(funcHash int32, entityID int64, arg []byte, done func(resp []byte, err error)) {
+		m.mu.Lock()
+		e, ok := m.entities[entityKey{serviceType, entityID}]
+		m.mu.Unlock()
+		if !ok {
+			done(nil, fmt.Errorf("%w: service:%d id:%d", ErrNoEntity, serviceType, entityID))
+			return
+		}
+		e.call(funcHash, arg, done)
+	}
+}
+
+// serveManager 迁移的目标实例 实体在 TransferEntity 之前不会收到调用
+func (m *Manager) serveManager(funcHash int32, _ int64, arg []byte) ([]byte, error) {
+	serviceType, entityID, state, err := decodeMigration(arg)
+	if err != nil {
+		return nil, err
+	}
+	key := entityKey{serviceType, entityID}
+	switch funcHash {
+	case migrateFunc:
+		m.mu.Lock()
+		te, ok := m.types[serviceType]
+		old, exists := m.entities[key]
+		m.mu.Unlock()
+		if !ok || te.factory == nil {
+			return nil, fmt.Errorf("%w: %d", ErrUnknownType, serviceType)
+		}
+		if exists && !old.gone() {
+			return nil, ErrExists
+		}
+		// factory 是业务代码 可能调用 Manager 的方法 不能持有 mu
+		imp, err := te.factory(entityID, state)
+		if err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if old, ok := m.entities[key]; ok && !old.gone() {
+			return nil, ErrExists
+		}
+		m.entities[key] = newEntity(m, te.t, serviceType, entityID, imp)
+		return nil, nil
+	case abortFunc:
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if e, ok := m.entities[key]; ok && !e.gone() {
+			delete(m.entities, key)
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%w service:%d func:%d", server.ErrUnknownFunc, managerType, funcHash)
+	}
+}
+
+// encodeMigration serviceType(4) entityID(8) state 小端
+func encodeMigration(serviceType int32, entityID int64, state []byte) []byte {
+	buf := make([]byte, 12+len(state))
+	binary.LittleEndian.PutUint32(buf, uint32(serviceType))
+	binary.LittleEndian.PutUint64(buf[4:], uint64(entityID))
+	copy(buf[12:], state)
+	return buf
+}
+
+func decodeMigration(body []byte) (int32, int64, []byte, error) {
+	if len(body) < 12 {
+		return 0, 0, nil, ErrBadMigration
+	}
+	return int32(binary.LittleEndian.Uint32(body)), int64(binary.LittleEndian.Uint64(body[4:])), body[12:], nil
+}
@@ -0,0 +1,158 @@
+package etcd
+
+import (
+	"context"
+	"github.com/jiangshuai341/zbus/config"
+	"github.com/jiangshuai341/zbus/discovery"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ServiceCache etcd服务实例的本地缓存
+// 先Get前缀下的全部实例 再从 revision+1 开始Watch 保证不丢事件
+// Watch中断或遇到compaction时重新全量同步 并把差异作为事件补发给watcher
+type ServiceCache struct {
+	*discovery.Cache
+	cli      *clientv3.Client
+	prefix   string
+	revision int64
+	synced   chan struct{}
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewServiceCache 缓存 appName 下的所有服务 后台立即开始同步
+func NewServiceCache(cli *clientv3.Client, appName string) *ServiceCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newServiceCache(cli, appName, cancel)
+	go c.run(ctx)
+	return c
+}
+
+func newServiceCache(cli *clientv3.Client, appName string, cancel context.CancelFunc) *ServiceCache {
+	return &ServiceCache{
+		Cache:  discovery.NewCache(),
+		cli:    cli,
+		prefix: appName + "/",
+		synced: make(chan struct{}),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+// WaitSynced 等待首次全量同步完成
+func (c *ServiceCache) WaitSynced(ctx context.Context) error {
+	select {
+	case <-c.synced:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Revision 缓存对应的etcd revision
+func (c *ServiceCache) Revision() int64 {
+	return atomic.LoadInt64(&c.revision)
+}
+
+// Close 停止同步 等待已投递的回调执行完
+func (c *ServiceCache) Close() {
+	c.cancel()
+	<-c.done
+	c.Cache.Close()
+}
+
+func (c *ServiceCache) run(ctx context.Context) {
+	defer close(c.done)
+	backoff := minRegisterBackoff
+	for {
+		rev, err := c.resync(ctx)
+		if err == nil {
+			backoff = minRegisterBackoff
+			err = c.watch(ctx, rev+1)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Errorf("[ServiceCache] %s sync interrupted, resync after %v err:%v", c.prefix, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// resync 全量拉取 与旧缓存比较后补发差异事件
+func (c *ServiceCache) resync(ctx context.Context) (int64, error) {
+	opCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	resp, err := c.cli.Get(opCtx, c.prefix, clientv3.WithPrefix())
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+
+	instances := make([]*discovery.ServiceInstance, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		if instance, ok := c.parse(kv.Key, kv.Value); ok {
+			instances = append(instances, instance)
+		}
+	}
+	c.Cache.Replace(instances)
+	atomic.StoreInt64(&c.revision, resp.Header.Revision)
+	select {
+	case <-c.synced:
+	default:
+		close(c.synced)
+	}
+	return resp.Header.Revision, nil
+}
+
+func (c *ServiceCache) watch(ctx context.Context, fromRev int64) error {
+	watchCtx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+	for w := range c.cli.Watch(watchCtx, c.prefix, clientv3.WithPrefix(), clientv3.WithRev(fromRev)) {
+		if w.CompactRevision != 0 {
+			return w.Err()
+		}
+		if err := w.Err(); err != nil {
+			return err
+		}
+		c.apply(w.Header.Revision, w.Events)
+	}
+	return ctx.Err()
+}
+
+func (c *ServiceCache) apply(rev int64, events []*clientv3.Event) {
+	for _, e := range events {
+		instance, ok := c.parse(e.Kv.Key, e.Kv.Value)
+		if !ok {
+			continue
+		}
+		switch e.Type {
+		case mvccpb.PUT:
+			c.Cache.Put(instance)
+		case mvccpb.DELETE:
+			c.Cache.Delete(instance.ServiceName, instance.ServiceID)
+		}
+	}
+	atomic.StoreInt64(&c.revision, rev)
+}
+
+func (c *ServiceCache) parse(key []byte, value []byte) (*discovery.ServiceInstance, bool) {
+	// 配置中心的目录不是服务实例
+	if strings.HasPrefix(string(key), c.prefix+config.ServiceName+"/") {
+		return nil, false
+	}
+	instance, err := discovery.ParseServiceInstance(key, value)
+	if err != nil {
+		log.Errorf("[ServiceCache] parse error key:%s val:%s err:%s", string(key), string(value), err.Error())
+		return nil, false
+	}
+	return instance, true
+}
@@ -0,0 +1,60 @@
+package etcd
+
+import (
+	"github.com/jiangshuai341/zbus/discovery"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"sync"
+	"testing"
+)
+
+type recordWatcher struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordWatcher) OnServiceStatusChange(instance *ServiceInstance, eventType discovery.EventType) {
+	r.mu.Lock()
+	r.events = append(r.events, eventType.String()+" "+instance.Key()+" "+instance.Zone)
+	r.mu.Unlock()
+}
+
+func putEvent(instance ServiceInstance) *clientv3.Event {
+	val, _ := instance.Value()
+	return &clientv3.Event{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{Key: []byte(instance.Key()), Value: []byte(val)}}
+}
+
+func TestServiceCache_ReplayThenWatch(t *testing.T) {
+	c := newServiceCache(nil, "zbus", func() {})
+	close(c.done)
+	a := ServiceInstance{AppName: "zbus", ServiceName: "lobby", ServiceVersion: 1, ServiceID: 1, Zone: "a"}
+	b := ServiceInstance{AppName: "zbus", ServiceName: "match", ServiceVersion: 1, ServiceID: 2}
+	c.apply(1, []*clientv3.Event{putEvent(a), putEvent(b)})
+
+	lobby, all := &recordWatcher{}, &recordWatcher{}
+	c.Watch("lobby", lobby)
+	c.WatchAll(all)
+
+	a.Zone = "b"
+	c.apply(2, []*clientv3.Event{
+		putEvent(a),
+		{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: []byte(a.Key())}},
+	})
+	c.Close()
+
+	want := []string{"PUT zbus/lobby/1/1 a", "PUT zbus/lobby/1/1 b", "DELETE zbus/lobby/1/1 b"}
+	if len(lobby.events) != len(want) {
+		t.Fatalf("got %v want %v", lobby.events, want)
+	}
+	for i := range want {
+		if lobby.events[i] != want[i] {
+			t.Fatalf("got %v want %v", lobby.events, want)
+		}
+	}
+	if len(all.events) != 4 {
+		t.Fatalf("watch all got %v", all.events)
+	}
+	if len(c.List("lobby")) != 0 || len(c.List("match")) != 1 || c.Revision() != 2 {
+		t.Fatalf("unexpected cache state rev:%d", c.Revision())
+	}
+}
@@ -2,6 +2,7 @@ package etcd
 
 import (
 	"context"
+	"fmt"
 	"github.com/jiangshuai341/zbus/logger"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"time"
@@ -9,39 +10,59 @@ import (
 
 var log = logger.GetLogger("")
 
-type baseInfo struct {
-	appName        string
-	serviceName    string
-	serviceVersion int32
-	serviceID      int32
-	serviceUrl     []string
-}
+const (
+	dialTimeout    = 5 * time.Second
+	requestTimeout = 5 * time.Second
+)
 
 type Client struct {
 	discover
 }
 
-func NewClient(etcdEndpoints []string) *Client {
+// NewClient 连接etcd 服务发现的范围为 appName 下的所有服务
+// ctx 控制本次连接检查的超时 不影响Client的生命周期
+func NewClient(ctx context.Context, appName string, etcdEndpoints []string) (*Client, error) {
 	cfg := clientv3.Config{
 		Endpoints:   etcdEndpoints,
-		DialTimeout: 5 * time.Second,
+		DialTimeout: dialTimeout,
 	}
 
 	etcdConn, err := clientv3.New(cfg)
 	if err != nil {
-		panic("[NewService] connect to etcd failed err:" + err.Error())
+		return nil, fmt.Errorf("[NewClient] connect to etcd failed: %w", err)
+	}
+	// clientv3.New 不会等待连接建立 这里用一次请求确认etcd可用
+	if _, err = etcdConn.MemberList(ctx); err != nil {
+		_ = etcdConn.Close()
+		return nil, fmt.Errorf("[NewClient] etcd unavailable: %w", err)
 	}
 
 	return &Client{
 		discover: discover{
-			etcdCli: etcdConn,
+			appName:       appName,
+			etcdCli:       etcdConn,
+			etcdEndpoints: etcdEndpoints,
 		},
-	}
+	}, nil
+}
+
+// AppName 服务发现的范围
+func (s *Client) AppName() string {
+	return s.appName
+}
+
+// EtcdClient 底层的etcd连接 供配置中心等模块使用 不要Close
+func (s *Client) EtcdClient() *clientv3.Client {
+	return s.etcdCli
 }
 
+// Close 停止保活并撤销租约(删除注册信息) 然后关闭连接
 func (s *Client) Close() {
-	_, _ = s.etcdCli.Delete(context.TODO(), s.etcdkey())
-	_ = s.etcdCli.Close()
+	s.stopKeepalive()
+	s.closeServices()
 
-	s.watchServicesCallback.reset()
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	s.revoke(ctx)
+	cancel()
+	_ = s.etcdCli.Close()
 }
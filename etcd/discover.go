@@ -3,200 +3,141 @@ package etcd
 import (
 	"context"
 	"errors"
-	"go.etcd.io/etcd/api/v3/mvccpb"
+	"github.com/jiangshuai341/zbus/config"
+	"github.com/jiangshuai341/zbus/discovery"
 	clientv3 "go.etcd.io/etcd/client/v3"
-	"strconv"
-	"strings"
 	"sync"
+	"time"
 )
 
-// 服务器集群服务发现模块
+// 服务器集群服务发现模块 discovery.Backend 的etcd实现
+
+type (
+	ServiceInstance = discovery.ServiceInstance
+	IServiceWatcher = discovery.Watcher
+)
+
+var _ discovery.Backend = (*Client)(nil)
 
 type discover struct {
-	appName        string
-	serviceName    string
-	serviceVersion int32
-	serviceID      int32
-	serviceUrl     []string
-
-	etcdCli               *clientv3.Client //etcd client conn
-	etcdEndpoints         []string         //etcd cluster addr
-	watchServicesCallback watchServiceCallbackMaps
+	appName    string
+	instanceMu sync.Mutex
+	instance   ServiceInstance // 本进程注册的实例
+
+	healthMu      sync.Mutex
+	healthCheck   func(ctx context.Context) error
+	healthTimeout time.Duration
+
+	etcdCli       *clientv3.Client //etcd client conn
+	etcdEndpoints []string         //etcd cluster addr
+
+	cacheOnce sync.Once
+	cache     *ServiceCache
+
+	keepalive
+}
+
+var (
+	ErrNotConnected    = errors.New("please connect to etcd before regist")
+	ErrReservedService = errors.New("service name is reserved by config center")
+)
+
+func (d *discover) self() ServiceInstance {
+	d.instanceMu.Lock()
+	defer d.instanceMu.Unlock()
+	return d.instance
 }
 
 func (d *discover) etcdkey() string {
-	return d.appName + "/" + d.serviceName + "/" + strconv.Itoa(int(d.serviceVersion)) + "/" + strconv.Itoa(int(d.serviceID))
+	instance := d.self()
+	return instance.Key()
 }
 func (d *discover) etcdval() string {
-	var value string
-	for index, v := range d.serviceUrl {
-		if index == 0 {
-			value = v
-			continue
-		}
-		value = value + "," + v
+	instance := d.self()
+	value, err := instance.Value()
+	if err != nil {
+		log.Errorf("[etcdval] marshal service instance failed err:%s", err.Error())
 	}
 	return value
 }
-func (d *discover) RegisterAndKeepaliveToETCD(
-	appName string,
-	serviceName string,
-	serviceVersion int32,
-	serviceID int32,
-	serviceUrl []string,
-) {
+
+// Register 注册服务并保活 instance.AppName 为空时使用Client的appName
+// instance.State 为空时 设置了健康检查则以starting注册 检查通过后变为serving 否则直接serving
+// ctx 只控制首次注册 之后租约丢失(过期、etcd分区)时会在后台带退避地重新注册 状态通过 SetRegisterStatusCallback 通知
+func (d *discover) Register(ctx context.Context, instance ServiceInstance) error {
 	if d.etcdCli == nil {
-		panic(errors.New("please connect to etcd before regist"))
+		return ErrNotConnected
 	}
-
-	d.appName = appName
-	d.serviceName = serviceName
-	d.serviceVersion = serviceVersion
-	d.serviceID = serviceID
-	d.serviceUrl = serviceUrl
-
-	leaseResp, err := d.etcdCli.Grant(context.TODO(), 10)
-
-	if err != nil {
-		panic("[RegisterAndKeepaliveToETCD] Etcd Call Grant Error:" + err.Error())
+	if d.keepalive.running() {
+		return discovery.ErrAlreadyRegistered
 	}
-
-	if _, err := d.etcdCli.Put(context.TODO(), d.etcdkey(), d.etcdval(), clientv3.WithLease(leaseResp.ID)); err != nil {
-		panic("[RegisterAndKeepaliveToETCD] Etcd Call Put Error:" + err.Error())
+	if instance.ServiceName == config.ServiceName {
+		return ErrReservedService
 	}
-	ch, err := d.etcdCli.KeepAlive(context.TODO(), leaseResp.ID)
-	if err != nil {
-		panic("[RegisterAndKeepaliveToETCD] Etcd Call KeepAlive Error:" + err.Error())
+	if instance.AppName == "" {
+		instance.AppName = d.appName
 	}
-
-	go func() {
-		for resp := range ch {
-			if resp != nil {
-				log.Infof("Keepalive Client TTL:%d", resp.TTL)
-			} else {
-				log.Errorf("Keepalive Resp Unexpected NULL")
-			}
+	if instance.State == "" {
+		instance.State = discovery.StateServing
+		if d.healthChecker() != nil {
+			instance.State = discovery.StateStarting
 		}
-	}()
-}
-func parseEtcdKey(key []byte) (serviceName string, serviceVersion int, serviceID int, succ bool) {
-	strs := strings.Split(string(key), "/")
-	if len(strs) < 4 {
-		return
-	}
-	serviceName = strs[1]
-	var err error
-	serviceVersion, err = strconv.Atoi(strs[2])
-	if err != nil {
-		return
 	}
-	serviceID, err = strconv.Atoi(strs[3])
-	if err != nil {
-		return
-	}
-	succ = true
-	return
-}
-func parseEtcdValue(value []byte) (urls []string) {
-	return strings.Split(string(value), ",")
+	d.instanceMu.Lock()
+	d.instance = instance
+	d.instanceMu.Unlock()
+
+	return d.startKeepalive(ctx, d.etcdCli, d.etcdkey, d.etcdval, d.checkHealth)
 }
-func (d *discover) WatchService(serviceName string, watcher IServiceWatcher) {
-	keyPrefix := d.appName + "/" + serviceName
-	watchchan := d.etcdCli.Watch(context.TODO(), keyPrefix, clientv3.WithPrefix())
-
-	if d.watchServicesCallback.add(serviceName, watcher) {
-		go func() {
-			for w := range watchchan {
-				for _, e := range w.Events {
-					name, version, id, ok := parseEtcdKey(e.Kv.Key)
-					urls := parseEtcdValue(e.Kv.Value)
-					if !ok {
-						log.Errorf("[WatchService] parse watch result error key:%s val:%s", string(e.Kv.Key), string(e.Kv.Value))
-						continue
-					}
-					d.watchServicesCallback.execute(name, int32(version), int32(id), urls, e.Type)
-				}
-			}
-		}()
+
+// Deregister 停止保活并撤销租约
+func (d *discover) Deregister(ctx context.Context) error {
+	if !d.keepalive.running() {
+		return discovery.ErrNotRegistered
 	}
+	d.stopKeepalive()
+	d.revoke(ctx)
+	return nil
 }
 
-func (d *discover) WatchAllService(watcher IServiceWatcher) {
-	keyPrefix := d.appName
-	watchchan := d.etcdCli.Watch(context.TODO(), keyPrefix, clientv3.WithPrefix())
-
-	if d.watchServicesCallback.add("everyone", watcher) {
-		go func() {
-			for w := range watchchan {
-				for _, e := range w.Events {
-					name, version, id, ok := parseEtcdKey(e.Kv.Key)
-					urls := parseEtcdValue(e.Kv.Value)
-					if !ok {
-						log.Errorf("[WatchService] parse watch result error key:%s val:%s", string(e.Kv.Key), string(e.Kv.Value))
-						continue
-					}
-					d.watchServicesCallback.execute(name, int32(version), int32(id), urls, e.Type)
-				}
-			}
-		}()
-	}
+// Services 本地服务缓存 首次调用时创建
+func (d *discover) Services() *ServiceCache {
+	d.cacheOnce.Do(func() {
+		d.cache = NewServiceCache(d.etcdCli, d.appName)
+	})
+	return d.cache
 }
 
-func (d *discover) UnwatchService(serviceName string, watcher IServiceWatcher) {
-	d.watchServicesCallback.del(serviceName, watcher)
+func (d *discover) List(serviceName string) []*ServiceInstance {
+	return d.Services().List(serviceName)
 }
 
-type watchServiceCallbackMaps struct {
-	Map    map[string]map[IServiceWatcher]struct{}
-	rwLock sync.RWMutex
+func (d *discover) ListServing(serviceName string) []*ServiceInstance {
+	return d.Services().ListServing(serviceName)
 }
-type IServiceWatcher interface {
-	OnServiceStatusChange(name string, version int32, serviceId int32, urls []string, eventType mvccpb.Event_EventType)
+
+func (d *discover) Get(serviceName string, serviceID int32) (*ServiceInstance, bool) {
+	return d.Services().Get(serviceName, serviceID)
 }
-type callbackFun func(name string, version int32, serviceId int32, urls []string, eventType mvccpb.Event_EventType)
 
-func (m *watchServiceCallbackMaps) add(serviceName string, watcher IServiceWatcher) (isFirst bool) {
-	m.rwLock.Lock()
-	defer m.rwLock.Unlock()
-	if m.Map == nil {
-		m.Map = make(map[string]map[IServiceWatcher]struct{})
-	}
-	if m.Map[serviceName] == nil {
-		m.Map[serviceName] = make(map[IServiceWatcher]struct{})
-		isFirst = true
-	}
-	m.Map[serviceName][watcher] = struct{}{}
-	return
+// Watch 先回放缓存中已存在的实例 再推送增量 同一个watcher的回调串行有序
+func (d *discover) Watch(serviceName string, watcher IServiceWatcher) {
+	d.Services().Watch(serviceName, watcher)
 }
-func (m *watchServiceCallbackMaps) del(serviceName string, watcher IServiceWatcher) {
-	m.rwLock.Lock()
-	defer m.rwLock.Unlock()
-	if m.Map == nil {
-		return
-	}
-	if m.Map[serviceName] == nil {
-		return
-	}
-	delete(m.Map[serviceName], watcher)
+
+func (d *discover) WatchAll(watcher IServiceWatcher) {
+	d.Services().WatchAll(watcher)
 }
-func (m *watchServiceCallbackMaps) execute(serviceName string, serviceVersion int32, serviceId int32, urls []string, eventType mvccpb.Event_EventType) {
-	m.rwLock.RLock()
-	defer m.rwLock.RUnlock()
-	if m.Map == nil {
-		return
-	}
-	if m.Map[serviceName] == nil {
-		return
-	}
-	for watcher := range m.Map[serviceName] {
-		//todo:操作收到线程池
-		go watcher.OnServiceStatusChange(serviceName, serviceVersion, serviceId, urls, eventType)
-	}
+
+func (d *discover) Unwatch(serviceName string, watcher IServiceWatcher) {
+	d.Services().Unwatch(serviceName, watcher)
 }
-func (m *watchServiceCallbackMaps) reset() {
-	m.rwLock.Lock()
-	defer m.rwLock.Unlock()
-	m.Map = make(map[string]map[IServiceWatcher]struct{})
+
+func (d *discover) closeServices() {
+	d.cacheOnce.Do(func() {})
+	if d.cache != nil {
+		d.cache.Close()
+	}
 }
 
 //resp, err := cli.Put(ctx, "", "")
@@ -0,0 +1,189 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"sync"
+	"time"
+)
+
+// 选举与分布式锁的key不在 appName/ 前缀下 避免被服务发现当作服务实例解析
+const (
+	electionPrefix = "election/"
+	mutexPrefix    = "mutex/"
+)
+
+var ErrElectionClosed = errors.New("election already resigned")
+
+// Election 选举参与者 当选后持有leader直到 Resign、ctx结束或租约丢失
+// 租约丢失(etcd分区、进程卡顿超过TTL)后会自动重新参与选举
+type Election struct {
+	client   *Client
+	name     string
+	value    string
+	onChange func(isLeader bool)
+
+	mu       sync.Mutex
+	isLeader bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Campaign 在后台参与名为 electionName 的选举 立即返回
+// 当选和失去leader时调用 onChange 在选举协程中执行 不要阻塞
+// ctx 结束等同于 Resign
+func (s *Client) Campaign(ctx context.Context, electionName string, onChange func(isLeader bool)) (*Election, error) {
+	if s.etcdCli == nil {
+		return nil, ErrNotConnected
+	}
+	instance := s.self()
+	e := &Election{
+		client:   s,
+		name:     electionPrefix + s.appName + "/" + electionName,
+		value:    instance.Key(),
+		onChange: onChange,
+		done:     make(chan struct{}),
+	}
+	if instance.ServiceName == "" {
+		e.value = s.appName
+	}
+	var campaignCtx context.Context
+	campaignCtx, e.cancel = context.WithCancel(ctx)
+	go e.run(campaignCtx)
+	return e, nil
+}
+
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.isLeader
+}
+
+// Leader 当前leader的value 默认为leader实例的注册key
+func (e *Election) Leader(ctx context.Context) (string, error) {
+	resp, err := e.client.etcdCli.Get(ctx, e.name+"/", clientv3.WithFirstCreate()...)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", concurrency.ErrElectionNoLeader
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+// Resign 退出选举 是leader时主动让出 其他参与者立即可以当选
+func (e *Election) Resign(ctx context.Context) error {
+	select {
+	case <-e.done:
+		return ErrElectionClosed
+	default:
+	}
+	e.cancel()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *Election) run(ctx context.Context) {
+	defer close(e.done)
+	backoff := minRegisterBackoff
+	for {
+		err := e.campaignOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Errorf("[Election] %s campaign failed, retry after %v err:%s", e.name, backoff, err.Error())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = nextBackoff(backoff)
+			continue
+		}
+		backoff = minRegisterBackoff
+	}
+}
+
+// campaignOnce 一轮选举 当选后阻塞到失去leader
+func (e *Election) campaignOnce(ctx context.Context) error {
+	session, err := concurrency.NewSession(e.client.etcdCli, concurrency.WithTTL(defaultLeaseTTL), concurrency.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = session.Close() }()
+
+	election := concurrency.NewElection(session, e.name)
+	if err = election.Campaign(ctx, e.value); err != nil {
+		return err
+	}
+	e.setLeader(true)
+
+	select {
+	case <-session.Done():
+		log.Errorf("[Election] %s session lost, leadership released", e.name)
+	case <-ctx.Done():
+		resignCtx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		if err = election.Resign(resignCtx); err != nil {
+			log.Errorf("[Election] %s resign failed err:%s", e.name, err.Error())
+		}
+		cancel()
+	}
+	e.setLeader(false)
+	return nil
+}
+
+func (e *Election) setLeader(isLeader bool) {
+	e.mu.Lock()
+	changed := e.isLeader != isLeader
+	e.isLeader = isLeader
+	e.mu.Unlock()
+	if changed && e.onChange != nil {
+		e.onChange(isLeader)
+	}
+}
+
+// Mutex 分布式锁 绑定在独立的租约上 持有者进程崩溃后TTL内自动释放
+type Mutex struct {
+	session *concurrency.Session
+	mutex   *concurrency.Mutex
+}
+
+// Mutex 阻塞直到获得名为 name 的锁或 ctx 结束 ttl<=0 时使用默认租约时长(秒)
+func (s *Client) Mutex(ctx context.Context, name string, ttl int) (*Mutex, error) {
+	if s.etcdCli == nil {
+		return nil, ErrNotConnected
+	}
+	if ttl <= 0 {
+		ttl = defaultLeaseTTL
+	}
+	session, err := concurrency.NewSession(s.etcdCli, concurrency.WithTTL(ttl))
+	if err != nil {
+		return nil, err
+	}
+	m := concurrency.NewMutex(session, mutexPrefix+s.appName+"/"+name)
+	if err = m.Lock(ctx); err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	return &Mutex{session: session, mutex: m}, nil
+}
+
+// Done 租约丢失时关闭 此后锁不再受保护 持有者应停止临界区内的操作
+func (m *Mutex) Done() <-chan struct{} {
+	return m.session.Done()
+}
+
+// Unlock 释放锁并撤销租约
+func (m *Mutex) Unlock(ctx context.Context) error {
+	err := m.mutex.Unlock(ctx)
+	_ = m.session.Close()
+	return err
+}
@@ -0,0 +1,120 @@
+package etcd
+
+import (
+	"context"
+	"github.com/jiangshuai341/zbus/toolkit"
+	"go.etcd.io/etcd/server/v3/embed"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startEmbedEtcd 启动单节点内嵌etcd 测试结束时关闭
+func startEmbedEtcd(t *testing.T) []string {
+	t.Helper()
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientPort, _ := toolkit.GetFreePort()
+	peerPort, _ := toolkit.GetFreePort()
+	clientUrl, _ := url.Parse("http://127.0.0.1:" + strconv.Itoa(clientPort))
+	peerUrl, _ := url.Parse("http://127.0.0.1:" + strconv.Itoa(peerPort))
+	cfg.LCUrls, cfg.ACUrls = []url.URL{*clientUrl}, []url.URL{*clientUrl}
+	cfg.LPUrls, cfg.APUrls = []url.URL{*peerUrl}, []url.URL{*peerUrl}
+	cfg.InitialCluster = cfg.Name + "=" + peerUrl.String()
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("embed etcd start timeout")
+	}
+	return []string{clientUrl.String()}
+}
+
+func newTestClient(t *testing.T, endpoints []string) *Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cli, err := NewClient(ctx, "zbus", endpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cli.Close)
+	return cli
+}
+
+func TestCampaign(t *testing.T) {
+	endpoints := startEmbedEtcd(t)
+	a, b := newTestClient(t, endpoints), newTestClient(t, endpoints)
+	ctx := context.Background()
+
+	leaderA, leaderB := make(chan bool, 4), make(chan bool, 4)
+	ea, err := a.Campaign(ctx, "match-room", func(isLeader bool) { leaderA <- isLeader })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !<-leaderA {
+		t.Fatal("a should be elected first")
+	}
+	eb, err := b.Campaign(ctx, "match-room", func(isLeader bool) { leaderB <- isLeader })
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-leaderB:
+		t.Fatal("b elected while a is leader")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err = ea.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if <-leaderA {
+		t.Fatal("a should lose leadership after resign")
+	}
+	select {
+	case isLeader := <-leaderB:
+		if !isLeader || !eb.IsLeader() {
+			t.Fatal("b should be elected after a resigned")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("b not elected after a resigned")
+	}
+	if _, err = eb.Leader(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = eb.Resign(ctx)
+}
+
+func TestMutex(t *testing.T) {
+	endpoints := startEmbedEtcd(t)
+	a, b := newTestClient(t, endpoints), newTestClient(t, endpoints)
+	ctx := context.Background()
+
+	ma, err := a.Mutex(ctx, "rankings", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tryCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	if _, err = b.Mutex(tryCtx, "rankings", 0); err == nil {
+		t.Fatal("b acquired a held mutex")
+	}
+	cancel()
+
+	if err = ma.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lockCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	mb, err := b.Mutex(lockCtx, "rankings", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = mb.Unlock(ctx)
+}
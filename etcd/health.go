@@ -0,0 +1,91 @@
+package etcd
+
+import (
+	"context"
+	"github.com/jiangshuai341/zbus/discovery"
+	"time"
+)
+
+const defaultHealthCheckTimeout = time.Second
+
+// SetHealthCheck 设置本地健康检查 每次续约前执行 需在 Register 之前调用
+// 检查返回错误或超过 timeout 未返回(如死锁)时发布为unhealthy 并且不续约
+// 持续失败超过租约TTL后实例从etcd中消失 恢复后重新注册并发布为serving
+// timeout<=0 时为1秒
+func (d *discover) SetHealthCheck(check func(ctx context.Context) error, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	d.healthMu.Lock()
+	d.healthCheck, d.healthTimeout = check, timeout
+	d.healthMu.Unlock()
+}
+
+func (d *discover) healthChecker() func(ctx context.Context) error {
+	d.healthMu.Lock()
+	defer d.healthMu.Unlock()
+	return d.healthCheck
+}
+
+// checkHealth 在保活协程中执行 检查本身卡住时不会阻塞保活协程 返回检查的结果
+func (d *discover) checkHealth(ctx context.Context) error {
+	check := d.healthChecker()
+	if check == nil {
+		return nil
+	}
+	d.healthMu.Lock()
+	timeout := d.healthTimeout
+	d.healthMu.Unlock()
+
+	checkCtx, cancelCheck := context.WithTimeout(ctx, timeout)
+	result := make(chan error, 1)
+	go func() { result <- check(checkCtx) }()
+	var err error
+	select {
+	case err = <-result:
+	case <-checkCtx.Done():
+		err = checkCtx.Err()
+	}
+	cancelCheck()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// 与 Drain 并发时不能覆盖draining 所以在锁内计算下一个状态
+	d.instanceMu.Lock()
+	cur := d.instance.State
+	next := discovery.NextState(cur, err == nil)
+	d.instance.State = next
+	d.instanceMu.Unlock()
+	if next == cur {
+		return err
+	}
+	if err != nil {
+		log.Errorf("[checkHealth] %s health check failed err:%s", d.etcdkey(), err.Error())
+	}
+	opCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	if publishErr := d.republish(opCtx); publishErr != nil {
+		log.Errorf("[checkHealth] %s publish state %s failed err:%s", d.etcdkey(), next, publishErr.Error())
+	}
+	return err
+}
+
+// SetHealthState 修改本实例的健康状态并立即写入etcd
+func (d *discover) SetHealthState(ctx context.Context, state discovery.HealthState) error {
+	if !d.keepalive.running() {
+		return discovery.ErrNotRegistered
+	}
+	d.instanceMu.Lock()
+	d.instance.State = state
+	d.instanceMu.Unlock()
+
+	opCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	return d.republish(opCtx)
+}
+
+// Drain 标记为draining 此后健康检查不再改变状态
+func (d *discover) Drain(ctx context.Context) error {
+	return d.SetHealthState(ctx, discovery.StateDraining)
+}
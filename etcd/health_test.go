@@ -0,0 +1,92 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/discovery"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitState(t *testing.T, cli *Client, want discovery.HealthState) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if v, ok := cli.Get("lobby", 1); ok && v.State == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			v, _ := cli.Get("lobby", 1)
+			t.Fatalf("state not %s: %+v", want, v)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	endpoints := startEmbedEtcd(t)
+	service, consumer := newTestClient(t, endpoints), newTestClient(t, endpoints)
+	ctx := context.Background()
+
+	var healthy int32 = 1
+	service.SetHealthCheck(func(ctx context.Context) error {
+		if atomic.LoadInt32(&healthy) == 0 {
+			<-ctx.Done() // 模拟死锁
+			return errors.New("stuck")
+		}
+		return nil
+	}, 100*time.Millisecond)
+	if err := service.Register(ctx, ServiceInstance{ServiceName: "lobby", ServiceVersion: 1, ServiceID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, consumer, discovery.StateServing)
+
+	atomic.StoreInt32(&healthy, 0)
+	waitState(t, consumer, discovery.StateUnhealthy)
+	if len(consumer.ListServing("lobby")) != 0 {
+		t.Fatal("unhealthy instance listed as serving")
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	waitState(t, consumer, discovery.StateServing)
+	if err := service.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, consumer, discovery.StateDraining)
+}
+
+// 健康检查持续失败时不续约 租约过期后实例消失 恢复后重新注册
+func TestHealthCheck_StopsRenew(t *testing.T) {
+	endpoints := startEmbedEtcd(t)
+	service, consumer := newTestClient(t, endpoints), newTestClient(t, endpoints)
+	ctx := context.Background()
+
+	var healthy int32 = 1
+	service.SetHealthCheck(func(ctx context.Context) error {
+		if atomic.LoadInt32(&healthy) == 0 {
+			return errors.New("unhealthy")
+		}
+		return nil
+	}, 100*time.Millisecond)
+	if err := service.Register(ctx, ServiceInstance{ServiceName: "lobby", ServiceVersion: 1, ServiceID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, consumer, discovery.StateServing)
+
+	atomic.StoreInt32(&healthy, 0)
+	waitState(t, consumer, discovery.StateUnhealthy)
+	deadline := time.Now().Add(defaultLeaseTTL*time.Second + 2*renewInterval)
+	for {
+		if _, ok := consumer.Get("lobby", 1); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lease renewed while health check failing")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	waitState(t, consumer, discovery.StateServing)
+}
@@ -0,0 +1,235 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLeaseTTL = 10 // 秒
+	renewInterval   = defaultLeaseTTL * time.Second / 3
+
+	minRegisterBackoff = 100 * time.Millisecond
+	maxRegisterBackoff = 10 * time.Second
+)
+
+var ErrKeepaliveClosed = errors.New("etcd lease lost")
+
+type RegisterStatus int32
+
+const (
+	Registered   RegisterStatus = iota // 首次注册成功
+	RegisterLost                       // 保活中断 服务已从etcd中消失
+	Reregistered                       // 中断后重新注册成功
+)
+
+func (s RegisterStatus) String() string {
+	switch s {
+	case Registered:
+		return "Registered"
+	case RegisterLost:
+		return "RegisterLost"
+	case Reregistered:
+		return "Reregistered"
+	default:
+		return "Unknown"
+	}
+}
+
+// keepalive 租约保活监督者
+// 由监督协程主动续约(而不是clientv3内部的KeepAlive协程) 每次续约前先调用 beforeRenew 做健康检查
+// 检查失败时跳过本次续约 持续失败超过租约TTL后租约过期 实例从etcd中消失
+// 续约失败且租约已过期时重新申请租约并写入注册信息
+type keepalive struct {
+	mu          sync.Mutex
+	cli         *clientv3.Client
+	key         func() string
+	val         func() string
+	beforeRenew func(ctx context.Context) error
+	leaseID     clientv3.LeaseID
+	onStatus    func(status RegisterStatus, err error)
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// SetRegisterStatusCallback 注册状态变化回调 在保活协程中执行 不要阻塞
+func (k *keepalive) SetRegisterStatusCallback(fn func(status RegisterStatus, err error)) {
+	k.mu.Lock()
+	k.onStatus = fn
+	k.mu.Unlock()
+}
+
+func (k *keepalive) running() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.cancel != nil
+}
+
+func (k *keepalive) startKeepalive(ctx context.Context, cli *clientv3.Client, key func() string, val func() string, beforeRenew func(ctx context.Context) error) error {
+	k.mu.Lock()
+	k.cli, k.key, k.val, k.beforeRenew = cli, key, val, beforeRenew
+	k.mu.Unlock()
+
+	if err := k.grantAndPut(ctx); err != nil {
+		return err
+	}
+
+	superviseCtx, cancel := context.WithCancel(context.Background())
+	k.mu.Lock()
+	k.cancel = cancel
+	k.done = make(chan struct{})
+	k.mu.Unlock()
+
+	go k.supervise(superviseCtx)
+	k.emit(Registered, nil)
+	return nil
+}
+
+// grantAndPut 申请租约 写入注册信息
+func (k *keepalive) grantAndPut(ctx context.Context) error {
+	leaseResp, err := k.cli.Grant(ctx, defaultLeaseTTL)
+	if err != nil {
+		return fmt.Errorf("etcd grant lease failed: %w", err)
+	}
+	if _, err = k.cli.Put(ctx, k.key(), k.val(), clientv3.WithLease(leaseResp.ID)); err != nil {
+		_, _ = k.cli.Revoke(ctx, leaseResp.ID)
+		return fmt.Errorf("etcd put %s failed: %w", k.key(), err)
+	}
+	k.mu.Lock()
+	k.leaseID = leaseResp.ID
+	k.mu.Unlock()
+	return nil
+}
+
+// republish 注册信息变化后(如健康状态)用当前租约重新写入
+func (k *keepalive) republish(ctx context.Context) error {
+	k.mu.Lock()
+	leaseID := k.leaseID
+	k.mu.Unlock()
+	if leaseID == 0 {
+		return ErrKeepaliveClosed
+	}
+	_, err := k.cli.Put(ctx, k.key(), k.val(), clientv3.WithLease(leaseID))
+	return err
+}
+
+func (k *keepalive) lease() clientv3.LeaseID {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.leaseID
+}
+
+func (k *keepalive) supervise(ctx context.Context) {
+	defer close(k.done)
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+	lastRenew := time.Now()
+	// 注册后立即检查一次 尽快从starting变为serving
+	if k.beforeRenew != nil {
+		_ = k.beforeRenew(ctx)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if k.beforeRenew != nil {
+			if err := k.beforeRenew(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				// 不续约 检查恢复后续约时发现租约已过期会重新注册
+				log.Warnf("[keepalive] %s health check failed, skip renew err:%s", k.key(), err.Error())
+				continue
+			}
+		}
+
+		opCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+		resp, err := k.cli.KeepAliveOnce(opCtx, k.lease())
+		cancel()
+		if err == nil {
+			lastRenew = time.Now()
+			log.Debugf("Keepalive Client TTL:%d", resp.TTL)
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		// 临时的网络错误 租约还没过期时下次继续续约
+		if !errors.Is(err, rpctypes.ErrLeaseNotFound) && time.Since(lastRenew) < defaultLeaseTTL*time.Second {
+			log.Warnf("[keepalive] %s renew failed err:%s", k.key(), err.Error())
+			continue
+		}
+		log.Errorf("[keepalive] %s lease lost, re-register err:%s", k.key(), err.Error())
+		k.emit(RegisterLost, ErrKeepaliveClosed)
+
+		for backoff := minRegisterBackoff; ; backoff = nextBackoff(backoff) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			opCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+			err = k.grantAndPut(opCtx)
+			cancel()
+			if err == nil {
+				break
+			}
+			log.Errorf("[keepalive] %s re-register failed, waited %v err:%s", k.key(), backoff, err.Error())
+		}
+		lastRenew = time.Now()
+		k.emit(Reregistered, nil)
+	}
+}
+
+// nextBackoff 指数退避 带±20%抖动 避免大量实例同时重连
+func nextBackoff(cur time.Duration) time.Duration {
+	next := cur * 2
+	if next > maxRegisterBackoff {
+		next = maxRegisterBackoff
+	}
+	return next - next/5 + time.Duration(rand.Int63n(int64(next/5)*2+1))
+}
+
+func (k *keepalive) emit(status RegisterStatus, err error) {
+	k.mu.Lock()
+	fn := k.onStatus
+	k.mu.Unlock()
+	if fn != nil {
+		fn(status, err)
+	}
+}
+
+// stopKeepalive 停止监督协程 不撤销租约
+func (k *keepalive) stopKeepalive() {
+	k.mu.Lock()
+	cancel, done := k.cancel, k.done
+	k.cancel = nil
+	k.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// revoke 撤销租约 etcd会删除绑定在租约上的注册信息
+func (k *keepalive) revoke(ctx context.Context) {
+	k.mu.Lock()
+	leaseID := k.leaseID
+	k.leaseID = 0
+	k.mu.Unlock()
+	if leaseID == 0 {
+		return
+	}
+	if _, err := k.cli.Revoke(ctx, leaseID); err != nil {
+		log.Errorf("[keepalive] revoke lease %x failed err:%s", leaseID, err.Error())
+	}
+}
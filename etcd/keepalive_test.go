@@ -0,0 +1,53 @@
+package etcd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func waitStatus(t *testing.T, statuses <-chan RegisterStatus, want RegisterStatus) {
+	t.Helper()
+	select {
+	case got := <-statuses:
+		if got != want {
+			t.Fatalf("status %s want %s", got, want)
+		}
+	case <-time.After(3 * renewInterval):
+		t.Fatalf("status %s timeout", want)
+	}
+}
+
+func TestKeepalive_Reregister(t *testing.T) {
+	endpoints := startEmbedEtcd(t)
+	service := newTestClient(t, endpoints)
+	ctx := context.Background()
+
+	statuses := make(chan RegisterStatus, 8)
+	service.SetRegisterStatusCallback(func(status RegisterStatus, err error) {
+		statuses <- status
+	})
+	if err := service.Register(ctx, ServiceInstance{ServiceName: "lobby", ServiceVersion: 1, ServiceID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, statuses, Registered)
+
+	key, oldLease := service.etcdkey(), service.lease()
+	// 模拟租约过期 注册信息随租约一起删除
+	if _, err := service.EtcdClient().Revoke(ctx, oldLease); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := service.EtcdClient().Get(ctx, key); err != nil || resp.Count != 0 {
+		t.Fatalf("key not deleted with lease: %v %v", resp, err)
+	}
+
+	waitStatus(t, statuses, RegisterLost)
+	waitStatus(t, statuses, Reregistered)
+	resp, err := service.EtcdClient().Get(ctx, key)
+	if err != nil || resp.Count != 1 {
+		t.Fatalf("key not re-registered: %v %v", resp, err)
+	}
+	if lease := resp.Kvs[0].Lease; lease == int64(oldLease) || lease != int64(service.lease()) {
+		t.Fatalf("re-registered with lease %x old %x current %x", lease, oldLease, service.lease())
+	}
+}
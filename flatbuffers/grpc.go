@@ -6,15 +6,25 @@ var Codec = "flatbuffers"
 // FlatbuffersCodec defines the interface gRPC uses to encode and decode messages.  Note
 // that implementations of this interface must be thread safe; a Codec's
 // methods can be called from concurrent goroutines.
-type FlatbuffersCodec struct{}
+type FlatbuffersCodec struct {
+	// SkipVerify trusts the peer, Unmarshal does not run the Verifier.
+	SkipVerify bool
+}
 
 // Marshal returns the wire format of v.
 func (FlatbuffersCodec) Marshal(v interface{}) ([]byte, error) {
 	return v.(*Builder).FinishedBytes(), nil
 }
 
-// Unmarshal parses the wire format into v.
-func (FlatbuffersCodec) Unmarshal(data []byte, v interface{}) error {
+// Unmarshal parses the wire format into v. Unless SkipVerify is set the
+// buffer is verified first, see VerifyRoot. v must then have a generated
+// Verify method, types without one fail with ErrNotVerifiable.
+func (c FlatbuffersCodec) Unmarshal(data []byte, v interface{}) error {
+	if !c.SkipVerify {
+		if err := VerifyRoot(data, v); err != nil {
+			return err
+		}
+	}
 	v.(flatbuffersInit).Init(data, GetUOffsetT(data))
 	return nil
 }
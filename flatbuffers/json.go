@@ -0,0 +1,755 @@
+package flatbuffers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ToJSON and FromJSON convert any table described by a Schema, like flatc
+// -t and flatc -b do, without generated code. The JSON has the field names of
+// the schema, enums are written by name, a union is written as the value of
+// the union field plus its name in the field with the "_type" suffix. NaN and
+// infinities are written as the strings "nan", "inf" and "-inf". Vectors of
+// unions and 64 bit offsets are not supported.
+
+// UnionTypeFieldSuffix is appended to a union field name to get the field
+// holding its type, like flatc does.
+const UnionTypeFieldSuffix = "_type"
+
+// Verify checks buf as a buffer whose root is rootType, "" means the root
+// table of the schema.
+func (s *Schema) Verify(rootType string, buf []byte) error {
+	obj, err := s.rootObject(rootType)
+	if err != nil {
+		return err
+	}
+	return s.verify(obj, buf)
+}
+
+func (s *Schema) verify(obj *Object, buf []byte) error {
+	return NewVerifier(buf, VerifierOptions{}).VerifyBuffer("", func(v *Verifier, pos UOffsetT) error {
+		return s.verifyTable(v, obj, pos)
+	})
+}
+
+func (s *Schema) rootObject(rootType string) (*Object, error) {
+	obj := s.RootTable
+	if rootType != "" {
+		obj = s.Object(rootType)
+	}
+	switch {
+	case obj == nil && rootType == "":
+		return nil, fmt.Errorf("%w: no root_table", ErrSchema)
+	case obj == nil:
+		return nil, fmt.Errorf("%w: no table %s", ErrSchema, rootType)
+	case obj.IsStruct:
+		return nil, fmt.Errorf("%w: %s is a struct", ErrSchema, obj.Name)
+	}
+	return obj, nil
+}
+
+func (s *Schema) verifyTable(v *Verifier, obj *Object, pos UOffsetT) error {
+	if err := v.VerifyTableStart(pos); err != nil {
+		return err
+	}
+	defer v.VerifyTableEnd()
+	for _, f := range obj.Fields {
+		if f.Deprecated {
+			continue
+		}
+		if err := s.verifyField(v, obj, f, pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) verifyField(v *Verifier, obj *Object, f *Field, pos UOffsetT) error {
+	t, slot := f.Type, VOffsetT(f.Offset)
+	if size := UOffsetT(t.BaseType.ScalarSize()); size > 0 {
+		return v.VerifyField(pos, slot, size, size, f.Required)
+	}
+	switch t.BaseType {
+	case BaseTypeString:
+		return v.VerifyStringField(pos, slot, f.Required)
+	case BaseTypeObj:
+		o := s.Objects[t.Index]
+		if o.IsStruct {
+			return v.VerifyField(pos, slot, UOffsetT(o.ByteSize), UOffsetT(o.MinAlign), f.Required)
+		}
+		return v.VerifyTableField(pos, slot, f.Required, func(v *Verifier, pos UOffsetT) error {
+			return s.verifyTable(v, o, pos)
+		})
+	case BaseTypeUnion:
+		enum := s.Enums[t.Index]
+		return v.VerifyUnionField(pos, slot-SizeVOffsetT, slot, f.Required, func(v *Verifier, typ byte, pos UOffsetT) error {
+			return s.verifyUnion(v, enum, typ, pos)
+		})
+	case BaseTypeVector:
+		if f.Offset64 {
+			break
+		}
+		if size := UOffsetT(t.Element.ScalarSize()); size > 0 {
+			return v.VerifyVectorField(pos, slot, size, size, f.Required)
+		}
+		switch t.Element {
+		case BaseTypeString:
+			return v.VerifyVectorOfStringsField(pos, slot, f.Required)
+		case BaseTypeObj:
+			o := s.Objects[t.Index]
+			if o.IsStruct {
+				return v.VerifyVectorField(pos, slot, UOffsetT(o.ByteSize), UOffsetT(o.MinAlign), f.Required)
+			}
+			return v.VerifyVectorOfTablesField(pos, slot, f.Required, func(v *Verifier, pos UOffsetT) error {
+				return s.verifyTable(v, o, pos)
+			})
+		}
+	}
+	return fmt.Errorf("%w: %s.%s: %s of %s is not supported", ErrSchema, obj.Name, f.Name, t.BaseType, t.Element)
+}
+
+// verifyUnion values of unknown types are not checked and not read.
+func (s *Schema) verifyUnion(v *Verifier, enum *Enum, typ byte, pos UOffsetT) error {
+	ev := enum.Lookup(int64(typ))
+	if ev == nil {
+		return nil
+	}
+	switch ev.UnionType.BaseType {
+	case BaseTypeObj:
+		o := s.Objects[ev.UnionType.Index]
+		if o.IsStruct {
+			return v.check(uint64(pos), uint64(o.ByteSize), uint64(o.MinAlign), "struct")
+		}
+		return s.verifyTable(v, o, pos)
+	case BaseTypeString:
+		return v.VerifyString(pos)
+	}
+	return nil
+}
+
+// ToJSON converts buf whose root is rootType to JSON, "" means the root table
+// of the schema. buf is verified first. Fields are written in schema order,
+// absent fields are omitted.
+func ToJSON(schema *Schema, rootType string, buf []byte) ([]byte, error) {
+	obj, err := schema.rootObject(rootType)
+	if err != nil {
+		return nil, err
+	}
+	if err = schema.verify(obj, buf); err != nil {
+		return nil, err
+	}
+	w := jsonWriter{s: schema, buf: buf}
+	w.table(obj, GetUOffsetT(buf))
+	return w.out, nil
+}
+
+// jsonWriter reads a buffer that passed Schema.verify.
+type jsonWriter struct {
+	s   *Schema
+	buf []byte
+	out []byte
+}
+
+func (w *jsonWriter) table(obj *Object, pos UOffsetT) {
+	t := Table{Bytes: w.buf, Pos: pos}
+	w.out = append(w.out, '{')
+	first := true
+	for _, f := range obj.Fields {
+		if f.Deprecated {
+			continue
+		}
+		o := UOffsetT(t.Offset(VOffsetT(f.Offset)))
+		if o == 0 {
+			continue
+		}
+		if f.Type.BaseType == BaseTypeUnion && w.unionType(f, t) == nil {
+			continue
+		}
+		if !first {
+			w.out = append(w.out, ',')
+		}
+		first = false
+		w.string(f.Name)
+		w.out = append(w.out, ':')
+		w.field(f, t, pos+o)
+	}
+	w.out = append(w.out, '}')
+}
+
+// unionType is nil for NONE and types unknown to the schema.
+func (w *jsonWriter) unionType(f *Field, t Table) *EnumVal {
+	typ := t.GetUint8Slot(VOffsetT(f.Offset)-SizeVOffsetT, 0)
+	if typ == 0 {
+		return nil
+	}
+	ev := w.s.Enums[f.Type.Index].Lookup(int64(typ))
+	if ev == nil || ev.UnionType.BaseType != BaseTypeObj && ev.UnionType.BaseType != BaseTypeString {
+		return nil
+	}
+	return ev
+}
+
+// field at is the position of the field in the table.
+func (w *jsonWriter) field(f *Field, t Table, at UOffsetT) {
+	typ := f.Type
+	switch typ.BaseType {
+	case BaseTypeString:
+		w.string(string(t.ByteVector(at)))
+	case BaseTypeObj:
+		if o := w.s.Objects[typ.Index]; o.IsStruct {
+			w.structValue(o, at)
+		} else {
+			w.table(o, t.Indirect(at))
+		}
+	case BaseTypeUnion:
+		ev := w.unionType(f, t)
+		if ev.UnionType.BaseType == BaseTypeString {
+			w.string(string(t.ByteVector(at)))
+		} else if o := w.s.Objects[ev.UnionType.Index]; o.IsStruct {
+			w.structValue(o, t.Indirect(at))
+		} else {
+			w.table(o, t.Indirect(at))
+		}
+	case BaseTypeVector:
+		vec := t.Indirect(at)
+		n := int(GetUOffsetT(w.buf[vec:]))
+		w.elements(typ, vec+SizeUOffsetT, n)
+	default:
+		w.scalar(typ.BaseType, typ.Index, at)
+	}
+}
+
+// elements writes n elements of a vector or an array starting at data.
+func (w *jsonWriter) elements(typ Type, data UOffsetT, n int) {
+	t := Table{Bytes: w.buf}
+	w.out = append(w.out, '[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			w.out = append(w.out, ',')
+		}
+		switch typ.Element {
+		case BaseTypeString:
+			w.string(string(t.ByteVector(data + UOffsetT(i)*SizeUOffsetT)))
+		case BaseTypeObj:
+			if o := w.s.Objects[typ.Index]; o.IsStruct {
+				w.structValue(o, data+UOffsetT(i*o.ByteSize))
+			} else {
+				w.table(o, t.Indirect(data+UOffsetT(i)*SizeUOffsetT))
+			}
+		default:
+			w.scalar(typ.Element, typ.Index, data+UOffsetT(i*typ.Element.ScalarSize()))
+		}
+	}
+	w.out = append(w.out, ']')
+}
+
+func (w *jsonWriter) structValue(obj *Object, pos UOffsetT) {
+	w.out = append(w.out, '{')
+	for i, f := range obj.Fields {
+		if i > 0 {
+			w.out = append(w.out, ',')
+		}
+		w.string(f.Name)
+		w.out = append(w.out, ':')
+		at := pos + UOffsetT(f.Offset)
+		switch f.Type.BaseType {
+		case BaseTypeObj:
+			w.structValue(w.s.Objects[f.Type.Index], at)
+		case BaseTypeArray:
+			w.elements(f.Type, at, f.Type.FixedLength)
+		default:
+			w.scalar(f.Type.BaseType, f.Type.Index, at)
+		}
+	}
+	w.out = append(w.out, '}')
+}
+
+func (w *jsonWriter) scalar(bt BaseType, index int, at UOffsetT) {
+	b := w.buf[at:]
+	switch bt {
+	case BaseTypeBool:
+		w.out = strconv.AppendBool(w.out, GetBool(b))
+		return
+	case BaseTypeFloat:
+		w.float(float64(GetFloat32(b)), 32)
+		return
+	case BaseTypeDouble:
+		w.float(GetFloat64(b), 64)
+		return
+	}
+	var n int64
+	switch bt {
+	case BaseTypeByte:
+		n = int64(GetInt8(b))
+	case BaseTypeUType, BaseTypeUByte:
+		n = int64(GetUint8(b))
+	case BaseTypeShort:
+		n = int64(GetInt16(b))
+	case BaseTypeUShort:
+		n = int64(GetUint16(b))
+	case BaseTypeInt:
+		n = int64(GetInt32(b))
+	case BaseTypeUInt:
+		n = int64(GetUint32(b))
+	case BaseTypeLong, BaseTypeULong:
+		n = GetInt64(b)
+	}
+	if index >= 0 {
+		if name, ok := enumName(w.s.Enums[index], n); ok {
+			w.string(name)
+			return
+		}
+	}
+	if bt == BaseTypeULong {
+		w.out = strconv.AppendUint(w.out, uint64(n), 10)
+	} else {
+		w.out = strconv.AppendInt(w.out, n, 10)
+	}
+}
+
+// enumName bit_flags enums are written as space separated names like flatc.
+func enumName(e *Enum, n int64) (string, bool) {
+	if v := e.Lookup(n); v != nil {
+		return v.Name, true
+	}
+	if _, ok := e.Attributes["bit_flags"]; !ok || n == 0 {
+		return "", false
+	}
+	var names []string
+	rest := n
+	for _, v := range e.Values {
+		if v.Value != 0 && n&v.Value == v.Value {
+			names = append(names, v.Name)
+			rest &^= v.Value
+		}
+	}
+	return strings.Join(names, " "), rest == 0
+}
+
+func (w *jsonWriter) float(f float64, bits int) {
+	switch {
+	case math.IsNaN(f):
+		w.out = append(w.out, `"nan"`...)
+	case math.IsInf(f, 1):
+		w.out = append(w.out, `"inf"`...)
+	case math.IsInf(f, -1):
+		w.out = append(w.out, `"-inf"`...)
+	default:
+		w.out = strconv.AppendFloat(w.out, f, 'g', -1, bits)
+	}
+}
+
+func (w *jsonWriter) string(s string) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	w.out = append(w.out, bytes.TrimSuffix(b.Bytes(), []byte{'\n'})...)
+}
+
+// FromJSON builds a buffer whose root is rootType from JSON, "" means the
+// root table of the schema. The file identifier of the schema is written when
+// the root is the root table of the schema. Fields equal to their default are
+// not written unless they are optional scalars. Unknown and deprecated fields
+// are errors.
+func FromJSON(schema *Schema, rootType string, data []byte) ([]byte, error) {
+	obj, err := schema.rootObject(rootType)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err = dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("flatbuffers: trailing data after JSON value")
+	}
+	r := jsonReader{s: schema, b: NewBuilder(len(data))}
+	root, err := r.table(obj, v)
+	if err != nil {
+		return nil, err
+	}
+	if obj == schema.RootTable && len(schema.FileIdent) == fileIdentifierLength {
+		r.b.FinishWithFileIdentifier(root, []byte(schema.FileIdent))
+	} else {
+		r.b.Finish(root)
+	}
+	return r.b.FinishedBytes(), nil
+}
+
+type jsonReader struct {
+	s *Schema
+	b *Builder
+}
+
+func (r *jsonReader) errorf(obj *Object, f *Field, format string, args ...interface{}) error {
+	return fmt.Errorf("flatbuffers: %s.%s: %s", obj.Name, f.Name, fmt.Sprintf(format, args...))
+}
+
+func (r *jsonReader) table(obj *Object, v interface{}) (UOffsetT, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("flatbuffers: %s: want a JSON object, got %T", obj.Name, v)
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if f := obj.Field(name); f == nil || f.Deprecated {
+			return 0, fmt.Errorf("flatbuffers: %s has no field %s", obj.Name, name)
+		}
+	}
+
+	// strings vectors and tables are written before the table
+	offsets := make(map[*Field]UOffsetT)
+	numFields := 0
+	for _, f := range obj.Fields {
+		if int(f.ID) >= numFields {
+			numFields = int(f.ID) + 1
+		}
+		fv, ok := m[f.Name]
+		if !ok || fv == nil {
+			if f.Required {
+				return 0, r.errorf(obj, f, "required field is missing")
+			}
+			continue
+		}
+		var off UOffsetT
+		var err error
+		switch f.Type.BaseType {
+		case BaseTypeString:
+			s, ok := fv.(string)
+			if !ok {
+				return 0, r.errorf(obj, f, "want a string, got %T", fv)
+			}
+			off = r.b.CreateString(s)
+		case BaseTypeObj:
+			if o := r.s.Objects[f.Type.Index]; !o.IsStruct {
+				off, err = r.table(o, fv)
+			}
+		case BaseTypeUnion:
+			off, err = r.union(obj, f, m, fv)
+		case BaseTypeVector:
+			off, err = r.vector(obj, f, fv)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if off != 0 {
+			offsets[f] = off
+		}
+	}
+
+	r.b.StartObject(numFields)
+	for _, f := range obj.Fields {
+		fv, ok := m[f.Name]
+		if !ok || fv == nil {
+			continue
+		}
+		if off, ok := offsets[f]; ok {
+			r.b.PrependUOffsetTSlot(int(f.ID), off, 0)
+			continue
+		}
+		switch f.Type.BaseType {
+		case BaseTypeObj:
+			o := r.s.Objects[f.Type.Index]
+			raw, err := r.structBytes(o, fv)
+			if err != nil {
+				return 0, err
+			}
+			r.placeStruct(o, raw)
+			r.b.Slot(int(f.ID))
+		default:
+			bits, err := r.scalar(f.Type.BaseType, f.Type.Index, fv)
+			if err != nil {
+				return 0, r.errorf(obj, f, "%s", err)
+			}
+			if !f.Optional && bits == defaultBits(f) {
+				continue
+			}
+			r.prependScalar(f.Type.BaseType, bits)
+			r.b.Slot(int(f.ID))
+		}
+	}
+	return r.b.EndObject(), nil
+}
+
+// union the type is taken from the "_type" field next to the union.
+func (r *jsonReader) union(obj *Object, f *Field, m map[string]interface{}, fv interface{}) (UOffsetT, error) {
+	tv, ok := m[f.Name+UnionTypeFieldSuffix]
+	if !ok {
+		return 0, r.errorf(obj, f, "missing %s%s", f.Name, UnionTypeFieldSuffix)
+	}
+	typ, err := r.scalar(BaseTypeUType, f.Type.Index, tv)
+	if err != nil {
+		return 0, r.errorf(obj, f, "%s", err)
+	}
+	ev := r.s.Enums[f.Type.Index].Lookup(int64(typ))
+	if ev == nil || ev.Value == 0 {
+		return 0, r.errorf(obj, f, "bad union type %v", tv)
+	}
+	switch ev.UnionType.BaseType {
+	case BaseTypeString:
+		s, ok := fv.(string)
+		if !ok {
+			return 0, r.errorf(obj, f, "want a string, got %T", fv)
+		}
+		return r.b.CreateString(s), nil
+	case BaseTypeObj:
+		o := r.s.Objects[ev.UnionType.Index]
+		if !o.IsStruct {
+			return r.table(o, fv)
+		}
+		raw, err := r.structBytes(o, fv)
+		if err != nil {
+			return 0, err
+		}
+		r.placeStruct(o, raw)
+		return r.b.Offset(), nil
+	}
+	return 0, r.errorf(obj, f, "union member %s is not supported", ev.Name)
+}
+
+func (r *jsonReader) vector(obj *Object, f *Field, fv interface{}) (UOffsetT, error) {
+	list, ok := fv.([]interface{})
+	if !ok {
+		return 0, r.errorf(obj, f, "want a JSON array, got %T", fv)
+	}
+	n := len(list)
+	typ := f.Type
+	if f.Offset64 {
+		return 0, r.errorf(obj, f, "64 bit offsets are not supported")
+	}
+	switch typ.Element {
+	case BaseTypeString, BaseTypeObj:
+		if typ.Element == BaseTypeObj && r.s.Objects[typ.Index].IsStruct {
+			o := r.s.Objects[typ.Index]
+			raws := make([][]byte, n)
+			for i, e := range list {
+				raw, err := r.structBytes(o, e)
+				if err != nil {
+					return 0, err
+				}
+				raws[i] = raw
+			}
+			r.b.StartVector(o.ByteSize, n, o.MinAlign)
+			for i := n - 1; i >= 0; i-- {
+				r.placeStruct(o, raws[i])
+			}
+			return r.b.EndVector(n), nil
+		}
+		offs := make([]UOffsetT, n)
+		for i, e := range list {
+			if typ.Element == BaseTypeString {
+				s, ok := e.(string)
+				if !ok {
+					return 0, r.errorf(obj, f, "element %d: want a string, got %T", i, e)
+				}
+				offs[i] = r.b.CreateString(s)
+				continue
+			}
+			off, err := r.table(r.s.Objects[typ.Index], e)
+			if err != nil {
+				return 0, err
+			}
+			offs[i] = off
+		}
+		r.b.StartVector(SizeUOffsetT, n, SizeUOffsetT)
+		for i := n - 1; i >= 0; i-- {
+			r.b.PrependUOffsetT(offs[i])
+		}
+		return r.b.EndVector(n), nil
+	}
+	size := typ.Element.ScalarSize()
+	if size == 0 {
+		return 0, r.errorf(obj, f, "vector of %s is not supported", typ.Element)
+	}
+	bits := make([]uint64, n)
+	for i, e := range list {
+		var err error
+		if bits[i], err = r.scalar(typ.Element, typ.Index, e); err != nil {
+			return 0, r.errorf(obj, f, "element %d: %s", i, err)
+		}
+	}
+	r.b.StartVector(size, n, size)
+	for i := n - 1; i >= 0; i-- {
+		r.prependScalar(typ.Element, bits[i])
+	}
+	return r.b.EndVector(n), nil
+}
+
+// structBytes lays out a struct, every field must be present.
+func (r *jsonReader) structBytes(obj *Object, v interface{}) ([]byte, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("flatbuffers: %s: want a JSON object, got %T", obj.Name, v)
+	}
+	if len(m) != len(obj.Fields) {
+		for name := range m {
+			if obj.Field(name) == nil {
+				return nil, fmt.Errorf("flatbuffers: %s has no field %s", obj.Name, name)
+			}
+		}
+	}
+	raw := make([]byte, obj.ByteSize)
+	for _, f := range obj.Fields {
+		fv, ok := m[f.Name]
+		if !ok {
+			return nil, r.errorf(obj, f, "struct fields are required")
+		}
+		if err := r.inline(obj, f, f.Type, raw[f.Offset:], fv); err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
+func (r *jsonReader) inline(obj *Object, f *Field, typ Type, raw []byte, v interface{}) error {
+	switch typ.BaseType {
+	case BaseTypeObj:
+		sub, err := r.structBytes(r.s.Objects[typ.Index], v)
+		if err != nil {
+			return err
+		}
+		copy(raw, sub)
+		return nil
+	case BaseTypeArray:
+		list, ok := v.([]interface{})
+		if !ok || len(list) != typ.FixedLength {
+			return r.errorf(obj, f, "want a JSON array of %d elements", typ.FixedLength)
+		}
+		elem := Type{BaseType: typ.Element, Index: typ.Index}
+		size := typ.Element.ScalarSize()
+		if typ.Element == BaseTypeObj {
+			size = r.s.Objects[typ.Index].ByteSize
+		}
+		for i, e := range list {
+			if err := r.inline(obj, f, elem, raw[i*size:], e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	bits, err := r.scalar(typ.BaseType, typ.Index, v)
+	if err != nil {
+		return r.errorf(obj, f, "%s", err)
+	}
+	for i := 0; i < typ.BaseType.ScalarSize(); i++ {
+		raw[i] = byte(bits >> (8 * i))
+	}
+	return nil
+}
+
+func (r *jsonReader) placeStruct(obj *Object, raw []byte) {
+	r.b.Prep(obj.MinAlign, obj.ByteSize)
+	for i := len(raw) - 1; i >= 0; i-- {
+		r.b.PlaceByte(raw[i])
+	}
+}
+
+func (r *jsonReader) prependScalar(bt BaseType, bits uint64) {
+	switch bt.ScalarSize() {
+	case 1:
+		r.b.PrependUint8(uint8(bits))
+	case 2:
+		r.b.PrependUint16(uint16(bits))
+	case 4:
+		r.b.PrependUint32(uint32(bits))
+	default:
+		r.b.PrependUint64(bits)
+	}
+}
+
+// defaultBits is the default of a scalar field in the representation
+// returned by scalar.
+func defaultBits(f *Field) uint64 {
+	switch f.Type.BaseType {
+	case BaseTypeFloat:
+		return uint64(math.Float32bits(float32(f.DefaultReal)))
+	case BaseTypeDouble:
+		return math.Float64bits(f.DefaultReal)
+	}
+	return truncate(f.Type.BaseType, uint64(f.DefaultInteger))
+}
+
+func truncate(bt BaseType, bits uint64) uint64 {
+	if size := bt.ScalarSize(); size < 8 {
+		bits &= 1<<(8*size) - 1
+	}
+	return bits
+}
+
+// scalar parses a JSON value into the little endian bits of a scalar.
+// Integers may be enum names, several space separated names are or'ed.
+func (r *jsonReader) scalar(bt BaseType, index int, v interface{}) (uint64, error) {
+	switch bt {
+	case BaseTypeBool:
+		switch x := v.(type) {
+		case bool:
+			if x {
+				return 1, nil
+			}
+			return 0, nil
+		case json.Number:
+			n, err := strconv.ParseUint(string(x), 10, 1)
+			return n, err
+		}
+		return 0, fmt.Errorf("want a bool, got %T", v)
+	case BaseTypeFloat, BaseTypeDouble:
+		var f float64
+		var err error
+		switch x := v.(type) {
+		case json.Number:
+			f, err = strconv.ParseFloat(string(x), 64)
+		case string:
+			f, err = strconv.ParseFloat(x, 64)
+		default:
+			err = fmt.Errorf("want a number, got %T", v)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if bt == BaseTypeFloat {
+			return uint64(math.Float32bits(float32(f))), nil
+		}
+		return math.Float64bits(f), nil
+	}
+
+	bits := 8 * bt.ScalarSize()
+	signed := bt == BaseTypeByte || bt == BaseTypeShort || bt == BaseTypeInt || bt == BaseTypeLong
+	parse := func(s string) (uint64, error) {
+		if signed {
+			n, err := strconv.ParseInt(s, 0, bits)
+			return truncate(bt, uint64(n)), err
+		}
+		return strconv.ParseUint(s, 0, bits)
+	}
+	switch x := v.(type) {
+	case json.Number:
+		return parse(string(x))
+	case string:
+		if index < 0 {
+			return parse(x)
+		}
+		enum := r.s.Enums[index]
+		var n uint64
+		for _, name := range strings.Fields(x) {
+			ev := enum.Named(name)
+			if ev == nil {
+				return 0, fmt.Errorf("%s has no value %s", enum.Name, name)
+			}
+			n |= uint64(ev.Value)
+		}
+		return truncate(bt, n), nil
+	}
+	return 0, fmt.Errorf("want a number, got %T", v)
+}
@@ -0,0 +1,388 @@
+package flatbuffers_test
+
+import (
+	"errors"
+	"github.com/jiangshuai341/zbus/benchmark/fb/Example"
+	"github.com/jiangshuai341/zbus/flatbuffers"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// encodeSchema 按 reflection.fbs 写出 .bfbs 与 flatc -b --schema 的布局相同
+func encodeSchema(s *flatbuffers.Schema) []byte {
+	b := flatbuffers.NewBuilder(0)
+	vector := func(offs []flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+		b.StartVector(flatbuffers.SizeUOffsetT, len(offs), flatbuffers.SizeUOffsetT)
+		for i := len(offs) - 1; i >= 0; i-- {
+			b.PrependUOffsetT(offs[i])
+		}
+		return b.EndVector(len(offs))
+	}
+	typ := func(t flatbuffers.Type) flatbuffers.UOffsetT {
+		b.StartObject(6)
+		b.PrependInt8Slot(0, int8(t.BaseType), 0)
+		b.PrependInt8Slot(1, int8(t.Element), 0)
+		b.PrependInt32Slot(2, int32(t.Index), -1)
+		b.PrependUint16Slot(3, uint16(t.FixedLength), 0)
+		b.PrependUint32Slot(4, uint32(t.BaseSize), 4)
+		b.PrependUint32Slot(5, uint32(t.ElementSize), 0)
+		return b.EndObject()
+	}
+	attributes := func(attrs map[string]string) flatbuffers.UOffsetT {
+		if len(attrs) == 0 {
+			return 0
+		}
+		var kvs []flatbuffers.UOffsetT
+		for k, v := range attrs {
+			key, value := b.CreateString(k), b.CreateString(v)
+			b.StartObject(2)
+			b.PrependUOffsetTSlot(0, key, 0)
+			b.PrependUOffsetTSlot(1, value, 0)
+			kvs = append(kvs, b.EndObject())
+		}
+		return vector(kvs)
+	}
+
+	var objects []flatbuffers.UOffsetT
+	rootTable := flatbuffers.UOffsetT(0)
+	for _, o := range s.Objects {
+		var fields []flatbuffers.UOffsetT
+		for _, f := range o.Fields {
+			name, t := b.CreateString(f.Name), typ(f.Type)
+			b.StartObject(14)
+			b.PrependUOffsetTSlot(0, name, 0)
+			b.PrependUOffsetTSlot(1, t, 0)
+			b.PrependUint16Slot(2, f.ID, 0)
+			b.PrependUint16Slot(3, f.Offset, 0)
+			b.PrependInt64Slot(4, f.DefaultInteger, 0)
+			b.PrependFloat64Slot(5, f.DefaultReal, 0)
+			b.PrependBoolSlot(6, f.Deprecated, false)
+			b.PrependBoolSlot(7, f.Required, false)
+			b.PrependBoolSlot(11, f.Optional, false)
+			fields = append(fields, b.EndObject())
+		}
+		name, fieldsVec := b.CreateString(o.Name), vector(fields)
+		b.StartObject(8)
+		b.PrependUOffsetTSlot(0, name, 0)
+		b.PrependUOffsetTSlot(1, fieldsVec, 0)
+		b.PrependBoolSlot(2, o.IsStruct, false)
+		b.PrependInt32Slot(3, int32(o.MinAlign), 0)
+		b.PrependInt32Slot(4, int32(o.ByteSize), 0)
+		objects = append(objects, b.EndObject())
+		if o == s.RootTable {
+			rootTable = objects[len(objects)-1]
+		}
+	}
+	var enums []flatbuffers.UOffsetT
+	for _, e := range s.Enums {
+		var values []flatbuffers.UOffsetT
+		for _, v := range e.Values {
+			name, t := b.CreateString(v.Name), typ(v.UnionType)
+			b.StartObject(6)
+			b.PrependUOffsetTSlot(0, name, 0)
+			b.PrependInt64Slot(1, v.Value, 0)
+			b.PrependUOffsetTSlot(3, t, 0)
+			values = append(values, b.EndObject())
+		}
+		name, valuesVec, t, attrs := b.CreateString(e.Name), vector(values), typ(e.UnderlyingType), attributes(e.Attributes)
+		b.StartObject(7)
+		b.PrependUOffsetTSlot(0, name, 0)
+		b.PrependUOffsetTSlot(1, valuesVec, 0)
+		b.PrependBoolSlot(2, e.IsUnion, false)
+		b.PrependUOffsetTSlot(3, t, 0)
+		b.PrependUOffsetTSlot(4, attrs, 0)
+		enums = append(enums, b.EndObject())
+	}
+	objectsVec, enumsVec := vector(objects), vector(enums)
+	ident := b.CreateString(s.FileIdent)
+	b.StartObject(8)
+	b.PrependUOffsetTSlot(0, objectsVec, 0)
+	b.PrependUOffsetTSlot(1, enumsVec, 0)
+	b.PrependUOffsetTSlot(2, ident, 0)
+	b.PrependUOffsetTSlot(4, rootTable, 0)
+	b.FinishWithFileIdentifier(b.EndObject(), []byte("BFBS"))
+	return b.FinishedBytes()
+}
+
+func scalar(bt flatbuffers.BaseType, index int) flatbuffers.Type {
+	return flatbuffers.Type{BaseType: bt, Index: index, BaseSize: bt.ScalarSize()}
+}
+
+func vectorOf(elem flatbuffers.BaseType, index int) flatbuffers.Type {
+	return flatbuffers.Type{BaseType: flatbuffers.BaseTypeVector, Element: elem, Index: index, BaseSize: 4}
+}
+
+// tableField 表字段的 vtable 偏移为 4+2*id
+func tableField(name string, id uint16, t flatbuffers.Type) *flatbuffers.Field {
+	return &flatbuffers.Field{Name: name, ID: id, Offset: 4 + 2*id, Type: t}
+}
+
+func structField(name string, id, offset uint16, t flatbuffers.Type) *flatbuffers.Field {
+	return &flatbuffers.Field{Name: name, ID: id, Offset: offset, Type: t}
+}
+
+// exampleSchema 与 benchmark/fb/Example 相同的 schema
+func exampleSchema(t *testing.T) *flatbuffers.Schema {
+	const ammunition, monster, weapon, vec3 = 0, 1, 2, 3
+	const color, equipment = 0, 1
+	obj := flatbuffers.Type{BaseType: flatbuffers.BaseTypeObj}
+	at := func(index int) flatbuffers.Type {
+		t := obj
+		t.Index = index
+		return t
+	}
+	weaponFields := []*flatbuffers.Field{
+		tableField("name", 0, scalar(flatbuffers.BaseTypeString, -1)),
+		tableField("damage", 1, scalar(flatbuffers.BaseTypeInt, -1)),
+	}
+	mana := tableField("mana", 1, scalar(flatbuffers.BaseTypeInt, -1))
+	mana.DefaultInteger = 150
+	hp := tableField("hp", 2, scalar(flatbuffers.BaseTypeInt, -1))
+	hp.DefaultInteger = 100
+	friendly := tableField("friendly", 4, scalar(flatbuffers.BaseTypeBool, -1))
+	friendly.Deprecated = true
+	colorField := tableField("color", 6, scalar(flatbuffers.BaseTypeByte, color))
+	colorField.DefaultInteger = 2
+	root := &flatbuffers.Object{Name: "Example.Monster", MinAlign: 4, Fields: []*flatbuffers.Field{
+		tableField("pos", 0, at(vec3)),
+		mana,
+		hp,
+		tableField("name", 3, scalar(flatbuffers.BaseTypeString, -1)),
+		friendly,
+		tableField("inventory", 5, vectorOf(flatbuffers.BaseTypeUByte, -1)),
+		colorField,
+		tableField("weapons", 7, vectorOf(flatbuffers.BaseTypeObj, weapon)),
+		tableField("path", 8, vectorOf(flatbuffers.BaseTypeObj, vec3)),
+	}}
+	s := &flatbuffers.Schema{
+		Objects: []*flatbuffers.Object{
+			{Name: "Example.Ammunition", MinAlign: 4, Fields: weaponFields},
+			root,
+			{Name: "Example.Weapon", MinAlign: 4, Fields: weaponFields},
+			{Name: "testinclude.Vec3", MinAlign: 4, Fields: []*flatbuffers.Field{
+				tableField("x", 0, scalar(flatbuffers.BaseTypeFloat, -1)),
+				tableField("y", 1, scalar(flatbuffers.BaseTypeFloat, -1)),
+				tableField("z", 2, scalar(flatbuffers.BaseTypeFloat, -1)),
+			}},
+		},
+		Enums: []*flatbuffers.Enum{
+			{Name: "Example.Color", UnderlyingType: scalar(flatbuffers.BaseTypeByte, color), Values: []*flatbuffers.EnumVal{
+				{Name: "Red", Value: 0}, {Name: "Green", Value: 1}, {Name: "Blue", Value: 2},
+			}},
+			{Name: "Example.Equipment", IsUnion: true, UnderlyingType: scalar(flatbuffers.BaseTypeUType, equipment), Values: []*flatbuffers.EnumVal{
+				{Name: "NONE", Value: 0},
+				{Name: "Weapon", Value: 1, UnionType: at(weapon)},
+				{Name: "Ammunition", Value: 2, UnionType: at(ammunition)},
+			}},
+		},
+		RootTable: root,
+	}
+	loaded, err := flatbuffers.LoadSchema(encodeSchema(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func TestLoadSchema(t *testing.T) {
+	s := exampleSchema(t)
+	if s.RootTable == nil || s.RootTable.Name != "Example.Monster" {
+		t.Fatalf("root table %v", s.RootTable)
+	}
+	m := s.Object("Example.Monster")
+	if f := m.Field("mana"); f == nil || f.DefaultInteger != 150 || f.Offset != 6 {
+		t.Fatalf("mana %+v", f)
+	}
+	if f := m.Field("weapons"); f.Type.BaseType != flatbuffers.BaseTypeVector || s.Objects[f.Type.Index].Name != "Example.Weapon" {
+		t.Fatalf("weapons %+v", f.Type)
+	}
+	if v := s.Enum("Example.Equipment").Lookup(2); v == nil || v.Name != "Ammunition" || s.Objects[v.UnionType.Index].Name != "Example.Ammunition" {
+		t.Fatalf("equipment %+v", v)
+	}
+	if _, err := flatbuffers.LoadSchema([]byte("not a schema")); !errors.Is(err, flatbuffers.ErrVerify) {
+		t.Fatalf("want ErrVerify, got %v", err)
+	}
+}
+
+func TestToJSON_Generated(t *testing.T) {
+	s := exampleSchema(t)
+	buf := monster(2)
+	got, err := flatbuffers.ToJSON(s, "", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"pos":{"x":1,"y":2,"z":3},"mana":10,"hp":20,"name":"orc","inventory":[1,2,3],"color":"Green",` +
+		`"weapons":[{"name":"axe"},{"name":"axe","damage":1}],"path":[{"x":1},{"y":2}]}`
+	if string(got) != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+
+	back, err := flatbuffers.FromJSON(s, "Example.Monster", got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Example.VerifyRootAsMonster(back); err != nil {
+		t.Fatal(err)
+	}
+	orig := Example.GetRootAsMonster(buf, 0).UnPack()
+	if rt := Example.GetRootAsMonster(back, 0).UnPack(); !reflect.DeepEqual(rt, orig) {
+		t.Fatalf("round trip %+v\nwant %+v", rt, orig)
+	}
+
+	buf[len(buf)-1] = 0xff
+	buf = buf[:len(buf)-8]
+	if _, err = flatbuffers.ToJSON(s, "", buf); !errors.Is(err, flatbuffers.ErrVerify) {
+		t.Fatalf("want ErrVerify, got %v", err)
+	}
+}
+
+// shapesSchema 覆盖 struct 定长数组 union bit_flags optional 和无符号64位
+func shapesSchema(t *testing.T) *flatbuffers.Schema {
+	const leaf, line, point, root = 0, 1, 2, 3
+	const anyEnum, flags = 0, 1
+	obj := func(index int) flatbuffers.Type {
+		return flatbuffers.Type{BaseType: flatbuffers.BaseTypeObj, Index: index}
+	}
+	opt := tableField("opt", 4, scalar(flatbuffers.BaseTypeInt, -1))
+	opt.Optional = true
+	name := tableField("s", 0, scalar(flatbuffers.BaseTypeString, -1))
+	name.Required = true
+	rootObj := &flatbuffers.Object{Name: "t.Root", MinAlign: 8, Fields: []*flatbuffers.Field{
+		tableField("line", 0, obj(line)),
+		tableField("any_type", 1, scalar(flatbuffers.BaseTypeUType, anyEnum)),
+		tableField("any", 2, flatbuffers.Type{BaseType: flatbuffers.BaseTypeUnion, Index: anyEnum}),
+		tableField("flags", 3, scalar(flatbuffers.BaseTypeUByte, flags)),
+		opt,
+		tableField("f", 5, scalar(flatbuffers.BaseTypeFloat, -1)),
+		tableField("names", 6, vectorOf(flatbuffers.BaseTypeString, -1)),
+		tableField("lines", 7, vectorOf(flatbuffers.BaseTypeObj, line)),
+		tableField("big", 8, scalar(flatbuffers.BaseTypeULong, -1)),
+	}}
+	s := &flatbuffers.Schema{
+		Objects: []*flatbuffers.Object{
+			{Name: "t.Leaf", MinAlign: 4, Fields: []*flatbuffers.Field{name}},
+			{Name: "t.Line", IsStruct: true, MinAlign: 8, ByteSize: 40, Fields: []*flatbuffers.Field{
+				structField("a", 0, 0, obj(point)),
+				structField("b", 1, 16, obj(point)),
+				structField("tags", 2, 32, flatbuffers.Type{BaseType: flatbuffers.BaseTypeArray, Element: flatbuffers.BaseTypeUByte, Index: -1, FixedLength: 3}),
+			}},
+			{Name: "t.Point", IsStruct: true, MinAlign: 8, ByteSize: 16, Fields: []*flatbuffers.Field{
+				structField("x", 0, 0, scalar(flatbuffers.BaseTypeShort, -1)),
+				structField("y", 1, 8, scalar(flatbuffers.BaseTypeDouble, -1)),
+			}},
+			rootObj,
+		},
+		Enums: []*flatbuffers.Enum{
+			{Name: "t.Any", IsUnion: true, UnderlyingType: scalar(flatbuffers.BaseTypeUType, anyEnum), Values: []*flatbuffers.EnumVal{
+				{Name: "NONE", Value: 0},
+				{Name: "Leaf", Value: 1, UnionType: obj(leaf)},
+				{Name: "Text", Value: 2, UnionType: scalar(flatbuffers.BaseTypeString, -1)},
+				{Name: "P", Value: 3, UnionType: obj(point)},
+			}},
+			{Name: "t.Flags", UnderlyingType: scalar(flatbuffers.BaseTypeUByte, flags), Attributes: map[string]string{"bit_flags": ""}, Values: []*flatbuffers.EnumVal{
+				{Name: "A", Value: 1}, {Name: "B", Value: 2}, {Name: "C", Value: 4},
+			}},
+		},
+		FileIdent: "SHAP",
+		RootTable: rootObj,
+	}
+	loaded, err := flatbuffers.LoadSchema(encodeSchema(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func TestJSON_RoundTrip(t *testing.T) {
+	s := shapesSchema(t)
+	line := `{"a":{"x":1,"y":1.5},"b":{"x":-2,"y":"inf"},"tags":[1,2,3]}`
+	cases := []string{
+		`{"line":` + line + `,"any_type":"Text","any":"hi","flags":"A C","opt":0,"f":0.5,"names":["a","b"],"lines":[` + line + `],"big":18446744073709551615}`,
+		`{"any_type":"Leaf","any":{"s":"leaf"},"flags":"B","f":"nan"}`,
+		`{"any_type":"P","any":{"x":7,"y":-0.25},"names":[],"lines":[]}`,
+		`{}`,
+	}
+	for _, c := range cases {
+		buf, err := flatbuffers.FromJSON(s, "", []byte(c))
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if string(buf[4:8]) != "SHAP" {
+			t.Fatalf("file identifier %q", buf[4:8])
+		}
+		got, err := flatbuffers.ToJSON(s, "t.Root", buf)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if string(got) != c {
+			t.Fatalf("got  %s\nwant %s", got, c)
+		}
+	}
+
+	// 与默认值相同的非optional字段不写入
+	buf, err := flatbuffers.FromJSON(s, "", []byte(`{"f":0,"big":0,"flags":0}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := flatbuffers.ToJSON(s, "", buf); string(got) != `{}` {
+		t.Fatalf("defaults written: %s", got)
+	}
+}
+
+func TestFromJSON_Errors(t *testing.T) {
+	s := shapesSchema(t)
+	cases := []struct {
+		json string
+		want string
+	}{
+		{`{"nope":1}`, "no field nope"},
+		{`{"any_type":"Leaf","any":{}}`, "required field is missing"},
+		{`{"flags":"A D"}`, "no value D"},
+		{`{"any":"x"}`, "missing any_type"},
+		{`{"line":{"a":{"x":1,"y":1}}}`, "struct fields are required"},
+		{`{"line":{"a":{"x":1,"y":1},"b":{"x":1,"y":1},"tags":[1]}}`, "array of 3"},
+		{`{"f":"x"}`, "invalid syntax"},
+		{`{"big":-1}`, "invalid syntax"},
+		{`{"names":"a"}`, "want a JSON array"},
+		{`[]`, "want a JSON object"},
+		{`{} {}`, "trailing data"},
+	}
+	for _, c := range cases {
+		_, err := flatbuffers.FromJSON(s, "", []byte(c.json))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: want error containing %q, got %v", c.json, c.want, err)
+		}
+	}
+	if _, err := flatbuffers.FromJSON(s, "t.Point", []byte(`{}`)); !errors.Is(err, flatbuffers.ErrSchema) {
+		t.Errorf("struct root: want ErrSchema, got %v", err)
+	}
+}
+
+// 损坏的 .bfbs 不能panic 能加载的schema转换数据也不能panic
+func TestLoadSchema_Fuzz(t *testing.T) {
+	s := shapesSchema(t)
+	valid := encodeSchema(s)
+	data, err := flatbuffers.FromJSON(s, "", []byte(`{"line":{"a":{"x":1,"y":1},"b":{"x":1,"y":1},"tags":[1,2,3]},"any_type":"Leaf","any":{"s":"x"},"names":["a"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		buf := append([]byte(nil), valid...)
+		for j := rnd.Intn(4); j >= 0; j-- {
+			buf[rnd.Intn(len(buf))] = byte(rnd.Intn(256))
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("schema %x panics: %v", buf, r)
+				}
+			}()
+			if loaded, err := flatbuffers.LoadSchema(buf); err == nil && loaded.RootTable != nil {
+				_, _ = flatbuffers.ToJSON(loaded, "", data)
+			}
+		}()
+	}
+}
@@ -0,0 +1,620 @@
+package flatbuffers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrSchema is wrapped by errors about a schema that can not describe the
+// requested data.
+var ErrSchema = errors.New("flatbuffers: bad schema")
+
+// schemaIdentifier is the file_identifier of reflection.fbs.
+const schemaIdentifier = "BFBS"
+
+// BaseType is reflection.BaseType, the wire type of a field.
+type BaseType int8
+
+const (
+	BaseTypeNone BaseType = iota
+	BaseTypeUType
+	BaseTypeBool
+	BaseTypeByte
+	BaseTypeUByte
+	BaseTypeShort
+	BaseTypeUShort
+	BaseTypeInt
+	BaseTypeUInt
+	BaseTypeLong
+	BaseTypeULong
+	BaseTypeFloat
+	BaseTypeDouble
+	BaseTypeString
+	BaseTypeVector
+	BaseTypeObj
+	BaseTypeUnion
+	BaseTypeArray
+	BaseTypeVector64
+)
+
+var baseTypeNames = [...]string{"None", "UType", "Bool", "Byte", "UByte", "Short", "UShort", "Int", "UInt", "Long", "ULong", "Float", "Double", "String", "Vector", "Obj", "Union", "Array", "Vector64"}
+
+func (t BaseType) String() string {
+	if t >= 0 && int(t) < len(baseTypeNames) {
+		return baseTypeNames[t]
+	}
+	return fmt.Sprintf("BaseType(%d)", int8(t))
+}
+
+// ScalarSize returns the size of a scalar type, 0 for other types.
+func (t BaseType) ScalarSize() int {
+	switch t {
+	case BaseTypeUType, BaseTypeBool, BaseTypeByte, BaseTypeUByte:
+		return 1
+	case BaseTypeShort, BaseTypeUShort:
+		return 2
+	case BaseTypeInt, BaseTypeUInt, BaseTypeFloat:
+		return 4
+	case BaseTypeLong, BaseTypeULong, BaseTypeDouble:
+		return 8
+	}
+	return 0
+}
+
+// Schema is a binary schema (.bfbs) produced by flatc -b --schema and read
+// with LoadSchema. Names are fully qualified, like "lobby.Monster".
+type Schema struct {
+	Objects          []*Object
+	Enums            []*Enum
+	FileIdent        string
+	FileExt          string
+	RootTable        *Object
+	Services         []*Service
+	AdvancedFeatures uint64
+
+	objects map[string]*Object
+	enums   map[string]*Enum
+}
+
+// Object is a table or a struct.
+type Object struct {
+	Name          string
+	Fields        []*Field // sorted by id
+	IsStruct      bool
+	MinAlign      int
+	ByteSize      int // structs only
+	Attributes    map[string]string
+	Documentation []string
+
+	fields map[string]*Field
+}
+
+type Field struct {
+	Name           string
+	Type           Type
+	ID             uint16
+	Offset         uint16 // vtable offset for tables, byte offset for structs
+	DefaultInteger int64
+	DefaultReal    float64
+	Deprecated     bool
+	Required       bool
+	Key            bool
+	Optional       bool
+	Padding        uint16
+	Offset64       bool
+	Attributes     map[string]string
+	Documentation  []string
+}
+
+// Type describes a field. Index points into Schema.Objects for Obj and into
+// Schema.Enums for unions and enums, it is -1 otherwise.
+type Type struct {
+	BaseType    BaseType
+	Element     BaseType // Vector and Array only
+	Index       int
+	FixedLength int // Array only
+	BaseSize    int
+	ElementSize int
+}
+
+// Enum is an enum or a union.
+type Enum struct {
+	Name           string
+	Values         []*EnumVal // in order of their values
+	IsUnion        bool
+	UnderlyingType Type
+	Attributes     map[string]string
+	Documentation  []string
+}
+
+type EnumVal struct {
+	Name          string
+	Value         int64
+	UnionType     Type // unions only
+	Documentation []string
+	Attributes    map[string]string
+}
+
+type Service struct {
+	Name          string
+	Calls         []*RPCCall
+	Attributes    map[string]string
+	Documentation []string
+}
+
+type RPCCall struct {
+	Name          string
+	Request       *Object
+	Response      *Object
+	Attributes    map[string]string
+	Documentation []string
+}
+
+// LoadSchema verifies and decodes a binary schema. The result does not
+// reference bfbs and is safe for concurrent use.
+func LoadSchema(bfbs []byte) (*Schema, error) {
+	if err := NewVerifier(bfbs, VerifierOptions{}).VerifyBuffer(schemaIdentifier, verifySchema); err != nil {
+		return nil, err
+	}
+	t := reflectTable{Table{Bytes: bfbs, Pos: GetUOffsetT(bfbs)}}
+	s := &Schema{
+		FileIdent:        t.str(8),
+		FileExt:          t.str(10),
+		AdvancedFeatures: t.GetUint64Slot(16, 0),
+		objects:          make(map[string]*Object),
+		enums:            make(map[string]*Enum),
+	}
+	objects := t.tables(4)
+	// root_table and rpc calls share the tables of objects, fall back to
+	// the name for writers that do not share them.
+	byPos := make(map[UOffsetT]*Object, len(objects))
+	object := func(t reflectTable) *Object {
+		if o, ok := byPos[t.Pos]; ok {
+			return o
+		}
+		return s.objects[t.str(4)]
+	}
+	for _, o := range objects {
+		obj := &Object{
+			Name:          o.str(4),
+			IsStruct:      o.GetBoolSlot(8, false),
+			MinAlign:      int(o.GetInt32Slot(10, 0)),
+			ByteSize:      int(o.GetInt32Slot(12, 0)),
+			Attributes:    o.attributes(14),
+			Documentation: o.strings(16),
+			fields:        make(map[string]*Field),
+		}
+		for _, f := range o.tables(6) {
+			field := &Field{
+				Name:           f.str(4),
+				ID:             f.GetUint16Slot(8, 0),
+				Offset:         f.GetUint16Slot(10, 0),
+				DefaultInteger: f.GetInt64Slot(12, 0),
+				DefaultReal:    f.GetFloat64Slot(14, 0),
+				Deprecated:     f.GetBoolSlot(16, false),
+				Required:       f.GetBoolSlot(18, false),
+				Key:            f.GetBoolSlot(20, false),
+				Attributes:     f.attributes(22),
+				Documentation:  f.strings(24),
+				Optional:       f.GetBoolSlot(26, false),
+				Padding:        f.GetUint16Slot(28, 0),
+				Offset64:       f.GetBoolSlot(30, false),
+			}
+			if typ, ok := f.table(6); ok {
+				field.Type = typ.typ()
+			}
+			obj.Fields = append(obj.Fields, field)
+			obj.fields[field.Name] = field
+		}
+		sort.Slice(obj.Fields, func(i, j int) bool {
+			return obj.Fields[i].ID < obj.Fields[j].ID
+		})
+		s.Objects = append(s.Objects, obj)
+		s.objects[obj.Name] = obj
+		byPos[o.Pos] = obj
+	}
+	for _, e := range t.tables(6) {
+		enum := &Enum{
+			Name:          e.str(4),
+			IsUnion:       e.GetBoolSlot(8, false),
+			Attributes:    e.attributes(12),
+			Documentation: e.strings(14),
+		}
+		if typ, ok := e.table(10); ok {
+			enum.UnderlyingType = typ.typ()
+		}
+		for _, ev := range e.tables(6) {
+			val := &EnumVal{
+				Name:          ev.str(4),
+				Value:         ev.GetInt64Slot(6, 0),
+				Documentation: ev.strings(12),
+				Attributes:    ev.attributes(14),
+			}
+			if typ, ok := ev.table(10); ok {
+				val.UnionType = typ.typ()
+			}
+			enum.Values = append(enum.Values, val)
+		}
+		s.Enums = append(s.Enums, enum)
+		s.enums[enum.Name] = enum
+	}
+	if root, ok := t.table(12); ok {
+		s.RootTable = object(root)
+	}
+	for _, sv := range t.tables(14) {
+		svc := &Service{Name: sv.str(4), Attributes: sv.attributes(8), Documentation: sv.strings(10)}
+		for _, c := range sv.tables(6) {
+			call := &RPCCall{Name: c.str(4), Attributes: c.attributes(10), Documentation: c.strings(12)}
+			if req, ok := c.table(6); ok {
+				call.Request = object(req)
+			}
+			if resp, ok := c.table(8); ok {
+				call.Response = object(resp)
+			}
+			svc.Calls = append(svc.Calls, call)
+		}
+		s.Services = append(s.Services, svc)
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// check makes sure indexes, vtable offsets and struct layouts are sane, so
+// that Verify, ToJSON and FromJSON can follow them without checking.
+func (s *Schema) check() error {
+	bad := func(o *Object, f *Field, format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s.%s: %s", ErrSchema, o.Name, f.Name, fmt.Sprintf(format, args...))
+	}
+	for _, o := range s.Objects {
+		if o.IsStruct && (o.ByteSize < 0 || o.MinAlign < 1 || o.MinAlign > 16 || o.MinAlign&(o.MinAlign-1) != 0) {
+			return fmt.Errorf("%w: struct %s has bad size %d or alignment %d", ErrSchema, o.Name, o.ByteSize, o.MinAlign)
+		}
+		for _, f := range o.Fields {
+			t := f.Type
+			index := -1
+			switch {
+			case t.BaseType == BaseTypeObj, (t.BaseType == BaseTypeVector || t.BaseType == BaseTypeArray) && t.Element == BaseTypeObj:
+				if t.Index < 0 || t.Index >= len(s.Objects) {
+					return bad(o, f, "object index %d out of range", t.Index)
+				}
+			case t.Index >= 0:
+				index = t.Index
+			}
+			if index >= len(s.Enums) {
+				return bad(o, f, "enum index %d out of range", index)
+			}
+			if (t.BaseType == BaseTypeUnion || t.BaseType == BaseTypeUType) && index < 0 {
+				return bad(o, f, "union without enum")
+			}
+			if !o.IsStruct {
+				if f.Offset < 2*SizeVOffsetT || f.Offset%SizeVOffsetT != 0 || t.BaseType == BaseTypeUnion && f.Offset < 3*SizeVOffsetT {
+					return bad(o, f, "bad vtable offset %d", f.Offset)
+				}
+				continue
+			}
+			size, err := s.inlineSize(t)
+			if err != nil {
+				return bad(o, f, "%s", err)
+			}
+			if int(f.Offset)+size > o.ByteSize {
+				return bad(o, f, "offset %d size %d exceeds struct size %d", f.Offset, size, o.ByteSize)
+			}
+		}
+	}
+	for _, e := range s.Enums {
+		sort.Slice(e.Values, func(i, j int) bool {
+			return e.Values[i].Value < e.Values[j].Value
+		})
+		for _, v := range e.Values {
+			if v.UnionType.BaseType == BaseTypeObj && (v.UnionType.Index < 0 || v.UnionType.Index >= len(s.Objects)) {
+				return fmt.Errorf("%w: %s.%s: object index %d out of range", ErrSchema, e.Name, v.Name, v.UnionType.Index)
+			}
+		}
+	}
+	// structs are stored inline and can not contain themselves
+	state := make(map[*Object]int)
+	var visit func(o *Object) error
+	visit = func(o *Object) error {
+		switch state[o] {
+		case 1:
+			return fmt.Errorf("%w: struct %s contains itself", ErrSchema, o.Name)
+		case 2:
+			return nil
+		}
+		state[o] = 1
+		for _, f := range o.Fields {
+			if f.Type.BaseType == BaseTypeObj || f.Type.Element == BaseTypeObj {
+				if err := visit(s.Objects[f.Type.Index]); err != nil {
+					return err
+				}
+			}
+		}
+		state[o] = 2
+		return nil
+	}
+	for _, o := range s.Objects {
+		if o.IsStruct {
+			if err := visit(o); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// inlineSize is the size of a struct field, only scalars, structs and
+// arrays of them are allowed.
+func (s *Schema) inlineSize(t Type) (int, error) {
+	elem := t.BaseType
+	n := 1
+	if elem == BaseTypeArray {
+		elem, n = t.Element, t.FixedLength
+	}
+	if size := elem.ScalarSize(); size > 0 && elem != BaseTypeUType {
+		return n * size, nil
+	}
+	if elem == BaseTypeObj && s.Objects[t.Index].IsStruct {
+		return n * s.Objects[t.Index].ByteSize, nil
+	}
+	return 0, fmt.Errorf("%s is not allowed in structs", elem)
+}
+
+// Object returns the table or struct with the fully qualified name.
+func (s *Schema) Object(name string) *Object {
+	return s.objects[name]
+}
+
+// Enum returns the enum or union with the fully qualified name.
+func (s *Schema) Enum(name string) *Enum {
+	return s.enums[name]
+}
+
+// Field returns the field with the name, nil if there is none.
+func (o *Object) Field(name string) *Field {
+	return o.fields[name]
+}
+
+// Lookup returns the value with the given value, nil if there is none.
+func (e *Enum) Lookup(value int64) *EnumVal {
+	i := sort.Search(len(e.Values), func(i int) bool {
+		return e.Values[i].Value >= value
+	})
+	if i < len(e.Values) && e.Values[i].Value == value {
+		return e.Values[i]
+	}
+	return nil
+}
+
+// Named returns the value with the name, nil if there is none.
+func (e *Enum) Named(name string) *EnumVal {
+	for _, v := range e.Values {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// reflectTable reads reflection.fbs tables that passed verifySchema.
+type reflectTable struct {
+	Table
+}
+
+func (t reflectTable) str(slot VOffsetT) string {
+	if o := UOffsetT(t.Offset(slot)); o != 0 {
+		return string(t.ByteVector(t.Pos + o))
+	}
+	return ""
+}
+
+func (t reflectTable) table(slot VOffsetT) (reflectTable, bool) {
+	if o := UOffsetT(t.Offset(slot)); o != 0 {
+		return reflectTable{Table{Bytes: t.Bytes, Pos: t.Indirect(t.Pos + o)}}, true
+	}
+	return reflectTable{}, false
+}
+
+func (t reflectTable) tables(slot VOffsetT) []reflectTable {
+	o := UOffsetT(t.Offset(slot))
+	if o == 0 {
+		return nil
+	}
+	start, n := t.Vector(o), t.VectorLen(o)
+	ret := make([]reflectTable, n)
+	for i := range ret {
+		at := start + UOffsetT(i)*SizeUOffsetT
+		ret[i] = reflectTable{Table{Bytes: t.Bytes, Pos: t.Indirect(at)}}
+	}
+	return ret
+}
+
+func (t reflectTable) strings(slot VOffsetT) []string {
+	o := UOffsetT(t.Offset(slot))
+	if o == 0 {
+		return nil
+	}
+	start, n := t.Vector(o), t.VectorLen(o)
+	ret := make([]string, n)
+	for i := range ret {
+		ret[i] = string(t.ByteVector(start + UOffsetT(i)*SizeUOffsetT))
+	}
+	return ret
+}
+
+func (t reflectTable) attributes(slot VOffsetT) map[string]string {
+	kvs := t.tables(slot)
+	if len(kvs) == 0 {
+		return nil
+	}
+	ret := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		ret[kv.str(4)] = kv.str(6)
+	}
+	return ret
+}
+
+func (t reflectTable) typ() Type {
+	return Type{
+		BaseType:    BaseType(t.GetInt8Slot(4, 0)),
+		Element:     BaseType(t.GetInt8Slot(6, 0)),
+		Index:       int(t.GetInt32Slot(8, -1)),
+		FixedLength: int(t.GetUint16Slot(10, 0)),
+		BaseSize:    int(t.GetUint32Slot(12, 4)),
+		ElementSize: int(t.GetUint32Slot(14, 0)),
+	}
+}
+
+// verify* check reflection.fbs tables like generated Verify helpers.
+func verifyReflectTable(v *Verifier, pos UOffsetT, fields func() error) error {
+	if err := v.VerifyTableStart(pos); err != nil {
+		return err
+	}
+	defer v.VerifyTableEnd()
+	return fields()
+}
+
+// firstError returns the first non nil error. Every check is safe once the
+// table passed VerifyTableStart, so they can all be evaluated.
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifySchema(v *Verifier, pos UOffsetT) error {
+	return verifyReflectTable(v, pos, func() error {
+		return firstError(
+			v.VerifyVectorOfTablesField(pos, 4, true, verifyObject),
+			v.VerifyVectorOfTablesField(pos, 6, true, verifyEnum),
+			v.VerifyStringField(pos, 8, false),
+			v.VerifyStringField(pos, 10, false),
+			v.VerifyTableField(pos, 12, false, verifyObject),
+			v.VerifyVectorOfTablesField(pos, 14, false, verifyService),
+			v.VerifyField(pos, 16, 8, 8, false),
+			v.VerifyVectorOfTablesField(pos, 18, false, verifySchemaFile),
+		)
+	})
+}
+
+func verifyObject(v *Verifier, pos UOffsetT) error {
+	return verifyReflectTable(v, pos, func() error {
+		return firstError(
+			v.VerifyStringField(pos, 4, true),
+			v.VerifyVectorOfTablesField(pos, 6, true, verifyField),
+			v.VerifyField(pos, 8, 1, 1, false),
+			v.VerifyField(pos, 10, 4, 4, false),
+			v.VerifyField(pos, 12, 4, 4, false),
+			v.VerifyVectorOfTablesField(pos, 14, false, verifyKeyValue),
+			v.VerifyVectorOfStringsField(pos, 16, false),
+			v.VerifyStringField(pos, 18, false),
+		)
+	})
+}
+
+func verifyField(v *Verifier, pos UOffsetT) error {
+	return verifyReflectTable(v, pos, func() error {
+		return firstError(
+			v.VerifyStringField(pos, 4, true),
+			v.VerifyTableField(pos, 6, true, verifyType),
+			v.VerifyField(pos, 8, 2, 2, false),
+			v.VerifyField(pos, 10, 2, 2, false),
+			v.VerifyField(pos, 12, 8, 8, false),
+			v.VerifyField(pos, 14, 8, 8, false),
+			v.VerifyField(pos, 16, 1, 1, false),
+			v.VerifyField(pos, 18, 1, 1, false),
+			v.VerifyField(pos, 20, 1, 1, false),
+			v.VerifyVectorOfTablesField(pos, 22, false, verifyKeyValue),
+			v.VerifyVectorOfStringsField(pos, 24, false),
+			v.VerifyField(pos, 26, 1, 1, false),
+			v.VerifyField(pos, 28, 2, 2, false),
+			v.VerifyField(pos, 30, 1, 1, false),
+		)
+	})
+}
+
+func verifyType(v *Verifier, pos UOffsetT) error {
+	return verifyReflectTable(v, pos, func() error {
+		return firstError(
+			v.VerifyField(pos, 4, 1, 1, false),
+			v.VerifyField(pos, 6, 1, 1, false),
+			v.VerifyField(pos, 8, 4, 4, false),
+			v.VerifyField(pos, 10, 2, 2, false),
+			v.VerifyField(pos, 12, 4, 4, false),
+			v.VerifyField(pos, 14, 4, 4, false),
+		)
+	})
+}
+
+func verifyKeyValue(v *Verifier, pos UOffsetT) error {
+	return verifyReflectTable(v, pos, func() error {
+		return firstError(
+			v.VerifyStringField(pos, 4, true),
+			v.VerifyStringField(pos, 6, false),
+		)
+	})
+}
+
+func verifyEnum(v *Verifier, pos UOffsetT) error {
+	return verifyReflectTable(v, pos, func() error {
+		return firstError(
+			v.VerifyStringField(pos, 4, true),
+			v.VerifyVectorOfTablesField(pos, 6, true, verifyEnumVal),
+			v.VerifyField(pos, 8, 1, 1, false),
+			v.VerifyTableField(pos, 10, true, verifyType),
+			v.VerifyVectorOfTablesField(pos, 12, false, verifyKeyValue),
+			v.VerifyVectorOfStringsField(pos, 14, false),
+			v.VerifyStringField(pos, 16, false),
+		)
+	})
+}
+
+func verifyEnumVal(v *Verifier, pos UOffsetT) error {
+	return verifyReflectTable(v, pos, func() error {
+		return firstError(
+			v.VerifyStringField(pos, 4, true),
+			v.VerifyField(pos, 6, 8, 8, false),
+			v.VerifyTableField(pos, 10, false, verifyType),
+			v.VerifyVectorOfStringsField(pos, 12, false),
+			v.VerifyVectorOfTablesField(pos, 14, false, verifyKeyValue),
+		)
+	})
+}
+
+func verifyService(v *Verifier, pos UOffsetT) error {
+	return verifyReflectTable(v, pos, func() error {
+		return firstError(
+			v.VerifyStringField(pos, 4, true),
+			v.VerifyVectorOfTablesField(pos, 6, false, verifyRPCCall),
+			v.VerifyVectorOfTablesField(pos, 8, false, verifyKeyValue),
+			v.VerifyVectorOfStringsField(pos, 10, false),
+			v.VerifyStringField(pos, 12, false),
+		)
+	})
+}
+
+func verifyRPCCall(v *Verifier, pos UOffsetT) error {
+	return verifyReflectTable(v, pos, func() error {
+		return firstError(
+			v.VerifyStringField(pos, 4, true),
+			v.VerifyTableField(pos, 6, true, verifyObject),
+			v.VerifyTableField(pos, 8, true, verifyObject),
+			v.VerifyVectorOfTablesField(pos, 10, false, verifyKeyValue),
+			v.VerifyVectorOfStringsField(pos, 12, false),
+		)
+	})
+}
+
+func verifySchemaFile(v *Verifier, pos UOffsetT) error {
+	return verifyReflectTable(v, pos, func() error {
+		return firstError(
+			v.VerifyStringField(pos, 4, true),
+			v.VerifyVectorOfStringsField(pos, 6, false),
+		)
+	})
+}
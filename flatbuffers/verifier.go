@@ -0,0 +1,359 @@
+package flatbuffers
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrVerify is wrapped by every error returned from a Verifier.
+	ErrVerify = errors.New("flatbuffers: verify failed")
+	// ErrNotVerifiable is returned by VerifyRoot for types without a
+	// generated Verify method.
+	ErrNotVerifiable = errors.New("flatbuffers: type has no Verify method")
+)
+
+const (
+	// DefaultMaxDepth is the default maximum nesting depth of tables.
+	DefaultMaxDepth = 64
+	// DefaultMaxTables is the default maximum number of tables visited.
+	DefaultMaxTables = 1000000
+
+	// maxBufferSize mirrors FLATBUFFERS_MAX_BUFFER_SIZE, offsets are 32 bit
+	// and soffsets are signed.
+	maxBufferSize = 1<<31 - 1
+)
+
+// VerifierOptions limit the work done on a single buffer. Zero values take
+// the defaults, so VerifierOptions{} matches the C++ verifier.
+type VerifierOptions struct {
+	MaxDepth  int
+	MaxTables int // a small buffer may reference the same table many times
+	// AllowUnaligned accepts scalars and offsets that are not aligned to
+	// their size relative to the start of the buffer.
+	AllowUnaligned bool
+}
+
+// VerifyFunc checks the table at pos. Generated code provides one per table.
+type VerifyFunc func(v *Verifier, pos UOffsetT) error
+
+// UnionVerifyFunc checks the union value of type typ at pos.
+type UnionVerifyFunc func(v *Verifier, typ byte, pos UOffsetT) error
+
+// Verifiable is implemented by generated tables.
+type Verifiable interface {
+	Verify(v *Verifier, pos UOffsetT) error
+}
+
+// Verifier checks that every offset, vtable, string and vector reachable
+// from the root lies inside the buffer before any accessor reads it, like
+// flatbuffers::Verifier in C++. A buffer that passes can be read with the
+// generated accessors without panicking. It is not safe for concurrent use.
+type Verifier struct {
+	buf    []byte
+	opts   VerifierOptions
+	depth  int
+	tables int
+}
+
+// NewVerifier returns a Verifier for buf.
+func NewVerifier(buf []byte, opts VerifierOptions) *Verifier {
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = DefaultMaxDepth
+	}
+	if opts.MaxTables <= 0 {
+		opts.MaxTables = DefaultMaxTables
+	}
+	return &Verifier{buf: buf, opts: opts}
+}
+
+// VerifyRoot checks buf as a buffer whose root is fb. fb must implement
+// Verifiable, otherwise ErrNotVerifiable is returned without looking at
+// buf: checking only the root table would let a corrupt buffer through.
+func VerifyRoot(buf []byte, fb interface{}) error {
+	t, ok := fb.(Verifiable)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrNotVerifiable, fb)
+	}
+	return NewVerifier(buf, VerifierOptions{}).VerifyBuffer("", t.Verify)
+}
+
+// VerifyBuffer checks the root offset and, when identifier is not empty,
+// the file identifier, then calls verifyRoot with the root table.
+func (v *Verifier) VerifyBuffer(identifier string, verifyRoot VerifyFunc) error {
+	return v.verifyBufferAt(0, identifier, verifyRoot)
+}
+
+// VerifySizePrefixedBuffer is VerifyBuffer for buffers finished with
+// FinishSizePrefixed. The prefix must match the size of the buffer.
+func (v *Verifier) VerifySizePrefixedBuffer(identifier string, verifyRoot VerifyFunc) error {
+	if err := v.check(0, SizeUOffsetT, SizeUOffsetT, "size prefix"); err != nil {
+		return err
+	}
+	if size := GetUOffsetT(v.buf); uint64(size) != uint64(len(v.buf))-SizeUOffsetT {
+		return v.errorf("size prefix %d does not match buffer size %d", size, len(v.buf))
+	}
+	return v.verifyBufferAt(SizeUOffsetT, identifier, verifyRoot)
+}
+
+func (v *Verifier) verifyBufferAt(start UOffsetT, identifier string, verifyRoot VerifyFunc) error {
+	if len(v.buf) > maxBufferSize {
+		return v.errorf("buffer size %d exceeds %d", len(v.buf), maxBufferSize)
+	}
+	size := uint64(SizeUOffsetT)
+	if identifier != "" {
+		if len(identifier) != fileIdentifierLength {
+			return v.errorf("file identifier %q must be %d bytes", identifier, fileIdentifierLength)
+		}
+		size += fileIdentifierLength
+	}
+	if err := v.check(uint64(start), size, SizeUOffsetT, "root offset"); err != nil {
+		return err
+	}
+	if identifier != "" {
+		at := start + SizeUOffsetT
+		if got := string(v.buf[at : at+fileIdentifierLength]); got != identifier {
+			return v.errorf("file identifier %q, want %q", got, identifier)
+		}
+	}
+	root, err := v.offset(start)
+	if err != nil {
+		return err
+	}
+	return verifyRoot(v, root)
+}
+
+// VerifyTableStart checks the table at pos and its vtable. A successful call
+// must be paired with VerifyTableEnd once the fields have been checked.
+func (v *Verifier) VerifyTableStart(pos UOffsetT) error {
+	v.depth++
+	v.tables++
+	if v.depth > v.opts.MaxDepth {
+		return v.errorf("table at %d nested deeper than %d", pos, v.opts.MaxDepth)
+	}
+	if v.tables > v.opts.MaxTables {
+		return v.errorf("more than %d tables", v.opts.MaxTables)
+	}
+	if err := v.check(uint64(pos), SizeSOffsetT, SizeSOffsetT, "table"); err != nil {
+		return err
+	}
+	vtable := int64(pos) - int64(GetSOffsetT(v.buf[pos:]))
+	if vtable < 0 {
+		return v.errorf("vtable of table at %d is before the buffer", pos)
+	}
+	if err := v.check(uint64(vtable), 2*SizeVOffsetT, SizeVOffsetT, "vtable"); err != nil {
+		return err
+	}
+	vsize := GetVOffsetT(v.buf[vtable:])
+	if vsize < 2*SizeVOffsetT || vsize%SizeVOffsetT != 0 || !v.in(uint64(vtable), uint64(vsize)) {
+		return v.errorf("vtable at %d has bad size %d", vtable, vsize)
+	}
+	if tsize := GetVOffsetT(v.buf[vtable+SizeVOffsetT:]); tsize < SizeSOffsetT || !v.in(uint64(pos), uint64(tsize)) {
+		return v.errorf("table at %d has bad size %d", pos, tsize)
+	}
+	return nil
+}
+
+// VerifyTableEnd leaves the table entered by VerifyTableStart.
+func (v *Verifier) VerifyTableEnd() {
+	v.depth--
+}
+
+// VerifyField checks an inline scalar, enum or struct field of size bytes.
+func (v *Verifier) VerifyField(pos UOffsetT, vtableOffset VOffsetT, size, align UOffsetT, required bool) error {
+	at := v.field(pos, vtableOffset)
+	if at == 0 {
+		return v.missing(pos, vtableOffset, required)
+	}
+	return v.check(uint64(at), uint64(size), uint64(align), "field")
+}
+
+// VerifyStringField checks a string field.
+func (v *Verifier) VerifyStringField(pos UOffsetT, vtableOffset VOffsetT, required bool) error {
+	at, ok, err := v.offsetField(pos, vtableOffset, required)
+	if !ok {
+		return err
+	}
+	return v.VerifyString(at)
+}
+
+// VerifyTableField checks a table field with the table's VerifyFunc.
+func (v *Verifier) VerifyTableField(pos UOffsetT, vtableOffset VOffsetT, required bool, verify VerifyFunc) error {
+	at, ok, err := v.offsetField(pos, vtableOffset, required)
+	if !ok {
+		return err
+	}
+	return verify(v, at)
+}
+
+// VerifyVectorField checks a vector of scalars, enums or structs.
+func (v *Verifier) VerifyVectorField(pos UOffsetT, vtableOffset VOffsetT, elemSize, align UOffsetT, required bool) error {
+	at, ok, err := v.offsetField(pos, vtableOffset, required)
+	if !ok {
+		return err
+	}
+	_, err = v.VerifyVector(at, elemSize, align)
+	return err
+}
+
+// VerifyVectorOfStringsField checks a vector of strings and every element.
+func (v *Verifier) VerifyVectorOfStringsField(pos UOffsetT, vtableOffset VOffsetT, required bool) error {
+	at, ok, err := v.offsetField(pos, vtableOffset, required)
+	if !ok {
+		return err
+	}
+	n, err := v.VerifyVector(at, SizeUOffsetT, SizeUOffsetT)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		s, err := v.offset(at + SizeUOffsetT + UOffsetT(i)*SizeUOffsetT)
+		if err != nil {
+			return err
+		}
+		if err = v.VerifyString(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyVectorOfTablesField checks a vector of tables and every element.
+func (v *Verifier) VerifyVectorOfTablesField(pos UOffsetT, vtableOffset VOffsetT, required bool, verify VerifyFunc) error {
+	at, ok, err := v.offsetField(pos, vtableOffset, required)
+	if !ok {
+		return err
+	}
+	n, err := v.VerifyVector(at, SizeUOffsetT, SizeUOffsetT)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		t, err := v.offset(at + SizeUOffsetT + UOffsetT(i)*SizeUOffsetT)
+		if err != nil {
+			return err
+		}
+		if err = verify(v, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyUnionField checks a union whose type is stored at typeOffset and
+// value at valueOffset. Like C++, a type unknown to verify is accepted so
+// that older readers keep working with newer schemas.
+func (v *Verifier) VerifyUnionField(pos UOffsetT, typeOffset, valueOffset VOffsetT, required bool, verify UnionVerifyFunc) error {
+	if err := v.VerifyField(pos, typeOffset, SizeByte, SizeByte, required); err != nil {
+		return err
+	}
+	typ := byte(0)
+	if at := v.field(pos, typeOffset); at != 0 {
+		typ = v.buf[at]
+	}
+	at, ok, err := v.offsetField(pos, valueOffset, required)
+	if !ok || typ == 0 {
+		return err
+	}
+	return verify(v, typ, at)
+}
+
+// VerifyString checks the length, the bytes and the null terminator of the
+// string at pos.
+func (v *Verifier) VerifyString(pos UOffsetT) error {
+	n, err := v.VerifyVector(pos, SizeByte, SizeByte)
+	if err != nil {
+		return err
+	}
+	end := uint64(pos) + SizeUOffsetT + uint64(n)
+	if !v.in(end, 1) || v.buf[end] != 0 {
+		return v.errorf("string at %d is not null terminated", pos)
+	}
+	return nil
+}
+
+// VerifyVector checks the length and the elements of the vector at pos and
+// returns the length.
+func (v *Verifier) VerifyVector(pos UOffsetT, elemSize, align UOffsetT) (int, error) {
+	if err := v.check(uint64(pos), SizeUOffsetT, SizeUOffsetT, "vector"); err != nil {
+		return 0, err
+	}
+	n := GetUOffsetT(v.buf[pos:])
+	data := uint64(pos) + SizeUOffsetT
+	if !v.aligned(data, uint64(align)) {
+		return 0, v.errorf("elements of vector at %d are not aligned to %d", pos, align)
+	}
+	if size := uint64(n) * uint64(elemSize); size > maxBufferSize || !v.in(data, size) {
+		return 0, v.errorf("vector at %d of %d elements is out of range", pos, n)
+	}
+	return int(n), nil
+}
+
+// field returns the position of a field in the table at pos, 0 when the
+// field is absent. The table must have passed VerifyTableStart.
+func (v *Verifier) field(pos UOffsetT, vtableOffset VOffsetT) UOffsetT {
+	vtable := UOffsetT(SOffsetT(pos) - GetSOffsetT(v.buf[pos:]))
+	if vtableOffset >= GetVOffsetT(v.buf[vtable:]) {
+		return 0
+	}
+	if o := GetVOffsetT(v.buf[vtable+UOffsetT(vtableOffset):]); o != 0 {
+		return pos + UOffsetT(o)
+	}
+	return 0
+}
+
+// offsetField follows an offset field, ok is false when the field is absent
+// or invalid and err tells which.
+func (v *Verifier) offsetField(pos UOffsetT, vtableOffset VOffsetT, required bool) (UOffsetT, bool, error) {
+	at := v.field(pos, vtableOffset)
+	if at == 0 {
+		return 0, false, v.missing(pos, vtableOffset, required)
+	}
+	target, err := v.offset(at)
+	return target, err == nil, err
+}
+
+// offset follows the uoffset stored at at. Offsets only point forward, so
+// following them always terminates.
+func (v *Verifier) offset(at UOffsetT) (UOffsetT, error) {
+	if err := v.check(uint64(at), SizeUOffsetT, SizeUOffsetT, "offset"); err != nil {
+		return 0, err
+	}
+	o := GetUOffsetT(v.buf[at:])
+	target := uint64(at) + uint64(o)
+	if o == 0 || o > maxBufferSize || !v.in(target, 1) {
+		return 0, v.errorf("offset %d at %d is out of range", o, at)
+	}
+	return UOffsetT(target), nil
+}
+
+func (v *Verifier) missing(pos UOffsetT, vtableOffset VOffsetT, required bool) error {
+	if required {
+		return v.errorf("required field at vtable offset %d of table at %d is missing", vtableOffset, pos)
+	}
+	return nil
+}
+
+// check reports whether size bytes at pos are inside the buffer and pos is
+// aligned to align.
+func (v *Verifier) check(pos, size, align uint64, what string) error {
+	if !v.aligned(pos, align) {
+		return v.errorf("%s at %d is not aligned to %d", what, pos, align)
+	}
+	if !v.in(pos, size) {
+		return v.errorf("%s at %d of %d bytes is out of range, buffer size is %d", what, pos, size, len(v.buf))
+	}
+	return nil
+}
+
+func (v *Verifier) in(pos, size uint64) bool {
+	return pos <= uint64(len(v.buf)) && size <= uint64(len(v.buf))-pos
+}
+
+func (v *Verifier) aligned(pos, align uint64) bool {
+	return v.opts.AllowUnaligned || align <= 1 || pos&(align-1) == 0
+}
+
+func (v *Verifier) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrVerify, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,171 @@
+package flatbuffers_test
+
+import (
+	"errors"
+	"github.com/jiangshuai341/zbus/benchmark/fb/Example"
+	"github.com/jiangshuai341/zbus/benchmark/fb/testinclude"
+	"github.com/jiangshuai341/zbus/flatbuffers"
+	"math/rand"
+	"testing"
+)
+
+func monster(weapons int) []byte {
+	m := &Example.MonsterT{
+		Pos:       &testinclude.Vec3T{X: 1, Y: 2, Z: 3},
+		Mana:      10,
+		Hp:        20,
+		Name:      "orc",
+		Inventory: []byte{1, 2, 3},
+		Color:     Example.ColorGreen,
+		Path:      []*testinclude.Vec3T{{X: 1}, {Y: 2}},
+	}
+	for i := 0; i < weapons; i++ {
+		m.Weapons = append(m.Weapons, &Example.WeaponT{Name: "axe", Damage: int32(i)})
+	}
+	b := flatbuffers.NewBuilder(0)
+	b.Finish(m.Pack(b))
+	return b.FinishedBytes()
+}
+
+// indirect 根表中偏移字段指向的位置
+func indirect(buf []byte, vtableOffset flatbuffers.VOffsetT) flatbuffers.UOffsetT {
+	t := flatbuffers.Table{Bytes: buf, Pos: flatbuffers.GetUOffsetT(buf)}
+	return t.Indirect(t.Pos + flatbuffers.UOffsetT(t.Offset(vtableOffset)))
+}
+
+// unverifiable a table generated without a Verify method
+type unverifiable struct {
+	tab flatbuffers.Table
+}
+
+func (u *unverifiable) Init(buf []byte, i flatbuffers.UOffsetT) {
+	u.tab = flatbuffers.Table{Bytes: buf, Pos: i}
+}
+
+func TestVerifier_NotVerifiable(t *testing.T) {
+	buf := monster(1)
+	if err := flatbuffers.VerifyRoot(buf, new(unverifiable)); !errors.Is(err, flatbuffers.ErrNotVerifiable) {
+		t.Fatalf("VerifyRoot: want ErrNotVerifiable, got %v", err)
+	}
+	if err := (flatbuffers.FlatbuffersCodec{}).Unmarshal(buf, new(unverifiable)); !errors.Is(err, flatbuffers.ErrNotVerifiable) {
+		t.Fatalf("Unmarshal: want ErrNotVerifiable, got %v", err)
+	}
+	if err := (flatbuffers.FlatbuffersCodec{SkipVerify: true}).Unmarshal(buf, new(unverifiable)); err != nil {
+		t.Fatalf("Unmarshal with SkipVerify: %v", err)
+	}
+}
+
+func TestVerifier_Valid(t *testing.T) {
+	buf := monster(3)
+	if err := Example.VerifyRootAsMonster(buf); err != nil {
+		t.Fatal(err)
+	}
+	var m Example.Monster
+	if err := (flatbuffers.FlatbuffersCodec{}).Unmarshal(buf, &m); err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Name()) != "orc" || m.WeaponsLength() != 3 {
+		t.Fatalf("unexpected monster %s %d", m.Name(), m.WeaponsLength())
+	}
+
+	b := flatbuffers.NewBuilder(0)
+	b.FinishSizePrefixed((&Example.WeaponT{Name: "bow"}).Pack(b))
+	err := flatbuffers.NewVerifier(b.FinishedBytes(), flatbuffers.VerifierOptions{}).VerifySizePrefixedBuffer("", Example.WeaponVerify)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifier_Corrupt(t *testing.T) {
+	valid := monster(3)
+	cases := []struct {
+		name   string
+		mutate func(buf []byte) []byte
+	}{
+		{"empty", func(buf []byte) []byte { return nil }},
+		{"root offset out of range", func(buf []byte) []byte {
+			buf[0], buf[1], buf[2], buf[3] = 0xff, 0xff, 0xff, 0x7f
+			return buf
+		}},
+		{"unaligned root", func(buf []byte) []byte {
+			buf[0]++
+			return buf
+		}},
+		{"vtable out of range", func(buf []byte) []byte {
+			root := flatbuffers.GetUOffsetT(buf)
+			flatbuffers.WriteSOffsetT(buf[root:], -0x1000)
+			return buf
+		}},
+		{"odd vtable size", func(buf []byte) []byte {
+			root := flatbuffers.GetUOffsetT(buf)
+			vtable := flatbuffers.SOffsetT(root) - flatbuffers.GetSOffsetT(buf[root:])
+			buf[vtable]++
+			return buf
+		}},
+		{"string not terminated", func(buf []byte) []byte {
+			buf[indirect(buf, 10)+flatbuffers.SizeUOffsetT+3] = 'x'
+			return buf
+		}},
+		{"vector too long", func(buf []byte) []byte {
+			flatbuffers.WriteUOffsetT(buf[indirect(buf, 14):], 1<<20)
+			return buf
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			buf := c.mutate(append([]byte(nil), valid...))
+			err := Example.VerifyRootAsMonster(buf)
+			if !errors.Is(err, flatbuffers.ErrVerify) {
+				t.Fatalf("want ErrVerify, got %v", err)
+			}
+			if err = (flatbuffers.FlatbuffersCodec{}).Unmarshal(buf, new(Example.Monster)); !errors.Is(err, flatbuffers.ErrVerify) {
+				t.Fatalf("Unmarshal: want ErrVerify, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifier_Limits(t *testing.T) {
+	buf := monster(10)
+	err := flatbuffers.NewVerifier(buf, flatbuffers.VerifierOptions{MaxTables: 5}).VerifyBuffer("", Example.MonsterVerify)
+	if !errors.Is(err, flatbuffers.ErrVerify) {
+		t.Fatalf("MaxTables: want ErrVerify, got %v", err)
+	}
+	err = flatbuffers.NewVerifier(buf, flatbuffers.VerifierOptions{MaxDepth: 1}).VerifyBuffer("", Example.MonsterVerify)
+	if !errors.Is(err, flatbuffers.ErrVerify) {
+		t.Fatalf("MaxDepth: want ErrVerify, got %v", err)
+	}
+	if err = flatbuffers.NewVerifier(buf, flatbuffers.VerifierOptions{MaxDepth: 2}).VerifyBuffer("", Example.MonsterVerify); err != nil {
+		t.Fatal(err)
+	}
+	if err = flatbuffers.NewVerifier(buf, flatbuffers.VerifierOptions{}).VerifyBuffer("MONS", Example.MonsterVerify); !errors.Is(err, flatbuffers.ErrVerify) {
+		t.Fatalf("identifier: want ErrVerify, got %v", err)
+	}
+}
+
+// 通过检查的数据读取所有字段都不能panic
+func TestVerifier_Fuzz(t *testing.T) {
+	valid := monster(3)
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < len(valid); n++ {
+		if Example.VerifyRootAsMonster(valid[:n]) == nil {
+			Example.GetRootAsMonster(valid[:n], 0).UnPack()
+		}
+	}
+	for i := 0; i < 20000; i++ {
+		buf := append([]byte(nil), valid...)
+		for j := rnd.Intn(4); j >= 0; j-- {
+			buf[rnd.Intn(len(buf))] = byte(rnd.Intn(256))
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("verified buffer %x panics: %v", buf, r)
+				}
+			}()
+			if Example.VerifyRootAsMonster(buf) == nil {
+				Example.GetRootAsMonster(buf, 0).UnPack()
+			}
+		}()
+	}
+}
@@ -0,0 +1,287 @@
+package hash
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Balancer 把key放置到节点上的算法 HashRing/JumpHash/Rendezvous/Maglev 均实现该接口
+// 所有实现的Get都是无锁的 Add/Del 可与Get并发
+type Balancer[T INode] interface {
+	Add(n T)
+	Del(n T)
+	Get(key []byte) T
+	Nodes() []T
+	Len() int
+}
+
+var (
+	_ Balancer[INode] = (*HashRing[INode])(nil)
+	_ Balancer[INode] = (*JumpHash[INode])(nil)
+	_ Balancer[INode] = (*Rendezvous[INode])(nil)
+	_ Balancer[INode] = (*Maglev[INode])(nil)
+)
+
+type entry[T INode] struct {
+	id   string
+	hash uint64
+	data T
+}
+
+// entrySet 按加入顺序保存的节点集合 copy-on-write 供各Balancer复用
+type entrySet[T INode] struct {
+	mu       sync.Mutex
+	snapshot atomic.Value // []entry[T]
+}
+
+func (s *entrySet[T]) load() []entry[T] {
+	ret, _ := s.snapshot.Load().([]entry[T])
+	return ret
+}
+
+// update 在写锁内基于旧集合生成新集合 rebuild 在发布前根据新集合构建派生数据
+func (s *entrySet[T]) update(fn func(old []entry[T]) []entry[T], rebuild func([]entry[T])) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := fn(s.load())
+	if rebuild != nil {
+		rebuild(entries)
+	}
+	s.snapshot.Store(entries)
+}
+
+func (s *entrySet[T]) add(n T, rebuild func([]entry[T])) {
+	id := string(n.GetID())
+	s.update(func(old []entry[T]) []entry[T] {
+		entries := make([]entry[T], 0, len(old)+1)
+		replaced := false
+		for _, e := range old {
+			if e.id == id {
+				e.data = n
+				replaced = true
+			}
+			entries = append(entries, e)
+		}
+		if !replaced {
+			entries = append(entries, entry[T]{id: id, hash: hash64([]byte(id)), data: n})
+		}
+		return entries
+	}, rebuild)
+}
+
+func (s *entrySet[T]) del(n T, rebuild func([]entry[T])) {
+	id := string(n.GetID())
+	s.update(func(old []entry[T]) []entry[T] {
+		entries := make([]entry[T], 0, len(old))
+		for _, e := range old {
+			if e.id != id {
+				entries = append(entries, e)
+			}
+		}
+		return entries
+	}, rebuild)
+}
+
+func (s *entrySet[T]) nodes() []T {
+	entries := s.load()
+	ret := make([]T, 0, len(entries))
+	for _, e := range entries {
+		ret = append(ret, e.data)
+	}
+	return ret
+}
+
+// hash64 64位 FNV-1a 再经过一次混淆 保证低位分布均匀
+func hash64(data []byte) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	var h uint64 = offset64
+	for _, c := range data {
+		h ^= uint64(c)
+		h *= prime64
+	}
+	return mix64(h)
+}
+
+// mix64 splitmix64 finalizer
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// JumpConsistentHash Lamping & Veach 返回 [0, buckets) 内的桶号
+func JumpConsistentHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// JumpHash 桶号即节点加入顺序 O(1)内存 O(ln n)查找
+// 只有在尾部增删节点时才满足最小迁移 适合编号连续的实例(如 serviceID 0..n-1)
+type JumpHash[T INode] struct {
+	set entrySet[T]
+}
+
+func NewJumpHash[T INode]() *JumpHash[T] {
+	return &JumpHash[T]{}
+}
+
+func (j *JumpHash[T]) Add(n T) { j.set.add(n, nil) }
+func (j *JumpHash[T]) Del(n T) { j.set.del(n, nil) }
+
+func (j *JumpHash[T]) Get(key []byte) (ret T) {
+	entries := j.set.load()
+	if len(entries) == 0 {
+		return
+	}
+	return entries[JumpConsistentHash(hash64(key), len(entries))].data
+}
+
+// GetByNumber 直接对数值key(如entityID)分桶
+func (j *JumpHash[T]) GetByNumber(key uint64) (ret T) {
+	entries := j.set.load()
+	if len(entries) == 0 {
+		return
+	}
+	return entries[JumpConsistentHash(key, len(entries))].data
+}
+
+func (j *JumpHash[T]) Nodes() []T { return j.set.nodes() }
+func (j *JumpHash[T]) Len() int   { return len(j.set.load()) }
+
+// Rendezvous 最高随机权重(HRW)哈希 每个key选择得分最高的节点
+// 节点增删时只迁移属于该节点的key O(n)查找 适合节点数较少的服务
+type Rendezvous[T INode] struct {
+	set entrySet[T]
+}
+
+func NewRendezvous[T INode]() *Rendezvous[T] {
+	return &Rendezvous[T]{}
+}
+
+func (r *Rendezvous[T]) Add(n T) { r.set.add(n, nil) }
+func (r *Rendezvous[T]) Del(n T) { r.set.del(n, nil) }
+
+func (r *Rendezvous[T]) Get(key []byte) (ret T) {
+	entries := r.set.load()
+	keyHash := hash64(key)
+	var best uint64
+	var bestID string
+	for i := range entries {
+		score := mix64(keyHash ^ entries[i].hash)
+		if i == 0 || score > best || (score == best && entries[i].id < bestID) {
+			best, bestID, ret = score, entries[i].id, entries[i].data
+		}
+	}
+	return
+}
+
+func (r *Rendezvous[T]) Nodes() []T { return r.set.nodes() }
+func (r *Rendezvous[T]) Len() int   { return len(r.set.load()) }
+
+// DefaultMaglevTableSize 查找表大小 需为质数且远大于节点数
+const DefaultMaglevTableSize = 65537
+
+// Maglev Google Maglev 一致性哈希 O(1)查找 分布非常均匀 节点增删时少量额外迁移
+type Maglev[T INode] struct {
+	size   uint64
+	set    entrySet[T]
+	lookup atomic.Value // *maglevLookup[T]
+}
+
+type maglevLookup[T INode] struct {
+	order []entry[T] // 按ID排序
+	table []int32    // 指向order的下标
+}
+
+// NewMaglev tableSize <=0 时使用 DefaultMaglevTableSize 非质数向上取到下一个质数
+// 表大小为合数时排列不能覆盖整张表 填表会死循环
+func NewMaglev[T INode](tableSize int) *Maglev[T] {
+	if tableSize <= 0 {
+		tableSize = DefaultMaglevTableSize
+	}
+	return &Maglev[T]{size: nextPrime(uint64(tableSize))}
+}
+
+// nextPrime 不小于n的最小质数 最小为2
+func nextPrime(n uint64) uint64 {
+	if n <= 2 {
+		return 2
+	}
+	if n%2 == 0 {
+		n++
+	}
+	for ; ; n += 2 {
+		prime := true
+		for d := uint64(3); d*d <= n; d += 2 {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
+
+func (m *Maglev[T]) Add(n T) { m.set.add(n, m.rebuild) }
+func (m *Maglev[T]) Del(n T) { m.set.del(n, m.rebuild) }
+
+func (m *Maglev[T]) rebuild(entries []entry[T]) {
+	order := make([]entry[T], len(entries))
+	copy(order, entries)
+	// 填表结果依赖节点顺序 按ID排序保证各进程一致
+	sort.Slice(order, func(i, j int) bool { return order[i].id < order[j].id })
+
+	table := make([]int32, m.size)
+	for i := range table {
+		table[i] = -1
+	}
+	if len(order) > 0 {
+		offsets := make([]uint64, len(order))
+		skips := make([]uint64, len(order))
+		next := make([]uint64, len(order))
+		for i, e := range order {
+			offsets[i] = e.hash % m.size
+			skips[i] = mix64(e.hash^0x9e3779b97f4a7c15)%(m.size-1) + 1
+		}
+		for filled := uint64(0); filled < m.size; {
+			for i := range order {
+				c := (offsets[i] + next[i]*skips[i]) % m.size
+				for table[c] >= 0 {
+					next[i]++
+					c = (offsets[i] + next[i]*skips[i]) % m.size
+				}
+				table[c] = int32(i)
+				next[i]++
+				if filled++; filled == m.size {
+					break
+				}
+			}
+		}
+	}
+	m.lookup.Store(&maglevLookup[T]{order: order, table: table})
+}
+
+func (m *Maglev[T]) Get(key []byte) (ret T) {
+	lookup, _ := m.lookup.Load().(*maglevLookup[T])
+	if lookup == nil || len(lookup.order) == 0 {
+		return
+	}
+	return lookup.order[lookup.table[hash64(key)%m.size]].data
+}
+
+func (m *Maglev[T]) Nodes() []T { return m.set.nodes() }
+func (m *Maglev[T]) Len() int   { return len(m.set.load()) }
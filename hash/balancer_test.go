@@ -0,0 +1,102 @@
+package hash
+
+import (
+	"strconv"
+	"testing"
+)
+
+func newTestKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte("entity-" + strconv.Itoa(i))
+	}
+	return keys
+}
+
+func TestEvaluateBalancer(t *testing.T) {
+	const nodes = 10
+	keys := newTestKeys(100000)
+	balancers := map[string]Balancer[*service]{
+		"ring":       NewHashRingWithHasher[*service]("ring", 160, XXHash32),
+		"jump":       NewJumpHash[*service](),
+		"rendezvous": NewRendezvous[*service](),
+		"maglev":     NewMaglev[*service](0),
+	}
+	for name, b := range balancers {
+		for i := 0; i < nodes; i++ {
+			b.Add(&service{member: int32(i)})
+		}
+		// 对JumpHash而言移除最后一个节点才是最小迁移
+		r := EvaluateBalancer[*service](b, keys, &service{member: nodes}, &service{member: nodes - 1})
+		t.Logf("%-10s %s", name, r)
+
+		if r.MovedOnJoin > 1.5/float64(nodes+1) {
+			t.Errorf("%s moved %.4f keys on join", name, r.MovedOnJoin)
+		}
+		if r.MovedOnLeave > 1.5/float64(nodes) {
+			t.Errorf("%s moved %.4f keys on leave", name, r.MovedOnLeave)
+		}
+		if r.MaxLoadRatio > 1.5 {
+			t.Errorf("%s max/avg load %.3f", name, r.MaxLoadRatio)
+		}
+		if b.Len() != nodes {
+			t.Errorf("%s has %d nodes after evaluate", name, b.Len())
+		}
+	}
+}
+
+func TestJumpConsistentHash(t *testing.T) {
+	// 与论文参考实现的结果一致
+	if got := JumpConsistentHash(1, 1); got != 0 {
+		t.Fatalf("got %d", got)
+	}
+	for key := uint64(0); key < 1000; key++ {
+		prev := JumpConsistentHash(key, 10)
+		next := JumpConsistentHash(key, 11)
+		if next != prev && next != 10 {
+			t.Fatalf("key:%d moved from %d to %d", key, prev, next)
+		}
+	}
+}
+
+func TestMaglev_TableSize(t *testing.T) {
+	// 合数和过小的表大小取到下一个质数 不能死循环或除零
+	for size, want := range map[int]uint64{1: 2, 2: 2, 1000: 1009, 4096: 4099, 65537: 65537} {
+		m := NewMaglev[*service](size)
+		if m.size != want {
+			t.Fatalf("size %d: table size %d want %d", size, m.size, want)
+		}
+		for i := 0; i < 3; i++ {
+			m.Add(&service{member: int32(i)})
+		}
+		seen := make(map[int32]bool)
+		for _, key := range newTestKeys(1000) {
+			seen[m.Get(key).member] = true
+		}
+		if want >= 3 && len(seen) != 3 {
+			t.Fatalf("size %d: keys map to %d nodes", size, len(seen))
+		}
+	}
+}
+
+func BenchmarkRendezvous_Get(b *testing.B) {
+	r := NewRendezvous[*service]()
+	for i := 0; i < 16; i++ {
+		r.Add(&service{member: int32(i)})
+	}
+	key := []byte("entity-1")
+	for i := 0; i < b.N; i++ {
+		r.Get(key)
+	}
+}
+
+func BenchmarkMaglev_Get(b *testing.B) {
+	m := NewMaglev[*service](0)
+	for i := 0; i < 16; i++ {
+		m.Add(&service{member: int32(i)})
+	}
+	key := []byte("entity-1")
+	for i := 0; i < b.N; i++ {
+		m.Get(key)
+	}
+}
@@ -0,0 +1,69 @@
+package hash
+
+import (
+	"fmt"
+	"math"
+)
+
+// BalanceReport 放置算法在一组key上的表现 用于按服务选择算法
+type BalanceReport struct {
+	Nodes        int
+	Keys         int
+	LoadStdDev   float64 // 各节点key数量的标准差 / 平均值 (变异系数)
+	MaxLoadRatio float64 // 最大负载 / 平均负载
+	MovedOnJoin  float64 // 加入一个节点后迁移的key比例 理想值为 1/(Nodes+1)
+	MovedOnLeave float64 // 移除一个节点后迁移的key比例 理想值为 1/Nodes
+}
+
+func (r BalanceReport) String() string {
+	return fmt.Sprintf("nodes:%d keys:%d cv:%.4f max/avg:%.3f join-moved:%.4f leave-moved:%.4f",
+		r.Nodes, r.Keys, r.LoadStdDev, r.MaxLoadRatio, r.MovedOnJoin, r.MovedOnLeave)
+}
+
+// EvaluateBalancer 统计负载分布 并分别加入join、移除leave后统计迁移比例
+// 结束时b恢复为原来的节点集合 (JumpHash中leave会被移到末尾)
+func EvaluateBalancer[T INode](b Balancer[T], keys [][]byte, join T, leave T) (r BalanceReport) {
+	r.Nodes = b.Len()
+	r.Keys = len(keys)
+	if r.Nodes == 0 || r.Keys == 0 {
+		return
+	}
+
+	before := make([]string, len(keys))
+	loads := make(map[string]int, r.Nodes)
+	for i, k := range keys {
+		before[i] = string(b.Get(k).GetID())
+		loads[before[i]]++
+	}
+	mean := float64(r.Keys) / float64(r.Nodes)
+	var variance float64
+	var max int
+	for _, n := range b.Nodes() {
+		l := loads[string(n.GetID())]
+		variance += (float64(l) - mean) * (float64(l) - mean)
+		if l > max {
+			max = l
+		}
+	}
+	r.LoadStdDev = math.Sqrt(variance/float64(r.Nodes)) / mean
+	r.MaxLoadRatio = float64(max) / mean
+
+	b.Add(join)
+	r.MovedOnJoin = moved(b, keys, before)
+	b.Del(join)
+
+	b.Del(leave)
+	r.MovedOnLeave = moved(b, keys, before)
+	b.Add(leave)
+	return
+}
+
+func moved[T INode](b Balancer[T], keys [][]byte, before []string) float64 {
+	var n int
+	for i, k := range keys {
+		if string(b.Get(k).GetID()) != before[i] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(keys))
+}
@@ -0,0 +1,124 @@
+package hash
+
+import (
+	"hash/crc32"
+	"math/bits"
+)
+
+// Hasher 将key映射到环上的位置
+type Hasher func(data []byte) uint32
+
+// CRC32 默认哈希 与早期版本的节点分布保持一致
+// CRC是线性的 同一节点的虚拟节点容易聚集 新服务建议使用XXHash32或Murmur3
+func CRC32(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data)
+}
+
+// FNV1a 32位 FNV-1a
+func FNV1a(data []byte) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	var h uint32 = offset32
+	for _, c := range data {
+		h ^= uint32(c)
+		h *= prime32
+	}
+	return h
+}
+
+// Murmur3 32位 MurmurHash3_x86_32 seed为0
+func Murmur3(data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	var h uint32
+	n := len(data) / 4
+	for i := 0; i < n; i++ {
+		k := uint32(data[i*4]) | uint32(data[i*4+1])<<8 | uint32(data[i*4+2])<<16 | uint32(data[i*4+3])<<24
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	tail := data[n*4:]
+	var k uint32
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// XXHash32 32位 xxHash seed为0
+func XXHash32(data []byte) uint32 {
+	var (
+		prime1 uint32 = 2654435761
+		prime2 uint32 = 2246822519
+		prime3 uint32 = 3266489917
+		prime4 uint32 = 668265263
+		prime5 uint32 = 374761393
+	)
+	u32 := func(b []byte) uint32 {
+		return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+	}
+	round := func(acc, input uint32) uint32 {
+		acc += input * prime2
+		acc = bits.RotateLeft32(acc, 13)
+		return acc * prime1
+	}
+
+	var h uint32
+	p := data
+	if len(p) >= 16 {
+		v1 := prime1 + prime2
+		v2 := prime2
+		var v3 uint32
+		v4 := -prime1
+		for len(p) >= 16 {
+			v1 = round(v1, u32(p[0:]))
+			v2 = round(v2, u32(p[4:]))
+			v3 = round(v3, u32(p[8:]))
+			v4 = round(v4, u32(p[12:]))
+			p = p[16:]
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = prime5
+	}
+	h += uint32(len(data))
+	for ; len(p) >= 4; p = p[4:] {
+		h += u32(p) * prime3
+		h = bits.RotateLeft32(h, 17) * prime4
+	}
+	for _, c := range p {
+		h += uint32(c) * prime5
+		h = bits.RotateLeft32(h, 11) * prime1
+	}
+	h ^= h >> 15
+	h *= prime2
+	h ^= h >> 13
+	h *= prime3
+	h ^= h >> 16
+	return h
+}
@@ -1,71 +1,260 @@
 package hash
 
 import (
-	"hash/crc32"
+	"math"
 	"sort"
+	"sync"
+	"sync/atomic"
 )
 
 type INode interface {
 	GetID() []byte
 }
+
+// member 一个真实节点 在多个快照之间共享 load 因此不会随Add/Del丢失
+type member[T INode] struct {
+	id     string
+	data   T
+	weight int
+	load   int64
+}
+
 type node[T INode] struct {
 	sortKey uint32
-	hashKey uint32
-	data    T
+	m       *member[T]
+}
+
+// ringSnapshot 只读快照 Add/Del 时整体替换(copy-on-write)
+type ringSnapshot[T INode] struct {
+	nodes   []node[T]
+	members map[string]*member[T]
+	weights int
 }
+
+// HashRing 一致性哈希环
+// Get/GetN/GetLeast 无锁读取快照 Add/Del 可以在服务发现回调中并发调用
 type HashRing[T INode] struct {
 	name                 string
-	nodes                []node[T]
 	numberOfVirtualNodes int
-	hasher               func(data []byte) uint32
+	hasher               Hasher
+	loadFactor           uint64 // float64 bits 有界负载系数 c 每个节点负载上限为 ceil(平均负载*(1+c)) 0表示不限制
+
+	mu        sync.Mutex
+	snapshot  atomic.Value // *ringSnapshot[T]
+	totalLoad int64
 }
 
 func NewHashRing[T INode](name string, numberOfVirtualNodes int) *HashRing[T] {
+	return NewHashRingWithHasher[T](name, numberOfVirtualNodes, CRC32)
+}
 
-	return &HashRing[T]{
+func NewHashRingWithHasher[T INode](name string, numberOfVirtualNodes int, hasher Hasher) *HashRing[T] {
+	if numberOfVirtualNodes <= 0 {
+		numberOfVirtualNodes = 1
+	}
+	h := &HashRing[T]{
 		name:                 name,
 		numberOfVirtualNodes: numberOfVirtualNodes,
-		nodes:                make([]node[T], 0),
-		hasher:               crc32.ChecksumIEEE,
+		hasher:               hasher,
 	}
+	h.snapshot.Store(&ringSnapshot[T]{members: make(map[string]*member[T])})
+	return h
 }
 
+func (h *HashRing[T]) Name() string {
+	return h.name
+}
+
+// SetLoadFactor 开启有界负载(consistent hashing with bounded loads) 只影响GetLeast
+func (h *HashRing[T]) SetLoadFactor(c float64) {
+	atomic.StoreUint64(&h.loadFactor, math.Float64bits(c))
+}
+
+func (h *HashRing[T]) load() *ringSnapshot[T] {
+	return h.snapshot.Load().(*ringSnapshot[T])
+}
+
+// Add 权重为1 重复添加同一ID会替换原节点
 func (h *HashRing[T]) Add(n T) {
-	temp := node[T]{
-		data:    n,
-		hashKey: h.hasher(n.GetID()),
+	h.AddWeighted(n, 1)
+}
+
+// AddWeighted 节点的虚拟节点数为 numberOfVirtualNodes*weight
+func (h *HashRing[T]) AddWeighted(n T, weight int) {
+	if weight <= 0 {
+		weight = 1
 	}
-	for i := 0; i < h.numberOfVirtualNodes; i++ { //虚拟节点的映射
-		temp.sortKey = h.hasher(append(n.GetID(), byte(i)))
-		h.nodes = append(h.nodes, temp)
+	id := string(n.GetID())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	old := h.load()
+	members := make(map[string]*member[T], len(old.members)+1)
+	for k, v := range old.members {
+		members[k] = v
+	}
+	m := &member[T]{id: id, data: n, weight: weight}
+	if prev, ok := members[id]; ok {
+		m.load = atomic.LoadInt64(&prev.load)
 	}
-	sort.Sort(nodeSlice[T](h.nodes))
+	members[id] = m
+	h.snapshot.Store(h.build(members))
 }
 
+// Del 按节点ID删除 不会因哈希冲突误删其他节点
 func (h *HashRing[T]) Del(n T) {
-	hashKey := h.hasher(n.GetID())
-	for i, hasBeenDeleted := 0, 0; i < len(h.nodes) && hasBeenDeleted < h.numberOfVirtualNodes; {
-		if h.nodes[i].hashKey == hashKey {
-			hasBeenDeleted++
-			h.nodes = append(h.nodes[0:i], h.nodes[i+1:]...)
-		} else {
-			i++
+	id := string(n.GetID())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	old := h.load()
+	prev, ok := old.members[id]
+	if !ok {
+		return
+	}
+	members := make(map[string]*member[T], len(old.members))
+	for k, v := range old.members {
+		if k != id {
+			members[k] = v
+		}
+	}
+	atomic.AddInt64(&h.totalLoad, -atomic.LoadInt64(&prev.load))
+	h.snapshot.Store(h.build(members))
+}
+
+func (h *HashRing[T]) build(members map[string]*member[T]) *ringSnapshot[T] {
+	snap := &ringSnapshot[T]{members: members}
+	for _, m := range members {
+		snap.weights += m.weight
+	}
+	snap.nodes = make([]node[T], 0, snap.weights*h.numberOfVirtualNodes)
+	buf := make([]byte, 0, 64)
+	for _, m := range members {
+		for i := 0; i < h.numberOfVirtualNodes*m.weight; i++ { //虚拟节点的映射
+			buf = append(buf[:0], m.id...)
+			buf = appendVirtualIndex(buf, i)
+			snap.nodes = append(snap.nodes, node[T]{sortKey: h.hasher(buf), m: m})
 		}
 	}
+	sort.Sort(nodeSlice[T](snap.nodes))
+	return snap
+}
+
+// appendVirtualIndex 256以内与早期版本的 append(id, byte(i)) 保持一致
+func appendVirtualIndex(buf []byte, i int) []byte {
+	if i < 256 {
+		return append(buf, byte(i))
+	}
+	return append(buf, byte(i), byte(i>>8), byte(i>>16))
+}
+
+// search 二分法搜索找到[0, n)区间内最小的满足f(i)>=true的值i
+func (s *ringSnapshot[T]) search(hashvalue uint32) int {
+	i := sort.Search(len(s.nodes), func(i int) bool {
+		return s.nodes[i].sortKey >= hashvalue // 数组 a =｛0 ，1 ，2， 5， 10 ，15｝  { a[i]>=4 return i=3 } {a[i]>=14 return 5}
+	})
+	if i == len(s.nodes) {
+		i = 0
+	}
+	return i
 }
+
 func (h *HashRing[T]) Get(key []byte) (ret T) {
-	if len(h.nodes) == 0 {
+	snap := h.load()
+	if len(snap.nodes) == 0 {
 		return
 	}
-	hashvalue := h.hasher(key)
-	i := sort.Search(len(h.nodes), func(i int) bool { //二分法搜索找到[0, n)区间内最小的满足f(i)>=true的值i
-		return h.nodes[i].sortKey >= hashvalue // 数组 a =｛0 ，1 ，2， 5， 10 ，15｝  { a[i]>=4 return i=3 } {a[i]>=14 return 5}
-	})
+	return snap.nodes[snap.search(h.hasher(key))].m.data
+}
 
-	if i == len(h.nodes) {
-		i = 0
+// GetN 顺时针返回最多n个不同的节点 用于副本选择 第一个与Get结果相同
+func (h *HashRing[T]) GetN(key []byte, n int) []T {
+	snap := h.load()
+	if n > len(snap.members) {
+		n = len(snap.members)
+	}
+	if n <= 0 || len(snap.nodes) == 0 {
+		return nil
+	}
+	ret := make([]T, 0, n)
+	seen := make(map[*member[T]]struct{}, n)
+	for i, j := snap.search(h.hasher(key)), 0; j < len(snap.nodes) && len(ret) < n; i, j = (i+1)%len(snap.nodes), j+1 {
+		m := snap.nodes[i].m
+		if _, ok := seen[m]; ok {
+			continue
+		}
+		seen[m] = struct{}{}
+		ret = append(ret, m.data)
 	}
-	return h.nodes[i].data
+	return ret
+}
+
+// GetLeast 有界负载选择 跳过负载已达上限的节点
+// 选中节点后需调用 Inc 计入负载 请求结束后调用 Done
+func (h *HashRing[T]) GetLeast(key []byte) (ret T) {
+	snap := h.load()
+	if len(snap.nodes) == 0 {
+		return
+	}
+	first := snap.search(h.hasher(key))
+	c := math.Float64frombits(atomic.LoadUint64(&h.loadFactor))
+	if c <= 0 {
+		return snap.nodes[first].m.data
+	}
+	total := atomic.LoadInt64(&h.totalLoad)
+	for i, j := first, 0; j < len(snap.nodes); i, j = (i+1)%len(snap.nodes), j+1 {
+		m := snap.nodes[i].m
+		if atomic.LoadInt64(&m.load)+1 <= maxLoad(c, total, snap.weights, m.weight) {
+			return m.data
+		}
+	}
+	return snap.nodes[first].m.data
+}
+
+// maxLoad 按权重分摊后的负载上限
+func maxLoad(c float64, total int64, weights int, weight int) int64 {
+	avg := float64(total+1) * float64(weight) / float64(weights)
+	return int64(math.Ceil(avg * (1 + c)))
+}
+
+// Inc 节点负载+1 节点不在环上时忽略
+func (h *HashRing[T]) Inc(n T) {
+	if m, ok := h.load().members[string(n.GetID())]; ok {
+		atomic.AddInt64(&m.load, 1)
+		atomic.AddInt64(&h.totalLoad, 1)
+	}
+}
+
+// Done 节点负载-1 节点不在环上时忽略
+func (h *HashRing[T]) Done(n T) {
+	if m, ok := h.load().members[string(n.GetID())]; ok {
+		atomic.AddInt64(&m.load, -1)
+		atomic.AddInt64(&h.totalLoad, -1)
+	}
+}
+
+// Loads 各节点当前负载
+func (h *HashRing[T]) Loads() map[string]int64 {
+	snap := h.load()
+	ret := make(map[string]int64, len(snap.members))
+	for id, m := range snap.members {
+		ret[id] = atomic.LoadInt64(&m.load)
+	}
+	return ret
+}
+
+// Nodes 当前所有真实节点
+func (h *HashRing[T]) Nodes() []T {
+	snap := h.load()
+	ret := make([]T, 0, len(snap.members))
+	for _, m := range snap.members {
+		ret = append(ret, m.data)
+	}
+	return ret
+}
+
+func (h *HashRing[T]) Len() int {
+	return len(h.load().members)
 }
 
 type nodeSlice[T INode] []node[T]
@@ -74,6 +263,9 @@ func (n nodeSlice[T]) Len() int {
 	return len(n)
 }
 func (n nodeSlice[T]) Less(i, j int) bool {
+	if n[i].sortKey == n[j].sortKey {
+		return n[i].m.id < n[j].m.id // 哈希冲突时按ID排序 保证各进程结果一致
+	}
 	return n[i].sortKey < n[j].sortKey
 }
 func (n nodeSlice[T]) Swap(i, j int) {
@@ -41,3 +41,108 @@ func FuzzHashRing_Get(f *testing.F) {
 		ring.Get([]byte(hashKey))
 	})
 }
+
+func TestHasher_KnownVectors(t *testing.T) {
+	cases := []struct {
+		name   string
+		hasher Hasher
+		in     string
+		want   uint32
+	}{
+		{"murmur3", Murmur3, "", 0},
+		{"murmur3", Murmur3, "hello", 0x248bfa47},
+		{"xxhash32", XXHash32, "", 0x02cc5d05},
+		{"xxhash32", XXHash32, "abc", 0x32d153ff},
+		{"xxhash32", XXHash32, "Nobody inspects the spammish repetition", 0xe2293b2f},
+		{"fnv1a", FNV1a, "a", 0xe40c292c},
+	}
+	for _, c := range cases {
+		if got := c.hasher([]byte(c.in)); got != c.want {
+			t.Errorf("%s(%q) = %#x want %#x", c.name, c.in, got, c.want)
+		}
+	}
+}
+
+func TestHashRing_DelByID(t *testing.T) {
+	ring := NewHashRing[*service]("test", 32)
+	addService(ring, 1, 2, 3)
+	ring.Del(&service{member: 2})
+	if ring.Len() != 2 {
+		t.Fatalf("Len:%d after Del", ring.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if s := ring.Get([]byte(strconv.Itoa(i))); s.member == 2 {
+			t.Fatal("deleted node still returned")
+		}
+	}
+}
+
+func TestHashRing_GetN(t *testing.T) {
+	ring := NewHashRingWithHasher[*service]("test", 16, XXHash32)
+	addService(ring, 1, 2, 3, 4)
+	key := []byte("entity")
+	replicas := ring.GetN(key, 3)
+	if len(replicas) != 3 || replicas[0] != ring.Get(key) {
+		t.Fatalf("unexpected replicas %+v", replicas)
+	}
+	seen := map[int32]bool{}
+	for _, r := range replicas {
+		if seen[r.member] {
+			t.Fatalf("duplicated replica %d", r.member)
+		}
+		seen[r.member] = true
+	}
+	if n := len(ring.GetN(key, 10)); n != 4 {
+		t.Fatalf("GetN over size returned %d", n)
+	}
+}
+
+func TestHashRing_Weight(t *testing.T) {
+	ring := NewHashRingWithHasher[*service]("test", 64, Murmur3)
+	heavy, light := &service{member: 1}, &service{member: 2}
+	ring.AddWeighted(heavy, 3)
+	ring.AddWeighted(light, 1)
+	var cnt int
+	for i := 0; i < 10000; i++ {
+		if ring.Get([]byte(strconv.Itoa(i))) == heavy {
+			cnt++
+		}
+	}
+	if cnt < 6500 || cnt > 8500 {
+		t.Fatalf("weight 3:1 got %d/10000 keys on heavy node", cnt)
+	}
+}
+
+func TestHashRing_BoundedLoad(t *testing.T) {
+	ring := NewHashRing[*service]("test", 32)
+	addService(ring, 1, 2, 3, 4)
+	ring.SetLoadFactor(0.25)
+	for i := 0; i < 400; i++ {
+		ring.Inc(ring.GetLeast([]byte("same-hot-key")))
+	}
+	for id, load := range ring.Loads() {
+		if load > 125 {
+			t.Fatalf("node %s load %d exceeds bound", id, load)
+		}
+	}
+}
+
+func TestHashRing_ConcurrentAddDel(t *testing.T) {
+	ring := NewHashRing[*service]("test", 32)
+	addService(ring, 1, 2, 3)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			s := &service{member: int32(100 + i%5)}
+			ring.Add(s)
+			ring.Del(s)
+		}
+	}()
+	for i := 0; i < 20000; i++ {
+		if ring.Get([]byte(strconv.Itoa(i))) == nil {
+			t.Fatal("Get returned nil while ring not empty")
+		}
+	}
+	<-done
+}
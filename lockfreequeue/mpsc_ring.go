@@ -0,0 +1,120 @@
+package lockfreequeue
+
+import (
+	"sync/atomic"
+)
+
+type mpscSlot[T any] struct {
+	seq uint64
+	val T
+}
+
+// MPSCRing 多生产者单消费者有界环形队列 (Vyukov bounded queue)
+// 生产者之间只竞争tail 队列未满时CAS失败会重试 不会像LockFreeRingArray那样虚假失败
+// Dequeue/DrainTo 只能在一个goroutine中调用
+type MPSCRing[T any] struct {
+	_         [cacheLinePad]byte
+	tail      uint64 // 生产者竞争
+	_         [cacheLinePad - 8]byte
+	published uint64 // 此前的槽位都已写入 由生产者推进
+	_         [cacheLinePad - 8]byte
+	head      uint64 // 消费者独占
+	_         [cacheLinePad - 8]byte
+	mask      uint64
+	slots     []mpscSlot[T]
+}
+
+// NewMPSCRing capacity 向上取整到2的幂
+func NewMPSCRing[T any](capacity uint32) *MPSCRing[T] {
+	if capacity < 2 {
+		capacity = 2
+	}
+	capacity = minQuantity(capacity)
+	q := &MPSCRing[T]{
+		mask:  uint64(capacity - 1),
+		slots: make([]mpscSlot[T], capacity),
+	}
+	for i := range q.slots {
+		q.slots[i].seq = uint64(i)
+	}
+	return q
+}
+
+func (q *MPSCRing[T]) Enqueue(v T) bool {
+	for {
+		pos := atomic.LoadUint64(&q.tail)
+		slot := &q.slots[pos&q.mask]
+		seq := atomic.LoadUint64(&slot.seq)
+		switch dif := int64(seq - pos); {
+		case dif == 0:
+			if atomic.CompareAndSwapUint64(&q.tail, pos, pos+1) {
+				slot.val = v
+				atomic.StoreUint64(&slot.seq, pos+1)
+				q.publish()
+				return true
+			}
+		case dif < 0:
+			// 槽位还未被消费者释放 队列已满
+			return false
+		}
+		// dif > 0 其他生产者已占用该槽位 重新读取tail
+	}
+}
+
+// publish 把published推进到第一个已占位但尚未写入的槽位
+// 写入seq之后才读取published 与先写入的生产者之间总有一方能看到对方 不会停在已写入的槽位前
+func (q *MPSCRing[T]) publish() {
+	for {
+		pos := atomic.LoadUint64(&q.published)
+		// seq == pos 表示该槽位尚未写入 大于pos表示已写入或已被消费
+		if int64(atomic.LoadUint64(&q.slots[pos&q.mask].seq)-pos) <= 0 {
+			return
+		}
+		atomic.CompareAndSwapUint64(&q.published, pos, pos+1)
+	}
+}
+
+func (q *MPSCRing[T]) Dequeue() (v T, ok bool) {
+	pos := q.head
+	slot := &q.slots[pos&q.mask]
+	if atomic.LoadUint64(&slot.seq) != pos+1 {
+		return
+	}
+	var zero T
+	v = slot.val
+	slot.val = zero
+	atomic.StoreUint64(&slot.seq, pos+q.mask+1)
+	atomic.StoreUint64(&q.head, pos+1)
+	return v, true
+}
+
+func (q *MPSCRing[T]) DrainTo(buf []T) int {
+	pos := q.head
+	var zero T
+	var n int
+	for ; n < len(buf); n++ {
+		slot := &q.slots[(pos+uint64(n))&q.mask]
+		if atomic.LoadUint64(&slot.seq) != pos+uint64(n)+1 {
+			break
+		}
+		buf[n] = slot.val
+		slot.val = zero
+		atomic.StoreUint64(&slot.seq, pos+uint64(n)+q.mask+1)
+	}
+	atomic.StoreUint64(&q.head, pos+uint64(n))
+	return n
+}
+
+// Len 可以出队的元素数 不包含已占位但尚未写入的元素 Len 大于0时 Dequeue 一定成功
+func (q *MPSCRing[T]) Len() int {
+	head := atomic.LoadUint64(&q.head)
+	published := atomic.LoadUint64(&q.published)
+	if published < head {
+		return 0
+	}
+	return int(published - head)
+}
+
+func (q *MPSCRing[T]) Cap() int {
+	return len(q.slots)
+}
@@ -0,0 +1,75 @@
+package lockfreequeue
+
+// cacheLinePad 防止生产者与消费者的索引落在同一缓存行造成伪共享
+const cacheLinePad = 64
+
+// Queue 有界泛型队列 不会把元素装箱成any
+type Queue[T any] interface {
+	// Enqueue 队列满时返回false 不会因CAS竞争而失败
+	Enqueue(v T) bool
+	// Dequeue 队列空时返回false
+	Dequeue() (T, bool)
+	// DrainTo 批量出队到buf 返回出队数量
+	DrainTo(buf []T) int
+	Len() int
+	Cap() int
+}
+
+// BlockingQueue 在Queue之上提供阻塞的Put/Take 等待方式由WaitStrategy决定
+type BlockingQueue[T any] struct {
+	q        Queue[T]
+	notEmpty WaitStrategy
+	notFull  WaitStrategy
+}
+
+// NewBlockingQueue newWait 为每个等待条件创建一个WaitStrategy
+func NewBlockingQueue[T any](q Queue[T], newWait func() WaitStrategy) *BlockingQueue[T] {
+	return &BlockingQueue[T]{
+		q:        q,
+		notEmpty: newWait(),
+		notFull:  newWait(),
+	}
+}
+
+// Put 队列满时阻塞
+func (b *BlockingQueue[T]) Put(v T) {
+	for !b.q.Enqueue(v) {
+		b.notFull.WaitFor(func() bool { return b.q.Len() < b.q.Cap() })
+	}
+	b.notEmpty.Signal()
+}
+
+// Offer 非阻塞入队
+func (b *BlockingQueue[T]) Offer(v T) bool {
+	if !b.q.Enqueue(v) {
+		return false
+	}
+	b.notEmpty.Signal()
+	return true
+}
+
+// Take 队列空时阻塞
+func (b *BlockingQueue[T]) Take() T {
+	for {
+		if v, ok := b.q.Dequeue(); ok {
+			b.notFull.Signal()
+			return v
+		}
+		b.notEmpty.WaitFor(func() bool { return b.q.Len() > 0 })
+	}
+}
+
+// TakeBatch 队列空时阻塞 非空时尽可能多地出队到buf
+func (b *BlockingQueue[T]) TakeBatch(buf []T) int {
+	for {
+		if n := b.q.DrainTo(buf); n > 0 {
+			b.notFull.Signal()
+			return n
+		}
+		b.notEmpty.WaitFor(func() bool { return b.q.Len() > 0 })
+	}
+}
+
+func (b *BlockingQueue[T]) Len() int {
+	return b.q.Len()
+}
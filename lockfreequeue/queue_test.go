@@ -0,0 +1,266 @@
+package lockfreequeue
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSPSCRing(t *testing.T) {
+	const cnt = 100000
+	q := NewSPSCRing[int](1024)
+	go func() {
+		for i := 0; i < cnt; i++ {
+			for !q.Enqueue(i) {
+				runtime.Gosched()
+			}
+		}
+	}()
+	buf := make([]int, 64)
+	for next := 0; next < cnt; {
+		n := q.DrainTo(buf)
+		for _, v := range buf[:n] {
+			if v != next {
+				t.Fatalf("want %d got %d", next, v)
+			}
+			next++
+		}
+		if n == 0 {
+			runtime.Gosched()
+		}
+	}
+	if q.Len() != 0 {
+		t.Fatalf("Len:%d after drain", q.Len())
+	}
+}
+
+func TestMPSCRing(t *testing.T) {
+	const producers, cnt = 8, 20000
+	q := NewMPSCRing[[2]int](1024)
+	for p := 0; p < producers; p++ {
+		go func(p int) {
+			for i := 0; i < cnt; i++ {
+				for !q.Enqueue([2]int{p, i}) {
+					runtime.Gosched()
+				}
+			}
+		}(p)
+	}
+	var last [producers]int
+	for i := range last {
+		last[i] = -1
+	}
+	for got := 0; got < producers*cnt; {
+		v, ok := q.Dequeue()
+		if !ok {
+			runtime.Gosched()
+			continue
+		}
+		if v[1] != last[v[0]]+1 {
+			t.Fatalf("producer:%d want %d got %d", v[0], last[v[0]]+1, v[1])
+		}
+		last[v[0]] = v[1]
+		got++
+	}
+}
+
+func TestMPSCRing_Full(t *testing.T) {
+	q := NewMPSCRing[int](4)
+	for i := 0; i < 4; i++ {
+		if !q.Enqueue(i) {
+			t.Fatalf("enqueue %d failed before full", i)
+		}
+	}
+	if q.Enqueue(4) {
+		t.Fatal("enqueue succeed on full queue")
+	}
+	if v, ok := q.Dequeue(); !ok || v != 0 {
+		t.Fatalf("dequeue got %d %v", v, ok)
+	}
+	if !q.Enqueue(4) {
+		t.Fatal("enqueue failed after dequeue")
+	}
+}
+
+// TestMPSCRing_LenPublished 已占位但尚未写入的槽位不计入Len 消费者不会因Len忙等
+func TestMPSCRing_LenPublished(t *testing.T) {
+	q := NewMPSCRing[int](4)
+	// 模拟一个生产者占位后还没有写入
+	atomic.AddUint64(&q.tail, 1)
+	if !q.Enqueue(1) {
+		t.Fatal("enqueue failed")
+	}
+	if n := q.Len(); n != 0 {
+		t.Fatalf("Len:%d with unpublished head", n)
+	}
+	if _, ok := q.Dequeue(); ok {
+		t.Fatal("dequeue before head published")
+	}
+	q.slots[0].val = 0
+	atomic.StoreUint64(&q.slots[0].seq, 1)
+	q.publish()
+	if n := q.Len(); n != 2 {
+		t.Fatalf("Len:%d after publish", n)
+	}
+	for want := 0; want < 2; want++ {
+		if v, ok := q.Dequeue(); !ok || v != want {
+			t.Fatalf("dequeue got %d %v want %d", v, ok, want)
+		}
+	}
+	if n := q.Len(); n != 0 {
+		t.Fatalf("Len:%d after drain", n)
+	}
+}
+
+var waitStrategies = map[string]func() WaitStrategy{
+	"yield": func() WaitStrategy { return YieldWait{} },
+	"park":  func() WaitStrategy { return NewParkWait(4) },
+}
+
+// blocked done 在 d 内没有关闭
+func blocked(done <-chan struct{}, d time.Duration) bool {
+	select {
+	case <-done:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func TestBlockingQueue_TakeBlocks(t *testing.T) {
+	for name, newWait := range waitStrategies {
+		t.Run(name, func(t *testing.T) {
+			bq := NewBlockingQueue[int](NewMPSCRing[int](4), newWait)
+			done := make(chan struct{})
+			var got int
+			go func() {
+				got = bq.Take()
+				close(done)
+			}()
+			if !blocked(done, 50*time.Millisecond) {
+				t.Fatal("Take returned on empty queue")
+			}
+			bq.Put(7)
+			if blocked(done, time.Second) {
+				t.Fatal("Take not woken by Put")
+			}
+			if got != 7 {
+				t.Fatalf("got %d", got)
+			}
+		})
+	}
+}
+
+func TestBlockingQueue_PutBlocks(t *testing.T) {
+	for name, newWait := range waitStrategies {
+		t.Run(name, func(t *testing.T) {
+			bq := NewBlockingQueue[int](NewMPSCRing[int](2), newWait)
+			bq.Put(0)
+			bq.Put(1)
+			if bq.Offer(2) {
+				t.Fatal("Offer succeed on full queue")
+			}
+			done := make(chan struct{})
+			go func() {
+				bq.Put(2)
+				close(done)
+			}()
+			if !blocked(done, 50*time.Millisecond) {
+				t.Fatal("Put returned on full queue")
+			}
+			buf := make([]int, 4)
+			if n := bq.TakeBatch(buf); n != 2 || buf[0] != 0 || buf[1] != 1 {
+				t.Fatalf("TakeBatch got %v", buf[:n])
+			}
+			if blocked(done, time.Second) {
+				t.Fatal("Put not woken by Take")
+			}
+			if v := bq.Take(); v != 2 {
+				t.Fatalf("got %d", v)
+			}
+		})
+	}
+}
+
+// TestParkWait_Wakeup 条件成立后Signal 挂起的等待方总能被唤醒 不会丢失信号
+func TestParkWait_Wakeup(t *testing.T) {
+	p := NewParkWait(0)
+	for i := 0; i < 1000; i++ {
+		var ready int32
+		done := make(chan struct{})
+		go func() {
+			p.WaitFor(func() bool { return atomic.LoadInt32(&ready) == 1 })
+			close(done)
+		}()
+		atomic.StoreInt32(&ready, 1)
+		p.Signal()
+		if blocked(done, time.Second) {
+			t.Fatalf("round %d: waiter not woken", i)
+		}
+	}
+}
+
+func benchmarkBlockingQueue(b *testing.B, producers int, q Queue[int], newWait func() WaitStrategy) {
+	bq := NewBlockingQueue[int](q, newWait)
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	per := b.N / producers
+	b.ResetTimer()
+	for p := 0; p < producers; p++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < per; i++ {
+				bq.Put(i)
+			}
+		}()
+	}
+	for i := 0; i < per*producers; i++ {
+		bq.Take()
+	}
+	wg.Wait()
+}
+
+func benchmarkChannel(b *testing.B, producers int) {
+	ch := make(chan int, 1024)
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	per := b.N / producers
+	b.ResetTimer()
+	for p := 0; p < producers; p++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < per; i++ {
+				ch <- i
+			}
+		}()
+	}
+	for i := 0; i < per*producers; i++ {
+		<-ch
+	}
+	wg.Wait()
+}
+
+func BenchmarkSPSC_Spin(b *testing.B) {
+	benchmarkBlockingQueue(b, 1, NewSPSCRing[int](1024), func() WaitStrategy { return SpinWait{} })
+}
+func BenchmarkSPSC_Yield(b *testing.B) {
+	benchmarkBlockingQueue(b, 1, NewSPSCRing[int](1024), func() WaitStrategy { return YieldWait{} })
+}
+func BenchmarkSPSC_Park(b *testing.B) {
+	benchmarkBlockingQueue(b, 1, NewSPSCRing[int](1024), func() WaitStrategy { return NewParkWait(64) })
+}
+func BenchmarkSPSC_Channel(b *testing.B) {
+	benchmarkChannel(b, 1)
+}
+
+func BenchmarkMPSC_Yield(b *testing.B) {
+	benchmarkBlockingQueue(b, 8, NewMPSCRing[int](1024), func() WaitStrategy { return YieldWait{} })
+}
+func BenchmarkMPSC_Park(b *testing.B) {
+	benchmarkBlockingQueue(b, 8, NewMPSCRing[int](1024), func() WaitStrategy { return NewParkWait(64) })
+}
+func BenchmarkMPSC_Channel(b *testing.B) {
+	benchmarkChannel(b, 8)
+}
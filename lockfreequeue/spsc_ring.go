@@ -0,0 +1,89 @@
+package lockfreequeue
+
+import (
+	"sync/atomic"
+)
+
+// SPSCRing 单生产者单消费者有界环形队列
+// Enqueue 只能在一个goroutine中调用 Dequeue/DrainTo 只能在另一个goroutine中调用
+type SPSCRing[T any] struct {
+	_          [cacheLinePad]byte
+	head       uint64 // 消费者写
+	cachedTail uint64 // 消费者缓存的tail 减少跨核读取
+	_          [cacheLinePad - 16]byte
+	tail       uint64 // 生产者写
+	cachedHead uint64 // 生产者缓存的head
+	_          [cacheLinePad - 16]byte
+	mask       uint64
+	buf        []T
+}
+
+// NewSPSCRing capacity 向上取整到2的幂
+func NewSPSCRing[T any](capacity uint32) *SPSCRing[T] {
+	if capacity < 2 {
+		capacity = 2
+	}
+	capacity = minQuantity(capacity)
+	return &SPSCRing[T]{
+		mask: uint64(capacity - 1),
+		buf:  make([]T, capacity),
+	}
+}
+
+func (q *SPSCRing[T]) Enqueue(v T) bool {
+	tail := q.tail
+	if tail-q.cachedHead > q.mask {
+		q.cachedHead = atomic.LoadUint64(&q.head)
+		if tail-q.cachedHead > q.mask {
+			return false
+		}
+	}
+	q.buf[tail&q.mask] = v
+	atomic.StoreUint64(&q.tail, tail+1)
+	return true
+}
+
+func (q *SPSCRing[T]) Dequeue() (v T, ok bool) {
+	head := q.head
+	if head >= q.cachedTail {
+		q.cachedTail = atomic.LoadUint64(&q.tail)
+		if head >= q.cachedTail {
+			return
+		}
+	}
+	var zero T
+	v = q.buf[head&q.mask]
+	q.buf[head&q.mask] = zero
+	atomic.StoreUint64(&q.head, head+1)
+	return v, true
+}
+
+func (q *SPSCRing[T]) DrainTo(buf []T) int {
+	head := q.head
+	q.cachedTail = atomic.LoadUint64(&q.tail)
+	n := int(q.cachedTail - head)
+	if n > len(buf) {
+		n = len(buf)
+	}
+	var zero T
+	for i := 0; i < n; i++ {
+		idx := (head + uint64(i)) & q.mask
+		buf[i] = q.buf[idx]
+		q.buf[idx] = zero
+	}
+	atomic.StoreUint64(&q.head, head+uint64(n))
+	return n
+}
+
+func (q *SPSCRing[T]) Len() int {
+	head := atomic.LoadUint64(&q.head)
+	tail := atomic.LoadUint64(&q.tail)
+	if tail < head {
+		return 0
+	}
+	return int(tail - head)
+}
+
+func (q *SPSCRing[T]) Cap() int {
+	return len(q.buf)
+}
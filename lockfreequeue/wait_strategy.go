@@ -0,0 +1,77 @@
+package lockfreequeue
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// WaitStrategy 队列空/满时的等待方式
+type WaitStrategy interface {
+	// WaitFor 阻塞直到cond返回true
+	WaitFor(cond func() bool)
+	// Signal 队列状态改变后调用 唤醒等待方
+	Signal()
+}
+
+// SpinWait 忙等 延迟最低 独占一个CPU
+type SpinWait struct{}
+
+func (SpinWait) WaitFor(cond func() bool) {
+	for !cond() {
+	}
+}
+
+func (SpinWait) Signal() {}
+
+// YieldWait 每次检查失败后让出调度
+type YieldWait struct{}
+
+func (YieldWait) WaitFor(cond func() bool) {
+	for !cond() {
+		runtime.Gosched()
+	}
+}
+
+func (YieldWait) Signal() {}
+
+// ParkWait 先自旋spins次 仍不满足则挂起在sync.Cond上 (类似futex)
+// 只有存在等待者时Signal才会加锁
+type ParkWait struct {
+	spins   int
+	waiters int32
+	mu      sync.Mutex
+	cond    *sync.Cond
+}
+
+func NewParkWait(spins int) *ParkWait {
+	p := &ParkWait{spins: spins}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *ParkWait) WaitFor(cond func() bool) {
+	for i := 0; i < p.spins; i++ {
+		if cond() {
+			return
+		}
+		runtime.Gosched()
+	}
+	p.mu.Lock()
+	// 先登记再检查条件 保证Signal要么能看到等待者 要么等待者能看到新状态
+	atomic.AddInt32(&p.waiters, 1)
+	for !cond() {
+		p.cond.Wait()
+	}
+	atomic.AddInt32(&p.waiters, -1)
+	p.mu.Unlock()
+}
+
+func (p *ParkWait) Signal() {
+	if atomic.LoadInt32(&p.waiters) == 0 {
+		return
+	}
+	p.mu.Lock()
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
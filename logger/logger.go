@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -37,7 +38,7 @@ const (
 
 // global Config
 var (
-	logLevel            = DEBUG
+	logLevel            = uint32(DEBUG) // 运行时可通过 SetLogLevel 修改
 	logMode     LogMode = Console
 	colored             = false
 	maxFileNum          = 10
@@ -75,7 +76,7 @@ var confOnce sync.Once
 func SetGlobalConfig(level LogLevel, mode LogMode, maxLogFileNum int, maxLogFileSize int, isColor bool, dirPath string) {
 	var isDo bool = false
 	confOnce.Do(func() {
-		logLevel = level
+		atomic.StoreUint32(&logLevel, uint32(level))
 		logMode = mode
 		maxFileNum = maxLogFileNum
 		maxFileSize = maxLogFileSize
@@ -90,6 +91,22 @@ func SetGlobalConfig(level LogLevel, mode LogMode, maxLogFileNum int, maxLogFile
 		fmt.Println(time.Now().Format("2006-01-02 15:04:05.000") + "| SetGlobalConfig Failed , please set config only once")
 	}
 }
+
+// SetLogLevel 运行时修改日志级别 线程安全 可多次调用
+func SetLogLevel(level LogLevel) {
+	atomic.StoreUint32(&logLevel, uint32(level))
+}
+
+// ParseLogLevel 不区分大小写 如 "debug" "INFO"
+func ParseLogLevel(s string) (LogLevel, bool) {
+	for lv := DEBUG; lv <= OFF; lv++ {
+		if strings.EqualFold(s, lv.string()) {
+			return lv, true
+		}
+	}
+	return OFF, false
+}
+
 func RedirectStdout(name string) {
 	lg := GetLogger("name")
 	os.Stdout = lg.writer.getOutFile()
@@ -193,6 +210,8 @@ func (lv *LogLevel) string() string {
 		return "WARN"
 	case ERROR:
 		return "ERROR"
+	case OFF:
+		return "OFF"
 	default:
 		return "UNKNOWN"
 	}
@@ -247,7 +266,7 @@ func (l *Logger) setConsole() {
 }
 
 func (l *Logger) writef(depth int, level LogLevel, format string, v []any) {
-	if level < logLevel {
+	if uint32(level) < atomic.LoadUint32(&logLevel) {
 		return
 	}
 
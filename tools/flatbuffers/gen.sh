@@ -10,6 +10,13 @@ rm -rf ${output}/*
 
 ./bin_linux/flatc ${language} -o ${output} ${pak} ${base_import_path} ${option} ./fbs/*.fbs
 
+#flatbuffers.Verifier 的检查代码 每个schema的类型输出到其namespace对应的包
+go run ../rpcgen -verify -o ${output}/lobby/example_verify.go ./fbs/example.fbs
+go run ../rpcgen -verify -o ${output}/vector/include_example_verify.go ./fbs/include_example.fbs
+
+#zrpc 实体代理与服务端代码 只处理 streaming: "rpc"/"delegate" 的 rpc_service
+go run ../rpcgen -package lobby -o ../../app/LobbyPlayerServiceRpc.go ./fbs/example.fbs
+
 
 
 #Usage: ./flatc [OPTION]... FILE... [-- BINARY_FILE...]
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"go/format"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// 生成代码的约定
+//   发送方传 flatc --gen-object-api 生成的 XxxT 由 zrpc.Pack 序列化
+//   接收方拿到的是直接指向收到数据的 *Xxx 只在回调/方法内有效
+//   delegate 的推送内容为方法的参数类型 返回类型不使用
+//   服务端的参数来自不可信的调用方 先用 rpcgen -verify 生成的 VerifyRootAsXxx 检查
+
+// Options 生成参数
+type Options struct {
+	Package    string // 生成代码的包名
+	BaseImport string // flatc --go-base-import-path 与 gen.sh 保持一致
+}
+
+type goType struct {
+	Pkg  string
+	Name string
+}
+
+func (t goType) View() string {
+	return t.Pkg + "." + t.Name
+}
+
+func (t goType) Object() string {
+	return t.Pkg + "." + t.Name + "T"
+}
+
+func (t goType) GetRoot() string {
+	return t.Pkg + ".GetRootAs" + t.Name
+}
+
+func (t goType) VerifyRoot() string {
+	return t.Pkg + ".VerifyRootAs" + t.Name
+}
+
+type genMethod struct {
+	Name     string
+	Const    string
+	Hash     int32
+	Request  goType
+	Response goType
+}
+
+type genService struct {
+	Name      string
+	Entity    string // LobbyPlayerService -> LobbyPlayer
+	TypeConst string
+	Hash      int32
+	Rpcs      []genMethod
+	Delegates []genMethod
+}
+
+type genFile struct {
+	Source   string
+	Package  string
+	Imports  []importSpec
+	Services []genService
+}
+
+type importSpec struct {
+	Alias string // 与路径最后一级相同时为空
+	Path  string
+}
+
+// Generate 为schema中 streaming 为 rpc/delegate 的服务生成代码
+// 只含gRPC方法(none/server/client/bidi)的服务跳过 两者混用报错
+// 没有需要生成的服务时返回nil
+func Generate(schema *Schema, opts Options) ([]byte, error) {
+	imports := newImportSet(opts.BaseImport)
+	file := genFile{Source: schema.File, Package: opts.Package}
+
+	for _, svc := range schema.Services {
+		zbus, other := 0, 0
+		for _, m := range svc.Methods {
+			if m.Streaming == StreamingRpc || m.Streaming == StreamingDelegate {
+				zbus++
+			} else {
+				other++
+			}
+		}
+		if zbus == 0 {
+			continue
+		}
+		if other > 0 {
+			return nil, fmt.Errorf("%s:%d: service %s mixes zrpc (rpc/delegate) and gRPC streaming kinds", schema.File, svc.Line, svc.Name)
+		}
+
+		g := genService{
+			Name:      svc.Name,
+			Entity:    strings.TrimSuffix(svc.Name, "Service"),
+			TypeConst: svc.Name + "Type",
+			Hash:      zrpc.ServiceType(svc.Name),
+		}
+		if g.Entity == "" {
+			g.Entity = svc.Name
+		}
+		hashes := make(map[int32]string)
+		for _, m := range svc.Methods {
+			req, err := imports.typeOf(m.Request)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", schema.File, m.Line, err)
+			}
+			resp, err := imports.typeOf(m.Response)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", schema.File, m.Line, err)
+			}
+			gm := genMethod{
+				Name:     m.Name,
+				Const:    svc.Name + "Func" + m.Name,
+				Hash:     zrpc.FuncHash(svc.Name, m.Name),
+				Request:  req,
+				Response: resp,
+			}
+			if exist, ok := hashes[gm.Hash]; ok {
+				return nil, fmt.Errorf("%s:%d: funcHash of %s.%s collides with %s, rename one of them", schema.File, m.Line, svc.Name, m.Name, exist)
+			}
+			hashes[gm.Hash] = m.Name
+			if m.Streaming == StreamingRpc {
+				g.Rpcs = append(g.Rpcs, gm)
+			} else {
+				g.Delegates = append(g.Delegates, gm)
+			}
+		}
+		file.Services = append(file.Services, g)
+	}
+	if len(file.Services) == 0 {
+		return nil, nil
+	}
+
+	needContext := false
+	for _, g := range file.Services {
+		needContext = needContext || len(g.Rpcs) > 0
+	}
+	paths := []string{
+		"github.com/jiangshuai341/zbus/zrpc",
+		"github.com/jiangshuai341/zbus/zrpc/client",
+		"github.com/jiangshuai341/zbus/zrpc/server",
+	}
+	if needContext {
+		paths = append(paths, "context")
+	}
+	file.Imports = imports.specs(paths...)
+	var buf bytes.Buffer
+	if err := fileTemplate.Execute(&buf, &file); err != nil {
+		return nil, err
+	}
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w\n%s", err, buf.Bytes())
+	}
+	return out, nil
+}
+
+// importSet flatc 的Go代码按namespace分包 a.b.C 位于 <base>/a/b 包名为 b
+type importSet struct {
+	base    string
+	aliases map[string]string // namespace -> 包名
+}
+
+func newImportSet(base string) *importSet {
+	if base != "" && !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return &importSet{base: base, aliases: make(map[string]string)}
+}
+
+func (s *importSet) typeOf(full string) (goType, error) {
+	i := strings.LastIndexByte(full, '.')
+	if i < 0 {
+		return goType{}, fmt.Errorf("type %s has no namespace, flatc can not generate a Go package for it", full)
+	}
+	ns, name := full[:i], full[i+1:]
+	alias, ok := s.aliases[ns]
+	if !ok {
+		alias = ns[strings.LastIndexByte(ns, '.')+1:]
+		for _, used := range s.aliases {
+			if used == alias {
+				alias = strings.ReplaceAll(ns, ".", "_")
+				break
+			}
+		}
+		s.aliases[ns] = alias
+	}
+	return goType{Pkg: alias, Name: name}, nil
+}
+
+// specs 与仓库一致 所有import放在一组按路径排序 paths 为生成代码固定使用的包
+func (s *importSet) specs(paths ...string) []importSpec {
+	var specs []importSpec
+	for _, path := range paths {
+		specs = append(specs, importSpec{Path: path})
+	}
+	for ns, alias := range s.aliases {
+		path := s.base + strings.ReplaceAll(ns, ".", "/")
+		spec := importSpec{Path: path}
+		if alias != path[strings.LastIndexByte(path, '/')+1:] {
+			spec.Alias = alias
+		}
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Path < specs[j].Path
+	})
+	return specs
+}
+
+var fileTemplate = template.Must(template.New("file").Parse(`// Code generated by rpcgen from {{.Source}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	{{if .Alias}}{{.Alias}} {{end}}"{{.Path}}"
+{{- end}}
+)
+
+{{range .Services}}{{$svc := .}}
+// {{.Name}} 的 serviceType 和 funcHash 由 zrpc.ServiceType zrpc.FuncHash 计算
+const (
+	{{.TypeConst}} int32 = {{.Hash}}
+{{- range .Rpcs}}
+	{{.Const}} int32 = {{.Hash}}
+{{- end}}
+{{- range .Delegates}}
+	{{.Const}} int32 = {{.Hash}}
+{{- end}}
+)
+
+// {{.Entity}}EntityProxy 调用方持有的实体代理 cli 为总线或网格模式的 client.Caller
+type {{.Entity}}EntityProxy struct {
+	entityID int64
+	cli      client.Caller
+}
+
+func New{{.Entity}}EntityProxy(entityID int64, cli client.Caller) *{{.Entity}}EntityProxy {
+	return &{{.Entity}}EntityProxy{
+		entityID: entityID,
+		cli:      cli,
+	}
+}
+
+func (p *{{.Entity}}EntityProxy) EntityID() int64 {
+	return p.entityID
+}
+{{range .Rpcs}}
+func (p *{{$svc.Entity}}EntityProxy) {{.Name}}Sync(ctx context.Context, in *{{.Request.Object}}) (*{{.Response.View}}, error) {
+	resp, err := p.cli.RemoteInvokeSync(ctx, {{$svc.TypeConst}}, {{.Const}}, p.entityID, zrpc.Pack(in))
+	if err != nil || len(resp) == 0 {
+		return nil, err
+	}
+	return {{.Response.GetRoot}}(resp, 0), nil
+}
+
+// {{.Name}}Async callback 在IO线程中执行 不要阻塞
+func (p *{{$svc.Entity}}EntityProxy) {{.Name}}Async(ctx context.Context, in *{{.Request.Object}}, callback func(*{{.Response.View}}, error)) {
+	p.cli.RemoteInvokeAsync(ctx, {{$svc.TypeConst}}, {{.Const}}, p.entityID, zrpc.Pack(in), func(resp []byte, err error) {
+		if err != nil || len(resp) == 0 {
+			callback(nil, err)
+			return
+		}
+		callback({{.Response.GetRoot}}(resp, 0), nil)
+	})
+}
+{{end}}
+{{- range .Delegates}}
+// Bind{{.Name}} 订阅实体的 {{.Name}} 连接断开时 callback 收到 client.ErrClosed 重连后自动重新订阅
+func (p *{{$svc.Entity}}EntityProxy) Bind{{.Name}}(callback func(*{{.Request.View}}, error)) error {
+	return p.cli.BindDelegate({{$svc.TypeConst}}, {{.Const}}, p.entityID, func(arg []byte, err error) {
+		if err != nil || len(arg) == 0 {
+			callback(nil, err)
+			return
+		}
+		callback({{.Request.GetRoot}}(arg, 0), nil)
+	})
+}
+
+func (p *{{$svc.Entity}}EntityProxy) Unbind{{.Name}}() error {
+	return p.cli.UnbindDelegate({{$svc.TypeConst}}, {{.Const}}, p.entityID)
+}
+{{end}}
+// I{{.Name}}Imp 服务端实现 参数只在方法内有效
+type I{{.Name}}Imp interface {
+{{- range .Rpcs}}
+	{{.Name}}(in *{{.Request.View}}) (*{{.Response.Object}}, error)
+{{- end}}
+}
+
+// {{.Entity}}Entity 服务端的实体 broadcaster 决定delegate由谁扇出 见 server.Broadcaster
+type {{.Entity}}Entity struct {
+	entityID    int64
+	imp         I{{.Name}}Imp
+	broadcaster server.Broadcaster
+}
+
+func New{{.Entity}}Entity(entityID int64, imp I{{.Name}}Imp, broadcaster server.Broadcaster) *{{.Entity}}Entity {
+	return &{{.Entity}}Entity{
+		entityID:    entityID,
+		imp:         imp,
+		broadcaster: broadcaster,
+	}
+}
+
+func (e *{{.Entity}}Entity) EntityID() int64 {
+	return e.entityID
+}
+
+// Dispatch 按funcHash调用实现 返回序列化后的结果 参数检查不通过时不调用实现
+func (e *{{.Entity}}Entity) Dispatch(funcHash int32, arg []byte) ([]byte, error) {
+	switch funcHash {
+{{- range .Rpcs}}
+	case {{.Const}}:
+		var in *{{.Request.View}}
+		if len(arg) > 0 {
+			if err := {{.Request.VerifyRoot}}(arg); err != nil {
+				return nil, err
+			}
+			in = {{.Request.GetRoot}}(arg, 0)
+		}
+		out, err := e.imp.{{.Name}}(in)
+		if err != nil || out == nil {
+			return nil, err
+		}
+		return zrpc.Pack(out), nil
+{{- end}}
+	default:
+		return nil, server.ErrUnknownFunc
+	}
+}
+{{range .Delegates}}
+// {{.Name}}Broadcast 通知所有订阅了 {{.Name}} 的调用方
+func (e *{{$svc.Entity}}Entity) {{.Name}}Broadcast(in *{{.Request.Object}}) error {
+	return e.broadcaster.Broadcast({{$svc.TypeConst}}, {{.Const}}, e.entityID, zrpc.Pack(in))
+}
+{{end}}
+// Register{{.Name}} 把 {{.Name}} 的所有rpc方法注册到 s entity 按entityID查找本进程的实体
+func Register{{.Name}}(s *server.Server, entity func(entityID int64) *{{.Entity}}Entity) error {
+	for _, funcHash := range []int32{ {{- range $i, $m := .Rpcs}}{{if $i}}, {{end}}{{$m.Const}}{{end -}} } {
+		funcHash := funcHash
+		err := s.RegisterHash({{.TypeConst}}, funcHash, func(entityID int64, arg []byte) ([]byte, error) {
+			e := entity(entityID)
+			if e == nil {
+				return nil, server.ErrEntityNotFound
+			}
+			return e.Dispatch(funcHash, arg)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+{{end}}`))
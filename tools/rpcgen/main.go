@@ -0,0 +1,79 @@
+// rpcgen 读取 .fbs 中的 rpc_service 生成 zrpc 的实体代理、服务端实体和注册代码
+// 只处理 streaming 为 "rpc" 和 "delegate" 的方法 不依赖 flatc
+// -verify 改为生成 table 和 union 的 flatbuffers.Verifier 检查代码 输出到flatc生成的包中
+//
+//	go run ./tools/rpcgen -package lobby -o app/LobbyPlayerServiceRpc.go tools/flatbuffers/fbs/example.fbs
+//	go run ./tools/rpcgen -verify -o build/fb/lobby/example_verify.go tools/flatbuffers/fbs/example.fbs
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	var (
+		pkg      = flag.String("package", "", "package name of the generated file, default is the last namespace component of the first service")
+		out      = flag.String("o", "", "output file, default stdout")
+		base     = flag.String("base", "github.com/jiangshuai341/zbus/build/fb/", "flatc --go-base-import-path")
+		includes = flag.String("I", "", "include directories separated by ':'")
+		verify   = flag.Bool("verify", false, "generate Verify helpers of tables and unions instead of rpc code, default package is the last namespace component")
+	)
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: rpcgen [-verify] [-package name] [-o file] [-I dirs] file.fbs")
+		os.Exit(2)
+	}
+
+	var dirs []string
+	if *includes != "" {
+		dirs = strings.Split(*includes, ":")
+	}
+	schema, err := ParseFile(flag.Arg(0), dirs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	opts := Options{Package: *pkg, BaseImport: *base}
+	var code []byte
+	if *verify {
+		code, err = GenerateVerify(schema, opts)
+	} else {
+		if opts.Package == "" {
+			opts.Package = defaultPackage(schema)
+		}
+		code, err = Generate(schema, opts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if code == nil {
+		if *verify {
+			fmt.Fprintf(os.Stderr, "%s: no table or union\n", flag.Arg(0))
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: no rpc_service with rpc or delegate methods\n", flag.Arg(0))
+		}
+		return
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(code)
+	} else {
+		err = os.WriteFile(*out, code, 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func defaultPackage(schema *Schema) string {
+	for _, svc := range schema.Services {
+		if svc.Namespace != "" {
+			return svc.Namespace[strings.LastIndexByte(svc.Namespace, '.')+1:] + "rpc"
+		}
+	}
+	return "rpc"
+}
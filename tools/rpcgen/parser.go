@@ -0,0 +1,740 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 只解析生成代码需要的部分: namespace include 类型声明 rpc_service
+// 字段只记录类型 序号和 deprecated required 用于生成verify代码 默认值和其他元数据跳过
+
+const (
+	KindTable  = "table"
+	KindStruct = "struct"
+	KindEnum   = "enum"
+	KindUnion  = "union"
+)
+
+const (
+	StreamingRpc      = "rpc"      // zrpc 请求响应
+	StreamingDelegate = "delegate" // zrpc 服务端推送
+)
+
+type Method struct {
+	Name      string
+	Request   string // 带namespace的全名
+	Response  string
+	Streaming string
+	Line      int
+
+	reqRef  typeRef
+	respRef typeRef
+}
+
+// typeRef 方法声明处的类型引用 类型可能声明在后面或include的文件中 全部解析完再查找
+type typeRef struct {
+	namespace string
+	name      string
+}
+
+type Service struct {
+	Name      string
+	Namespace string
+	Methods   []*Method
+	Line      int
+}
+
+type Schema struct {
+	File     string
+	Services []*Service
+	Types    map[string]string // 全名 -> Kind 包含include文件中的类型
+	Decls    map[string]*Decl  // 全名 -> 定义
+	Roots    []*Decl           // 根文件中声明的类型 按声明顺序
+}
+
+// Decl table struct enum union 的定义
+type Decl struct {
+	Name      string
+	Namespace string
+	Kind      string
+	Base      string         // enum 的底层类型
+	Fields    []*Field       // table struct
+	Members   []*UnionMember // union 不含 NONE
+	Line      int
+
+	file        string
+	explicitIDs bool
+}
+
+// FullName 带namespace的全名
+func (d *Decl) FullName() string {
+	if d.Namespace == "" {
+		return d.Name
+	}
+	return d.Namespace + "." + d.Name
+}
+
+type Field struct {
+	Name       string
+	Type       FieldType
+	ID         int // vtable中的序号 union字段占两个 类型在 ID-1
+	Deprecated bool
+	Required   bool
+	Line       int
+}
+
+// FieldType Elem 为标量名 string 或解析后的类型全名
+type FieldType struct {
+	Elem   string
+	Vector bool
+	Array  int // struct 中的定长数组长度
+	ref    typeRef
+}
+
+type UnionMember struct {
+	Type  string // 解析后的类型全名
+	Value int
+	ref   typeRef
+}
+
+// scalarSizes 标量类型的大小 也是对齐
+var scalarSizes = map[string]int{
+	"bool": 1, "byte": 1, "ubyte": 1, "int8": 1, "uint8": 1,
+	"short": 2, "ushort": 2, "int16": 2, "uint16": 2,
+	"int": 4, "uint": 4, "int32": 4, "uint32": 4, "float": 4, "float32": 4,
+	"long": 8, "ulong": 8, "int64": 8, "uint64": 8, "double": 8, "float64": 8,
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+type parser struct {
+	file      string
+	toks      []token
+	pos       int
+	namespace string
+	schema    *Schema
+}
+
+// ParseFile 解析 path 及其include的文件 include按 path 所在目录和 includeDirs 查找
+func ParseFile(path string, includeDirs []string) (*Schema, error) {
+	schema := &Schema{File: filepath.Base(path), Types: make(map[string]string), Decls: make(map[string]*Decl)}
+	visited := make(map[string]bool)
+	if err := parseInto(schema, path, includeDirs, visited, true); err != nil {
+		return nil, err
+	}
+	if err := schema.check(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func parseInto(schema *Schema, path string, includeDirs []string, visited map[string]bool, root bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if visited[abs] {
+		return nil
+	}
+	visited[abs] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	toks, err := tokenize(filepath.Base(path), data)
+	if err != nil {
+		return err
+	}
+	p := &parser{file: filepath.Base(path), toks: toks, schema: schema}
+	includes, err := p.parse(root)
+	if err != nil {
+		return err
+	}
+	dirs := append([]string{filepath.Dir(path)}, includeDirs...)
+	for _, inc := range includes {
+		found := ""
+		for _, dir := range dirs {
+			candidate := filepath.Join(dir, inc)
+			if _, err := os.Stat(candidate); err == nil {
+				found = candidate
+				break
+			}
+		}
+		if found == "" {
+			return fmt.Errorf("%s: include %q not found", p.file, inc)
+		}
+		if err = parseInto(schema, found, includeDirs, visited, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tokenize(file string, data []byte) ([]token, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	var toks []token
+	line := 1
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, fmt.Errorf("%s:%d: unterminated comment", file, line)
+			}
+			line += bytes.Count(data[i:i+2+end], []byte("\n"))
+			i += end + 4
+		case c == '"':
+			j := i + 1
+			for j < len(data) && data[j] != '"' && data[j] != '\n' {
+				if data[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(data) || data[j] != '"' {
+				return nil, fmt.Errorf("%s:%d: unterminated string", file, line)
+			}
+			toks = append(toks, token{tokString, string(data[i+1 : j]), line})
+			i = j + 1
+		case isIdentStart(c):
+			j := i
+			for j < len(data) && (isIdentStart(data[j]) || isDigit(data[j])) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, string(data[i:j]), line})
+			i = j
+		case isDigit(c) || c == '-' || c == '+':
+			j := i + 1
+			for j < len(data) && (isIdentStart(data[j]) || isDigit(data[j]) || data[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, string(data[i:j]), line})
+			i = j
+		case strings.IndexByte("{}()[]:;,=.", c) >= 0:
+			toks = append(toks, token{tokPunct, string(c), line})
+			i++
+		default:
+			return nil, fmt.Errorf("%s:%d: unexpected character %q", file, line, c)
+		}
+	}
+	return append(toks, token{tokEOF, "", line}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", p.file, t.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) expect(text string) error {
+	t := p.next()
+	if t.kind == tokString || t.text != text {
+		return p.errorf(t, "expected %q, got %q", text, t.text)
+	}
+	return nil
+}
+
+func (p *parser) ident() (token, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return t, p.errorf(t, "expected identifier, got %q", t.text)
+	}
+	return t, nil
+}
+
+// qualifiedIdent a.b.c
+func (p *parser) qualifiedIdent() (token, error) {
+	t, err := p.ident()
+	if err != nil {
+		return t, err
+	}
+	for p.peek().text == "." && p.peek().kind == tokPunct {
+		p.next()
+		part, err := p.ident()
+		if err != nil {
+			return t, err
+		}
+		t.text += "." + part.text
+	}
+	return t, nil
+}
+
+func (p *parser) qualify(name string) string {
+	if p.namespace == "" {
+		return name
+	}
+	return p.namespace + "." + name
+}
+
+// parse 返回本文件的include
+func (p *parser) parse(root bool) ([]string, error) {
+	var includes []string
+	for {
+		t := p.next()
+		if t.kind == tokEOF {
+			return includes, nil
+		}
+		if t.kind != tokIdent {
+			return nil, p.errorf(t, "unexpected %q", t.text)
+		}
+		switch t.text {
+		case "include", "native_include":
+			s := p.next()
+			if s.kind != tokString {
+				return nil, p.errorf(s, "expected file name after include")
+			}
+			if t.text == "include" {
+				includes = append(includes, s.text)
+			}
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case "namespace":
+			ns, err := p.qualifiedIdent()
+			if err != nil {
+				return nil, err
+			}
+			p.namespace = ns.text
+			if err = p.expect(";"); err != nil {
+				return nil, err
+			}
+		case "attribute", "file_identifier", "file_extension", "root_type":
+			if t.text == "root_type" {
+				if _, err := p.qualifiedIdent(); err != nil {
+					return nil, err
+				}
+			} else {
+				p.next()
+			}
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case KindTable, KindStruct, KindEnum, KindUnion:
+			d, err := p.parseType(t.text)
+			if err != nil {
+				return nil, err
+			}
+			if root {
+				p.schema.Roots = append(p.schema.Roots, d)
+			}
+		case "rpc_service":
+			svc, err := p.parseService()
+			if err != nil {
+				return nil, err
+			}
+			if root {
+				p.schema.Services = append(p.schema.Services, svc)
+			}
+		default:
+			return nil, p.errorf(t, "unexpected %q", t.text)
+		}
+	}
+}
+
+// parseType 解析类型的定义
+func (p *parser) parseType(kind string) (*Decl, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	d := &Decl{Name: name.text, Namespace: p.namespace, Kind: kind, Line: name.line, file: p.file}
+	full := d.FullName()
+	if _, ok := p.schema.Types[full]; ok {
+		return nil, p.errorf(name, "type %s redefined", full)
+	}
+	p.schema.Types[full] = kind
+	p.schema.Decls[full] = d
+	if kind == KindEnum {
+		if err = p.expect(":"); err != nil {
+			return nil, err
+		}
+		base, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := scalarSizes[base.text]; !ok || base.text == "bool" || strings.HasPrefix(base.text, "float") || base.text == "double" {
+			return nil, p.errorf(base, "enum %s: underlying type must be an integer, got %s", full, base.text)
+		}
+		d.Base = base.text
+	}
+	if p.peek().text == "(" {
+		if _, err = p.parseMetadata(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek().text != "{" {
+		return nil, p.errorf(p.peek(), "expected body of %s", full)
+	}
+	switch kind {
+	case KindTable, KindStruct:
+		err = p.parseFields(d)
+	case KindUnion:
+		err = p.parseMembers(d)
+	default:
+		err = p.skipBlock("{", "}")
+	}
+	return d, err
+}
+
+// parseFields name:type = default (metadata);
+func (p *parser) parseFields(d *Decl) error {
+	start := p.next()
+	explicit := 0
+	for p.peek().text != "}" || p.peek().kind != tokPunct {
+		if p.peek().kind == tokEOF {
+			return p.errorf(start, "unbalanced %q", "{")
+		}
+		name, err := p.ident()
+		if err != nil {
+			return err
+		}
+		f := &Field{Name: name.text, Line: name.line}
+		if err = p.expect(":"); err != nil {
+			return err
+		}
+		if f.Type, err = p.parseFieldType(); err != nil {
+			return err
+		}
+		if p.peek().text == "=" {
+			p.next()
+			p.next()
+		}
+		attrs := map[string]string{}
+		if p.peek().text == "(" {
+			if attrs, err = p.parseMetadata(); err != nil {
+				return err
+			}
+		}
+		_, f.Deprecated = attrs["deprecated"]
+		_, f.Required = attrs["required"]
+		if s, ok := attrs["id"]; ok {
+			explicit++
+			if _, err = fmt.Sscan(s, &f.ID); err != nil || f.ID < 0 {
+				return p.errorf(name, "bad id %q of field %s", s, f.Name)
+			}
+		}
+		d.Fields = append(d.Fields, f)
+		if err = p.expect(";"); err != nil {
+			return err
+		}
+	}
+	p.next()
+	if explicit > 0 && explicit != len(d.Fields) {
+		return p.errorf(start, "%s: either all fields or no fields must have an id", d.FullName())
+	}
+	d.explicitIDs = explicit > 0
+	return nil
+}
+
+// parseFieldType T [T] [T:N]
+func (p *parser) parseFieldType() (FieldType, error) {
+	var ft FieldType
+	vector := p.peek().text == "["
+	if vector {
+		p.next()
+	}
+	t, err := p.qualifiedIdent()
+	if err != nil {
+		return ft, err
+	}
+	if _, ok := scalarSizes[t.text]; ok || t.text == "string" {
+		ft.Elem = t.text
+	} else {
+		ft.ref = typeRef{p.namespace, t.text}
+	}
+	if !vector {
+		return ft, nil
+	}
+	if p.peek().text == ":" {
+		p.next()
+		n := p.next()
+		if _, err = fmt.Sscan(n.text, &ft.Array); n.kind != tokNumber || err != nil || ft.Array <= 0 {
+			return ft, p.errorf(n, "bad array length %q", n.text)
+		}
+	} else {
+		ft.Vector = true
+	}
+	return ft, p.expect("]")
+}
+
+// parseMembers A, Alias: ns.B, C = 5
+func (p *parser) parseMembers(d *Decl) error {
+	start := p.next()
+	value := 0
+	for p.peek().text != "}" || p.peek().kind != tokPunct {
+		if p.peek().kind == tokEOF {
+			return p.errorf(start, "unbalanced %q", "{")
+		}
+		t, err := p.qualifiedIdent()
+		if err != nil {
+			return err
+		}
+		if p.peek().text == ":" {
+			p.next()
+			if t, err = p.qualifiedIdent(); err != nil {
+				return err
+			}
+		}
+		value++
+		if p.peek().text == "=" {
+			p.next()
+			n := p.next()
+			if _, err = fmt.Sscan(n.text, &value); n.kind != tokNumber || err != nil {
+				return p.errorf(n, "bad union value %q", n.text)
+			}
+		}
+		d.Members = append(d.Members, &UnionMember{Value: value, ref: typeRef{p.namespace, t.text}})
+		if p.peek().text == "," {
+			p.next()
+		}
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) skipBlock(open string, close string) error {
+	start := p.peek()
+	if err := p.expect(open); err != nil {
+		return err
+	}
+	for depth := 1; depth > 0; {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			return p.errorf(start, "unbalanced %q", open)
+		case t.kind != tokPunct:
+		case t.text == open:
+			depth++
+		case t.text == close:
+			depth--
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseService() (*Service, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	svc := &Service{Name: name.text, Namespace: p.namespace, Line: name.line}
+	if err = p.expect("{"); err != nil {
+		return nil, err
+	}
+	for p.peek().text != "}" || p.peek().kind != tokPunct {
+		m, err := p.parseMethod()
+		if err != nil {
+			return nil, err
+		}
+		svc.Methods = append(svc.Methods, m)
+	}
+	p.next()
+	return svc, nil
+}
+
+// parseMethod Name(Request):Response (streaming: "rpc");
+func (p *parser) parseMethod() (*Method, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	m := &Method{Name: name.text, Line: name.line, Streaming: "none"}
+	if err = p.expect("("); err != nil {
+		return nil, err
+	}
+	req, err := p.qualifiedIdent()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect(")"); err != nil {
+		return nil, err
+	}
+	if err = p.expect(":"); err != nil {
+		return nil, err
+	}
+	resp, err := p.qualifiedIdent()
+	if err != nil {
+		return nil, err
+	}
+	m.reqRef = typeRef{p.namespace, req.text}
+	m.respRef = typeRef{p.namespace, resp.text}
+	if p.peek().text == "(" {
+		attrs, err := p.parseMetadata()
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := attrs["streaming"]; ok {
+			m.Streaming = s
+		}
+	}
+	return m, p.expect(";")
+}
+
+// parseMetadata (key: value, key)
+func (p *parser) parseMetadata() (map[string]string, error) {
+	attrs := make(map[string]string)
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for {
+		key, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		attrs[key.text] = ""
+		if p.peek().text == ":" {
+			p.next()
+			v := p.next()
+			if v.kind == tokEOF || v.kind == tokPunct {
+				return nil, p.errorf(v, "expected value for %s", key.text)
+			}
+			attrs[key.text] = v.text
+		}
+		t := p.next()
+		if t.text == ")" {
+			return attrs, nil
+		}
+		if t.text != "," {
+			return nil, p.errorf(t, "expected \",\" or \")\", got %q", t.text)
+		}
+	}
+}
+
+// resolve 与flatc一致 未限定的名字先在当前namespace中查找 再逐级向外
+func (s *Schema) resolve(ref typeRef) (string, bool) {
+	ns := ref.namespace
+	for {
+		full := ref.name
+		if ns != "" {
+			full = ns + "." + ref.name
+		}
+		if _, ok := s.Types[full]; ok {
+			return full, true
+		}
+		if ns == "" {
+			return "", false
+		}
+		if i := strings.LastIndexByte(ns, '.'); i >= 0 {
+			ns = ns[:i]
+		} else {
+			ns = ""
+		}
+	}
+}
+
+// check 解析类型引用 rpc参数必须是table 方法名和哈希不能冲突
+func (s *Schema) check() error {
+	for _, d := range s.Decls {
+		if err := s.checkDecl(d); err != nil {
+			return err
+		}
+	}
+	for _, svc := range s.Services {
+		names := make(map[string]bool)
+		for _, m := range svc.Methods {
+			var ok bool
+			if m.Request, ok = s.resolve(m.reqRef); !ok {
+				return fmt.Errorf("%s:%d: unknown type %s", s.File, m.Line, m.reqRef.name)
+			}
+			if m.Response, ok = s.resolve(m.respRef); !ok {
+				return fmt.Errorf("%s:%d: unknown type %s", s.File, m.Line, m.respRef.name)
+			}
+			for _, typ := range []string{m.Request, m.Response} {
+				if kind := s.Types[typ]; kind != KindTable {
+					return fmt.Errorf("%s:%d: %s.%s: %s is a %s, rpc arguments must be tables", s.File, m.Line, svc.Name, m.Name, typ, kind)
+				}
+			}
+			if names[m.Name] {
+				return fmt.Errorf("%s:%d: %s.%s redefined", s.File, m.Line, svc.Name, m.Name)
+			}
+			names[m.Name] = true
+		}
+	}
+	return nil
+}
+
+// checkDecl 解析字段和union成员的类型 没有写id的字段按顺序分配 union字段的类型在值之前
+func (s *Schema) checkDecl(d *Decl) error {
+	id := 0
+	for _, f := range d.Fields {
+		if f.Type.ref.name != "" {
+			full, ok := s.resolve(f.Type.ref)
+			if !ok {
+				return fmt.Errorf("%s:%d: unknown type %s", d.file, f.Line, f.Type.ref.name)
+			}
+			f.Type.Elem = full
+		}
+		kind := s.Types[f.Type.Elem]
+		if d.Kind == KindStruct && (f.Type.Vector || f.Type.Elem == "string" || kind == KindTable || kind == KindUnion) {
+			return fmt.Errorf("%s:%d: %s.%s: structs may only contain scalars, enums, structs and fixed arrays", d.file, f.Line, d.Name, f.Name)
+		}
+		if d.Kind == KindTable && f.Type.Array > 0 {
+			return fmt.Errorf("%s:%d: %s.%s: fixed arrays are only allowed in structs", d.file, f.Line, d.Name, f.Name)
+		}
+		if d.explicitIDs {
+			if kind == KindUnion && !f.Type.Vector && f.ID == 0 {
+				return fmt.Errorf("%s:%d: %s.%s: union field id must be at least 1, its type uses id-1", d.file, f.Line, d.Name, f.Name)
+			}
+			continue
+		}
+		if kind == KindUnion && !f.Type.Vector {
+			id++
+		}
+		f.ID = id
+		id++
+	}
+	for _, m := range d.Members {
+		full, ok := s.resolve(m.ref)
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown type %s", d.file, d.Line, m.ref.name)
+		}
+		m.Type = full
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGenerate_Golden(t *testing.T) {
+	cases := []struct {
+		fbs    string
+		pkg    string
+		golden string
+	}{
+		{"../flatbuffers/fbs/example.fbs", "lobby", "testdata/example.golden"},
+		{"testdata/nested.fbs", "matchrpc", "testdata/nested.golden"},
+	}
+	for _, c := range cases {
+		t.Run(filepath.Base(c.fbs), func(t *testing.T) {
+			schema, err := ParseFile(c.fbs, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Generate(schema, Options{Package: c.pkg, BaseImport: "github.com/jiangshuai341/zbus/build/fb"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *update {
+				if err = os.WriteFile(c.golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(c.golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("generated code differs from %s, run go test ./tools/rpcgen -update\n%s", c.golden, got)
+			}
+		})
+	}
+}
+
+func TestGenerateVerify_Golden(t *testing.T) {
+	cases := []struct {
+		fbs    string
+		golden string
+	}{
+		{"../flatbuffers/fbs/example.fbs", "testdata/example_verify.golden"},
+		{"../flatbuffers/fbs/include_example.fbs", "testdata/include_example_verify.golden"},
+		{"testdata/verify.fbs", "testdata/verify.golden"},
+	}
+	for _, c := range cases {
+		t.Run(filepath.Base(c.fbs), func(t *testing.T) {
+			schema, err := ParseFile(c.fbs, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := GenerateVerify(schema, Options{BaseImport: "github.com/jiangshuai341/zbus/build/fb"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *update {
+				if err = os.WriteFile(c.golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(c.golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("generated code differs from %s, run go test ./tools/rpcgen -update\n%s", c.golden, got)
+			}
+		})
+	}
+}
+
+// app 中提交的生成代码必须与schema一致
+func TestGenerate_AppUpToDate(t *testing.T) {
+	want, err := os.ReadFile("testdata/example.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("../../app/LobbyPlayerServiceRpc.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("app/LobbyPlayerServiceRpc.go is stale, regenerate it with tools/flatbuffers/gen.sh")
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{"unknown type", `namespace a; table T {} rpc_service S { F(T):U (streaming: "rpc"); }`, "unknown type U"},
+		{"struct argument", `namespace a; struct P { x:int; } table T {} rpc_service S { F(P):T (streaming: "rpc"); }`, "must be tables"},
+		{"duplicate method", `namespace a; table T {} rpc_service S { F(T):T (streaming: "rpc"); F(T):T (streaming: "rpc"); }`, "redefined"},
+		{"duplicate type", `namespace a; table T {} table T {}`, "redefined"},
+		{"missing include", `include "none.fbs"; namespace a;`, "not found"},
+		{"unterminated comment", `namespace a; /* table T {}`, "unterminated comment"},
+		{"unbalanced", `namespace a; table T { x:int;`, "unbalanced"},
+		{"bad method", `namespace a; table T {} rpc_service S { F(T) T; }`, `expected ":"`},
+		{"line number", "namespace a;\n\ntable T {}\nrpc_service S {\n  F(T):X (streaming: \"rpc\");\n}", "x.fbs:5:"},
+		{"unknown field type", `namespace a; table T { x:U; }`, "unknown type U"},
+		{"partial ids", `namespace a; table T { x:int (id: 1); y:int; }`, "no fields must have an id"},
+		{"string in struct", `namespace a; struct P { s:string; }`, "structs may only contain"},
+		{"array in table", `namespace a; table T { x:[int:2]; }`, "only allowed in structs"},
+		{"enum type", `namespace a; enum E:float { A }`, "must be an integer"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "x.fbs")
+			if err := os.WriteFile(path, []byte(c.schema), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := ParseFile(path, nil)
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("want error containing %q, got %v", c.want, err)
+			}
+		})
+	}
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{"mixed kinds", `namespace a; table T {} rpc_service S { F(T):T (streaming: "rpc"); G(T):T; }`, "mixes"},
+		{"no namespace", `table T {} rpc_service S { F(T):T (streaming: "rpc"); }`, "no namespace"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "x.fbs")
+			if err := os.WriteFile(path, []byte(c.schema), 0644); err != nil {
+				t.Fatal(err)
+			}
+			schema, err := ParseFile(path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Generate(schema, Options{Package: "p"})
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("want error containing %q, got %v", c.want, err)
+			}
+		})
+	}
+}
+
+func TestGenerateVerify_Errors(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{"two namespaces", `namespace a; table T {} namespace b; table U {}`, "one package per schema file"},
+		{"vector of unions", `namespace a; table T {} union U { T } table V { u:[U]; }`, "vectors of unions"},
+		{"struct in union", `namespace a; struct P { x:int; } union U { P }`, "only tables"},
+		{"recursive struct", `namespace a; struct P { p:P; } table T { p:P; }`, "contains itself"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "x.fbs")
+			if err := os.WriteFile(path, []byte(c.schema), 0644); err != nil {
+				t.Fatal(err)
+			}
+			schema, err := ParseFile(path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = GenerateVerify(schema, Options{})
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("want error containing %q, got %v", c.want, err)
+			}
+		})
+	}
+}
+
+// benchmarkSchema 把 example.fbs 的namespace改成 benchmark/fb 中flatc已生成的包 生成的代码可以真正编译
+func benchmarkSchema(t *testing.T) string {
+	dir := t.TempDir()
+	renames := strings.NewReplacer("namespace lobby;", "namespace Example;", "namespace vector;", "namespace testinclude;", "vector.", "testinclude.")
+	for _, name := range []string{"example.fbs", "include_example.fbs"} {
+		data, err := os.ReadFile(filepath.Join("../flatbuffers/fbs", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(dir, name), []byte(renames.Replace(string(data))), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "example.fbs")
+}
+
+// 生成的代理和服务端代码需要能通过编译和vet
+func TestGenerate_Compiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go vet")
+	}
+	gotool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	schema, err := ParseFile(benchmarkSchema(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := Generate(schema, Options{Package: "rpcbuild", BaseImport: "github.com/jiangshuai341/zbus/benchmark/fb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 需要在模块内才能引用本仓库的包 testdata 下的目录不会被 ./... 匹配
+	dir, err := os.MkdirTemp("testdata", "build-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	if err = os.WriteFile(filepath.Join(dir, "rpc.go"), code, 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(gotool, "vet", "./"+filepath.ToSlash(dir)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("generated code does not compile: %v\n%s\n%s", err, out, code)
+	}
+}
+
+// benchmark/fb 中提交的 Verify 代码由当前生成器生成 它们随 go build ./... 一起编译
+func TestGenerateVerify_BenchmarkUpToDate(t *testing.T) {
+	path := benchmarkSchema(t)
+	schemas := map[string]string{
+		path: "../../benchmark/fb/Example/example_verify.go",
+		filepath.Join(filepath.Dir(path), "include_example.fbs"): "../../benchmark/fb/testinclude/include_example_verify.go",
+	}
+	for fbs, committed := range schemas {
+		schema, err := ParseFile(fbs, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := GenerateVerify(schema, Options{BaseImport: "github.com/jiangshuai341/zbus/benchmark/fb"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.ReadFile(committed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is stale\n%s", committed, got)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"text/template"
+)
+
+// 为根文件中的 table 和 union 生成 flatbuffers.Verifier 的检查代码 与flatc生成的代码放在同一个包
+//   XxxVerify(v, pos)        检查 pos 处的表 字段按 flatc 的 vtable 布局检查 deprecated 字段跳过
+//   (*Xxx).Verify            实现 flatbuffers.Verifiable 供 FlatbuffersCodec 使用
+//   VerifyRootAsXxx(buf)     在 GetRootAsXxx(buf, 0) 之前检查不可信的数据
+//   UnionVerify(v, typ, pos) 按类型检查union的值 未知的类型不检查 与C++一致
+
+type verifyTable struct {
+	Name   string
+	Checks []string
+}
+
+type verifyMember struct {
+	Value  int
+	Verify string
+}
+
+type verifyUnion struct {
+	Name    string
+	Members []verifyMember
+}
+
+type verifyFile struct {
+	Source  string
+	Package string
+	Imports []importSpec
+	Tables  []verifyTable
+	Unions  []verifyUnion
+}
+
+type verifyGen struct {
+	schema    *Schema
+	namespace string
+	imports   *importSet
+	layouts   map[string][2]int // struct -> 大小 对齐
+}
+
+// GenerateVerify 根文件中的类型必须在同一个namespace 没有table和union时返回nil
+func GenerateVerify(schema *Schema, opts Options) ([]byte, error) {
+	g := &verifyGen{schema: schema, imports: newImportSet(opts.BaseImport), layouts: make(map[string][2]int)}
+	file := verifyFile{Source: schema.File, Package: opts.Package}
+	for _, d := range schema.Roots {
+		if d.Kind != KindTable && d.Kind != KindUnion {
+			continue
+		}
+		if file.Tables == nil && file.Unions == nil {
+			g.namespace = d.Namespace
+		} else if d.Namespace != g.namespace {
+			return nil, fmt.Errorf("%s:%d: %s is not in namespace %s, verify code is generated into one package per schema file", d.file, d.Line, d.FullName(), g.namespace)
+		}
+		if d.Kind == KindUnion {
+			u, err := g.union(d)
+			if err != nil {
+				return nil, err
+			}
+			file.Unions = append(file.Unions, u)
+			continue
+		}
+		t, err := g.table(d)
+		if err != nil {
+			return nil, err
+		}
+		file.Tables = append(file.Tables, t)
+	}
+	if file.Tables == nil && file.Unions == nil {
+		return nil, nil
+	}
+	if file.Package == "" {
+		file.Package = g.namespace[strings.LastIndexByte(g.namespace, '.')+1:]
+	}
+	file.Imports = g.imports.specs("github.com/jiangshuai341/zbus/flatbuffers")
+
+	var buf bytes.Buffer
+	if err := verifyTemplate.Execute(&buf, &file); err != nil {
+		return nil, err
+	}
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w\n%s", err, buf.Bytes())
+	}
+	return out, nil
+}
+
+func (g *verifyGen) table(d *Decl) (verifyTable, error) {
+	t := verifyTable{Name: d.Name}
+	for _, f := range d.Fields {
+		if f.Deprecated {
+			continue
+		}
+		vt := 4 + 2*f.ID
+		elem, kind := f.Type.Elem, g.schema.Types[f.Type.Elem]
+		var check string
+		switch {
+		case kind == KindUnion && f.Type.Vector:
+			return t, fmt.Errorf("%s:%d: %s.%s: vectors of unions are not supported in Go", d.file, f.Line, d.Name, f.Name)
+		case kind == KindUnion:
+			check = fmt.Sprintf("v.VerifyUnionField(pos, %d, %d, %t, %s)", vt-2, vt, f.Required, g.verifyFunc(elem))
+		case kind == KindTable && f.Type.Vector:
+			check = fmt.Sprintf("v.VerifyVectorOfTablesField(pos, %d, %t, %s)", vt, f.Required, g.verifyFunc(elem))
+		case kind == KindTable:
+			check = fmt.Sprintf("v.VerifyTableField(pos, %d, %t, %s)", vt, f.Required, g.verifyFunc(elem))
+		case elem == "string" && f.Type.Vector:
+			check = fmt.Sprintf("v.VerifyVectorOfStringsField(pos, %d, %t)", vt, f.Required)
+		case elem == "string":
+			check = fmt.Sprintf("v.VerifyStringField(pos, %d, %t)", vt, f.Required)
+		default:
+			size, align, err := g.layout(elem)
+			if err != nil {
+				return t, fmt.Errorf("%s:%d: %s.%s: %w", d.file, f.Line, d.Name, f.Name, err)
+			}
+			if f.Type.Vector {
+				check = fmt.Sprintf("v.VerifyVectorField(pos, %d, %d, %d, %t)", vt, size, align, f.Required)
+			} else {
+				check = fmt.Sprintf("v.VerifyField(pos, %d, %d, %d, %t)", vt, size, align, f.Required)
+			}
+		}
+		t.Checks = append(t.Checks, check)
+	}
+	return t, nil
+}
+
+// union Go只支持table作为union的成员
+func (g *verifyGen) union(d *Decl) (verifyUnion, error) {
+	u := verifyUnion{Name: d.Name}
+	for _, m := range d.Members {
+		if kind := g.schema.Types[m.Type]; kind != KindTable {
+			return u, fmt.Errorf("%s:%d: union %s: member %s is a %s, only tables are supported in Go", d.file, d.Line, d.Name, m.Type, kind)
+		}
+		u.Members = append(u.Members, verifyMember{Value: m.Value, Verify: g.verifyFunc(m.Type)})
+	}
+	return u, nil
+}
+
+// verifyFunc 其他namespace的类型带包名
+func (g *verifyGen) verifyFunc(full string) string {
+	i := strings.LastIndexByte(full, '.')
+	if i < 0 || full[:i] == g.namespace {
+		return full[i+1:] + "Verify"
+	}
+	t, _ := g.imports.typeOf(full)
+	return t.Pkg + "." + t.Name + "Verify"
+}
+
+// layout 标量 enum struct 的大小和对齐 struct 的成员按顺序对齐 末尾补齐到最大的对齐
+func (g *verifyGen) layout(elem string) (int, int, error) {
+	if size, ok := scalarSizes[elem]; ok {
+		return size, size, nil
+	}
+	d := g.schema.Decls[elem]
+	if d.Kind == KindEnum {
+		size := scalarSizes[d.Base]
+		return size, size, nil
+	}
+	if l, ok := g.layouts[elem]; ok {
+		if l[0] < 0 {
+			return 0, 0, fmt.Errorf("struct %s contains itself", elem)
+		}
+		return l[0], l[1], nil
+	}
+	g.layouts[elem] = [2]int{-1, -1}
+	size, align := 0, 1
+	for _, f := range d.Fields {
+		fs, fa, err := g.layout(f.Type.Elem)
+		if err != nil {
+			return 0, 0, err
+		}
+		if f.Type.Array > 0 {
+			fs *= f.Type.Array
+		}
+		size = (size+fa-1)/fa*fa + fs
+		if fa > align {
+			align = fa
+		}
+	}
+	size = (size + align - 1) / align * align
+	g.layouts[elem] = [2]int{size, align}
+	return size, align, nil
+}
+
+var verifyTemplate = template.Must(template.New("verify").Parse(`// Code generated by rpcgen -verify from {{.Source}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	{{if .Alias}}{{.Alias}} {{end}}"{{.Path}}"
+{{- end}}
+)
+{{range .Tables}}
+// {{.Name}}Verify 检查 pos 处的 {{.Name}} 通过后访问器不会越界
+func {{.Name}}Verify(v *flatbuffers.Verifier, pos flatbuffers.UOffsetT) error {
+	if err := v.VerifyTableStart(pos); err != nil {
+		return err
+	}
+	defer v.VerifyTableEnd()
+{{- range .Checks}}
+	if err := {{.}}; err != nil {
+		return err
+	}
+{{- end}}
+	return nil
+}
+
+func (rcv *{{.Name}}) Verify(v *flatbuffers.Verifier, pos flatbuffers.UOffsetT) error {
+	return {{.Name}}Verify(v, pos)
+}
+
+// VerifyRootAs{{.Name}} 在 GetRootAs{{.Name}}(buf, 0) 之前检查不可信的数据
+func VerifyRootAs{{.Name}}(buf []byte) error {
+	return flatbuffers.NewVerifier(buf, flatbuffers.VerifierOptions{}).VerifyBuffer("", {{.Name}}Verify)
+}
+{{end}}
+{{- range .Unions}}
+// {{.Name}}Verify 按类型检查 pos 处的 {{.Name}} 未知的类型不检查
+func {{.Name}}Verify(v *flatbuffers.Verifier, typ byte, pos flatbuffers.UOffsetT) error {
+	switch typ {
+{{- range .Members}}
+	case {{.Value}}:
+		return {{.Verify}}(v, pos)
+{{- end}}
+	}
+	return nil
+}
+{{end}}`))
@@ -22,9 +22,6 @@ func tcpSocket(protoType ProtoType, addr string, listen bool, sockOpts ...Option
 	}
 	defer func() {
 		if err != nil {
-			if err, ok := err.(*os.SyscallError); ok && err.Err == syscall.EINPROGRESS {
-				return
-			}
 			_ = syscall.Close(fd)
 		}
 	}()
@@ -44,7 +41,11 @@ func tcpSocket(protoType ProtoType, addr string, listen bool, sockOpts ...Option
 		}
 		err = os.NewSyscallError("tcpSocket listen", syscall.Listen(fd, listenerBacklogMaxSize))
 	} else {
-		err = os.NewSyscallError("tcpSocket connect", syscall.Connect(fd, sa))
+		// 非阻塞socket 连接在后台完成 完成前写入的数据留在发送缓冲区 可写(EPOLLOUT)时发出
+		if err = syscall.Connect(fd, sa); err == syscall.EINPROGRESS {
+			err = nil
+		}
+		err = os.NewSyscallError("tcpSocket connect", err)
 	}
 	return
 }
@@ -7,11 +7,16 @@ import (
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
 const _EPOLLET = 0x80000000
-const MaxAsyncTasksOnceLoop = 256
+
+const (
+	MaxAsyncTasksOnceLoop = 256     // 默认每轮循环最多执行的普通任务数
+	DefaultTaskQueueSize  = 1 << 16 // 默认任务环容量
+)
 
 const (
 	readEvents      = syscall.EPOLLPRI | syscall.EPOLLIN | _EPOLLET
@@ -21,6 +26,19 @@ const (
 
 var log = logger.GetLogger("epoller")
 
+type Config struct {
+	MaxAsyncTasksOnceLoop int    // 每轮循环最多执行的普通任务数 紧急任务不受限制
+	TaskQueueSize         uint32 // normal/urgent 无锁环的容量 环满时任务进入加锁的溢出队列 入队不会失败
+	TaskLatency           bool   // 是否统计任务从入队到执行的延迟
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxAsyncTasksOnceLoop: MaxAsyncTasksOnceLoop,
+		TaskQueueSize:         DefaultTaskQueueSize,
+	}
+}
+
 type Epoller struct {
 	fd int //epoll fd
 
@@ -28,6 +46,16 @@ type Epoller struct {
 }
 
 func OpenEpoller() (poller *Epoller, err error) {
+	return OpenEpollerWithConfig(DefaultConfig())
+}
+
+func OpenEpollerWithConfig(cfg Config) (poller *Epoller, err error) {
+	if cfg.MaxAsyncTasksOnceLoop <= 0 {
+		cfg.MaxAsyncTasksOnceLoop = MaxAsyncTasksOnceLoop
+	}
+	if cfg.TaskQueueSize == 0 {
+		cfg.TaskQueueSize = DefaultTaskQueueSize
+	}
 	poller = &Epoller{}
 	poller.fd, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
 	if err != nil {
@@ -53,8 +81,12 @@ func OpenEpoller() (poller *Epoller, err error) {
 		poller = nil
 		return
 	}
-	poller.asyncTack.normal = NewTaskQueue()
-	poller.asyncTack.urgent = NewTaskQueue()
+	poller.asyncTack.normal = newTaskQueue(cfg.TaskQueueSize)
+	poller.asyncTack.urgent = newTaskQueue(cfg.TaskQueueSize)
+	poller.asyncTack.maxTasksOnceLoop = cfg.MaxAsyncTasksOnceLoop
+	if cfg.TaskLatency {
+		poller.asyncTack.latency = new(LatencyHistogram)
+	}
 	return
 }
 
@@ -76,9 +108,7 @@ var (
 
 func (p *Epoller) AppendUrgentTask(fn TaskFunc) (err error) {
 	var a uint64 = 1
-	task := p.getTask()
-	task.Run = fn
-	p.urgent.Enqueue(task)
+	p.urgent.push(p.getTask(fn))
 	if atomic.CompareAndSwapInt32(&p.isWeak, 0, 1) {
 		if _, err = syscall.Write(p.triggerFD, (*(*[8]byte)(unsafe.Pointer(&a)))[:]); err == syscall.EAGAIN {
 			err = nil
@@ -87,9 +117,7 @@ func (p *Epoller) AppendUrgentTask(fn TaskFunc) (err error) {
 	return os.NewSyscallError("Write", err)
 }
 func (p *Epoller) AppendTask(fn TaskFunc) (err error) {
-	task := p.getTask()
-	task.Run = fn
-	p.normal.Enqueue(task)
+	p.normal.push(p.getTask(fn))
 	if atomic.CompareAndSwapInt32(&p.isWeak, 0, 1) {
 		if _, err = syscall.Write(p.triggerFD, b); err == syscall.EAGAIN {
 			err = nil
@@ -119,7 +147,8 @@ func (p *Epoller) Epolling(callback func(fd int, ev uint32)) (err error) {
 	var triggerReadBuf = make([]byte, 8)
 	var doTask bool
 
-	var currentTask *Task
+	var taskBuf = make([]*Task, p.maxTasksOnceLoop)
+	var n int
 	for {
 		switch eventNums, err = syscall.EpollWait(p.fd, maxEvents, -1); err {
 		case nil:
@@ -143,20 +172,15 @@ func (p *Epoller) Epolling(callback func(fd int, ev uint32)) (err error) {
 
 		if doTask {
 			doTask = false
-			for currentTask = p.urgent.Dequeue(); currentTask != nil; currentTask = p.urgent.Dequeue() {
-				currentTask.Run(p)
-				p.putTask(currentTask)
+			for n = p.urgent.drain(taskBuf); n > 0; n = p.urgent.drain(taskBuf) {
+				p.runTasks(taskBuf[:n])
 			}
-			for i := 0; i < MaxAsyncTasksOnceLoop; i++ {
-				if currentTask = p.normal.Dequeue(); currentTask == nil {
-					break
-				}
-				currentTask.Run(p)
-				p.putTask(currentTask)
+			if n = p.normal.drain(taskBuf); n > 0 {
+				p.runTasks(taskBuf[:n])
 			}
 			atomic.StoreInt32(&p.isWeak, 0)
 			//这个间隙 其他线程是有可能写入任务的需要重新检查
-			if (!p.normal.IsEmpty() || !p.urgent.IsEmpty()) &&
+			if (p.normal.pending() || p.urgent.pending()) &&
 				atomic.CompareAndSwapInt32(&p.isWeak, 0, 1) {
 				//有任务没做完 先占位 后触发eventfd
 				switch _, err = syscall.Write(p.triggerFD, b); err {
@@ -203,55 +227,111 @@ func (p *Epoller) ModReadWrite(fd int32) error {
 type TaskFunc func(p *Epoller)
 
 type Task struct {
-	Run TaskFunc
+	Run         TaskFunc
+	enqueueTime int64 // UnixNano 仅在统计延迟时记录
+}
+
+// AsyncTaskQueue 任意线程入队 IO线程批量出队
+type AsyncTaskQueue = lockfreequeue.Queue[*Task]
+
+func NewTaskQueue(capacity uint32) AsyncTaskQueue {
+	return lockfreequeue.NewMPSCRing[*Task](capacity)
 }
 
-type AsyncTaskQueue interface {
-	Enqueue(*Task)
-	Dequeue() *Task
-	IsEmpty() bool
+// taskQueue 无锁环加无界的溢出队列 入队不会失败 关闭连接等任务不会因为队列满而丢失
+// 溢出队列非空时新任务也进入溢出队列 IO线程取完环中的任务后才取溢出的任务 同一生产者的任务保持有序
+type taskQueue struct {
+	ring        AsyncTaskQueue
+	overflowing int32 // 溢出队列非空
+	mu          sync.Mutex
+	overflow    []*Task
 }
 
-type TaskQueue struct {
-	q *lockfreequeue.LockFreeList
+func newTaskQueue(capacity uint32) *taskQueue {
+	return &taskQueue{ring: NewTaskQueue(capacity)}
 }
 
-func NewTaskQueue() *TaskQueue {
-	return &TaskQueue{
-		q: lockfreequeue.NewLockFreeList(),
+func (q *taskQueue) push(task *Task) {
+	if atomic.LoadInt32(&q.overflowing) == 0 && q.ring.Enqueue(task) {
+		return
 	}
+	q.mu.Lock()
+	q.overflow = append(q.overflow, task)
+	atomic.StoreInt32(&q.overflowing, 1)
+	q.mu.Unlock()
 }
 
-func (que *TaskQueue) Enqueue(task *Task) {
-	que.q.Enqueue(task)
-}
-func (que *TaskQueue) Dequeue() *Task {
-	task := que.q.Dequeue()
-	if task == nil {
-		return nil
+// drain IO线程调用
+func (q *taskQueue) drain(buf []*Task) int {
+	if n := q.ring.DrainTo(buf); n > 0 || atomic.LoadInt32(&q.overflowing) == 0 {
+		return n
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// 加锁后再检查环 生产者可能先写入环再写入溢出队列 需要先取环中的任务
+	if q.ring.Len() > 0 {
+		return q.ring.DrainTo(buf)
 	}
-	return task.(*Task)
+	n := copy(buf, q.overflow)
+	for i := range q.overflow[:n] {
+		q.overflow[i] = nil
+	}
+	if q.overflow = q.overflow[n:]; len(q.overflow) == 0 {
+		q.overflow = nil
+		atomic.StoreInt32(&q.overflowing, 0)
+	}
+	return n
 }
-func (que *TaskQueue) IsEmpty() bool {
-	return que.q.IsEmpty()
+
+func (q *taskQueue) pending() bool {
+	return q.ring.Len() != 0 || atomic.LoadInt32(&q.overflowing) != 0
 }
 
 var taskPool = sync.Pool{New: func() any { return new(Task) }}
 
 type asyncTack struct {
-	isWeak    int32
-	triggerFD int            //trigger handle task queue
-	normal    AsyncTaskQueue // queue with low priority
-	urgent    AsyncTaskQueue // queue with high priority
+	isWeak           int32
+	triggerFD        int        //trigger handle task queue
+	normal           *taskQueue // queue with low priority
+	urgent           *taskQueue // queue with high priority
+	maxTasksOnceLoop int
+	latency          *LatencyHistogram // nil 表示不统计
+}
+
+// TaskLatency 任务从入队到开始执行的延迟分布 未开启Config.TaskLatency时返回nil
+func (a *asyncTack) TaskLatency() *LatencyHistogram {
+	return a.latency
 }
 
 // getTask gets a cached Task from pool.
-func (a *asyncTack) getTask() *Task {
-	return taskPool.Get().(*Task)
+func (a *asyncTack) getTask(fn TaskFunc) *Task {
+	task := taskPool.Get().(*Task)
+	task.Run = fn
+	if a.latency != nil {
+		task.enqueueTime = time.Now().UnixNano()
+	}
+	return task
 }
 
 // putTask puts the trashy Task back in pool.
 func (a *asyncTack) putTask(task *Task) {
 	task.Run = nil
+	task.enqueueTime = 0
 	taskPool.Put(task)
 }
+
+// runTasks 执行线程 IO Thread
+func (p *Epoller) runTasks(tasks []*Task) {
+	var now int64
+	if p.latency != nil {
+		now = time.Now().UnixNano()
+	}
+	for i, task := range tasks {
+		if p.latency != nil {
+			p.latency.Observe(time.Duration(now - task.enqueueTime))
+		}
+		task.Run(p)
+		p.putTask(task)
+		tasks[i] = nil
+	}
+}
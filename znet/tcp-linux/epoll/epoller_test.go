@@ -0,0 +1,94 @@
+package epoll
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestEpoller_AppendTask(t *testing.T) {
+	const producers, cnt = 4, 10000
+	p, err := OpenEpollerWithConfig(Config{MaxAsyncTasksOnceLoop: 32, TaskQueueSize: 1024, TaskLatency: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = p.Epolling(func(fd int, ev uint32) {}) }()
+
+	var wg sync.WaitGroup
+	wg.Add(producers * cnt)
+	var executed int // 只在IO线程中修改
+	for i := 0; i < producers; i++ {
+		go func() {
+			for j := 0; j < cnt; j++ {
+				_ = p.AppendTask(func(p *Epoller) { executed++; wg.Done() })
+			}
+		}()
+	}
+	wg.Wait()
+
+	done := make(chan int)
+	_ = p.AppendUrgentTask(func(p *Epoller) { done <- executed })
+	if n := <-done; n != producers*cnt {
+		t.Fatalf("executed %d tasks want %d", n, producers*cnt)
+	}
+	if s := p.TaskLatency().Snapshot(); s.Count != producers*cnt+1 {
+		t.Fatalf("latency count %d", s.Count)
+	}
+}
+
+// TestEpoller_Overflow IO线程没有运行时任务超过环的容量 全部进入队列并按生产者的顺序执行
+func TestEpoller_Overflow(t *testing.T) {
+	const producers, cnt = 4, 1000
+	p, err := OpenEpollerWithConfig(Config{MaxAsyncTasksOnceLoop: 16, TaskQueueSize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	last := make([]int, producers) // 只在IO线程中修改
+	var outOfOrder int
+	for i := 0; i < producers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < cnt; j++ {
+				j := j
+				if err := p.AppendTask(func(p *Epoller) {
+					if j != last[i] {
+						outOfOrder++
+					}
+					last[i] = j + 1
+				}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	go func() { _ = p.Epolling(func(fd int, ev uint32) {}) }()
+
+	done := make(chan int)
+	_ = p.AppendTask(func(p *Epoller) {
+		n := outOfOrder
+		for _, v := range last {
+			n += cnt - v
+		}
+		done <- n
+	})
+	if n := <-done; n != 0 {
+		t.Fatalf("%d tasks lost or out of order", n)
+	}
+}
+
+func BenchmarkEpoller_AppendTask(b *testing.B) {
+	p, _ := OpenEpoller()
+	go func() { _ = p.Epolling(func(fd int, ev uint32) {}) }()
+	var wg sync.WaitGroup
+	wg.Add(b.N)
+	task := func(p *Epoller) { wg.Done() }
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = p.AppendTask(task)
+	}
+	wg.Wait()
+}
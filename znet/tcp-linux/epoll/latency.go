@@ -0,0 +1,72 @@
+package epoll
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// 桶i统计延迟在 [2^(i-1), 2^i) 微秒之间的任务 最后一个桶包含所有更大的值
+const latencyBuckets = 24
+
+// LatencyHistogram 按2的幂分桶的延迟直方图 IO线程写 任意线程读
+type LatencyHistogram struct {
+	buckets [latencyBuckets]uint64
+	count   uint64
+	sum     uint64 // ns
+}
+
+func (h *LatencyHistogram) Observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	idx := bits.Len64(uint64(d / time.Microsecond))
+	if idx >= latencyBuckets {
+		idx = latencyBuckets - 1
+	}
+	atomic.AddUint64(&h.buckets[idx], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+}
+
+type LatencySnapshot struct {
+	Buckets [latencyBuckets]uint64
+	Count   uint64
+	Sum     time.Duration
+}
+
+func (h *LatencyHistogram) Snapshot() (s LatencySnapshot) {
+	for i := range h.buckets {
+		s.Buckets[i] = atomic.LoadUint64(&h.buckets[i])
+	}
+	s.Count = atomic.LoadUint64(&h.count)
+	s.Sum = time.Duration(atomic.LoadUint64(&h.sum))
+	return
+}
+
+func (s *LatencySnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Quantile 返回q分位所在桶的上界 例如 Quantile(0.99)
+func (s *LatencySnapshot) Quantile(q float64) time.Duration {
+	var total uint64
+	for _, v := range s.Buckets {
+		total += v
+	}
+	if total == 0 {
+		return 0
+	}
+	target := uint64(float64(total) * q)
+	var acc uint64
+	for i, v := range s.Buckets {
+		acc += v
+		if acc > target || acc == total {
+			return time.Duration(uint64(1)<<i) * time.Microsecond
+		}
+	}
+	return 0
+}
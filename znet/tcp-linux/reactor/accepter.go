@@ -107,16 +107,21 @@ func (a *Accepter) onAccept(lfd int, _ uint32) {
 		err != syscall.EPROTO &&
 		err != syscall.EINTR {
 		log.Errorf("[Accepter] syscall.Accept Failed ListenFD:%d  Err:%s", a.lfd, err.Error())
-		a.Close()
+		// 已在IO线程 直接关闭 不经过任务队列
+		a.close(a.ep)
 	}
 	return
 }
 
-func (a *Accepter) Close() {
-	_ = a.ep.AppendUrgentTask(func(e *epoll.Epoller) {
-		for _, v := range a.lfd {
-			_ = syscall.Close(v)
-		}
-		_ = e.Close()
-	})
+// Close 投递到IO线程关闭监听 任务队列不会满 只在唤醒IO线程失败时返回错误
+func (a *Accepter) Close() error {
+	return a.ep.AppendUrgentTask(a.close)
+}
+
+func (a *Accepter) close(e *epoll.Epoller) {
+	for _, v := range a.lfd {
+		_ = syscall.Close(v)
+	}
+	a.lfd = nil
+	_ = e.Close()
 }
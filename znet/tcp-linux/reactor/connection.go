@@ -21,6 +21,7 @@ type Connection struct {
 	outboundBuffer *zbuffer.LinkListBuffer // 出栈缓冲区
 	inboundBuffer  *zbuffer.CombinesBuffer // 入栈缓冲区
 	reactor        *Reactor
+	closed         bool
 	INetHandle
 }
 
@@ -57,9 +58,20 @@ func (c *Connection) SendSafeZeroCopy(data ...[]byte) error {
 func (c *Connection) SendUnsafeZeroCopy(data ...[]byte) {
 	c.write(data...)
 }
+
+// Close 非线程安全 需在reactor协程中调用(如 OnTraffic 中发现协议错误时)
+func (c *Connection) Close() {
+	c.onRemoteClose()
+}
+
 func (c *Connection) onRemoteClose() {
+	if c.closed {
+		return
+	}
+	c.closed = true
 	delete(c.reactor.conns, c.fd)
 	_ = c.reactor.epoller.Delete(c.fd)
+	_ = syscall.Close(c.fd)
 	c.INetHandle.OnClose()
 }
 
@@ -74,9 +86,18 @@ func (c *Connection) write(data ...[]byte) {
 }
 
 func (c *Connection) onTraffic() {
+	if c.closed {
+		return
+	}
+	eof := false
 	for {
 		c.reactor.riovc.SetPrefix(c.inboundBuffer.PeekRingBufferFreeSpace())
 		n, err := epoll.Readv(c.fd, c.reactor.riovc.BufferWithPrefix())
+		if n == 0 && err == nil {
+			// 对端关闭 先处理已读到的数据再关闭
+			eof = true
+			break
+		}
 		if err == syscall.EAGAIN || err == syscall.EINTR || n == 0 {
 			break
 		}
@@ -89,10 +110,13 @@ func (c *Connection) onTraffic() {
 		c.inboundBuffer.PushsNoCopy(c.reactor.riovc.MoveTemp(n))
 	}
 	c.INetHandle.OnTraffic(c.inboundBuffer)
+	if eof {
+		c.onRemoteClose()
+	}
 }
 
 func (c *Connection) onTriggerWrite() {
-	if c.outboundBuffer.ByteLength() == 0 {
+	if c.closed || c.outboundBuffer.ByteLength() == 0 {
 		return
 	}
 	for {
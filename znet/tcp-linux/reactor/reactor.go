@@ -20,13 +20,18 @@ type Reactor struct {
 }
 
 func NewReactor() (r *Reactor, err error) {
+	return NewReactorWithConfig(epoll.DefaultConfig())
+}
+
+// NewReactorWithConfig cfg 控制IO线程的异步任务队列 见 epoll.Config
+func NewReactorWithConfig(cfg epoll.Config) (r *Reactor, err error) {
 	r = &Reactor{
 		conns:   make(map[int]*Connection),
 		epoller: nil,
 		riovc:   zbuffer.NewIocvArr(2, 1024*10*5, 1024),
 		wiovc:   make([]epoll.Iovec, 128),
 	}
-	r.epoller, err = epoll.OpenEpoller()
+	r.epoller, err = epoll.OpenEpollerWithConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -48,6 +53,11 @@ func (r *Reactor) DoUrgentTaskInIoThread(fn epoll.TaskFunc) error {
 	return r.epoller.AppendUrgentTask(fn)
 }
 
+// TaskLatency IO线程异步任务的排队延迟 未开启统计时返回nil
+func (r *Reactor) TaskLatency() *epoll.LatencyHistogram {
+	return r.epoller.TaskLatency()
+}
+
 // OnReadWriteEventTrigger Trigger On Io Thread
 func (r *Reactor) OnReadWriteEventTrigger(fd int, ev uint32) {
 	conn, ok := r.conns[fd]
@@ -71,8 +81,9 @@ func (r *Reactor) AddConn(conn *Connection) error {
 	if conn.INetHandle == nil {
 		return ErrNetHandle
 	}
+	// 先设置reactor AddConn返回后即可调用 SendSafeZeroCopy
+	conn.reactor = r
 	return r.DoUrgentTaskInIoThread(func(p *epoll.Epoller) {
-		conn.reactor = r
 		r.conns[conn.fd] = conn
 		_ = r.epoller.AddReadWrite(conn.fd)
 	})
@@ -0,0 +1,256 @@
+package coroutinepool
+
+import (
+	"errors"
+	"github.com/jiangshuai341/zbus/logger"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var log = logger.GetLogger("coroutinepool")
+
+var (
+	ErrMailboxFull = errors.New("keyed executor mailbox is full")
+	ErrPoolClosed  = errors.New("keyed executor is closed")
+)
+
+// 每个worker一次最多连续执行同一个key的任务数 超过后让出 保证key之间的公平
+const laneBatchOnceSchedule = 16
+
+type keyedTask struct {
+	fn         func()
+	submitTime int64 // UnixNano
+}
+
+// lane 同一个key的任务邮箱 同一时刻只会被一个worker执行
+type lane struct {
+	key       int64
+	tasks     []keyedTask
+	scheduled bool // 已在就绪队列中或正在被worker执行
+	unkeyed   bool // Submit 提交的单个任务 不在lanes中
+}
+
+// KeyedExecutor 按key串行 跨key并发的执行器 (Actor模型)
+// 相同key的任务按提交顺序(FIFO)在同一时刻只被一个worker执行 不同key之间并发执行
+type KeyedExecutor struct {
+	mailboxSize int
+	onOverflow  func(key int64, fn func())
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	lanes   map[int64]*lane
+	ready   []*lane
+	unkeyed int // 就绪队列中 Submit 提交的任务数
+	closed  bool
+	wg      sync.WaitGroup
+
+	submitted uint64
+	executed  uint64
+	overflows uint64
+}
+
+// NewKeyedExecutor
+// workerNum 并发执行的worker数量 <=0 时为1
+// mailboxSize 每个key最多积压的任务数 <=0 时不限制
+// onOverflow 邮箱满时回调 可为nil 在SubmitKeyed的调用线程中执行
+func NewKeyedExecutor(workerNum int, mailboxSize int, onOverflow func(key int64, fn func())) *KeyedExecutor {
+	if workerNum <= 0 {
+		workerNum = 1
+	}
+	e := &KeyedExecutor{
+		mailboxSize: mailboxSize,
+		onOverflow:  onOverflow,
+		lanes:       make(map[int64]*lane),
+	}
+	e.cond = sync.NewCond(&e.mu)
+	e.wg.Add(workerNum)
+	for i := 0; i < workerNum; i++ {
+		go e.worker()
+	}
+	return e
+}
+
+// SubmitKeyed 线程安全 相同key的任务按提交顺序串行执行
+func (e *KeyedExecutor) SubmitKeyed(key int64, fn func()) error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return ErrPoolClosed
+	}
+	l, ok := e.lanes[key]
+	if !ok {
+		l = &lane{key: key}
+		e.lanes[key] = l
+	}
+	if e.mailboxSize > 0 && len(l.tasks) >= e.mailboxSize {
+		e.mu.Unlock()
+		atomic.AddUint64(&e.overflows, 1)
+		if e.onOverflow != nil {
+			e.onOverflow(key, fn)
+		}
+		return ErrMailboxFull
+	}
+	l.tasks = append(l.tasks, keyedTask{fn: fn, submitTime: time.Now().UnixNano()})
+	atomic.AddUint64(&e.submitted, 1)
+	if !l.scheduled {
+		l.scheduled = true
+		e.ready = append(e.ready, l)
+		e.cond.Signal()
+	}
+	e.mu.Unlock()
+	return nil
+}
+
+// Submit 线程安全 任务不和其他任务串行 由任意空闲worker执行 不受 mailboxSize 限制
+func (e *KeyedExecutor) Submit(fn func()) error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return ErrPoolClosed
+	}
+	l := &lane{
+		tasks:     []keyedTask{{fn: fn, submitTime: time.Now().UnixNano()}},
+		scheduled: true,
+		unkeyed:   true,
+	}
+	e.unkeyed++
+	atomic.AddUint64(&e.submitted, 1)
+	e.ready = append(e.ready, l)
+	e.cond.Signal()
+	e.mu.Unlock()
+	return nil
+}
+
+// Close 不再接收新任务 已提交的任务执行完毕后返回
+func (e *KeyedExecutor) Close() {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return
+	}
+	e.closed = true
+	e.cond.Broadcast()
+	e.mu.Unlock()
+	e.wg.Wait()
+}
+
+func (e *KeyedExecutor) worker() {
+	defer e.wg.Done()
+	for {
+		e.mu.Lock()
+		for len(e.ready) == 0 && !e.closed {
+			e.cond.Wait()
+		}
+		if len(e.ready) == 0 {
+			e.mu.Unlock()
+			return
+		}
+		l := e.ready[0]
+		e.ready[0] = nil
+		e.ready = e.ready[1:]
+		if l.unkeyed {
+			e.unkeyed--
+		}
+		e.mu.Unlock()
+
+		if l.unkeyed {
+			e.execute(l.key, l.tasks[0].fn)
+			continue
+		}
+		e.runLane(l)
+	}
+}
+
+func (e *KeyedExecutor) runLane(l *lane) {
+	for i := 0; i < laneBatchOnceSchedule; i++ {
+		e.mu.Lock()
+		if len(l.tasks) == 0 {
+			e.mu.Unlock()
+			break
+		}
+		task := l.tasks[0]
+		l.tasks[0] = keyedTask{}
+		l.tasks = l.tasks[1:]
+		e.mu.Unlock()
+
+		e.execute(l.key, task.fn)
+	}
+
+	e.mu.Lock()
+	if len(l.tasks) == 0 {
+		l.scheduled = false
+		delete(e.lanes, l.key)
+	} else {
+		e.ready = append(e.ready, l)
+		e.cond.Signal()
+	}
+	e.mu.Unlock()
+}
+
+func (e *KeyedExecutor) execute(key int64, fn func()) {
+	defer func() {
+		atomic.AddUint64(&e.executed, 1)
+		if err := recover(); err != nil {
+			log.Errorf("[KeyedExecutor] key:%d task panic:%+v\n%s", key, err, debug.Stack())
+		}
+	}()
+	fn()
+}
+
+// LaneStat 某个key的积压情况
+type LaneStat struct {
+	Key     int64
+	Pending int
+	Wait    time.Duration // 队首任务已等待的时长
+}
+
+type KeyedStats struct {
+	Keys      int
+	Pending   int
+	Submitted uint64
+	Executed  uint64
+	Overflows uint64
+}
+
+func (e *KeyedExecutor) Stats() (ret KeyedStats) {
+	e.mu.Lock()
+	ret.Keys = len(e.lanes)
+	ret.Pending = e.unkeyed
+	for _, l := range e.lanes {
+		ret.Pending += len(l.tasks)
+	}
+	e.mu.Unlock()
+	ret.Submitted = atomic.LoadUint64(&e.submitted)
+	ret.Executed = atomic.LoadUint64(&e.executed)
+	ret.Overflows = atomic.LoadUint64(&e.overflows)
+	return
+}
+
+// LongestWaiting 返回队首任务等待时间最长的n个key 按等待时长降序
+func (e *KeyedExecutor) LongestWaiting(n int) []LaneStat {
+	now := time.Now().UnixNano()
+	e.mu.Lock()
+	stats := make([]LaneStat, 0, len(e.lanes))
+	for _, l := range e.lanes {
+		if len(l.tasks) == 0 {
+			continue
+		}
+		stats = append(stats, LaneStat{
+			Key:     l.key,
+			Pending: len(l.tasks),
+			Wait:    time.Duration(now - l.tasks[0].submitTime),
+		})
+	}
+	e.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Wait > stats[j].Wait
+	})
+	if n >= 0 && len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
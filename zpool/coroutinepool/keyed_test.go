@@ -0,0 +1,98 @@
+package coroutinepool
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestKeyedExecutor_Order(t *testing.T) {
+	const keys, tasks = 64, 1000
+	e := NewKeyedExecutor(8, 0, nil)
+	result := make([][]int, keys)
+	for i := 0; i < tasks; i++ {
+		for k := 0; k < keys; k++ {
+			k, i := k, i
+			if err := e.SubmitKeyed(int64(k), func() { result[k] = append(result[k], i) }); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	e.Close()
+	for k, v := range result {
+		if len(v) != tasks {
+			t.Fatalf("key:%d executed %d tasks, want %d", k, len(v), tasks)
+		}
+		for i := range v {
+			if v[i] != i {
+				t.Fatalf("key:%d out of order at %d got %d", k, i, v[i])
+			}
+		}
+	}
+}
+
+func TestKeyedExecutor_Overflow(t *testing.T) {
+	var overflowKey int64 = -1
+	block := make(chan struct{})
+	e := NewKeyedExecutor(1, 2, func(key int64, fn func()) { overflowKey = key })
+	_ = e.SubmitKeyed(7, func() { <-block })
+	// 等待第一个任务被worker取走
+	for e.Stats().Pending != 0 {
+		runtime.Gosched()
+	}
+	_ = e.SubmitKeyed(7, func() {})
+	_ = e.SubmitKeyed(7, func() {})
+	if err := e.SubmitKeyed(7, func() {}); err != ErrMailboxFull {
+		t.Fatalf("want ErrMailboxFull got %v", err)
+	}
+	if overflowKey != 7 {
+		t.Fatalf("overflow callback key:%d", overflowKey)
+	}
+	if s := e.LongestWaiting(1); len(s) != 1 || s[0].Key != 7 || s[0].Pending != 2 {
+		t.Fatalf("unexpected LongestWaiting %+v", s)
+	}
+	close(block)
+	e.Close()
+	if s := e.Stats(); s.Executed != 3 || s.Overflows != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestKeyedExecutor_Submit(t *testing.T) {
+	const tasks = 1000
+	e := NewKeyedExecutor(4, 1, nil)
+	var mu sync.Mutex
+	done := 0
+	for i := 0; i < tasks; i++ {
+		// Submit 不受 mailboxSize 限制
+		if err := e.Submit(func() {
+			mu.Lock()
+			done++
+			mu.Unlock()
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.Close()
+	if done != tasks {
+		t.Fatalf("executed %d tasks, want %d", done, tasks)
+	}
+	if s := e.Stats(); s.Pending != 0 || s.Keys != 0 || s.Executed != tasks {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if err := e.Submit(func() {}); err != ErrPoolClosed {
+		t.Fatalf("want ErrPoolClosed got %v", err)
+	}
+}
+
+func BenchmarkKeyedExecutor_SubmitKeyed(b *testing.B) {
+	e := NewKeyedExecutor(8, 0, nil)
+	var wg sync.WaitGroup
+	wg.Add(b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = e.SubmitKeyed(int64(i&1023), wg.Done)
+	}
+	wg.Wait()
+	e.Close()
+}
@@ -0,0 +1,241 @@
+package zredis
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrTooManyRedirects = errors.New("zredis: too many cluster redirects")
+
+const maxRedirects = 5
+
+// Cluster 按key所在的槽把命令发到负责的节点 每个节点一个 Pool
+// 槽表来自 CLUSTER SLOTS 第一次使用时加载
+// 收到 MOVED 时更新该槽并重新加载槽表 收到 ASK 时只对这条命令先发 ASKING 到目标节点
+// 只按第一个key路由 多key命令需要调用方用 {tag} 保证在同一个槽
+type Cluster struct {
+	r         *reactor.Reactor
+	seeds     []string
+	size      int
+	onConnect func(ctx context.Context, c *Conn) error
+
+	mu     sync.Mutex
+	slots  []string // 槽 -> 节点地址 nil表示还没有加载
+	pools  map[string]*Pool
+	closed bool
+}
+
+// NewCluster seeds 为任意几个节点的地址 size onConnect 用于每个节点的 Pool
+func NewCluster(r *reactor.Reactor, seeds []string, size int, onConnect func(ctx context.Context, c *Conn) error) *Cluster {
+	return &Cluster{r: r, seeds: seeds, size: size, onConnect: onConnect, pools: make(map[string]*Pool)}
+}
+
+// Pool 节点的连接池 不存在时创建
+func (c *Cluster) Pool(addr string) (*Pool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrPoolClosed
+	}
+	p, ok := c.pools[addr]
+	if !ok {
+		p = NewPool(c.r, addr, c.size, c.onConnect)
+		c.pools[addr] = p
+	}
+	return p, nil
+}
+
+// Refresh 依次向已知节点请求 CLUSTER SLOTS 直到成功
+func (c *Cluster) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	addrs := append([]string{}, c.seeds...)
+	for addr := range c.pools {
+		addrs = append(addrs, addr)
+	}
+	c.mu.Unlock()
+
+	err := ErrDial
+	for _, addr := range addrs {
+		var p *Pool
+		if p, err = c.Pool(addr); err != nil {
+			return err
+		}
+		var v Value
+		if v, err = p.Do(ctx, "CLUSTER", "SLOTS"); err != nil {
+			continue
+		}
+		var slots []string
+		if slots, err = parseSlots(v); err != nil {
+			continue
+		}
+		c.mu.Lock()
+		c.slots = slots
+		c.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// parseSlots 每项为 [start end [host port id] 副本...] 只使用主节点
+func parseSlots(v Value) ([]string, error) {
+	ranges, err := v.Values()
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]string, SlotCount)
+	for _, r := range ranges {
+		if len(r.Array) < 3 || len(r.Array[2].Array) < 2 {
+			return nil, ErrType
+		}
+		start, err1 := r.Array[0].Int64()
+		end, err2 := r.Array[1].Int64()
+		host, err3 := r.Array[2].Array[0].Text()
+		port, err4 := r.Array[2].Array[1].Int64()
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || start < 0 || end >= SlotCount || start > end {
+			return nil, ErrType
+		}
+		addr := "tcp://" + host + ":" + strconv.FormatInt(port, 10)
+		for i := start; i <= end; i++ {
+			slots[i] = addr
+		}
+	}
+	return slots, nil
+}
+
+// node slot 为-1或槽表中没有该槽时使用第一个种子节点
+func (c *Cluster) node(ctx context.Context, slot int) (*Pool, error) {
+	c.mu.Lock()
+	loaded := c.slots != nil
+	c.mu.Unlock()
+	if !loaded {
+		if err := c.Refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+	c.mu.Lock()
+	addr := c.seeds[0]
+	if slot >= 0 && c.slots[slot] != "" {
+		addr = c.slots[slot]
+	}
+	c.mu.Unlock()
+	return c.Pool(addr)
+}
+
+// Do 重定向超过 maxRedirects 次时返回 ErrTooManyRedirects
+func (c *Cluster) Do(ctx context.Context, args ...any) (Value, error) {
+	slot := -1
+	if key, ok := commandKey(args); ok {
+		slot = Slot(key)
+	}
+	p, err := c.node(ctx, slot)
+	if err != nil {
+		return Value{}, err
+	}
+	asking := false
+	for i := 0; i <= maxRedirects; i++ {
+		var v Value
+		if asking {
+			v, err = askingDo(ctx, p, args)
+		} else {
+			v, err = p.Do(ctx, args...)
+		}
+		var e Error
+		if !errors.As(err, &e) {
+			return v, err
+		}
+		kind, addr, ok := parseRedirect(string(e))
+		if !ok {
+			return v, err
+		}
+		if p, err = c.Pool(addr); err != nil {
+			return Value{}, err
+		}
+		asking = kind == "ASK"
+		if !asking {
+			c.moved(ctx, slot, addr)
+		}
+	}
+	return Value{}, ErrTooManyRedirects
+}
+
+// moved 先更新这个槽 再刷新整个槽表 刷新失败不影响本次重试
+func (c *Cluster) moved(ctx context.Context, slot int, addr string) {
+	c.mu.Lock()
+	if slot >= 0 && c.slots != nil {
+		c.slots[slot] = addr
+	}
+	c.mu.Unlock()
+	if err := c.Refresh(ctx); err != nil {
+		log.Warnf("[zredis] refresh cluster slots err:%s", err.Error())
+	}
+}
+
+// askingDo ASKING 和命令在一次发送中写入同一条连接 中间不会插入其他命令
+func askingDo(ctx context.Context, p *Pool, args []any) (Value, error) {
+	pipe, err := p.Pipeline(ctx)
+	if err != nil {
+		return Value{}, err
+	}
+	values, err := pipe.Add("ASKING").Add(args...).Exec(ctx)
+	if err != nil {
+		return Value{}, err
+	}
+	if err = values[0].Err(); err != nil {
+		return Value{}, err
+	}
+	return values[1], values[1].Err()
+}
+
+// parseRedirect MOVED 3999 127.0.0.1:6381 或 ASK 3999 127.0.0.1:6381
+func parseRedirect(msg string) (kind string, addr string, ok bool) {
+	parts := strings.Fields(msg)
+	if len(parts) != 3 || (parts[0] != "MOVED" && parts[0] != "ASK") {
+		return "", "", false
+	}
+	return parts[0], "tcp://" + parts[2], true
+}
+
+// commandKey 命令的第一个key 没有key的命令发到任意节点
+func commandKey(args []any) (string, bool) {
+	if len(args) < 2 {
+		return "", false
+	}
+	name, err := argBytes(args[0])
+	if err != nil {
+		return "", false
+	}
+	pos := 1
+	switch strings.ToUpper(string(name)) {
+	case "PING", "ECHO", "SCRIPT", "PUBLISH", "CLUSTER", "HELLO", "INFO", "FLUSHALL":
+		return "", false
+	case "EVAL", "EVALSHA":
+		if len(args) < 4 {
+			return "", false
+		}
+		if n, err := argBytes(args[2]); err != nil || string(n) == "0" {
+			return "", false
+		}
+		pos = 3
+	}
+	key, err := argBytes(args[pos])
+	if err != nil {
+		return "", false
+	}
+	return string(key), true
+}
+
+// Close 关闭所有节点的连接池
+func (c *Cluster) Close() {
+	c.mu.Lock()
+	c.closed = true
+	pools := c.pools
+	c.pools = map[string]*Pool{}
+	c.mu.Unlock()
+	for _, p := range pools {
+		p.Close()
+	}
+}
@@ -0,0 +1,96 @@
+package zredis_test
+
+import (
+	"context"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zredis"
+	"github.com/jiangshuai341/zbus/zredis/redistest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCluster(t *testing.T) {
+	cluster, err := redistest.NewCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	r, err := reactor.NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只给一个种子节点 其他节点从槽表中发现
+	cli := zredis.NewCluster(r, cluster.Addrs()[:1], 1, nil)
+	defer cli.Close()
+	c := zredis.Commands{Doer: cli}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	owned := make([]int, 3)
+	for i := 0; i < 30; i++ {
+		key := "key" + strconv.Itoa(i)
+		if err = c.Set(ctx, key, i); err != nil {
+			t.Fatal(err)
+		}
+		owner := cluster.Owner(zredis.Slot(key))
+		if got := cluster.Servers[owner].Get(key); string(got) != strconv.Itoa(i) {
+			t.Fatalf("%s not on owner %d got %q", key, owner, got)
+		}
+		owned[owner]++
+	}
+	for i, n := range owned {
+		if n == 0 {
+			t.Fatalf("no key on node %d %v", i, owned)
+		}
+	}
+
+	// 相同tag的key在同一个节点 脚本按第一个key路由
+	const src = "redis.call('SET', KEYS[2], redis.call('GET', KEYS[1]))"
+	cluster.Script(src, func(call func(args ...any) zredis.Value, keys, argv [][]byte) zredis.Value {
+		return call("SET", keys[1], call("GET", keys[0]).Str)
+	})
+	if err = c.Set(ctx, "{user1}.a", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = zredis.NewScript(src).Run(ctx, cli, []string{"{user1}.a", "{user1}.b"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "{user1}.b"); err != nil || string(v) != "x" {
+		t.Fatalf("got %q %v", v, err)
+	}
+
+	// 槽迁移完成后 旧节点回复 MOVED
+	slot := zredis.Slot("key1")
+	from := cluster.Owner(slot)
+	to := (from + 1) % 3
+	cluster.MoveSlot(slot, to)
+	if v, err := c.Get(ctx, "key1"); err != nil || string(v) != "1" {
+		t.Fatalf("got %q %v", v, err)
+	}
+
+	// 迁移中 源节点没有的key回复 ASK 写到目标节点
+	slot = zredis.Slot("key2")
+	from = cluster.Owner(slot)
+	to = (from + 1) % 3
+	cluster.Migrating(slot, to)
+	if err = c.Set(ctx, "{key2}.new", "n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := cluster.Servers[to].Get("{key2}.new"); string(got) != "n" {
+		t.Fatalf("new key not on target got %q", got)
+	}
+	if v, err := c.Get(ctx, "key2"); err != nil || string(v) != "2" {
+		t.Fatalf("got %q %v", v, err)
+	}
+	if v, err := c.Get(ctx, "{key2}.new"); err != nil || string(v) != "n" {
+		t.Fatalf("got %q %v", v, err)
+	}
+	cluster.MoveSlot(slot, to)
+	if v, err := c.Get(ctx, "key2"); err != nil || string(v) != "2" {
+		t.Fatalf("got %q %v", v, err)
+	}
+	if v, err := cli.Do(ctx, "PING"); err != nil || string(v.Str) != "PONG" {
+		t.Fatalf("got %+v %v", v, err)
+	}
+}
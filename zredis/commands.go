@@ -0,0 +1,273 @@
+package zredis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Commands 常用命令的类型化封装 Doer 可以是 Conn Pool 或 Cluster
+// 不存在的key返回 ErrNil 错误回复以 Error 返回
+type Commands struct {
+	Doer
+}
+
+func (c Commands) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := c.Do(ctx, "GET", key)
+	if err != nil {
+		return nil, err
+	}
+	return v.Bytes()
+}
+
+func (c Commands) Set(ctx context.Context, key string, val any) error {
+	_, err := c.Do(ctx, "SET", key, val)
+	return err
+}
+
+// SetNX key已经存在时返回false
+func (c Commands) SetNX(ctx context.Context, key string, val any) (bool, error) {
+	v, err := c.Do(ctx, "SET", key, val, "NX")
+	if err != nil {
+		return false, err
+	}
+	return !v.IsNil(), nil
+}
+
+func (c Commands) Del(ctx context.Context, keys ...string) (int64, error) {
+	return c.int(ctx, keysArgs("DEL", keys)...)
+}
+
+func (c Commands) Exists(ctx context.Context, keys ...string) (int64, error) {
+	return c.int(ctx, keysArgs("EXISTS", keys)...)
+}
+
+func (c Commands) Incr(ctx context.Context, key string) (int64, error) {
+	return c.int(ctx, "INCR", key)
+}
+
+func (c Commands) IncrBy(ctx context.Context, key string, by int64) (int64, error) {
+	return c.int(ctx, "INCRBY", key, by)
+}
+
+func (c Commands) HGet(ctx context.Context, key, field string) ([]byte, error) {
+	v, err := c.Do(ctx, "HGET", key, field)
+	if err != nil {
+		return nil, err
+	}
+	return v.Bytes()
+}
+
+// HSet fieldValues 为字段和值交替 返回新增的字段数
+func (c Commands) HSet(ctx context.Context, key string, fieldValues ...any) (int64, error) {
+	args := append([]any{"HSET", key}, fieldValues...)
+	return c.int(ctx, args...)
+}
+
+func (c Commands) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	args := []any{"HDEL", key}
+	for _, f := range fields {
+		args = append(args, f)
+	}
+	return c.int(ctx, args...)
+}
+
+func (c Commands) HLen(ctx context.Context, key string) (int64, error) {
+	return c.int(ctx, "HLEN", key)
+}
+
+func (c Commands) HIncrBy(ctx context.Context, key, field string, by int64) (int64, error) {
+	return c.int(ctx, "HINCRBY", key, field, by)
+}
+
+// HGetAll key不存在时返回空map
+func (c Commands) HGetAll(ctx context.Context, key string) (map[string][]byte, error) {
+	v, err := c.Do(ctx, "HGETALL", key)
+	if err != nil {
+		return nil, err
+	}
+	return v.BytesMap()
+}
+
+// Z 有序集合的成员
+type Z struct {
+	Member string
+	Score  float64
+}
+
+// ZAdd 返回新增的成员数
+func (c Commands) ZAdd(ctx context.Context, key string, members ...Z) (int64, error) {
+	args := make([]any, 0, 2+2*len(members))
+	args = append(args, "ZADD", key)
+	for _, m := range members {
+		args = append(args, m.Score, m.Member)
+	}
+	return c.int(ctx, args...)
+}
+
+func (c Commands) ZIncrBy(ctx context.Context, key string, by float64, member string) (float64, error) {
+	v, err := c.Do(ctx, "ZINCRBY", key, by, member)
+	if err != nil {
+		return 0, err
+	}
+	return v.Float64()
+}
+
+// ZScore 成员不存在时返回 ErrNil
+func (c Commands) ZScore(ctx context.Context, key, member string) (float64, error) {
+	v, err := c.Do(ctx, "ZSCORE", key, member)
+	if err != nil {
+		return 0, err
+	}
+	return v.Float64()
+}
+
+func (c Commands) ZRem(ctx context.Context, key string, members ...string) (int64, error) {
+	args := []any{"ZREM", key}
+	for _, m := range members {
+		args = append(args, m)
+	}
+	return c.int(ctx, args...)
+}
+
+func (c Commands) ZCard(ctx context.Context, key string) (int64, error) {
+	return c.int(ctx, "ZCARD", key)
+}
+
+// ZRank 成员不存在时返回 ErrNil
+func (c Commands) ZRank(ctx context.Context, key, member string) (int64, error) {
+	return c.int(ctx, "ZRANK", key, member)
+}
+
+// ZRange 按分数从小到大 start stop 为下标 负数从末尾开始
+func (c Commands) ZRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	v, err := c.Do(ctx, "ZRANGE", key, start, stop)
+	if err != nil {
+		return nil, err
+	}
+	return v.Strings()
+}
+
+func (c Commands) ZRangeWithScores(ctx context.Context, key string, start, stop int64) ([]Z, error) {
+	v, err := c.Do(ctx, "ZRANGE", key, start, stop, "WITHSCORES")
+	if err != nil {
+		return nil, err
+	}
+	return scores(v)
+}
+
+func (c Commands) ZRevRangeWithScores(ctx context.Context, key string, start, stop int64) ([]Z, error) {
+	v, err := c.Do(ctx, "ZREVRANGE", key, start, stop, "WITHSCORES")
+	if err != nil {
+		return nil, err
+	}
+	return scores(v)
+}
+
+// ZRangeByScore min max 支持 -inf +inf 和 ( 开头的开区间
+func (c Commands) ZRangeByScore(ctx context.Context, key, min, max string) ([]string, error) {
+	v, err := c.Do(ctx, "ZRANGEBYSCORE", key, min, max)
+	if err != nil {
+		return nil, err
+	}
+	return v.Strings()
+}
+
+// scores RESP2 中成员与分数交替 RESP3 中每个成员是 [member score] 数组
+func scores(v Value) ([]Z, error) {
+	values, err := v.Values()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) > 0 && values[0].Kind == KindArray {
+		flat := make([]Value, 0, 2*len(values))
+		for _, pair := range values {
+			flat = append(flat, pair.Array...)
+		}
+		values = flat
+	}
+	if len(values)%2 != 0 {
+		return nil, ErrType
+	}
+	ret := make([]Z, len(values)/2)
+	for i := range ret {
+		if ret[i].Member, err = values[2*i].Text(); err != nil {
+			return nil, err
+		}
+		if ret[i].Score, err = values[2*i+1].Float64(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// Eval 脚本的返回值原样返回
+func (c Commands) Eval(ctx context.Context, script string, keys []string, args ...any) (Value, error) {
+	return c.Do(ctx, evalArgs("EVAL", script, keys, args)...)
+}
+
+func (c Commands) EvalSha(ctx context.Context, sha string, keys []string, args ...any) (Value, error) {
+	return c.Do(ctx, evalArgs("EVALSHA", sha, keys, args)...)
+}
+
+// ScriptLoad 返回脚本的sha1
+func (c Commands) ScriptLoad(ctx context.Context, script string) (string, error) {
+	v, err := c.Do(ctx, "SCRIPT", "LOAD", script)
+	if err != nil {
+		return "", err
+	}
+	return v.Text()
+}
+
+func (c Commands) int(ctx context.Context, args ...any) (int64, error) {
+	v, err := c.Do(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	return v.Int64()
+}
+
+func keysArgs(cmd string, keys []string) []any {
+	args := make([]any, 0, 1+len(keys))
+	args = append(args, cmd)
+	for _, k := range keys {
+		args = append(args, k)
+	}
+	return args
+}
+
+func evalArgs(cmd, script string, keys []string, args []any) []any {
+	ret := make([]any, 0, 3+len(keys)+len(args))
+	ret = append(ret, cmd, script, len(keys))
+	for _, k := range keys {
+		ret = append(ret, k)
+	}
+	return append(ret, args...)
+}
+
+// Script 先以 EVALSHA 执行 服务端没有缓存该脚本时改用 EVAL
+type Script struct {
+	src string
+	sha string
+}
+
+func NewScript(src string) *Script {
+	sum := sha1.Sum([]byte(src))
+	return &Script{src: src, sha: hex.EncodeToString(sum[:])}
+}
+
+func (s *Script) Hash() string {
+	return s.sha
+}
+
+func (s *Script) Run(ctx context.Context, d Doer, keys []string, args ...any) (Value, error) {
+	c := Commands{d}
+	v, err := c.EvalSha(ctx, s.sha, keys, args...)
+	var e Error
+	if errors.As(err, &e) && strings.HasPrefix(string(e), "NOSCRIPT") {
+		return c.Eval(ctx, s.src, keys, args...)
+	}
+	return v, err
+}
@@ -0,0 +1,189 @@
+package zredis_test
+
+import (
+	"context"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zredis"
+	"github.com/jiangshuai341/zbus/zredis/redistest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newPool(t *testing.T, resp3 bool) (*redistest.Server, *zredis.Pool) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	r, err := reactor.NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var onConnect func(ctx context.Context, c *zredis.Conn) error
+	if resp3 {
+		onConnect = func(ctx context.Context, c *zredis.Conn) error {
+			_, err := c.Do(ctx, "HELLO", 3)
+			return err
+		}
+	}
+	p := zredis.NewPool(r, s.Addr(), 2, onConnect)
+	t.Cleanup(p.Close)
+	return s, p
+}
+
+func TestCommands(t *testing.T) {
+	for _, resp3 := range []bool{false, true} {
+		resp3 := resp3
+		name := "resp2"
+		if resp3 {
+			name = "resp3"
+		}
+		t.Run(name, func(t *testing.T) {
+			s, p := newPool(t, resp3)
+			testCommands(t, s, p, resp3)
+		})
+	}
+}
+
+func testCommands(t *testing.T, s *redistest.Server, p *zredis.Pool, resp3 bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := zredis.Commands{Doer: p}
+
+	// strings
+	if err := c.Set(ctx, "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "k"); err != nil || string(v) != "v" {
+		t.Fatalf("got %q %v", v, err)
+	}
+	if _, err := c.Get(ctx, "missing"); err != zredis.ErrNil {
+		t.Fatalf("want ErrNil got %v", err)
+	}
+	if ok, err := c.SetNX(ctx, "k", "x"); err != nil || ok {
+		t.Fatalf("got %v %v", ok, err)
+	}
+	if n, err := c.IncrBy(ctx, "n", 5); err != nil || n != 5 {
+		t.Fatalf("got %d %v", n, err)
+	}
+	if n, err := c.Del(ctx, "k", "n", "missing"); err != nil || n != 2 {
+		t.Fatalf("got %d %v", n, err)
+	}
+
+	// hashes
+	if n, err := c.HSet(ctx, "h", "a", 1, "b", "x"); err != nil || n != 2 {
+		t.Fatalf("got %d %v", n, err)
+	}
+	if n, err := c.HIncrBy(ctx, "h", "a", 2); err != nil || n != 3 {
+		t.Fatalf("got %d %v", n, err)
+	}
+	if m, err := c.HGetAll(ctx, "h"); err != nil || string(m["a"]) != "3" || string(m["b"]) != "x" {
+		t.Fatalf("got %v %v", m, err)
+	}
+	if v, err := p.Do(ctx, "HGETALL", "h"); err != nil || (v.Kind == zredis.KindMap) != resp3 {
+		t.Fatalf("got %+v %v", v, err)
+	}
+	if n, err := c.HDel(ctx, "h", "a"); err != nil || n != 1 {
+		t.Fatalf("got %d %v", n, err)
+	}
+	if _, err := c.HGet(ctx, "h", "a"); err != zredis.ErrNil {
+		t.Fatalf("want ErrNil got %v", err)
+	}
+
+	// sorted sets
+	if n, err := c.ZAdd(ctx, "z", zredis.Z{Member: "a", Score: 3}, zredis.Z{Member: "b", Score: 1}, zredis.Z{Member: "c", Score: 2}); err != nil || n != 3 {
+		t.Fatalf("got %d %v", n, err)
+	}
+	if f, err := c.ZIncrBy(ctx, "z", 2.5, "b"); err != nil || f != 3.5 {
+		t.Fatalf("got %v %v", f, err)
+	}
+	if v, err := p.Do(ctx, "ZSCORE", "z", "b"); err != nil || (v.Kind == zredis.KindDouble) != resp3 {
+		t.Fatalf("got %+v %v", v, err)
+	}
+	if f, err := c.ZScore(ctx, "z", "a"); err != nil || f != 3 {
+		t.Fatalf("got %v %v", f, err)
+	}
+	if _, err := c.ZScore(ctx, "z", "missing"); err != zredis.ErrNil {
+		t.Fatalf("want ErrNil got %v", err)
+	}
+	if n, err := c.ZRank(ctx, "z", "a"); err != nil || n != 1 {
+		t.Fatalf("got %d %v", n, err)
+	}
+	if got, err := c.ZRange(ctx, "z", 0, -1); err != nil || !reflect.DeepEqual(got, []string{"c", "a", "b"}) {
+		t.Fatalf("got %v %v", got, err)
+	}
+	if got, err := c.ZRevRangeWithScores(ctx, "z", 0, 1); err != nil || !reflect.DeepEqual(got, []zredis.Z{{Member: "b", Score: 3.5}, {Member: "a", Score: 3}}) {
+		t.Fatalf("got %v %v", got, err)
+	}
+	if got, err := c.ZRangeByScore(ctx, "z", "(2", "+inf"); err != nil || !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("got %v %v", got, err)
+	}
+	if n, err := c.ZRem(ctx, "z", "a", "missing"); err != nil || n != 1 {
+		t.Fatalf("got %d %v", n, err)
+	}
+	if got, err := c.ZRangeWithScores(ctx, "z", 0, -1); err != nil || !reflect.DeepEqual(got, []zredis.Z{{Member: "c", Score: 2}, {Member: "b", Score: 3.5}}) {
+		t.Fatalf("got %v %v", got, err)
+	}
+
+	// scripts 第一次 EVALSHA 找不到 自动改用 EVAL
+	const src = "return redis.call('INCRBY', KEYS[1], ARGV[1])"
+	s.Script(src, func(call func(args ...any) zredis.Value, keys, argv [][]byte) zredis.Value {
+		return call("INCRBY", keys[0], argv[0])
+	})
+	script := zredis.NewScript(src)
+	for i, want := range []int64{2, 4} {
+		v, err := script.Run(ctx, p, []string{"counter"}, 2)
+		if err != nil || v.Int != want {
+			t.Fatalf("run %d got %+v %v", i, v, err)
+		}
+	}
+	if sha, err := c.ScriptLoad(ctx, src); err != nil || sha != script.Hash() {
+		t.Fatalf("got %s %v", sha, err)
+	}
+	if _, err := c.EvalSha(ctx, "0000", nil); err == nil {
+		t.Fatal("want NOSCRIPT")
+	}
+}
+
+func TestPool(t *testing.T) {
+	s, p := newPool(t, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 独占的连接归还后复用
+	a, err := p.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Do(ctx, "WATCH", "k"); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = a.Do(ctx, "UNWATCH")
+	p.Put(a)
+	if b, err := p.Get(ctx); err != nil || b != a {
+		t.Fatalf("idle connection not reused %v", err)
+	}
+
+	if _, err = p.Do(ctx, "SET", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	// 服务端断开后共享连接重连
+	s.Disconnect()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		v, err := p.Do(ctx, "GET", "k")
+		if err == nil && string(v.Str) == "v" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool not reconnected %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	p.Close()
+	if _, err = p.Do(ctx, "PING"); err != zredis.ErrPoolClosed {
+		t.Fatalf("want ErrPoolClosed got %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+package zredis
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/logger"
+	"github.com/jiangshuai341/zbus/zbuffer"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/epoll"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var log = logger.GetLogger("zredis")
+
+// DefaultTimeout ctx 没有设置deadline时使用
+const DefaultTimeout = 5 * time.Second
+
+var (
+	ErrClosed = errors.New("zredis: connection closed")
+	ErrDial   = errors.New("zredis: dial failed")
+)
+
+// Doer Conn Pool Cluster 都实现 Commands 通过它发送命令
+type Doer interface {
+	Do(ctx context.Context, args ...any) (Value, error)
+}
+
+type call struct {
+	done int32
+	cb   func(v Value, err error)
+}
+
+// complete 回复、超时、连接断开 以先发生者为准
+func (c *call) complete(v Value, err error) {
+	if atomic.CompareAndSwapInt32(&c.done, 0, 1) {
+		c.cb(v, err)
+	}
+}
+
+// Conn 一条到redis的连接 实现 reactor.INetHandle
+// 请求按发送顺序排队 回复按FIFO与请求匹配 多个协程可以同时在一条连接上发送命令
+// WATCH/MULTI 等依赖连接状态的命令需要调用方保证期间没有其他命令插入
+// HELLO 3 切换到RESP3后 服务端推送的消息交给 SetPushHandler 设置的回调 不参与FIFO匹配
+// 订阅的确认不按FIFO返回 订阅使用 PubSub
+type Conn struct {
+	mu      sync.Mutex
+	conn    *reactor.Connection
+	close   func()
+	pending []*call
+	closed  bool
+	push    func(v Value)
+}
+
+// Dial 连接 url 并加入 r 管理 url 如 tcp://127.0.0.1:6379
+func Dial(r *reactor.Reactor, url string) (*Conn, error) {
+	conn := reactor.Dial(url)
+	if conn == nil {
+		return nil, ErrDial
+	}
+	c := &Conn{conn: conn}
+	c.close = func() {
+		_ = r.DoTaskInIoThread(func(*epoll.Epoller) {
+			conn.Close()
+		})
+	}
+	conn.INetHandle = c
+	if err := r.AddConn(conn); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close 线程安全 等待回复的命令收到 ErrClosed
+func (c *Conn) Close() {
+	c.close()
+}
+
+// SetPushHandler fn 在IO线程中执行 不要阻塞 没有设置时丢弃推送
+func (c *Conn) SetPushHandler(fn func(v Value)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.push = fn
+}
+
+func (c *Conn) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Do 发送一条命令并等待回复 错误回复以 Error 返回
+func (c *Conn) Do(ctx context.Context, args ...any) (Value, error) {
+	type result struct {
+		v   Value
+		err error
+	}
+	ch := make(chan result, 1)
+	c.DoAsync(ctx, func(v Value, err error) {
+		ch <- result{v, err}
+	}, args...)
+	r := <-ch
+	return r.v, r.err
+}
+
+// DoAsync callback 恰好执行一次 在IO线程或超时协程中执行 不要阻塞
+func (c *Conn) DoAsync(ctx context.Context, callback func(v Value, err error), args ...any) {
+	cmd, err := EncodeCommand(args...)
+	if err != nil {
+		callback(Value{}, err)
+		return
+	}
+	c.send(ctx, [][]byte{cmd}, []func(Value, error){callback})
+}
+
+// send cmds 与 callbacks 一一对应 一次写入连接
+func (c *Conn) send(ctx context.Context, cmds [][]byte, callbacks []func(Value, error)) {
+	var cancel context.CancelFunc = func() {}
+	if _, ok := ctx.Deadline(); !ok {
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+	}
+	if err := ctx.Err(); err != nil {
+		cancel()
+		for _, cb := range callbacks {
+			cb(Value{}, err)
+		}
+		return
+	}
+
+	var remain = int32(len(callbacks))
+	done := make(chan struct{})
+	calls := make([]*call, len(callbacks))
+	for i, cb := range callbacks {
+		cb := cb
+		calls[i] = &call{cb: func(v Value, err error) {
+			if atomic.AddInt32(&remain, -1) == 0 {
+				close(done)
+			}
+			cb(v, err)
+		}}
+	}
+
+	// 入队和写入在同一把锁内 保证队列顺序与发送顺序一致
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		cancel()
+		for _, cl := range calls {
+			cl.complete(Value{}, ErrClosed)
+		}
+		return
+	}
+	c.pending = append(c.pending, calls...)
+	err := c.conn.SendSafeZeroCopy(cmds...)
+	c.mu.Unlock()
+	if err != nil {
+		// 写入失败后连接上的请求与回复不再对应
+		c.Close()
+		cancel()
+		for _, cl := range calls {
+			cl.complete(Value{}, err)
+		}
+		return
+	}
+
+	go func() {
+		defer cancel()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			// 超时的回复到达时被丢弃
+			for _, cl := range calls {
+				cl.complete(Value{}, ctx.Err())
+			}
+		}
+	}()
+}
+
+// Pipeline 多条命令一次写入 回复按顺序返回
+type Pipeline struct {
+	conn *Conn
+	cmds [][]byte
+	err  error
+}
+
+func (c *Conn) Pipeline() *Pipeline {
+	return &Pipeline{conn: c}
+}
+
+// Add 编码失败时 Exec 返回该错误
+func (p *Pipeline) Add(args ...any) *Pipeline {
+	if p.err != nil {
+		return p
+	}
+	cmd, err := EncodeCommand(args...)
+	if err != nil {
+		p.err = err
+		return p
+	}
+	p.cmds = append(p.cmds, cmd)
+	return p
+}
+
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Exec 错误回复保留在对应的 Value 中 返回的error只表示发送或连接错误
+func (p *Pipeline) Exec(ctx context.Context) ([]Value, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if len(p.cmds) == 0 {
+		return nil, nil
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	values := make([]Value, len(p.cmds))
+	callbacks := make([]func(Value, error), len(p.cmds))
+	for i := range p.cmds {
+		i := i
+		callbacks[i] = func(v Value, err error) {
+			if err != nil && v.Kind != KindError {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+			values[i] = v
+			wg.Done()
+		}
+	}
+	wg.Add(len(p.cmds))
+	p.conn.send(ctx, p.cmds, callbacks)
+	p.cmds = nil
+	wg.Wait()
+	return values, firstErr
+}
+
+func (c *Conn) OnTraffic(inboundBuffer *zbuffer.CombinesBuffer) {
+	for {
+		v, err := ReadValue(inboundBuffer)
+		if err == ErrIncomplete {
+			return
+		}
+		if err != nil {
+			log.Errorf("[zredis] bad reply err:%s", err.Error())
+			c.conn.Close()
+			return
+		}
+		c.mu.Lock()
+		if v.Kind == KindPush {
+			push := c.push
+			c.mu.Unlock()
+			if push != nil {
+				push(v)
+			}
+			continue
+		}
+		if len(c.pending) == 0 {
+			c.mu.Unlock()
+			log.Errorf("[zredis] unexpected reply kind:%s", v.Kind)
+			c.conn.Close()
+			return
+		}
+		cl := c.pending[0]
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+		c.mu.Unlock()
+		cl.complete(v, v.Err())
+	}
+}
+
+func (c *Conn) OnClose() {
+	c.mu.Lock()
+	c.closed = true
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	for _, cl := range pending {
+		cl.complete(Value{}, ErrClosed)
+	}
+}
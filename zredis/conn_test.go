@@ -0,0 +1,92 @@
+package zredis_test
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zredis"
+	"github.com/jiangshuai341/zbus/zredis/redistest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func dial(t *testing.T) (*redistest.Server, *zredis.Conn) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	r, err := reactor.NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := zredis.Dial(r, s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return s, c
+}
+
+func TestConn(t *testing.T) {
+	s, c := dial(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if v, err := c.Do(ctx, "SET", "k", "v"); err != nil || string(v.Str) != "OK" {
+		t.Fatalf("got %+v %v", v, err)
+	}
+	if v, err := c.Do(ctx, "GET", "k"); err != nil || string(v.Str) != "v" {
+		t.Fatalf("got %+v %v", v, err)
+	}
+	if v, err := c.Do(ctx, "GET", "missing"); err != nil || !v.IsNil() {
+		t.Fatalf("got %+v %v", v, err)
+	}
+	var redisErr zredis.Error
+	if _, err := c.Do(ctx, "HGET", "k", "f"); !errors.As(err, &redisErr) {
+		t.Fatalf("want zredis.Error got %v", err)
+	}
+
+	// 多个协程共享一条连接 回复按FIFO匹配
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := c.Do(ctx, "ECHO", strconv.Itoa(i))
+			if err == nil && string(v.Str) != strconv.Itoa(i) {
+				err = errors.New("reply mismatch " + string(v.Str))
+			}
+			if err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// pipeline 错误回复留在对应位置
+	values, err := c.Pipeline().Add("INCR", "n").Add("HGET", "k", "f").Add("INCRBY", "n", 2).Exec(ctx)
+	if err != nil || values[0].Int != 1 || values[1].Err() == nil || values[2].Int != 3 {
+		t.Fatalf("got %+v %v", values, err)
+	}
+
+	// 服务端断开后 调用收到 ErrClosed
+	s.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.Closed() {
+		if time.Now().After(deadline) {
+			t.Fatal("connection not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err = c.Do(ctx, "PING"); err != zredis.ErrClosed {
+		t.Fatalf("want ErrClosed got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package orm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jiangshuai341/zbus/flatbuffers"
+	"github.com/jiangshuai341/zbus/zredis"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// redis ORM 实体状态以flatbuffers表的形式保存在redis hash中 key为 Schema.Name:entityID
+//
+// 乐观锁 hash的 _ver 字段为版本号 每次保存加一
+// 保存时 WATCH key 并确认版本与加载时一致 再以 MULTI/EXEC 写入变化的字段
+// 期间其他进程修改了该key时返回 ErrConflict 调用方重新加载后再修改
+//
+// 脏字段 加载时记录每个字段的值 保存时只写入与之不同的字段
+// 用生成的 MutateXxx 原地修改 或用对象API重新打包后 Set 都能被发现
+
+var (
+	ErrNotFound = errors.New("orm: record not found")
+	ErrConflict = errors.New("orm: record modified by others")
+	ErrCorrupt  = errors.New("orm: corrupt record")
+)
+
+// Store 一条redis连接 事务期间独占该连接
+type Store struct {
+	conn *zredis.Conn
+	mu   sync.Mutex
+}
+
+func NewStore(conn *zredis.Conn) *Store {
+	return &Store{conn: conn}
+}
+
+// Packer 对象API生成的 XxxT
+type Packer interface {
+	Pack(builder *flatbuffers.Builder) flatbuffers.UOffsetT
+}
+
+// TablePtr 生成的表类型 如 *Monster 需要带rpcgen生成的 Verify 方法 加载时校验redis中的数据
+type TablePtr[T any] interface {
+	*T
+	flatbuffers.FlatBuffer
+	flatbuffers.Verifiable
+}
+
+// Record 一个实体的状态 不是线程安全的
+type Record[T any, P TablePtr[T]] struct {
+	id      int64
+	version int64
+	buf     []byte
+	table   P
+	saved   map[string][]byte
+}
+
+func (r *Record[T, P]) ID() int64 {
+	return r.id
+}
+
+// Version 0 表示还没有保存过
+func (r *Record[T, P]) Version() int64 {
+	return r.version
+}
+
+// Table 读取和原地修改 修改在 Save 时写入
+func (r *Record[T, P]) Table() P {
+	return r.table
+}
+
+func (r *Record[T, P]) Bytes() []byte {
+	return r.buf
+}
+
+// Set 替换整个buffer buf 之后归 Record 所有
+func (r *Record[T, P]) Set(buf []byte) {
+	r.buf = buf
+	r.table = new(T)
+	flatbuffers.GetRootAs(buf, 0, r.table)
+}
+
+// SetObject 用对象API的值替换
+func (r *Record[T, P]) SetObject(obj Packer) {
+	b := flatbuffers.NewBuilder(256)
+	b.Finish(obj.Pack(b))
+	r.Set(b.FinishedBytes())
+}
+
+// Repo 按 schema 存取一种实体
+type Repo[T any, P TablePtr[T]] struct {
+	store  *Store
+	schema *Schema
+}
+
+func NewRepo[T any, P TablePtr[T]](store *Store, schema *Schema) *Repo[T, P] {
+	return &Repo[T, P]{store: store, schema: schema}
+}
+
+func (r *Repo[T, P]) Key(id int64) string {
+	return r.schema.Name + ":" + strconv.FormatInt(id, 10)
+}
+
+// New 还没有保存的实体 第一次 Save 时如果key已经存在返回 ErrConflict
+func (r *Repo[T, P]) New(id int64, obj Packer) *Record[T, P] {
+	rec := &Record[T, P]{id: id, saved: map[string][]byte{}}
+	rec.SetObject(obj)
+	return rec
+}
+
+func (r *Repo[T, P]) Load(ctx context.Context, id int64) (*Record[T, P], error) {
+	r.store.mu.Lock()
+	v, err := r.store.conn.Do(ctx, "HGETALL", r.Key(id))
+	r.store.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	values, err := v.BytesMap()
+	if err != nil {
+		return nil, err
+	}
+	ver, ok := values[VersionField]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	rec := &Record[T, P]{id: id}
+	if rec.version, err = strconv.ParseInt(string(ver), 10, 64); err != nil {
+		return nil, err
+	}
+	var buf []byte
+	if r.schema.Mode == ModeBlob {
+		if buf = values[BlobField]; len(buf) == 0 {
+			return nil, fmt.Errorf("%w: %s has no %s", ErrCorrupt, r.Key(id), BlobField)
+		}
+	} else if buf, err = r.schema.build(values); err != nil {
+		return nil, err
+	}
+	// 数据可能被其他程序写坏 校验之后才能用生成的方法读取
+	if err = flatbuffers.VerifyRoot(buf, P(new(T))); err != nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrCorrupt, r.Key(id), err.Error())
+	}
+	rec.Set(buf)
+	// 按buffer重新编码 缺少的字段按默认值记录 不会被当作变化
+	rec.saved = r.fields(rec)
+	return rec, nil
+}
+
+// fields 实体在redis中应有的字段值
+func (r *Repo[T, P]) fields(rec *Record[T, P]) map[string][]byte {
+	if r.schema.Mode == ModeBlob {
+		return map[string][]byte{BlobField: append([]byte{}, rec.buf...)}
+	}
+	t := rec.table.Table()
+	return r.schema.encode(&t)
+}
+
+// Dirty Save 会写入的字段
+func (r *Repo[T, P]) Dirty(rec *Record[T, P]) []string {
+	var ret []string
+	for name, v := range r.fields(rec) {
+		if old, ok := rec.saved[name]; !ok || !bytes.Equal(old, v) {
+			ret = append(ret, name)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Save 只写入变化的字段 没有变化时不访问redis
+func (r *Repo[T, P]) Save(ctx context.Context, rec *Record[T, P]) error {
+	current := r.fields(rec)
+	dirty := r.Dirty(rec)
+	if len(dirty) == 0 && rec.version > 0 {
+		return nil
+	}
+	args := make([]any, 0, 4+2*len(dirty))
+	args = append(args, "HSET", r.Key(rec.id), VersionField, rec.version+1)
+	for _, name := range dirty {
+		args = append(args, name, current[name])
+	}
+	if err := r.store.tx(ctx, r.Key(rec.id), rec.version, args); err != nil {
+		return err
+	}
+	rec.version++
+	for _, name := range dirty {
+		rec.saved[name] = current[name]
+	}
+	return nil
+}
+
+// Delete 版本与加载时一致才删除
+func (r *Repo[T, P]) Delete(ctx context.Context, rec *Record[T, P]) error {
+	if err := r.store.tx(ctx, r.Key(rec.id), rec.version, []any{"DEL", r.Key(rec.id)}); err != nil {
+		return err
+	}
+	rec.version, rec.saved = 0, map[string][]byte{}
+	return nil
+}
+
+// tx key 的版本为 version 时在事务中执行 cmd
+func (s *Store) tx(ctx context.Context, key string, version int64, cmd []any) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err = s.conn.Do(ctx, "WATCH", key); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil && !errors.Is(err, zredis.ErrClosed) {
+			_, _ = s.conn.Do(context.Background(), "UNWATCH")
+		}
+	}()
+	v, err := s.conn.Do(ctx, "HGET", key, VersionField)
+	if err != nil {
+		return err
+	}
+	var cur int64
+	if !v.IsNil() {
+		if cur, err = v.Int64(); err != nil {
+			return err
+		}
+	}
+	if cur != version {
+		return ErrConflict
+	}
+	values, err := s.conn.Pipeline().Add("MULTI").Add(cmd...).Add("EXEC").Exec(ctx)
+	if err != nil {
+		return err
+	}
+	for _, v := range values[:2] {
+		if err = v.Err(); err != nil {
+			return err
+		}
+	}
+	exec := values[2]
+	if exec.IsNil() {
+		return ErrConflict
+	}
+	results, err := exec.Values()
+	if err != nil {
+		return err
+	}
+	for _, v := range results {
+		if err = v.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
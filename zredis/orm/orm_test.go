@@ -0,0 +1,203 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/benchmark/fb/Example"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zredis"
+	"github.com/jiangshuai341/zbus/zredis/redistest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// monsterFields 与 tools/flatbuffers/fbs/example.fbs 中的 lobby.Monster 对应
+var monsterFields = []Field{
+	{Name: "mana", ID: 1, Kind: Int32, Default: "150"},
+	{Name: "hp", ID: 2, Kind: Int32, Default: "100"},
+	{Name: "name", ID: 3, Kind: String},
+	{Name: "inventory", ID: 5, Kind: Bytes},
+	{Name: "color", ID: 6, Kind: Int8, Default: "2"},
+}
+
+func newStore(t *testing.T) (*redistest.Server, *Store) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	r, err := reactor.NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := zredis.Dial(r, s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return s, NewStore(c)
+}
+
+func TestRepo_Hash(t *testing.T) {
+	server, store := newStore(t)
+	schema, err := NewSchema("monster", ModeHash, monsterFields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepo[Example.Monster](store, schema)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err = repo.Load(ctx, 1); err != ErrNotFound {
+		t.Fatalf("want ErrNotFound got %v", err)
+	}
+	rec := repo.New(1, &Example.MonsterT{Name: "orc", Hp: 80, Mana: 150, Inventory: []byte{1, 2}, Color: Example.ColorRed})
+	if err = repo.Save(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"_ver": "1", "mana": "150", "hp": "80", "name": "orc", "inventory": "\x01\x02", "color": "0"}
+	if got := server.HGetAll("monster:1"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v", got)
+	}
+
+	// 加载后所有标量都在buffer中 生成的 Mutate 可以直接使用
+	a, err := repo.Load(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := a.Table(); m.Hp() != 80 || m.Mana() != 150 || string(m.Name()) != "orc" || m.Color() != Example.ColorRed || a.Version() != 1 {
+		t.Fatalf("loaded %+v version:%d", m.UnPack(), a.Version())
+	}
+	if got := repo.Dirty(a); len(got) != 0 {
+		t.Fatalf("fresh record dirty %v", got)
+	}
+	if !a.Table().MutateHp(50) || !a.Table().MutateMana(150) {
+		t.Fatal("mutate failed")
+	}
+	if got := repo.Dirty(a); !reflect.DeepEqual(got, []string{"hp"}) {
+		t.Fatalf("dirty %v", got)
+	}
+
+	// 另一份同版本的副本先保存 a 的保存失败
+	b, err := repo.Load(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Table().MutateMana(10)
+	if err = repo.Save(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Save(ctx, a); err != ErrConflict {
+		t.Fatalf("want ErrConflict got %v", err)
+	}
+	if a, err = repo.Load(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	a.Table().MutateHp(50)
+	if err = repo.Save(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	got := server.HGetAll("monster:1")
+	if got["_ver"] != "3" || got["hp"] != "50" || got["mana"] != "10" {
+		t.Fatalf("got %v", got)
+	}
+
+	// 对象API修改
+	obj := a.Table().UnPack()
+	obj.Name = "orc king"
+	a.SetObject(obj)
+	if got := repo.Dirty(a); !reflect.DeepEqual(got, []string{"name"}) {
+		t.Fatalf("dirty %v", got)
+	}
+	if err = repo.Save(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	// 没有变化时不写入
+	if err = repo.Save(ctx, a); err != nil || server.HGetAll("monster:1")["_ver"] != "4" {
+		t.Fatalf("got %v %v", server.HGetAll("monster:1"), err)
+	}
+
+	// 其他进程写入了新版本 按旧版本删除失败
+	server.HSet("monster:1", "_ver", []byte("9"))
+	if err = repo.Delete(ctx, a); err != ErrConflict {
+		t.Fatalf("want ErrConflict got %v", err)
+	}
+	if a, err = repo.Load(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Delete(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.Load(ctx, 1); err != ErrNotFound {
+		t.Fatalf("want ErrNotFound got %v", err)
+	}
+}
+
+func TestRepo_Blob(t *testing.T) {
+	server, store := newStore(t)
+	schema, err := NewSchema("monster_blob", ModeBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepo[Example.Monster](store, schema)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	obj := &Example.MonsterT{Name: "orc", Hp: 80, Weapons: []*Example.WeaponT{{Name: "axe", Damage: 5}}}
+	rec := repo.New(7, obj)
+	if err = repo.Save(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	// 另一方已经创建 新建的记录不能覆盖
+	if err = repo.Save(ctx, repo.New(7, obj)); err != ErrConflict {
+		t.Fatalf("want ErrConflict got %v", err)
+	}
+	loaded, err := repo.Load(ctx, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w Example.Weapon
+	if m := loaded.Table(); m.WeaponsLength() != 1 || !m.Weapons(&w, 0) || string(w.Name()) != "axe" || m.Hp() != 80 {
+		t.Fatalf("loaded %+v", m.UnPack())
+	}
+	if !loaded.Table().MutateHp(70) {
+		t.Fatal("mutate failed")
+	}
+	if got := repo.Dirty(loaded); !reflect.DeepEqual(got, []string{BlobField}) {
+		t.Fatalf("dirty %v", got)
+	}
+	if err = repo.Save(ctx, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.HGetAll("monster_blob:7"); got["_ver"] != "2" || len(got) != 2 {
+		t.Fatalf("got %v", got)
+	}
+	if loaded, err = repo.Load(ctx, 7); err != nil || loaded.Table().Hp() != 70 {
+		t.Fatalf("got %v", err)
+	}
+
+	// 只有版本号 或blob被写坏时返回 ErrCorrupt 不会在读取时panic
+	for _, blob := range [][]byte{nil, {0xff, 0xff, 0xff, 0x7f, 1, 2}} {
+		server.HSet("monster_blob:8", VersionField, []byte("1"))
+		server.HSet("monster_blob:8", BlobField, blob)
+		if _, err = repo.Load(ctx, 8); !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("blob %v want ErrCorrupt got %v", blob, err)
+		}
+	}
+}
+
+func TestNewSchema_Errors(t *testing.T) {
+	for _, fields := range [][]Field{
+		nil,
+		{{Name: "_ver", ID: 0, Kind: Int32}},
+		{{Name: "a", ID: 0, Kind: Int32}, {Name: "b", ID: 0, Kind: Int32}},
+		{{Name: "a", ID: 0, Kind: Int32}, {Name: "a", ID: 1, Kind: Int32}},
+		{{Name: "a", ID: 0, Kind: Int8, Default: "300"}},
+		{{Name: "a", ID: 0}},
+	} {
+		if _, err := NewSchema("m", ModeHash, fields...); err == nil {
+			t.Fatalf("%+v should be invalid", fields)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package orm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jiangshuai341/zbus/flatbuffers"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type Mode uint8
+
+const (
+	// ModeHash 声明的字段各自存为hash的一个字段 只支持标量、string 和 [ubyte]
+	// 加载后所有标量都写入buffer 生成的 MutateXxx 总能成功
+	ModeHash Mode = iota
+	// ModeBlob 整个buffer存为一个字段 支持任意表 等于默认值的标量不在buffer中 MutateXxx 会失败 需要用对象API重新打包
+	ModeBlob
+)
+
+// 保留的hash字段 声明的字段名不能以 _ 开头
+const (
+	VersionField = "_ver"
+	BlobField    = "_buf"
+)
+
+type Kind uint8
+
+const (
+	Bool Kind = iota + 1
+	Int8
+	Uint8
+	Int16
+	Uint16
+	Int32
+	Uint32
+	Int64
+	Uint64
+	Float32
+	Float64
+	String
+	Bytes // [ubyte]
+)
+
+func (k Kind) scalar() bool {
+	return k >= Bool && k <= Float64
+}
+
+var ErrBadSchema = errors.New("orm: bad schema")
+
+// Field 与fbs中的字段对应 ID 为字段的序号(deprecated 的字段也占序号) Default 为fbs中的默认值 如 "150" "true"
+type Field struct {
+	Name    string
+	ID      int
+	Kind    Kind
+	Default string
+
+	vt     flatbuffers.VOffsetT
+	defInt int64
+	defU   uint64
+	defF   float64
+}
+
+// Schema 一种实体在redis中的存储方式 key 为 Name:entityID
+type Schema struct {
+	Name      string
+	Mode      Mode
+	Fields    []Field
+	numFields int
+}
+
+func NewSchema(name string, mode Mode, fields ...Field) (*Schema, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: empty name", ErrBadSchema)
+	}
+	if mode == ModeHash && len(fields) == 0 {
+		return nil, fmt.Errorf("%w: %s has no fields", ErrBadSchema, name)
+	}
+	s := &Schema{Name: name, Mode: mode, Fields: make([]Field, len(fields))}
+	seen := make(map[string]bool, len(fields))
+	ids := make(map[int]bool, len(fields))
+	for i, f := range fields {
+		if f.Name == "" || strings.HasPrefix(f.Name, "_") || seen[f.Name] {
+			return nil, fmt.Errorf("%w: %s bad field name %q", ErrBadSchema, name, f.Name)
+		}
+		if f.ID < 0 || f.ID > math.MaxUint16/2-2 || ids[f.ID] {
+			return nil, fmt.Errorf("%w: %s.%s bad id %d", ErrBadSchema, name, f.Name, f.ID)
+		}
+		if f.Kind < Bool || f.Kind > Bytes {
+			return nil, fmt.Errorf("%w: %s.%s bad kind %d", ErrBadSchema, name, f.Name, f.Kind)
+		}
+		if err := f.parseDefault(); err != nil {
+			return nil, fmt.Errorf("%w: %s.%s default %q: %s", ErrBadSchema, name, f.Name, f.Default, err.Error())
+		}
+		seen[f.Name], ids[f.ID] = true, true
+		f.vt = flatbuffers.VOffsetT(4 + 2*f.ID)
+		if f.ID+1 > s.numFields {
+			s.numFields = f.ID + 1
+		}
+		s.Fields[i] = f
+	}
+	return s, nil
+}
+
+func (f *Field) parseDefault() (err error) {
+	d := f.Default
+	if d == "" || !f.Kind.scalar() {
+		return nil
+	}
+	switch f.Kind {
+	case Bool:
+		var b bool
+		if b, err = strconv.ParseBool(d); b {
+			f.defInt = 1
+		}
+	case Int8, Int16, Int32, Int64:
+		f.defInt, err = strconv.ParseInt(d, 10, bitSize(f.Kind))
+	case Uint8, Uint16, Uint32, Uint64:
+		f.defU, err = strconv.ParseUint(d, 10, bitSize(f.Kind))
+	case Float32, Float64:
+		f.defF, err = strconv.ParseFloat(d, bitSize(f.Kind))
+	}
+	return err
+}
+
+func bitSize(k Kind) int {
+	switch k {
+	case Int8, Uint8:
+		return 8
+	case Int16, Uint16:
+		return 16
+	case Int32, Uint32, Float32:
+		return 32
+	default:
+		return 64
+	}
+}
+
+// encode 字段在redis中的值 标量为十进制文本 便于 HINCRBY 和人工查看
+func (f *Field) encode(t *flatbuffers.Table) []byte {
+	switch f.Kind {
+	case Bool:
+		if t.GetBoolSlot(f.vt, f.defInt == 1) {
+			return []byte("1")
+		}
+		return []byte("0")
+	case Int8:
+		return strconv.AppendInt(nil, int64(t.GetInt8Slot(f.vt, int8(f.defInt))), 10)
+	case Int16:
+		return strconv.AppendInt(nil, int64(t.GetInt16Slot(f.vt, int16(f.defInt))), 10)
+	case Int32:
+		return strconv.AppendInt(nil, int64(t.GetInt32Slot(f.vt, int32(f.defInt))), 10)
+	case Int64:
+		return strconv.AppendInt(nil, t.GetInt64Slot(f.vt, f.defInt), 10)
+	case Uint8:
+		return strconv.AppendUint(nil, uint64(t.GetUint8Slot(f.vt, uint8(f.defU))), 10)
+	case Uint16:
+		return strconv.AppendUint(nil, uint64(t.GetUint16Slot(f.vt, uint16(f.defU))), 10)
+	case Uint32:
+		return strconv.AppendUint(nil, uint64(t.GetUint32Slot(f.vt, uint32(f.defU))), 10)
+	case Uint64:
+		return strconv.AppendUint(nil, t.GetUint64Slot(f.vt, f.defU), 10)
+	case Float32:
+		return strconv.AppendFloat(nil, float64(t.GetFloat32Slot(f.vt, float32(f.defF))), 'g', -1, 32)
+	case Float64:
+		return strconv.AppendFloat(nil, t.GetFloat64Slot(f.vt, f.defF), 'g', -1, 64)
+	default:
+		if o := flatbuffers.UOffsetT(t.Offset(f.vt)); o != 0 {
+			return append([]byte{}, t.ByteVector(o+t.Pos)...)
+		}
+		return []byte{}
+	}
+}
+
+// prepend 标量总是写入 不按默认值省略
+func (f *Field) prepend(b *flatbuffers.Builder, text []byte, present bool) error {
+	var err error
+	switch f.Kind {
+	case Bool:
+		v := f.defInt == 1
+		if present {
+			v, err = strconv.ParseBool(string(text))
+		}
+		b.PrependBool(v)
+	case Int8, Int16, Int32, Int64:
+		v := f.defInt
+		if present {
+			v, err = strconv.ParseInt(string(text), 10, bitSize(f.Kind))
+		}
+		switch f.Kind {
+		case Int8:
+			b.PrependInt8(int8(v))
+		case Int16:
+			b.PrependInt16(int16(v))
+		case Int32:
+			b.PrependInt32(int32(v))
+		default:
+			b.PrependInt64(v)
+		}
+	case Uint8, Uint16, Uint32, Uint64:
+		v := f.defU
+		if present {
+			v, err = strconv.ParseUint(string(text), 10, bitSize(f.Kind))
+		}
+		switch f.Kind {
+		case Uint8:
+			b.PrependUint8(uint8(v))
+		case Uint16:
+			b.PrependUint16(uint16(v))
+		case Uint32:
+			b.PrependUint32(uint32(v))
+		default:
+			b.PrependUint64(v)
+		}
+	case Float32, Float64:
+		v := f.defF
+		if present {
+			v, err = strconv.ParseFloat(string(text), bitSize(f.Kind))
+		}
+		if f.Kind == Float32 {
+			b.PrependFloat32(float32(v))
+		} else {
+			b.PrependFloat64(v)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("orm: field %s value %q: %w", f.Name, text, err)
+	}
+	b.Slot(f.ID)
+	return nil
+}
+
+// build 由hash字段构造buffer 缺少的字段取默认值
+func (s *Schema) build(values map[string][]byte) ([]byte, error) {
+	b := flatbuffers.NewBuilder(256)
+	offsets := make([]flatbuffers.UOffsetT, len(s.Fields))
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		v, ok := values[f.Name]
+		switch {
+		case !ok || f.Kind.scalar():
+		case f.Kind == String:
+			offsets[i] = b.CreateByteString(v)
+		default:
+			offsets[i] = b.CreateByteVector(v)
+		}
+	}
+	b.StartObject(s.numFields)
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		if !f.Kind.scalar() {
+			if offsets[i] != 0 {
+				b.PrependUOffsetTSlot(f.ID, offsets[i], 0)
+			}
+			continue
+		}
+		v, ok := values[f.Name]
+		if err := f.prepend(b, v, ok); err != nil {
+			return nil, err
+		}
+	}
+	b.Finish(b.EndObject())
+	return b.FinishedBytes(), nil
+}
+
+// encode 所有声明字段在redis中的值
+func (s *Schema) encode(t *flatbuffers.Table) map[string][]byte {
+	ret := make(map[string][]byte, len(s.Fields))
+	for i := range s.Fields {
+		ret[s.Fields[i].Name] = s.Fields[i].encode(t)
+	}
+	return ret
+}
@@ -0,0 +1,152 @@
+package zredis
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"sync"
+)
+
+var ErrPoolClosed = errors.New("zredis: pool closed")
+
+// Pool 到同一地址的一组连接
+// Do 轮流使用 size 条共享连接 多个协程的命令在共享连接上流水线发送 适合无状态的命令
+// Get 取出独占的连接 用于 WATCH/MULTI 阻塞命令等依赖连接状态的场景 用完后 Put 归还
+// 断开的连接在下次使用时重连
+type Pool struct {
+	r         *reactor.Reactor
+	url       string
+	size      int
+	onConnect func(ctx context.Context, c *Conn) error
+
+	mu     sync.Mutex
+	shared []*Conn
+	next   int
+	idle   []*Conn
+	closed bool
+}
+
+// NewPool onConnect 在每条新连接上执行 如 HELLO AUTH SELECT 可以为nil
+func NewPool(r *reactor.Reactor, url string, size int, onConnect func(ctx context.Context, c *Conn) error) *Pool {
+	if size <= 0 {
+		size = 1
+	}
+	return &Pool{r: r, url: url, size: size, onConnect: onConnect, shared: make([]*Conn, size)}
+}
+
+func (p *Pool) Addr() string {
+	return p.url
+}
+
+func (p *Pool) dial(ctx context.Context) (*Conn, error) {
+	c, err := Dial(p.r, p.url)
+	if err != nil {
+		return nil, err
+	}
+	if p.onConnect != nil {
+		if err = p.onConnect(ctx, c); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Shared 轮流返回共享连接 调用方不能关闭 也不能执行依赖连接状态的命令
+func (p *Pool) Shared(ctx context.Context) (*Conn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	i := p.next % p.size
+	p.next++
+	c := p.shared[i]
+	p.mu.Unlock()
+	if c != nil && !c.Closed() {
+		return c, nil
+	}
+
+	// 拨号不持有锁 并发重连时保留先完成的
+	nc, err := p.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		nc.Close()
+		return nil, ErrPoolClosed
+	}
+	if cur := p.shared[i]; cur != c && cur != nil && !cur.Closed() {
+		nc.Close()
+		return cur, nil
+	}
+	p.shared[i] = nc
+	return nc, nil
+}
+
+func (p *Pool) Do(ctx context.Context, args ...any) (Value, error) {
+	c, err := p.Shared(ctx)
+	if err != nil {
+		return Value{}, err
+	}
+	return c.Do(ctx, args...)
+}
+
+// Pipeline 在一条共享连接上发送
+func (p *Pool) Pipeline(ctx context.Context) (*Pipeline, error) {
+	c, err := p.Shared(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.Pipeline(), nil
+}
+
+// Get 独占的连接 优先使用空闲连接
+func (p *Pool) Get(ctx context.Context) (*Conn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	for len(p.idle) > 0 {
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if !c.Closed() {
+			p.mu.Unlock()
+			return c, nil
+		}
+	}
+	p.mu.Unlock()
+	return p.dial(ctx)
+}
+
+// Put 归还 Get 取出的连接 连接上不能留有 WATCH MULTI 订阅等状态 空闲连接超过 size 时关闭
+func (p *Pool) Put(c *Conn) {
+	if c.Closed() {
+		return
+	}
+	p.mu.Lock()
+	if p.closed || len(p.idle) >= p.size {
+		p.mu.Unlock()
+		c.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+	p.mu.Unlock()
+}
+
+// Close 关闭所有共享和空闲的连接 Get 取出的连接归还时关闭
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	conns := append(p.idle, p.shared...)
+	p.idle, p.shared = nil, nil
+	p.mu.Unlock()
+	for _, c := range conns {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
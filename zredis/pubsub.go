@@ -0,0 +1,209 @@
+package zredis
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/zbuffer"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/epoll"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"strings"
+	"sync"
+)
+
+var ErrNoChannel = errors.New("zredis: no channel")
+
+// Message 订阅收到的消息 Pattern 只在模式订阅时有值
+type Message struct {
+	Channel string
+	Pattern string
+	Payload []byte
+}
+
+// PubSub 独占一条连接用于订阅 订阅状态下连接不能执行普通命令 发布用普通连接的 PUBLISH
+// 订阅确认和消息在RESP2中为数组 在RESP3中为push类型 处理相同
+// 连接断开后不会自动重连和重新订阅 调用方根据 Closed 重建
+type PubSub struct {
+	conn    *reactor.Connection
+	close   func()
+	handler func(msg Message)
+	done    chan struct{}
+
+	mu      sync.Mutex
+	waiters map[string][]chan struct{} // "subscribe ch" -> 等待确认
+	closed  bool
+}
+
+// NewPubSub handler 在IO线程中执行 不要阻塞
+func NewPubSub(r *reactor.Reactor, url string, handler func(msg Message)) (*PubSub, error) {
+	conn := reactor.Dial(url)
+	if conn == nil {
+		return nil, ErrDial
+	}
+	p := &PubSub{
+		conn:    conn,
+		handler: handler,
+		done:    make(chan struct{}),
+		waiters: make(map[string][]chan struct{}),
+	}
+	p.close = func() {
+		_ = r.DoTaskInIoThread(func(*epoll.Epoller) {
+			conn.Close()
+		})
+	}
+	conn.INetHandle = p
+	if err := r.AddConn(conn); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *PubSub) Close() {
+	p.close()
+}
+
+func (p *PubSub) Closed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// Subscribe 等待所有频道的订阅确认
+func (p *PubSub) Subscribe(ctx context.Context, channels ...string) error {
+	return p.do(ctx, "subscribe", channels)
+}
+
+// PSubscribe pattern 为glob模式 如 news.*
+func (p *PubSub) PSubscribe(ctx context.Context, patterns ...string) error {
+	return p.do(ctx, "psubscribe", patterns)
+}
+
+func (p *PubSub) Unsubscribe(ctx context.Context, channels ...string) error {
+	return p.do(ctx, "unsubscribe", channels)
+}
+
+func (p *PubSub) PUnsubscribe(ctx context.Context, patterns ...string) error {
+	return p.do(ctx, "punsubscribe", patterns)
+}
+
+// do 每个频道各有一条确认 names 为空时确认数量未知 所以不允许
+func (p *PubSub) do(ctx context.Context, kind string, names []string) error {
+	if len(names) == 0 {
+		return ErrNoChannel
+	}
+	var cancel context.CancelFunc = func() {}
+	if _, ok := ctx.Deadline(); !ok {
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+	}
+	defer cancel()
+
+	args := make([]any, 0, 1+len(names))
+	args = append(args, strings.ToUpper(kind))
+	for _, n := range names {
+		args = append(args, n)
+	}
+	cmd, err := EncodeCommand(args...)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, len(names))
+	chs := make([]chan struct{}, len(names))
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrClosed
+	}
+	for i, n := range names {
+		keys[i], chs[i] = kind+" "+n, make(chan struct{})
+		p.waiters[keys[i]] = append(p.waiters[keys[i]], chs[i])
+	}
+	err = p.conn.SendSafeZeroCopy(cmd)
+	p.mu.Unlock()
+	if err != nil {
+		p.Close()
+		return err
+	}
+	for i, ch := range chs {
+		select {
+		case <-ch:
+		case <-p.done:
+			return ErrClosed
+		case <-ctx.Done():
+			p.mu.Lock()
+			for j := i; j < len(chs); j++ {
+				p.removeWaiter(keys[j], chs[j])
+			}
+			p.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// removeWaiter 调用方持有 p.mu
+func (p *PubSub) removeWaiter(key string, ch chan struct{}) {
+	list := p.waiters[key]
+	for i, c := range list {
+		if c == ch {
+			p.waiters[key] = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(p.waiters[key]) == 0 {
+		delete(p.waiters, key)
+	}
+}
+
+func (p *PubSub) OnTraffic(inboundBuffer *zbuffer.CombinesBuffer) {
+	for {
+		v, err := ReadValue(inboundBuffer)
+		if err == ErrIncomplete {
+			return
+		}
+		if err != nil {
+			log.Errorf("[zredis] bad pubsub reply err:%s", err.Error())
+			p.conn.Close()
+			return
+		}
+		p.dispatch(v)
+	}
+}
+
+func (p *PubSub) dispatch(v Value) {
+	if v.Kind == KindError {
+		log.Errorf("[zredis] pubsub error reply:%s", v.Str)
+		return
+	}
+	if v.Kind != KindArray && v.Kind != KindPush || len(v.Array) < 3 {
+		log.Errorf("[zredis] unexpected pubsub reply kind:%s", v.Kind)
+		return
+	}
+	kind := strings.ToLower(string(v.Array[0].Str))
+	switch kind {
+	case "message":
+		p.handler(Message{Channel: string(v.Array[1].Str), Payload: v.Array[2].Str})
+	case "pmessage":
+		if len(v.Array) == 4 {
+			p.handler(Message{Pattern: string(v.Array[1].Str), Channel: string(v.Array[2].Str), Payload: v.Array[3].Str})
+		}
+	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe":
+		key := kind + " " + string(v.Array[1].Str)
+		p.mu.Lock()
+		if list := p.waiters[key]; len(list) > 0 {
+			close(list[0])
+			p.removeWaiter(key, list[0])
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *PubSub) OnClose() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	p.waiters = map[string][]chan struct{}{}
+	p.mu.Unlock()
+	close(p.done)
+}
@@ -0,0 +1,80 @@
+package zredis_test
+
+import (
+	"context"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zredis"
+	"testing"
+	"time"
+)
+
+func TestPubSub(t *testing.T) {
+	s, c := dial(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, err := reactor.NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan zredis.Message, 16)
+	ps, err := zredis.NewPubSub(r, s.Addr(), func(msg zredis.Message) {
+		messages <- msg
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	if err = ps.Subscribe(ctx, "news", "sport"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ps.PSubscribe(ctx, "chat.[ab]*"); err != nil {
+		t.Fatal(err)
+	}
+
+	publish := func(channel, payload string, want int64) {
+		t.Helper()
+		if v, err := c.Do(ctx, "PUBLISH", channel, payload); err != nil || v.Int != want {
+			t.Fatalf("publish %s got %+v %v", channel, v, err)
+		}
+	}
+	publish("news", "hello", 1)
+	publish("chat.a1", "hi", 1)
+	publish("chat.c1", "ignored", 0)
+	for _, want := range []zredis.Message{
+		{Channel: "news", Payload: []byte("hello")},
+		{Channel: "chat.a1", Pattern: "chat.[ab]*", Payload: []byte("hi")},
+	} {
+		select {
+		case msg := <-messages:
+			if msg.Channel != want.Channel || msg.Pattern != want.Pattern || string(msg.Payload) != string(want.Payload) {
+				t.Fatalf("got %+v want %+v", msg, want)
+			}
+		case <-ctx.Done():
+			t.Fatal("message not received")
+		}
+	}
+
+	if err = ps.Unsubscribe(ctx, "news"); err != nil {
+		t.Fatal(err)
+	}
+	publish("news", "gone", 0)
+	publish("sport", "goal", 1)
+	if msg := <-messages; msg.Channel != "sport" {
+		t.Fatalf("got %+v", msg)
+	}
+	if err = ps.Unsubscribe(ctx); err != zredis.ErrNoChannel {
+		t.Fatalf("want ErrNoChannel got %v", err)
+	}
+
+	s.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ps.Closed() {
+		if time.Now().After(deadline) {
+			t.Fatal("pubsub not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err = ps.Subscribe(ctx, "news"); err != zredis.ErrClosed {
+		t.Fatalf("want ErrClosed got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package redistest
+
+import (
+	"github.com/jiangshuai341/zbus/zredis"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Cluster 多个 Server 组成的集群 槽按节点数平均分配
+// 只按命令的第一个key检查槽 不检查 CROSSSLOT
+// 锁的顺序为 Server.mu 再 Cluster.mu
+type Cluster struct {
+	Servers []*Server
+
+	mu        sync.Mutex
+	owners    [zredis.SlotCount]int
+	migrating map[int]int // 迁移中的槽 -> 目标节点
+}
+
+func NewCluster(n int) (*Cluster, error) {
+	c := &Cluster{migrating: make(map[int]int)}
+	// 先创建的节点已经在监听 节点中读取 Servers 和槽表都持有 c.mu
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for slot := range c.owners {
+		c.owners[slot] = slot * n / zredis.SlotCount
+	}
+	for i := 0; i < n; i++ {
+		s, err := newServer(c, i)
+		if err != nil {
+			for _, s := range c.Servers {
+				s.Close()
+			}
+			return nil, err
+		}
+		c.Servers = append(c.Servers, s)
+	}
+	return c, nil
+}
+
+func (c *Cluster) Addrs() []string {
+	ret := make([]string, len(c.Servers))
+	for i, s := range c.Servers {
+		ret[i] = s.Addr()
+	}
+	return ret
+}
+
+func (c *Cluster) Close() {
+	for _, s := range c.Servers {
+		s.Close()
+	}
+}
+
+// Owner 负责 slot 的节点序号
+func (c *Cluster) Owner(slot int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.owners[slot]
+}
+
+// Script 在所有节点上注册脚本
+func (c *Cluster) Script(src string, fn ScriptFunc) {
+	for _, s := range c.Servers {
+		s.Script(src, fn)
+	}
+}
+
+// Migrating 开始把 slot 迁移到 to 源节点上不存在的key回复 ASK 目标节点只接受 ASKING 之后的命令
+func (c *Cluster) Migrating(slot, to int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.migrating[slot] = to
+}
+
+// MoveSlot 把 slot 的数据和归属移到 to 之后源节点回复 MOVED
+func (c *Cluster) MoveSlot(slot, to int) {
+	from := c.Owner(slot)
+	if from == to {
+		return
+	}
+	src, dst := c.Servers[from], c.Servers[to]
+	first, second := src, dst
+	if from > to {
+		first, second = dst, src
+	}
+	first.mu.Lock()
+	second.mu.Lock()
+	defer first.mu.Unlock()
+	defer second.mu.Unlock()
+	for key, v := range src.data {
+		if zredis.Slot(key) == slot {
+			dst.data[key] = v
+			delete(src.data, key)
+			src.touch(key)
+			dst.touch(key)
+		}
+	}
+	c.mu.Lock()
+	c.owners[slot] = to
+	delete(c.migrating, slot)
+	c.mu.Unlock()
+}
+
+// redirect 调用方持有 s.mu key不由本节点处理时返回 MOVED 或 ASK
+func (s *Server) redirect(key []byte, asking bool) (zredis.Value, bool) {
+	c := s.cluster
+	if c == nil {
+		return zredis.Value{}, false
+	}
+	slot := zredis.Slot(string(key))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	owner := c.owners[slot]
+	target, migrating := c.migrating[slot]
+	switch {
+	case owner == s.index:
+		if _, ok := s.data[string(key)]; !ok && migrating {
+			return errorValue("ASK " + strconv.Itoa(slot) + " " + c.Servers[target].hostPort()), true
+		}
+		return zredis.Value{}, false
+	case migrating && target == s.index && asking:
+		return zredis.Value{}, false
+	default:
+		return errorValue("MOVED " + strconv.Itoa(slot) + " " + c.Servers[owner].hostPort()), true
+	}
+}
+
+// cmdCluster CLUSTER SLOTS/KEYSLOT
+func cmdCluster(s *Server, args [][]byte) zredis.Value {
+	if len(args) < 2 {
+		return errArgs("cluster")
+	}
+	switch strings.ToUpper(string(args[1])) {
+	case "KEYSLOT":
+		if len(args) != 3 {
+			return errArgs("cluster|keyslot")
+		}
+		return integer(int64(zredis.Slot(string(args[2]))))
+	case "SLOTS":
+		c := s.cluster
+		if c == nil {
+			return errorValue("ERR This instance has cluster support disabled")
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		ret := array()
+		for start := 0; start < zredis.SlotCount; {
+			end := start
+			for end+1 < zredis.SlotCount && c.owners[end+1] == c.owners[start] {
+				end++
+			}
+			node := c.Servers[c.owners[start]]
+			host, port, _ := strings.Cut(node.hostPort(), ":")
+			p, _ := strconv.Atoi(port)
+			ret.Array = append(ret.Array, array(
+				integer(int64(start)), integer(int64(end)),
+				array(bulk([]byte(host)), integer(int64(p)), bulk([]byte("node"+strconv.Itoa(node.index)))),
+			))
+			start = end + 1
+		}
+		return ret
+	default:
+		return errorValue("ERR unknown subcommand '" + string(args[1]) + "'")
+	}
+}
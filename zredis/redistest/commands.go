@@ -0,0 +1,573 @@
+package redistest
+
+import (
+	"github.com/jiangshuai341/zbus/zredis"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type command struct {
+	fn  func(s *Server, args [][]byte) zredis.Value
+	key int // 第一个key的位置 0表示没有key
+}
+
+// firstKey EVAL EVALSHA 的key数量为0时没有key
+func (c command) firstKey(args [][]byte) ([]byte, bool) {
+	if c.key == 0 || c.key >= len(args) {
+		return nil, false
+	}
+	if c.key == 3 && string(args[2]) == "0" {
+		return nil, false
+	}
+	return args[c.key], true
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":          {cmdPing, 0},
+		"ECHO":          {cmdEcho, 0},
+		"GET":           {cmdGet, 1},
+		"SET":           {cmdSet, 1},
+		"DEL":           {cmdDel, 1},
+		"EXISTS":        {cmdExists, 1},
+		"INCR":          {cmdIncr, 1},
+		"INCRBY":        {cmdIncr, 1},
+		"HSET":          {cmdHSet, 1},
+		"HGET":          {cmdHGet, 1},
+		"HDEL":          {cmdHDel, 1},
+		"HLEN":          {cmdHLen, 1},
+		"HGETALL":       {cmdHGetAll, 1},
+		"HINCRBY":       {cmdHIncrBy, 1},
+		"ZADD":          {cmdZAdd, 1},
+		"ZINCRBY":       {cmdZIncrBy, 1},
+		"ZSCORE":        {cmdZScore, 1},
+		"ZREM":          {cmdZRem, 1},
+		"ZCARD":         {cmdZCard, 1},
+		"ZRANK":         {cmdZRank, 1},
+		"ZRANGE":        {cmdZRange, 1},
+		"ZREVRANGE":     {cmdZRange, 1},
+		"ZRANGEBYSCORE": {cmdZRangeByScore, 1},
+		"EVAL":          {cmdEval, 3},
+		"EVALSHA":       {cmdEval, 3},
+		"SCRIPT":        {cmdScript, 0},
+		"PUBLISH":       {cmdPublish, 0},
+		"CLUSTER":       {cmdCluster, 0},
+		"FLUSHALL":      {cmdFlushAll, 0},
+	}
+}
+
+func cmdPing(_ *Server, args [][]byte) zredis.Value {
+	if len(args) > 1 {
+		return bulk(args[1])
+	}
+	return simple("PONG")
+}
+
+func cmdEcho(_ *Server, args [][]byte) zredis.Value {
+	if len(args) != 2 {
+		return errArgs("echo")
+	}
+	return bulk(args[1])
+}
+
+func cmdGet(s *Server, args [][]byte) zredis.Value {
+	if len(args) != 2 {
+		return errArgs("get")
+	}
+	v, ok := s.data[string(args[1])]
+	if !ok {
+		return zredis.Value{}
+	}
+	if !v.isString() {
+		return errorValue(wrongType)
+	}
+	return bulk(v.str)
+}
+
+// cmdSet 支持 NX XX 不支持过期
+func cmdSet(s *Server, args [][]byte) zredis.Value {
+	if len(args) < 3 {
+		return errArgs("set")
+	}
+	key := string(args[1])
+	_, exists := s.data[key]
+	for _, opt := range args[3:] {
+		switch strings.ToUpper(string(opt)) {
+		case "NX":
+			if exists {
+				return zredis.Value{}
+			}
+		case "XX":
+			if !exists {
+				return zredis.Value{}
+			}
+		default:
+			return errorValue("ERR syntax error")
+		}
+	}
+	s.data[key] = &value{str: append([]byte{}, args[2]...)}
+	s.touch(key)
+	return okValue
+}
+
+func cmdDel(s *Server, args [][]byte) zredis.Value {
+	if len(args) < 2 {
+		return errArgs("del")
+	}
+	var n int64
+	for _, key := range args[1:] {
+		if _, ok := s.data[string(key)]; ok {
+			delete(s.data, string(key))
+			s.touch(string(key))
+			n++
+		}
+	}
+	return integer(n)
+}
+
+func cmdExists(s *Server, args [][]byte) zredis.Value {
+	if len(args) < 2 {
+		return errArgs("exists")
+	}
+	var n int64
+	for _, key := range args[1:] {
+		if _, ok := s.data[string(key)]; ok {
+			n++
+		}
+	}
+	return integer(n)
+}
+
+func cmdIncr(s *Server, args [][]byte) zredis.Value {
+	by := int64(1)
+	switch {
+	case len(args) == 3:
+		n, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil {
+			return errorValue("ERR value is not an integer or out of range")
+		}
+		by = n
+	case len(args) != 2:
+		return errArgs(string(args[0]))
+	}
+	key := string(args[1])
+	var cur int64
+	if v, ok := s.data[key]; ok {
+		if !v.isString() {
+			return errorValue(wrongType)
+		}
+		n, err := strconv.ParseInt(string(v.str), 10, 64)
+		if err != nil {
+			return errorValue("ERR value is not an integer or out of range")
+		}
+		cur = n
+	}
+	cur += by
+	s.data[key] = &value{str: strconv.AppendInt(nil, cur, 10)}
+	s.touch(key)
+	return integer(cur)
+}
+
+// hash 不存在时返回nil 类型不对时 ok 为false
+func (s *Server) hash(key string, create bool) (map[string][]byte, bool) {
+	v, exists := s.data[key]
+	if !exists {
+		if !create {
+			return nil, true
+		}
+		v = &value{hash: make(map[string][]byte)}
+		s.data[key] = v
+	}
+	if v.hash == nil {
+		return nil, false
+	}
+	return v.hash, true
+}
+
+func cmdHSet(s *Server, args [][]byte) zredis.Value {
+	if len(args) < 4 || len(args)%2 != 0 {
+		return errArgs("hset")
+	}
+	key := string(args[1])
+	h, ok := s.hash(key, true)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	var added int64
+	for i := 2; i < len(args); i += 2 {
+		if _, ok := h[string(args[i])]; !ok {
+			added++
+		}
+		h[string(args[i])] = append([]byte(nil), args[i+1]...)
+	}
+	s.touch(key)
+	return integer(added)
+}
+
+func cmdHGet(s *Server, args [][]byte) zredis.Value {
+	if len(args) != 3 {
+		return errArgs("hget")
+	}
+	h, ok := s.hash(string(args[1]), false)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	v, ok := h[string(args[2])]
+	if !ok {
+		return zredis.Value{}
+	}
+	return bulk(v)
+}
+
+func cmdHDel(s *Server, args [][]byte) zredis.Value {
+	if len(args) < 3 {
+		return errArgs("hdel")
+	}
+	key := string(args[1])
+	h, ok := s.hash(key, false)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	var n int64
+	for _, field := range args[2:] {
+		if _, ok := h[string(field)]; ok {
+			delete(h, string(field))
+			n++
+		}
+	}
+	if n > 0 {
+		if len(h) == 0 {
+			delete(s.data, key)
+		}
+		s.touch(key)
+	}
+	return integer(n)
+}
+
+func cmdHLen(s *Server, args [][]byte) zredis.Value {
+	if len(args) != 2 {
+		return errArgs("hlen")
+	}
+	h, ok := s.hash(string(args[1]), false)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	return integer(int64(len(h)))
+}
+
+// cmdHGetAll 按字段名排序 便于测试比较 RESP3 中返回map
+func cmdHGetAll(s *Server, args [][]byte) zredis.Value {
+	if len(args) != 2 {
+		return errArgs("hgetall")
+	}
+	h, ok := s.hash(string(args[1]), false)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	fields := make([]string, 0, len(h))
+	for k := range h {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	ret := zredis.Value{Kind: zredis.KindMap, Array: make([]zredis.Value, 0, len(fields)*2)}
+	for _, k := range fields {
+		ret.Array = append(ret.Array, bulk([]byte(k)), bulk(h[k]))
+	}
+	return ret
+}
+
+func cmdHIncrBy(s *Server, args [][]byte) zredis.Value {
+	if len(args) != 4 {
+		return errArgs("hincrby")
+	}
+	by, err := strconv.ParseInt(string(args[3]), 10, 64)
+	if err != nil {
+		return errorValue("ERR value is not an integer or out of range")
+	}
+	key := string(args[1])
+	h, ok := s.hash(key, true)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	var cur int64
+	if v, ok := h[string(args[2])]; ok {
+		if cur, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+			return errorValue("ERR hash value is not an integer")
+		}
+	}
+	cur += by
+	h[string(args[2])] = strconv.AppendInt(nil, cur, 10)
+	s.touch(key)
+	return integer(cur)
+}
+
+// zset 与 hash 相同
+func (s *Server) zset(key string, create bool) (map[string]float64, bool) {
+	v, exists := s.data[key]
+	if !exists {
+		if !create {
+			return nil, true
+		}
+		v = &value{zset: make(map[string]float64)}
+		s.data[key] = v
+	}
+	if v.zset == nil {
+		return nil, false
+	}
+	return v.zset, true
+}
+
+type member struct {
+	name  string
+	score float64
+}
+
+// sorted 按分数排序 分数相同时按成员名排序
+func sorted(z map[string]float64) []member {
+	ret := make([]member, 0, len(z))
+	for name, score := range z {
+		ret = append(ret, member{name, score})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].score != ret[j].score {
+			return ret[i].score < ret[j].score
+		}
+		return ret[i].name < ret[j].name
+	})
+	return ret
+}
+
+func parseScore(b []byte) (float64, bool) {
+	f, err := strconv.ParseFloat(string(b), 64)
+	return f, err == nil && !math.IsNaN(f)
+}
+
+// cmdZAdd 不支持 NX XX GT LT CH INCR
+func cmdZAdd(s *Server, args [][]byte) zredis.Value {
+	if len(args) < 4 || len(args)%2 != 0 {
+		return errArgs("zadd")
+	}
+	scores := make([]float64, 0, len(args)/2-1)
+	for i := 2; i < len(args); i += 2 {
+		f, ok := parseScore(args[i])
+		if !ok {
+			return errorValue("ERR value is not a valid float")
+		}
+		scores = append(scores, f)
+	}
+	key := string(args[1])
+	z, ok := s.zset(key, true)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	var added int64
+	for i, f := range scores {
+		name := string(args[3+2*i])
+		if _, ok := z[name]; !ok {
+			added++
+		}
+		z[name] = f
+	}
+	s.touch(key)
+	return integer(added)
+}
+
+func cmdZIncrBy(s *Server, args [][]byte) zredis.Value {
+	if len(args) != 4 {
+		return errArgs("zincrby")
+	}
+	by, ok := parseScore(args[2])
+	if !ok {
+		return errorValue("ERR value is not a valid float")
+	}
+	key := string(args[1])
+	z, ok := s.zset(key, true)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	z[string(args[3])] += by
+	s.touch(key)
+	return double(z[string(args[3])])
+}
+
+func cmdZScore(s *Server, args [][]byte) zredis.Value {
+	if len(args) != 3 {
+		return errArgs("zscore")
+	}
+	z, ok := s.zset(string(args[1]), false)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	f, ok := z[string(args[2])]
+	if !ok {
+		return zredis.Value{}
+	}
+	return double(f)
+}
+
+func cmdZRem(s *Server, args [][]byte) zredis.Value {
+	if len(args) < 3 {
+		return errArgs("zrem")
+	}
+	key := string(args[1])
+	z, ok := s.zset(key, false)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	var n int64
+	for _, name := range args[2:] {
+		if _, ok := z[string(name)]; ok {
+			delete(z, string(name))
+			n++
+		}
+	}
+	if n > 0 {
+		if len(z) == 0 {
+			delete(s.data, key)
+		}
+		s.touch(key)
+	}
+	return integer(n)
+}
+
+func cmdZCard(s *Server, args [][]byte) zredis.Value {
+	if len(args) != 2 {
+		return errArgs("zcard")
+	}
+	z, ok := s.zset(string(args[1]), false)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	return integer(int64(len(z)))
+}
+
+func cmdZRank(s *Server, args [][]byte) zredis.Value {
+	if len(args) != 3 {
+		return errArgs("zrank")
+	}
+	z, ok := s.zset(string(args[1]), false)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	for i, m := range sorted(z) {
+		if m.name == string(args[2]) {
+			return integer(int64(i))
+		}
+	}
+	return zredis.Value{}
+}
+
+// rangeReply WITHSCORES 时成员与分数交替 与RESP2一致
+func rangeReply(members []member, withScores bool) zredis.Value {
+	ret := array()
+	for _, m := range members {
+		ret.Array = append(ret.Array, bulk([]byte(m.name)))
+		if withScores {
+			ret.Array = append(ret.Array, double(m.score))
+		}
+	}
+	return ret
+}
+
+func withScores(args [][]byte) (bool, bool) {
+	switch {
+	case len(args) == 0:
+		return false, true
+	case len(args) == 1 && strings.EqualFold(string(args[0]), "WITHSCORES"):
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// cmdZRange ZRANGE ZREVRANGE key start stop [WITHSCORES] 不支持 BYSCORE BYLEX
+func cmdZRange(s *Server, args [][]byte) zredis.Value {
+	if len(args) < 4 {
+		return errArgs(string(args[0]))
+	}
+	scores, ok := withScores(args[4:])
+	if !ok {
+		return errorValue("ERR syntax error")
+	}
+	start, err1 := strconv.Atoi(string(args[2]))
+	stop, err2 := strconv.Atoi(string(args[3]))
+	if err1 != nil || err2 != nil {
+		return errorValue("ERR value is not an integer or out of range")
+	}
+	z, ok := s.zset(string(args[1]), false)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	members := sorted(z)
+	if strings.EqualFold(string(args[0]), "ZREVRANGE") {
+		for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+			members[i], members[j] = members[j], members[i]
+		}
+	}
+	n := len(members)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return array()
+	}
+	return rangeReply(members[start:stop+1], scores)
+}
+
+// parseBound -inf +inf 和 ( 开头的开区间
+func parseBound(b []byte) (f float64, exclusive bool, ok bool) {
+	if len(b) > 0 && b[0] == '(' {
+		exclusive, b = true, b[1:]
+	}
+	f, ok = parseScore(b)
+	return f, exclusive, ok
+}
+
+// cmdZRangeByScore 不支持 LIMIT
+func cmdZRangeByScore(s *Server, args [][]byte) zredis.Value {
+	if len(args) < 4 {
+		return errArgs("zrangebyscore")
+	}
+	scores, ok := withScores(args[4:])
+	if !ok {
+		return errorValue("ERR syntax error")
+	}
+	min, minEx, ok1 := parseBound(args[2])
+	max, maxEx, ok2 := parseBound(args[3])
+	if !ok1 || !ok2 {
+		return errorValue("ERR min or max is not a float")
+	}
+	z, ok := s.zset(string(args[1]), false)
+	if !ok {
+		return errorValue(wrongType)
+	}
+	var members []member
+	for _, m := range sorted(z) {
+		if m.score < min || (minEx && m.score == min) || m.score > max || (maxEx && m.score == max) {
+			continue
+		}
+		members = append(members, m)
+	}
+	return rangeReply(members, scores)
+}
+
+func cmdFlushAll(s *Server, _ [][]byte) zredis.Value {
+	for key := range s.data {
+		s.touch(key)
+	}
+	s.data = make(map[string]*value)
+	return okValue
+}
@@ -0,0 +1,143 @@
+package redistest
+
+import (
+	"github.com/jiangshuai341/zbus/zredis"
+	"sort"
+	"strings"
+)
+
+// 订阅确认和消息在RESP3中为push类型 RESP2中为数组
+// 所有连接在同一个IO线程中 PUBLISH 直接写入订阅者的连接
+// 集群中 PUBLISH 只发给本节点的订阅者
+
+func push(values ...zredis.Value) zredis.Value {
+	return zredis.Value{Kind: zredis.KindPush, Array: values}
+}
+
+// subscribe 每个频道回复一条确认 没有参数的取消订阅取消全部
+func (c *session) subscribe(dst []byte, name string, names [][]byte) []byte {
+	if len(names) == 0 && (name == "SUBSCRIBE" || name == "PSUBSCRIBE") {
+		return c.reply(dst, errArgs(name))
+	}
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := &c.channels
+	if name[0] == 'P' {
+		set = &c.patterns
+	}
+	if *set == nil {
+		*set = make(map[string]struct{})
+	}
+	if len(names) == 0 {
+		all := make([]string, 0, len(*set))
+		for k := range *set {
+			all = append(all, k)
+		}
+		sort.Strings(all)
+		for _, k := range all {
+			names = append(names, []byte(k))
+		}
+	}
+	kind := []byte(strings.ToLower(name))
+	if len(names) == 0 {
+		return c.reply(dst, push(bulk(kind), zredis.Value{}, integer(0)))
+	}
+	for _, n := range names {
+		if name == "SUBSCRIBE" || name == "PSUBSCRIBE" {
+			(*set)[string(n)] = struct{}{}
+		} else {
+			delete(*set, string(n))
+		}
+		count := int64(len(c.channels) + len(c.patterns))
+		dst = c.reply(dst, push(bulk(kind), bulk(n), integer(count)))
+	}
+	return dst
+}
+
+func cmdPublish(s *Server, args [][]byte) zredis.Value {
+	if len(args) != 3 {
+		return errArgs("publish")
+	}
+	channel, payload := string(args[1]), args[2]
+	var n int64
+	for sess := range s.sessions {
+		if _, ok := sess.channels[channel]; ok {
+			msg := push(bulk([]byte("message")), bulk(args[1]), bulk(payload))
+			sess.conn.SendUnsafeZeroCopy(sess.reply(nil, msg))
+			n++
+		}
+		for pattern := range sess.patterns {
+			if match(pattern, channel) {
+				msg := push(bulk([]byte("pmessage")), bulk([]byte(pattern)), bulk(args[1]), bulk(payload))
+				sess.conn.SendUnsafeZeroCopy(sess.reply(nil, msg))
+				n++
+			}
+		}
+	}
+	return integer(n)
+}
+
+// match redis的glob匹配 支持 * ? [abc] [^a] [a-z] 和 \ 转义
+func match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if match(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				return false
+			}
+			class := pattern[1 : 1+end]
+			pattern = pattern[1+end:]
+			negate := len(class) > 0 && class[0] == '^'
+			if negate {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if i+2 < len(class) && class[i+1] == '-' {
+					if class[i] <= s[0] && s[0] <= class[i+2] {
+						matched = true
+					}
+					i += 2
+				} else if class[i] == s[0] {
+					matched = true
+				}
+			}
+			if matched == negate {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
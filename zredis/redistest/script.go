@@ -0,0 +1,102 @@
+package redistest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/jiangshuai341/zbus/zredis"
+	"strconv"
+	"strings"
+)
+
+// ScriptFunc 代替Lua脚本 call 相当于 redis.call 在服务端的锁内执行
+type ScriptFunc func(call func(args ...any) zredis.Value, keys, argv [][]byte) zredis.Value
+
+// Script 注册脚本 src 与客户端发送的脚本文本一致 EVAL 或 SCRIPT LOAD 后 EVALSHA 才能找到
+func (s *Server) Script(src string, fn ScriptFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[scriptHash(src)] = fn
+}
+
+func scriptHash(src string) string {
+	sum := sha1.Sum([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+// cmdEval EVAL script numkeys key... arg... / EVALSHA sha1 numkeys key... arg...
+func cmdEval(s *Server, args [][]byte) zredis.Value {
+	if len(args) < 3 {
+		return errArgs(string(args[0]))
+	}
+	n, err := strconv.Atoi(string(args[2]))
+	if err != nil || n < 0 {
+		return errorValue("ERR value is not an integer or out of range")
+	}
+	if n > len(args)-3 {
+		return errorValue("ERR Number of keys can't be greater than number of args")
+	}
+	var sha string
+	if strings.EqualFold(string(args[0]), "EVALSHA") {
+		sha = strings.ToLower(string(args[1]))
+		if !s.loaded[sha] {
+			return errorValue("NOSCRIPT No matching script. Please use EVAL.")
+		}
+	} else {
+		sha = scriptHash(string(args[1]))
+	}
+	fn, ok := s.scripts[sha]
+	if !ok {
+		return errorValue("ERR redistest: script not registered")
+	}
+	s.loaded[sha] = true
+	call := func(callArgs ...any) zredis.Value {
+		cmd := make([][]byte, len(callArgs))
+		for i, a := range callArgs {
+			switch v := a.(type) {
+			case []byte:
+				cmd[i] = v
+			case string:
+				cmd[i] = []byte(v)
+			default:
+				cmd[i] = []byte(fmt.Sprint(v))
+			}
+		}
+		return s.run(cmd)
+	}
+	return fn(call, args[3:3+n], args[3+n:])
+}
+
+// cmdScript SCRIPT LOAD/EXISTS/FLUSH
+func cmdScript(s *Server, args [][]byte) zredis.Value {
+	if len(args) < 2 {
+		return errArgs("script")
+	}
+	switch strings.ToUpper(string(args[1])) {
+	case "LOAD":
+		if len(args) != 3 {
+			return errArgs("script|load")
+		}
+		sha := scriptHash(string(args[2]))
+		if _, ok := s.scripts[sha]; !ok {
+			return errorValue("ERR redistest: script not registered")
+		}
+		s.loaded[sha] = true
+		return bulk([]byte(sha))
+	case "EXISTS":
+		ret := array()
+		for _, sha := range args[2:] {
+			var n int64
+			if s.loaded[strings.ToLower(string(sha))] {
+				n = 1
+			}
+			ret.Array = append(ret.Array, integer(n))
+		}
+		return ret
+	case "FLUSH":
+		s.loaded = make(map[string]bool)
+		return okValue
+	default:
+		return errorValue("ERR unknown subcommand '" + string(args[1]) + "'")
+	}
+}
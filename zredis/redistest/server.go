@@ -0,0 +1,413 @@
+package redistest
+
+import (
+	"github.com/jiangshuai341/zbus/toolkit"
+	"github.com/jiangshuai341/zbus/zbuffer"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/epoll"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zredis"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type value struct {
+	str  []byte
+	hash map[string][]byte
+	zset map[string]float64
+}
+
+func (v *value) isString() bool {
+	return v.hash == nil && v.zset == nil
+}
+
+// Server 进程内的RESP服务 只实现测试用到的命令 数据保存在内存中
+// 所有命令在一把锁内执行 WATCH 按key的修改计数判断是否被修改
+// 支持 HELLO 切换到RESP3 Lua脚本用 Script 注册的Go函数代替
+type Server struct {
+	r        *reactor.Reactor
+	accepter *reactor.Accepter
+	addr     string
+
+	// 属于 Cluster 时检查key所在的槽
+	cluster *Cluster
+	index   int
+
+	mu       sync.Mutex
+	data     map[string]*value
+	versions map[string]uint64 // 删除后保留 WATCH 能发现删除后重建
+	sessions map[*session]struct{}
+	scripts  map[string]ScriptFunc // sha1 -> 注册的脚本
+	loaded   map[string]bool       // EVAL 或 SCRIPT LOAD 过的脚本
+}
+
+// NewServer 监听本机的空闲端口
+func NewServer() (*Server, error) {
+	return newServer(nil, 0)
+}
+
+// newServer 集群字段在开始监听前设置
+func newServer(cluster *Cluster, index int) (*Server, error) {
+	r, err := reactor.NewReactor()
+	if err != nil {
+		return nil, err
+	}
+	port, err := toolkit.GetFreePort()
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		r:        r,
+		cluster:  cluster,
+		index:    index,
+		addr:     "tcp://127.0.0.1:" + strconv.Itoa(port),
+		data:     make(map[string]*value),
+		versions: make(map[string]uint64),
+		sessions: make(map[*session]struct{}),
+		scripts:  make(map[string]ScriptFunc),
+		loaded:   make(map[string]bool),
+	}
+	if s.accepter, err = reactor.NewListener(s); err != nil {
+		return nil, err
+	}
+	if err = s.accepter.ListenUrl(s.addr); err != nil {
+		_ = s.accepter.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Addr 传给 zredis.Dial 的地址
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// hostPort MOVED ASK CLUSTER SLOTS 中使用的地址
+func (s *Server) hostPort() string {
+	return strings.TrimPrefix(s.addr, "tcp://")
+}
+
+// Close 停止监听并断开所有连接
+func (s *Server) Close() {
+	_ = s.accepter.Close()
+	s.Disconnect()
+}
+
+// Disconnect 断开所有连接 继续监听 用于测试重连
+func (s *Server) Disconnect() {
+	_ = s.r.DoTaskInIoThread(func(*epoll.Epoller) {
+		s.mu.Lock()
+		sessions := make([]*session, 0, len(s.sessions))
+		for sess := range s.sessions {
+			sessions = append(sessions, sess)
+		}
+		s.mu.Unlock()
+		for _, sess := range sessions {
+			sess.conn.Close()
+		}
+	})
+}
+
+func (s *Server) OnAccept(conn *reactor.Connection) {
+	sess := &session{server: s, conn: conn}
+	conn.INetHandle = sess
+	s.mu.Lock()
+	s.sessions[sess] = struct{}{}
+	s.mu.Unlock()
+	if err := s.r.AddConn(conn); err != nil {
+		s.mu.Lock()
+		delete(s.sessions, sess)
+		s.mu.Unlock()
+	}
+}
+
+// Set 直接写入 用于测试构造数据和模拟并发修改
+func (s *Server) Set(key string, val []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = &value{str: val}
+	s.touch(key)
+}
+
+// HSet 直接写入hash的一个字段
+func (s *Server) HSet(key, field string, val []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	if !ok || v.hash == nil {
+		v = &value{hash: make(map[string][]byte)}
+		s.data[key] = v
+	}
+	v.hash[field] = val
+	s.touch(key)
+}
+
+// HGetAll 直接读取hash
+func (s *Server) HGetAll(key string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	if !ok || v.hash == nil {
+		return nil
+	}
+	ret := make(map[string]string, len(v.hash))
+	for k, b := range v.hash {
+		ret[k] = string(b)
+	}
+	return ret
+}
+
+// Get 直接读取字符串 不存在时返回nil
+func (s *Server) Get(key string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.data[key]; ok && v.isString() {
+		return v.str
+	}
+	return nil
+}
+
+func (s *Server) touch(key string) {
+	s.versions[key]++
+}
+
+type session struct {
+	server  *Server
+	conn    *reactor.Connection
+	resp3   bool
+	asking  bool
+	watched map[string]uint64
+	multi   bool
+	aborted bool
+	queued  [][][]byte
+
+	// 由 server.mu 保护 PUBLISH 时遍历
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+func (c *session) OnTraffic(inboundBuffer *zbuffer.CombinesBuffer) {
+	for {
+		v, err := zredis.ReadValue(inboundBuffer)
+		if err == zredis.ErrIncomplete {
+			return
+		}
+		args, ok := commandArgs(v, err)
+		if !ok {
+			c.conn.SendUnsafeZeroCopy(c.reply(nil, errorValue("ERR Protocol error")))
+			c.conn.Close()
+			return
+		}
+		c.conn.SendUnsafeZeroCopy(c.handle(nil, args))
+	}
+}
+
+func (c *session) OnClose() {
+	c.server.mu.Lock()
+	delete(c.server.sessions, c)
+	c.server.mu.Unlock()
+}
+
+func commandArgs(v zredis.Value, err error) ([][]byte, bool) {
+	if err != nil || v.Kind != zredis.KindArray || len(v.Array) == 0 {
+		return nil, false
+	}
+	args := make([][]byte, len(v.Array))
+	for i, a := range v.Array {
+		if a.Kind != zredis.KindBulk {
+			return nil, false
+		}
+		args[i] = a.Str
+	}
+	return args, true
+}
+
+// reply 按会话的协议版本编码
+func (c *session) reply(dst []byte, v zredis.Value) []byte {
+	return zredis.AppendValue(dst, v, c.resp3)
+}
+
+// subscribed 订阅状态下RESP2连接只能执行订阅相关的命令
+func (c *session) subscribed() bool {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	return len(c.channels)+len(c.patterns) > 0
+}
+
+// handle 连接状态相关的命令在这里处理 其他命令在 MULTI 中排队
+func (c *session) handle(dst []byte, args [][]byte) []byte {
+	s := c.server
+	name := strings.ToUpper(string(args[0]))
+	switch name {
+	case "HELLO":
+		return c.reply(dst, c.hello(args))
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		return c.subscribe(dst, name, args[1:])
+	}
+	if !c.resp3 && name != "PING" && c.subscribed() {
+		return c.reply(dst, errorValue("ERR Can't execute '"+strings.ToLower(name)+
+			"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context"))
+	}
+	switch name {
+	case "ASKING":
+		c.asking = true
+		return c.reply(dst, okValue)
+	case "MULTI":
+		if c.multi {
+			return c.reply(dst, errorValue("ERR MULTI calls can not be nested"))
+		}
+		c.multi, c.aborted, c.queued = true, false, nil
+		return c.reply(dst, okValue)
+	case "DISCARD":
+		if !c.multi {
+			return c.reply(dst, errorValue("ERR DISCARD without MULTI"))
+		}
+		c.multi, c.queued, c.watched = false, nil, nil
+		return c.reply(dst, okValue)
+	case "EXEC":
+		if !c.multi {
+			return c.reply(dst, errorValue("ERR EXEC without MULTI"))
+		}
+		return c.reply(dst, c.exec())
+	case "WATCH":
+		if c.multi {
+			return c.reply(dst, errorValue("ERR WATCH inside MULTI is not allowed"))
+		}
+		if len(args) < 2 {
+			return c.reply(dst, errArgs(name))
+		}
+		s.mu.Lock()
+		if c.watched == nil {
+			c.watched = make(map[string]uint64)
+		}
+		for _, key := range args[1:] {
+			if _, ok := c.watched[string(key)]; !ok {
+				c.watched[string(key)] = s.versions[string(key)]
+			}
+		}
+		s.mu.Unlock()
+		return c.reply(dst, okValue)
+	case "UNWATCH":
+		c.watched = nil
+		return c.reply(dst, okValue)
+	}
+	if c.multi {
+		if _, ok := commands[name]; !ok {
+			c.aborted = true
+			return c.reply(dst, errorValue("ERR unknown command '"+string(args[0])+"'"))
+		}
+		c.queued = append(c.queued, args)
+		return c.reply(dst, simple("QUEUED"))
+	}
+	asking := c.asking
+	c.asking = false
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return c.reply(dst, s.call(args, asking))
+}
+
+// hello HELLO [protover] 不支持 AUTH SETNAME
+func (c *session) hello(args [][]byte) zredis.Value {
+	if len(args) > 2 {
+		return errorValue("ERR syntax error")
+	}
+	if len(args) == 2 {
+		switch string(args[1]) {
+		case "2":
+			c.resp3 = false
+		case "3":
+			c.resp3 = true
+		default:
+			return errorValue("NOPROTO unsupported protocol version")
+		}
+	}
+	proto := int64(2)
+	if c.resp3 {
+		proto = 3
+	}
+	return zredis.Value{Kind: zredis.KindMap, Array: []zredis.Value{
+		bulk([]byte("server")), bulk([]byte("redistest")),
+		bulk([]byte("version")), bulk([]byte("7.0.0")),
+		bulk([]byte("proto")), integer(proto),
+	}}
+}
+
+func (c *session) exec() zredis.Value {
+	s := c.server
+	queued, watched, aborted := c.queued, c.watched, c.aborted
+	c.multi, c.queued, c.watched, c.aborted = false, nil, nil, false
+	if aborted {
+		return errorValue("EXECABORT Transaction discarded because of previous errors.")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, ver := range watched {
+		if s.versions[key] != ver {
+			return zredis.Value{}
+		}
+	}
+	ret := zredis.Value{Kind: zredis.KindArray, Array: make([]zredis.Value, 0, len(queued))}
+	for _, args := range queued {
+		ret.Array = append(ret.Array, s.call(args, false))
+	}
+	return ret
+}
+
+// call 调用方持有 s.mu 集群中key不属于本节点时回复重定向
+func (s *Server) call(args [][]byte, asking bool) zredis.Value {
+	name := strings.ToUpper(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		return errorValue("ERR unknown command '" + string(args[0]) + "'")
+	}
+	if key, ok := cmd.firstKey(args); ok {
+		if v, redirect := s.redirect(key, asking); redirect {
+			return v
+		}
+	}
+	return cmd.fn(s, args)
+}
+
+// run 脚本中执行命令 不检查槽
+func (s *Server) run(args [][]byte) zredis.Value {
+	cmd, ok := commands[strings.ToUpper(string(args[0]))]
+	if !ok {
+		return errorValue("ERR unknown command '" + string(args[0]) + "'")
+	}
+	return cmd.fn(s, args)
+}
+
+var okValue = simple("OK")
+
+func simple(s string) zredis.Value {
+	return zredis.Value{Kind: zredis.KindString, Str: []byte(s)}
+}
+
+func errorValue(s string) zredis.Value {
+	return zredis.Value{Kind: zredis.KindError, Str: []byte(s)}
+}
+
+func integer(n int64) zredis.Value {
+	return zredis.Value{Kind: zredis.KindInt, Int: n}
+}
+
+func double(f float64) zredis.Value {
+	return zredis.Value{Kind: zredis.KindDouble, Float: f}
+}
+
+func bulk(b []byte) zredis.Value {
+	return zredis.Value{Kind: zredis.KindBulk, Str: b}
+}
+
+func array(values ...zredis.Value) zredis.Value {
+	if values == nil {
+		values = []zredis.Value{}
+	}
+	return zredis.Value{Kind: zredis.KindArray, Array: values}
+}
+
+func errArgs(name string) zredis.Value {
+	return errorValue("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+}
+
+const wrongType = "WRONGTYPE Operation against a key holding the wrong kind of value"
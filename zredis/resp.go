@@ -0,0 +1,583 @@
+package zredis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jiangshuai341/zbus/zbuffer"
+	"github.com/jiangshuai341/zbus/zpool/slicepool"
+	"math"
+	"strconv"
+)
+
+// RESP 协议 https://redis.io/docs/reference/protocol-spec/
+//   RESP2 +OK\r\n  -ERR msg\r\n  :1\r\n  $3\r\nfoo\r\n  $-1\r\n  *2\r\n...  *-1\r\n
+//   RESP3 _\r\n  ,1.5\r\n  #t\r\n  (123\r\n  !3\r\nerr\r\n  =7\r\ntxt:foo\r\n  %1\r\nk v  ~2\r\n...  >3\r\n...  |1\r\nk v 后跟回复
+// 连接默认使用RESP2 发送 HELLO 3 后切换到RESP3 两种协议的回复解析为相同的 Value
+
+var (
+	ErrIncomplete = errors.New("zredis: incomplete reply")
+	ErrProtocol   = errors.New("zredis: protocol error")
+	ErrNil        = errors.New("zredis: nil reply")
+	ErrType       = errors.New("zredis: unexpected reply type")
+)
+
+const (
+	maxBulkLen = 512 << 20
+	maxDepth   = 32
+)
+
+// Error redis返回的错误回复
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+type Kind uint8
+
+const (
+	KindNil Kind = iota
+	KindString
+	KindError
+	KindInt
+	KindBulk
+	KindArray
+	KindMap    // Array 中key value交替
+	KindSet    // 元素在 Array 中
+	KindPush   // 服务端主动推送 元素在 Array 中
+	KindDouble // Float
+	KindBool   // Int 为0或1
+	KindBigInt // Str 为十进制文本
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNil:
+		return "nil"
+	case KindString:
+		return "string"
+	case KindError:
+		return "error"
+	case KindInt:
+		return "int"
+	case KindBulk:
+		return "bulk"
+	case KindArray:
+		return "array"
+	case KindMap:
+		return "map"
+	case KindSet:
+		return "set"
+	case KindPush:
+		return "push"
+	case KindDouble:
+		return "double"
+	case KindBool:
+		return "bool"
+	case KindBigInt:
+		return "bigint"
+	default:
+		return "Kind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// Value 一个回复 Str 用于 String/Error/Bulk/BigInt Int 用于 Int/Bool Float 用于 Double
+// Array 用于 Array/Map/Set/Push
+type Value struct {
+	Kind  Kind
+	Str   []byte
+	Int   int64
+	Float float64
+	Array []Value
+}
+
+func (v Value) IsNil() bool {
+	return v.Kind == KindNil
+}
+
+// Err 错误回复转为 Error
+func (v Value) Err() error {
+	if v.Kind == KindError {
+		return Error(v.Str)
+	}
+	return nil
+}
+
+func (v Value) Bytes() ([]byte, error) {
+	switch v.Kind {
+	case KindString, KindBulk, KindBigInt:
+		return v.Str, nil
+	case KindInt, KindBool:
+		return strconv.AppendInt(nil, v.Int, 10), nil
+	case KindDouble:
+		return strconv.AppendFloat(nil, v.Float, 'g', -1, 64), nil
+	case KindNil:
+		return nil, ErrNil
+	case KindError:
+		return nil, Error(v.Str)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrType, v.Kind)
+	}
+}
+
+func (v Value) Text() (string, error) {
+	b, err := v.Bytes()
+	return string(b), err
+}
+
+func (v Value) Int64() (int64, error) {
+	switch v.Kind {
+	case KindInt, KindBool:
+		return v.Int, nil
+	case KindString, KindBulk:
+		n, err := strconv.ParseInt(string(v.Str), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", ErrType, err.Error())
+		}
+		return n, nil
+	case KindNil:
+		return 0, ErrNil
+	case KindError:
+		return 0, Error(v.Str)
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrType, v.Kind)
+	}
+}
+
+// Float64 RESP2 中浮点数以bulk返回 RESP3 中为double
+func (v Value) Float64() (float64, error) {
+	switch v.Kind {
+	case KindDouble:
+		return v.Float, nil
+	case KindInt:
+		return float64(v.Int), nil
+	case KindString, KindBulk:
+		f, err := strconv.ParseFloat(string(v.Str), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", ErrType, err.Error())
+		}
+		return f, nil
+	case KindNil:
+		return 0, ErrNil
+	case KindError:
+		return 0, Error(v.Str)
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrType, v.Kind)
+	}
+}
+
+func (v Value) Values() ([]Value, error) {
+	switch v.Kind {
+	case KindArray, KindMap, KindSet, KindPush:
+		return v.Array, nil
+	case KindNil:
+		return nil, ErrNil
+	case KindError:
+		return nil, Error(v.Str)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrType, v.Kind)
+	}
+}
+
+// Strings 数组中的元素转为字符串 nil元素为空字符串
+func (v Value) Strings() ([]string, error) {
+	values, err := v.Values()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, len(values))
+	for i, e := range values {
+		if e.Kind == KindNil {
+			continue
+		}
+		b, err := e.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = string(b)
+	}
+	return ret, nil
+}
+
+// BytesMap HGETALL 等返回的 key value 交替数组 或RESP3的map
+func (v Value) BytesMap() (map[string][]byte, error) {
+	values, err := v.Values()
+	if err != nil {
+		return nil, err
+	}
+	if len(values)%2 != 0 {
+		return nil, fmt.Errorf("%w: odd map length %d", ErrType, len(values))
+	}
+	ret := make(map[string][]byte, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		k, err := values[i].Bytes()
+		if err != nil {
+			return nil, err
+		}
+		if ret[string(k)], err = values[i+1].Bytes(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// ReadValue 从 buf 中取出一个完整的回复 数据不完整时返回 ErrIncomplete 且不消费数据
+// 其他错误表示连接上的数据已经错乱 应关闭连接
+func ReadValue(buf *zbuffer.CombinesBuffer) (Value, error) {
+	r := reader{segs: *buf.PeekDataAll()}
+	v, err := r.value(0)
+	if err != nil {
+		return Value{}, err
+	}
+	buf.Discard(r.n)
+	return v, nil
+}
+
+// reader 在不连续的数据段上解析 n 为已解析的字节数
+type reader struct {
+	segs [][]byte
+	seg  int
+	off  int
+	n    int
+}
+
+func (r *reader) readByte() (byte, bool) {
+	for r.seg < len(r.segs) {
+		s := r.segs[r.seg]
+		if r.off < len(s) {
+			b := s[r.off]
+			r.off++
+			r.n++
+			return b, true
+		}
+		r.seg, r.off = r.seg+1, 0
+	}
+	return 0, false
+}
+
+// readLine 不包含 \r\n
+func (r *reader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		b, ok := r.readByte()
+		if !ok {
+			return nil, ErrIncomplete
+		}
+		if b == '\n' {
+			if len(line) == 0 || line[len(line)-1] != '\r' {
+				return nil, ErrProtocol
+			}
+			return line[:len(line)-1], nil
+		}
+		line = append(line, b)
+	}
+}
+
+// readBulk n 字节数据和结尾的 \r\n
+func (r *reader) readBulk(n int) ([]byte, error) {
+	ret := make([]byte, n)
+	for got := 0; got < n; {
+		if r.seg >= len(r.segs) {
+			return nil, ErrIncomplete
+		}
+		s := r.segs[r.seg][r.off:]
+		if len(s) == 0 {
+			r.seg, r.off = r.seg+1, 0
+			continue
+		}
+		c := copy(ret[got:], s)
+		got += c
+		r.off += c
+		r.n += c
+	}
+	for _, want := range []byte("\r\n") {
+		b, ok := r.readByte()
+		if !ok {
+			return nil, ErrIncomplete
+		}
+		if b != want {
+			return nil, ErrProtocol
+		}
+	}
+	return ret, nil
+}
+
+func (r *reader) readInt() (int64, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(string(line), 10, 64)
+	if err != nil {
+		return 0, ErrProtocol
+	}
+	return n, nil
+}
+
+func (r *reader) value(depth int) (Value, error) {
+	if depth > maxDepth {
+		return Value{}, ErrProtocol
+	}
+	t, ok := r.readByte()
+	if !ok {
+		return Value{}, ErrIncomplete
+	}
+	switch t {
+	case '+', '-', '(':
+		line, err := r.readLine()
+		if err != nil {
+			return Value{}, err
+		}
+		switch t {
+		case '-':
+			return Value{Kind: KindError, Str: line}, nil
+		case '(':
+			return Value{Kind: KindBigInt, Str: line}, nil
+		}
+		return Value{Kind: KindString, Str: line}, nil
+	case '_':
+		line, err := r.readLine()
+		if err == nil && len(line) != 0 {
+			err = ErrProtocol
+		}
+		return Value{}, err
+	case ',':
+		line, err := r.readLine()
+		if err != nil {
+			return Value{}, err
+		}
+		f, err := parseDouble(line)
+		return Value{Kind: KindDouble, Float: f}, err
+	case '#':
+		line, err := r.readLine()
+		if err != nil {
+			return Value{}, err
+		}
+		if len(line) != 1 || (line[0] != 't' && line[0] != 'f') {
+			return Value{}, ErrProtocol
+		}
+		v := Value{Kind: KindBool}
+		if line[0] == 't' {
+			v.Int = 1
+		}
+		return v, nil
+	case ':':
+		n, err := r.readInt()
+		return Value{Kind: KindInt, Int: n}, err
+	case '$', '!', '=':
+		n, err := r.readInt()
+		if err != nil || (n == -1 && t == '$') {
+			return Value{}, err
+		}
+		if n < 0 || n > maxBulkLen {
+			return Value{}, ErrProtocol
+		}
+		b, err := r.readBulk(int(n))
+		if err != nil {
+			return Value{}, err
+		}
+		switch t {
+		case '!':
+			return Value{Kind: KindError, Str: b}, nil
+		case '=':
+			// 去掉 txt: 等格式前缀
+			if len(b) < 4 || b[3] != ':' {
+				return Value{}, ErrProtocol
+			}
+			b = b[4:]
+		}
+		return Value{Kind: KindBulk, Str: b}, nil
+	case '*', '~', '>', '%', '|':
+		n, err := r.readInt()
+		if err != nil || (n == -1 && t == '*') {
+			return Value{}, err
+		}
+		if n < 0 || n > math.MaxInt32/2 {
+			return Value{}, ErrProtocol
+		}
+		if t == '%' || t == '|' {
+			n *= 2
+		}
+		ret := Value{Kind: aggregateKinds[t], Array: make([]Value, 0, minInt(int(n), 1024))}
+		for i := int64(0); i < n; i++ {
+			v, err := r.value(depth + 1)
+			if err != nil {
+				return Value{}, err
+			}
+			ret.Array = append(ret.Array, v)
+		}
+		if t == '|' {
+			// 属性只是附加信息 丢弃后读取真正的回复
+			return r.value(depth)
+		}
+		return ret, nil
+	default:
+		return Value{}, ErrProtocol
+	}
+}
+
+var aggregateKinds = map[byte]Kind{'*': KindArray, '~': KindSet, '>': KindPush, '%': KindMap, '|': KindMap}
+
+func parseDouble(line []byte) (float64, error) {
+	switch string(line) {
+	case "inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan":
+		return math.NaN(), nil
+	}
+	f, err := strconv.ParseFloat(string(line), 64)
+	if err != nil {
+		return 0, ErrProtocol
+	}
+	return f, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// AppendValue 把 v 编码追加到 dst resp3 为false时RESP3特有的类型降级为RESP2 用于实现服务端
+func AppendValue(dst []byte, v Value, resp3 bool) []byte {
+	switch v.Kind {
+	case KindNil:
+		if resp3 {
+			return append(dst, "_\r\n"...)
+		}
+		return append(dst, "$-1\r\n"...)
+	case KindString:
+		return appendLine(append(dst, '+'), v.Str)
+	case KindError:
+		return appendLine(append(dst, '-'), v.Str)
+	case KindInt:
+		return appendLine(append(dst, ':'), strconv.AppendInt(nil, v.Int, 10))
+	case KindBulk:
+		return appendBulk(dst, v.Str)
+	case KindDouble:
+		f := strconv.AppendFloat(nil, v.Float, 'g', -1, 64)
+		switch {
+		case math.IsInf(v.Float, 1):
+			f = []byte("inf")
+		case math.IsInf(v.Float, -1):
+			f = []byte("-inf")
+		case math.IsNaN(v.Float):
+			f = []byte("nan")
+		}
+		if resp3 {
+			return appendLine(append(dst, ','), f)
+		}
+		return appendBulk(dst, f)
+	case KindBool:
+		if resp3 {
+			if v.Int != 0 {
+				return append(dst, "#t\r\n"...)
+			}
+			return append(dst, "#f\r\n"...)
+		}
+		return appendLine(append(dst, ':'), strconv.AppendInt(nil, v.Int, 10))
+	case KindBigInt:
+		if resp3 {
+			return appendLine(append(dst, '('), v.Str)
+		}
+		return appendBulk(dst, v.Str)
+	default:
+		t, n := byte('*'), len(v.Array)
+		if resp3 {
+			switch v.Kind {
+			case KindMap:
+				t, n = '%', n/2
+			case KindSet:
+				t = '~'
+			case KindPush:
+				t = '>'
+			}
+		}
+		dst = appendLine(append(dst, t), strconv.AppendInt(nil, int64(n), 10))
+		for _, e := range v.Array {
+			dst = AppendValue(dst, e, resp3)
+		}
+		return dst
+	}
+}
+
+func appendLine(dst []byte, b []byte) []byte {
+	dst = append(dst, b...)
+	return append(dst, '\r', '\n')
+}
+
+func appendBulk(dst []byte, b []byte) []byte {
+	dst = append(dst, '$')
+	dst = strconv.AppendInt(dst, int64(len(b)), 10)
+	dst = append(dst, '\r', '\n')
+	return appendLine(dst, b)
+}
+
+// EncodeCommand 以 RESP 数组编码命令 返回的切片来自 slicepool 发送完成后由连接归还
+// 参数支持 string []byte 整数 浮点数 bool
+func EncodeCommand(args ...any) ([]byte, error) {
+	parts := make([][]byte, len(args))
+	size := 1 + intLen(int64(len(args))) + 2
+	for i, arg := range args {
+		b, err := argBytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = b
+		size += 1 + intLen(int64(len(b))) + 2 + len(b) + 2
+	}
+	buf := slicepool.GetBuffer2(size)[:0]
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, b := range parts {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(b)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, b...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf, nil
+}
+
+func argBytes(arg any) ([]byte, error) {
+	switch v := arg.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case int:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case int32:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(nil, v, 10), nil
+	case uint32:
+		return strconv.AppendUint(nil, uint64(v), 10), nil
+	case uint64:
+		return strconv.AppendUint(nil, v, 10), nil
+	case float64:
+		return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+	case bool:
+		if v {
+			return []byte("1"), nil
+		}
+		return []byte("0"), nil
+	default:
+		return nil, fmt.Errorf("zredis: unsupported argument type %T", arg)
+	}
+}
+
+func intLen(n int64) int {
+	l := 1
+	if n < 0 {
+		l, n = 2, -n
+	}
+	for ; n >= 10; n /= 10 {
+		l++
+	}
+	return l
+}
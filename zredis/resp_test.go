@@ -0,0 +1,153 @@
+package zredis
+
+import (
+	"github.com/jiangshuai341/zbus/zbuffer"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestReadValue(t *testing.T) {
+	wire := []byte("+OK\r\n-ERR bad\r\n:-42\r\n$5\r\nhel\r\n\r\n$-1\r\n*3\r\n$1\r\na\r\n*-1\r\n*1\r\n:1\r\n$0\r\n\r\n")
+	want := []Value{
+		{Kind: KindString, Str: []byte("OK")},
+		{Kind: KindError, Str: []byte("ERR bad")},
+		{Kind: KindInt, Int: -42},
+		{Kind: KindBulk, Str: []byte("hel\r\n")},
+		{},
+		{Kind: KindArray, Array: []Value{
+			{Kind: KindBulk, Str: []byte("a")},
+			{},
+			{Kind: KindArray, Array: []Value{{Kind: KindInt, Int: 1}}},
+		}},
+		{Kind: KindBulk, Str: []byte{}},
+	}
+
+	buf := zbuffer.NewCombinesBuffer(16)
+	// 按字节分散推入 行和bulk跨越多个切片
+	var got []Value
+	for i := 0; i < len(wire); i++ {
+		temp := [][]byte{{wire[i]}}
+		buf.PushsNoCopy(&temp)
+		for {
+			v, err := ReadValue(buf)
+			if err == ErrIncomplete {
+				break
+			}
+			if err != nil {
+				t.Fatalf("at %d: %v", i, err)
+			}
+			got = append(got, v)
+		}
+	}
+	if !reflect.DeepEqual(got, want) || buf.LengthData() != 0 {
+		t.Fatalf("got %+v left:%d", got, buf.LengthData())
+	}
+}
+
+func TestReadValue_Errors(t *testing.T) {
+	for _, wire := range []string{"?x\r\n", ":1\n", ":abc\r\n", "$3\r\nabcd\r\n", "$-2\r\n"} {
+		buf := zbuffer.NewCombinesBuffer(16)
+		temp := [][]byte{[]byte(wire)}
+		buf.PushsNoCopy(&temp)
+		if _, err := ReadValue(buf); err != ErrProtocol {
+			t.Fatalf("%q want ErrProtocol got %v", wire, err)
+		}
+	}
+}
+
+func TestEncodeCommand(t *testing.T) {
+	cmd, err := EncodeCommand("HSET", []byte("k"), 12, int64(-3), 1.5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "*6\r\n$4\r\nHSET\r\n$1\r\nk\r\n$2\r\n12\r\n$2\r\n-3\r\n$3\r\n1.5\r\n$1\r\n1\r\n"
+	if string(cmd) != want {
+		t.Fatalf("got %q", cmd)
+	}
+	if _, err = EncodeCommand("GET", struct{}{}); err == nil {
+		t.Fatal("want unsupported argument error")
+	}
+}
+
+func TestValue_Convert(t *testing.T) {
+	v := Value{Kind: KindArray, Array: []Value{
+		{Kind: KindBulk, Str: []byte("a")}, {Kind: KindInt, Int: 1},
+	}}
+	m, err := v.BytesMap()
+	if err != nil || string(m["a"]) != "1" {
+		t.Fatalf("got %v %v", m, err)
+	}
+	if n, err := (Value{Kind: KindBulk, Str: []byte("7")}).Int64(); err != nil || n != 7 {
+		t.Fatalf("got %d %v", n, err)
+	}
+	if _, err = (Value{}).Text(); err != ErrNil {
+		t.Fatalf("want ErrNil got %v", err)
+	}
+	if err = (Value{Kind: KindError, Str: []byte("ERR x")}).Err(); err != Error("ERR x") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestReadValue_RESP3(t *testing.T) {
+	wire := "_\r\n,1.5\r\n,-inf\r\n#t\r\n(12345678901234567890\r\n!5\r\nERR x\r\n=7\r\ntxt:foo\r\n" +
+		"%1\r\n+k\r\n:1\r\n~1\r\n:2\r\n>2\r\n+message\r\n$0\r\n\r\n|1\r\n+ttl\r\n:3\r\n$1\r\nv\r\n"
+	want := []Value{
+		{},
+		{Kind: KindDouble, Float: 1.5},
+		{Kind: KindDouble, Float: math.Inf(-1)},
+		{Kind: KindBool, Int: 1},
+		{Kind: KindBigInt, Str: []byte("12345678901234567890")},
+		{Kind: KindError, Str: []byte("ERR x")},
+		{Kind: KindBulk, Str: []byte("foo")},
+		{Kind: KindMap, Array: []Value{{Kind: KindString, Str: []byte("k")}, {Kind: KindInt, Int: 1}}},
+		{Kind: KindSet, Array: []Value{{Kind: KindInt, Int: 2}}},
+		{Kind: KindPush, Array: []Value{{Kind: KindString, Str: []byte("message")}, {Kind: KindBulk, Str: []byte{}}}},
+		// 属性被丢弃
+		{Kind: KindBulk, Str: []byte("v")},
+	}
+	buf := zbuffer.NewCombinesBuffer(16)
+	temp := [][]byte{[]byte(wire)}
+	buf.PushsNoCopy(&temp)
+	var got []Value
+	for buf.LengthData() > 0 {
+		v, err := ReadValue(buf)
+		if err != nil {
+			t.Fatalf("after %d values: %v", len(got), err)
+		}
+		got = append(got, v)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v", got)
+	}
+	for _, wire := range []string{"_x\r\n", "#x\r\n", ",abc\r\n", "=3\r\nfoo\r\n"} {
+		buf := zbuffer.NewCombinesBuffer(16)
+		temp := [][]byte{[]byte(wire)}
+		buf.PushsNoCopy(&temp)
+		if _, err := ReadValue(buf); err != ErrProtocol {
+			t.Fatalf("%q want ErrProtocol got %v", wire, err)
+		}
+	}
+}
+
+func TestAppendValue(t *testing.T) {
+	v := Value{Kind: KindMap, Array: []Value{
+		{Kind: KindBulk, Str: []byte("score")}, {Kind: KindDouble, Float: 2.5},
+		{Kind: KindBulk, Str: []byte("ok")}, {Kind: KindBool, Int: 1},
+		{Kind: KindBulk, Str: []byte("none")}, {},
+	}}
+	if got := string(AppendValue(nil, v, true)); got != "%3\r\n$5\r\nscore\r\n,2.5\r\n$2\r\nok\r\n#t\r\n$4\r\nnone\r\n_\r\n" {
+		t.Fatalf("resp3 got %q", got)
+	}
+	// RESP2 降级为数组 bulk 整数和 nil bulk
+	if got := string(AppendValue(nil, v, false)); got != "*6\r\n$5\r\nscore\r\n$3\r\n2.5\r\n$2\r\nok\r\n:1\r\n$4\r\nnone\r\n$-1\r\n" {
+		t.Fatalf("resp2 got %q", got)
+	}
+
+	buf := zbuffer.NewCombinesBuffer(16)
+	temp := [][]byte{AppendValue(nil, v, true)}
+	buf.PushsNoCopy(&temp)
+	if got, err := ReadValue(buf); err != nil || !reflect.DeepEqual(got, v) {
+		t.Fatalf("round trip got %+v %v", got, err)
+	}
+}
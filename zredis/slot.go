@@ -0,0 +1,41 @@
+package zredis
+
+import "strings"
+
+// SlotCount redis集群的槽数
+const SlotCount = 16384
+
+// Slot key 所在的槽 key中有非空的 {tag} 时只对第一个tag计算 相同tag的key在同一个槽
+func Slot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % SlotCount)
+}
+
+var crc16Table [256]uint16
+
+func init() {
+	// CRC16-CCITT (XMODEM) 多项式 0x1021
+	for i := range crc16Table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
@@ -0,0 +1,21 @@
+package zredis
+
+import "testing"
+
+func TestSlot(t *testing.T) {
+	if got := crc16("123456789"); got != 0x31c3 {
+		t.Fatalf("crc16 got %#x", got)
+	}
+	for key, want := range map[string]int{
+		"foo":                  12182,
+		"{user1000}.following": Slot("user1000"),
+		"{user1000}.followers": Slot("user1000"),
+		"foo{}{bar}":           int(crc16("foo{}{bar}") % SlotCount), // 第一个tag为空时使用整个key
+		"foo{{bar}}zap":        Slot("{bar"),
+		"foo{bar}{zap}":        Slot("bar"),
+	} {
+		if got := Slot(key); got != want {
+			t.Fatalf("%s got %d want %d", key, got, want)
+		}
+	}
+}
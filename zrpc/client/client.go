@@ -1,27 +1,432 @@
 package client
 
-//message
-/*
-0       2       4       6       8 (BYTE)
-+-------+-------+-------+-------+
-|     pakLen    |       cmd     |
-+---------------+---------------+   8
-|  serviceType  |    funcHash   |
-+---------------+---------------+  16
-|            entityID           |
-+---------------+---------------+  24
-|                               |
-|              DATA             |
-|                               |
-+-------------------------------+
-*/
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/logger"
+	"github.com/jiangshuai341/zbus/zbuffer"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/epoll"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/protocol"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
-func BindDelegate(serviceType int32, funcHash int32, entityID int64, callback func([]byte, error)) {
+// 帧格式见 zrpc/protocol
 
+var log = logger.GetLogger("zrpc")
+
+// DefaultTimeout ctx 没有设置deadline时使用 避免对端不响应时调用永久挂起
+const DefaultTimeout = 5 * time.Second
+
+var (
+	ErrClosed    = errors.New("zrpc client: connection closed")
+	ErrDial      = errors.New("zrpc client: dial failed")
+	ErrConnected = errors.New("zrpc client: connection still open")
+)
+
+// RemoteError 对端返回的错误 帧带 FlagError 时DATA为错误信息
+type RemoteError struct {
+	Msg string
+}
+
+func (e *RemoteError) Error() string {
+	return "zrpc remote error: " + e.Msg
+}
+
+type delegateKey struct {
+	serviceType int32
+	funcHash    int32
+	entityID    int64
+}
+
+// Caller 生成的实体代理通过它发起调用
+// 总线模式为连接gproxy的 *Client 网格模式为直连各服务实例的 cluster.Mesh 应用代码不需要区分
+// arg 的所有权交给 Caller: 以零拷贝方式发送 写入socket后归还 slicepool 调用方之后不能再读写它
+// arg 应由 slicepool.GetBuffer2 分配 转发收到的数据(如handler的arg)前需要先拷贝一份
+type Caller interface {
+	RemoteInvokeSync(ctx context.Context, serviceType int32, funcHash int32, entityID int64, arg []byte) ([]byte, error)
+	RemoteInvokeAsync(ctx context.Context, serviceType int32, funcHash int32, entityID int64, arg []byte, callback func(resp []byte, err error))
+	Notify(serviceType int32, funcHash int32, entityID int64, arg []byte) error
+	BindDelegate(serviceType int32, funcHash int32, entityID int64, callback func(arg []byte, err error)) error
+	UnbindDelegate(serviceType int32, funcHash int32, entityID int64) error
+}
+
+var _ Caller = (*Client)(nil)
+
+// Client 一条连接上的rpc客户端 实现 reactor.INetHandle
+// 回调在完成它的协程中执行(IO线程或超时定时器) 不要阻塞
+// 连接断开后可以 Reconnect 之前订阅的delegate会在新连接上重新订阅
+type Client struct {
+	seq uint64
+
+	mu        sync.Mutex
+	driver    zrpc.NetDriver
+	closeConn func()
+	onClose   func()
+	pending   map[uint64]func(resp []byte, err error)
+	delegates map[delegateKey]func(arg []byte, err error)
+	closed    bool
+}
+
+// NewClient driver 为nil时处于断开状态 需要 Reconnect 或 Attach 后才能调用
+func NewClient(driver zrpc.NetDriver) *Client {
+	c := &Client{
+		driver:    driver,
+		pending:   make(map[uint64]func(resp []byte, err error)),
+		delegates: make(map[delegateKey]func(arg []byte, err error)),
+		closed:    driver == nil,
+	}
+	if driver != nil {
+		c.closeConn = driver.Close
+	}
+	return c
+}
+
+// Dial 连接 url 并加入 r 管理
+func Dial(r *reactor.Reactor, url string) (*Client, error) {
+	conn := reactor.Dial(url)
+	if conn == nil {
+		return nil, ErrDial
+	}
+	c := NewClient(conn)
+	c.closeConn = closeInIoThread(r, conn)
+	conn.INetHandle = c
+	if err := r.AddConn(conn); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func closeInIoThread(r *reactor.Reactor, conn *reactor.Connection) func() {
+	return func() {
+		_ = r.DoTaskInIoThread(func(*epoll.Epoller) {
+			conn.Close()
+		})
+	}
+}
+
+// Reconnect 连接断开后重新连接 url 并重新订阅delegate
+func (c *Client) Reconnect(r *reactor.Reactor, url string) error {
+	if !c.isClosed() {
+		return ErrConnected
+	}
+	conn := reactor.Dial(url)
+	if conn == nil {
+		return ErrDial
+	}
+	conn.INetHandle = c
+	// 先切换连接再加入reactor 保证新连接的 OnClose 不会被当作旧连接的重复通知忽略
+	keys, err := c.attach(conn, closeInIoThread(r, conn))
+	if err != nil {
+		return err
+	}
+	if err = r.AddConn(conn); err != nil {
+		c.OnClose()
+		return err
+	}
+	return c.rebind(keys)
+}
+
+// Attach 连接断开后换用 driver 并重新订阅delegate
+// driver 的数据需要交给本client的 OnTraffic/OnClose
+func (c *Client) Attach(driver zrpc.NetDriver) error {
+	keys, err := c.attach(driver, driver.Close)
+	if err != nil {
+		return err
+	}
+	return c.rebind(keys)
+}
+
+func (c *Client) attach(driver zrpc.NetDriver, closeConn func()) ([]delegateKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		return nil, ErrConnected
+	}
+	c.driver, c.closeConn, c.closed = driver, closeConn, false
+	keys := make([]delegateKey, 0, len(c.delegates))
+	for key := range c.delegates {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (c *Client) rebind(keys []delegateKey) error {
+	for _, key := range keys {
+		if err := c.sendBind(zrpc.BindDelegate, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 关闭当前连接 之后的处理与连接断开相同 可以再 Reconnect
+// Dial/Reconnect 建立的连接可以在任意协程调用 NewClient/Attach 传入的 driver 只能在IO线程中调用
+func (c *Client) Close() {
+	c.mu.Lock()
+	closeConn, closed := c.closeConn, c.closed
+	c.mu.Unlock()
+	if !closed && closeConn != nil {
+		closeConn()
+	}
+}
+
+// SetCloseCallback fn 在连接断开、所有调用和delegate收到 ErrClosed 之后执行 可以在其中安排重连
+func (c *Client) SetCloseCallback(fn func()) {
+	c.mu.Lock()
+	c.onClose = fn
+	c.mu.Unlock()
 }
-func RemoteInvokeSync(serviceType int32, funcHash int32, entityID int64, arg []byte) {
 
+// RemoteInvokeSync 同步调用 ctx 控制超时与取消 arg 的所有权交给连接 见 Caller
+func (c *Client) RemoteInvokeSync(ctx context.Context, serviceType int32, funcHash int32, entityID int64, arg []byte) ([]byte, error) {
+	return c.Invoke(ctx, zrpc.RemoteInvoke, serviceType, funcHash, entityID, arg)
 }
-func RemoteInvokeAsync(serviceType int32, funcHash int32, entityID int64, arg []byte, callback func([]byte, error)) {
 
+// RemoteInvokeAsync 异步调用 callback 恰好执行一次 arg 的所有权交给连接 见 Caller
+// 响应、超时、连接断开 以先发生者为准 ctx 的取消在截止时间才生效 见 InvokeAsync
+func (c *Client) RemoteInvokeAsync(ctx context.Context, serviceType int32, funcHash int32, entityID int64, arg []byte, callback func(resp []byte, err error)) {
+	c.InvokeAsync(ctx, zrpc.RemoteInvoke, serviceType, funcHash, entityID, arg, callback)
+}
+
+// Invoke 以 cmd 发送需要响应的请求 用于 CreateEntity RegistService 等控制命令
+// 在调用方协程中等待 ctx 取消时立即返回
+func (c *Client) Invoke(ctx context.Context, cmd zrpc.Cmd, serviceType int32, funcHash int32, entityID int64, arg []byte) ([]byte, error) {
+	type result struct {
+		resp []byte
+		err  error
+	}
+	ch := make(chan result, 1)
+	seq := c.invokeAsync(ctx, cmd, serviceType, funcHash, entityID, arg, func(resp []byte, err error) {
+		ch <- result{resp, err}
+	})
+	var r result
+	select {
+	case r = <-ch:
+	case <-ctx.Done():
+		c.complete(seq, nil, ctx.Err())
+		r = <-ch
+	}
+	return r.resp, r.err
+}
+
+// InvokeAsync 同 RemoteInvokeAsync 命令由 cmd 指定
+// 超时由定时器触发 不为每个调用启动协程 ctx 在截止时间之前被取消不会提前结束调用
+func (c *Client) InvokeAsync(ctx context.Context, cmd zrpc.Cmd, serviceType int32, funcHash int32, entityID int64, arg []byte, callback func(resp []byte, err error)) {
+	c.invokeAsync(ctx, cmd, serviceType, funcHash, entityID, arg, callback)
+}
+
+// invokeAsync 返回调用的序号 调用已经结束时返回0
+func (c *Client) invokeAsync(ctx context.Context, cmd zrpc.Cmd, serviceType int32, funcHash int32, entityID int64, arg []byte, callback func(resp []byte, err error)) uint64 {
+	if err := ctx.Err(); err != nil {
+		callback(nil, err)
+		return 0
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultTimeout)
+	}
+
+	seq := atomic.AddUint64(&c.seq, 1)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		callback(nil, ErrClosed)
+		return 0
+	}
+	// 定时器回调要拿 c.mu 在登记pending之前触发也只会等到解锁之后
+	timer := time.AfterFunc(time.Until(deadline), func() {
+		err := ctx.Err()
+		if err == nil {
+			err = context.DeadlineExceeded
+		}
+		c.complete(seq, nil, err)
+	})
+	c.pending[seq] = func(resp []byte, err error) {
+		timer.Stop()
+		callback(resp, err)
+	}
+	driver := c.driver
+	c.mu.Unlock()
+
+	h := protocol.Header{
+		Cmd:         cmd,
+		ServiceType: serviceType,
+		FuncHash:    funcHash,
+		EntityID:    entityID,
+		Sequence:    seq,
+	}
+	if err := driver.SendSafeZeroCopy(protocol.EncodeFrame(h, arg)...); err != nil {
+		c.complete(seq, nil, err)
+	}
+	return seq
+}
+
+// Notify 单向调用 不等待响应 arg 的所有权交给连接 见 Caller
+func (c *Client) Notify(serviceType int32, funcHash int32, entityID int64, arg []byte) error {
+	driver := c.conn()
+	if driver == nil {
+		return ErrClosed
+	}
+	h := protocol.Header{
+		Flags:       protocol.FlagOneway,
+		Cmd:         zrpc.RemoteInvoke,
+		ServiceType: serviceType,
+		FuncHash:    funcHash,
+		EntityID:    entityID,
+	}
+	return driver.SendSafeZeroCopy(protocol.EncodeFrame(h, arg)...)
+}
+
+// BindDelegate 订阅实体的delegate 对端执行delegate时调用 callback 同一delegate重复订阅时替换 callback
+// 连接断开时 callback 收到 ErrClosed 但订阅保留 Reconnect 后继续生效
+func (c *Client) BindDelegate(serviceType int32, funcHash int32, entityID int64, callback func(arg []byte, err error)) error {
+	key := delegateKey{serviceType, funcHash, entityID}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.delegates[key] = callback
+	c.mu.Unlock()
+	return c.sendBind(zrpc.BindDelegate, key)
+}
+
+// UnbindDelegate 取消订阅 连接断开时只删除本地记录
+func (c *Client) UnbindDelegate(serviceType int32, funcHash int32, entityID int64) error {
+	key := delegateKey{serviceType, funcHash, entityID}
+	c.mu.Lock()
+	delete(c.delegates, key)
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil
+	}
+	return c.sendBind(zrpc.UnbindDelegate, key)
+}
+
+func (c *Client) sendBind(cmd zrpc.Cmd, key delegateKey) error {
+	driver := c.conn()
+	if driver == nil {
+		return ErrClosed
+	}
+	h := protocol.Header{
+		Flags:       protocol.FlagOneway,
+		Cmd:         cmd,
+		ServiceType: key.serviceType,
+		FuncHash:    key.funcHash,
+		EntityID:    key.entityID,
+	}
+	return driver.SendSafeZeroCopy(protocol.EncodeFrame(h, nil)...)
+}
+
+// conn 连接已断开时返回nil
+func (c *Client) conn() zrpc.NetDriver {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	return c.driver
+}
+
+// Pending 等待响应的调用数
+func (c *Client) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// complete 从pending表中摘除并执行回调 已完成的调用忽略
+func (c *Client) complete(seq uint64, resp []byte, err error) bool {
+	c.mu.Lock()
+	fn, ok := c.pending[seq]
+	delete(c.pending, seq)
+	c.mu.Unlock()
+	if ok {
+		fn(resp, err)
+	}
+	return ok
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// OnTraffic 在IO线程中执行
+func (c *Client) OnTraffic(inboundBuffer *zbuffer.CombinesBuffer) {
+	for {
+		h, body, err := protocol.ReadFrame(inboundBuffer)
+		if err == protocol.ErrIncompleteFrame {
+			return
+		}
+		if err != nil {
+			// 连接上的数据已经错乱 关闭连接 OnClose 中结束所有调用
+			log.Errorf("[zrpc client] bad frame err:%s", err.Error())
+			if driver := c.conn(); driver != nil {
+				driver.Close()
+			}
+			return
+		}
+		c.Dispatch(h, body)
+	}
+}
+
+// Dispatch 处理一个响应或delegate帧 与服务端共用一条连接时(见 server.ProxyConn)由连接的handler调用
+func (c *Client) Dispatch(h protocol.Header, body []byte) {
+	if h.Flags.Has(protocol.FlagResponse) {
+		var err error
+		if h.Flags.Has(protocol.FlagError) {
+			err, body = &RemoteError{Msg: string(body)}, nil
+		}
+		if !c.complete(h.Sequence, body, err) {
+			log.Debugf("[zrpc client] response seq:%d arrived after timeout or cancel", h.Sequence)
+		}
+		return
+	}
+
+	switch h.Cmd {
+	case zrpc.ExecuteDelegate, zrpc.BroadcastDelegate:
+		c.mu.Lock()
+		fn := c.delegates[delegateKey{h.ServiceType, h.FuncHash, h.EntityID}]
+		c.mu.Unlock()
+		if fn != nil {
+			fn(body, nil)
+		}
+	default:
+		log.Warnf("[zrpc client] unexpected cmd:%s seq:%d", h.Cmd, h.Sequence)
+	}
+}
+
+// OnClose 连接断开 所有等待中的调用以 ErrClosed 结束 delegate收到 ErrClosed 但保留订阅
+func (c *Client) OnClose() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	pending := c.pending
+	c.pending = make(map[uint64]func(resp []byte, err error))
+	delegates := make([]func(arg []byte, err error), 0, len(c.delegates))
+	for _, fn := range c.delegates {
+		delegates = append(delegates, fn)
+	}
+	onClose := c.onClose
+	c.mu.Unlock()
+
+	for _, fn := range pending {
+		fn(nil, ErrClosed)
+	}
+	for _, fn := range delegates {
+		fn(nil, ErrClosed)
+	}
+	if onClose != nil {
+		onClose()
+	}
 }
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/zbuffer"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/protocol"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDriver 把发出的请求交给 serve 生成响应 再通过 OnTraffic 送回客户端
+type fakeDriver struct {
+	mu     sync.Mutex
+	client *Client
+	in     *zbuffer.CombinesBuffer
+	serve  func(h protocol.Header, body []byte) [][]byte
+}
+
+func newFakeClient(serve func(h protocol.Header, body []byte) [][]byte) (*Client, *fakeDriver) {
+	d := &fakeDriver{in: zbuffer.NewCombinesBuffer(64), serve: serve}
+	d.client = NewClient(d)
+	return d.client, d
+}
+
+func (d *fakeDriver) SendSafeZeroCopy(data ...[]byte) error {
+	var wire []byte
+	for _, b := range data {
+		wire = append(wire, b...)
+	}
+	h, err := protocol.DecodeHeader(wire)
+	if err != nil {
+		return err
+	}
+	if resp := d.serve(h, wire[protocol.HeaderLen:]); resp != nil {
+		go d.deliver(resp)
+	}
+	return nil
+}
+
+func (d *fakeDriver) deliver(frame [][]byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.in.PushsNoCopy(&frame)
+	d.client.OnTraffic(d.in)
+}
+
+func (d *fakeDriver) Close() {
+	d.client.OnClose()
+}
+
+func echo(h protocol.Header, body []byte) [][]byte {
+	h.Flags = protocol.FlagResponse
+	return protocol.EncodeFrame(h, body)
+}
+
+func TestRemoteInvokeSync(t *testing.T) {
+	c, _ := newFakeClient(echo)
+	resp, err := c.RemoteInvokeSync(context.Background(), 1, 2, 3, []byte("ping"))
+	if err != nil || string(resp) != "ping" {
+		t.Fatalf("got %q %v", resp, err)
+	}
+	if c.Pending() != 0 {
+		t.Fatalf("pending %d", c.Pending())
+	}
+}
+
+func TestRemoteInvoke_RemoteError(t *testing.T) {
+	c, _ := newFakeClient(func(h protocol.Header, body []byte) [][]byte {
+		h.Flags = protocol.FlagResponse | protocol.FlagError
+		return protocol.EncodeFrame(h, []byte("no such func"))
+	})
+	_, err := c.RemoteInvokeSync(context.Background(), 1, 2, 3, nil)
+	var remote *RemoteError
+	if !errors.As(err, &remote) || remote.Msg != "no such func" {
+		t.Fatalf("want RemoteError got %v", err)
+	}
+}
+
+func TestRemoteInvoke_TimeoutAndLateResponse(t *testing.T) {
+	var late [][]byte
+	c, d := newFakeClient(func(h protocol.Header, body []byte) [][]byte {
+		late = echo(h, body)
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.RemoteInvokeSync(ctx, 1, 2, 3, nil); err != context.DeadlineExceeded {
+		t.Fatalf("want DeadlineExceeded got %v", err)
+	}
+	// 超时后到达的响应被丢弃
+	d.deliver(late)
+	if c.Pending() != 0 {
+		t.Fatalf("pending %d", c.Pending())
+	}
+}
+
+func TestRemoteInvoke_ConnectionLost(t *testing.T) {
+	c, d := newFakeClient(func(h protocol.Header, body []byte) [][]byte { return nil })
+	const n = 8
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		c.RemoteInvokeAsync(context.Background(), 1, 2, int64(i), nil, func(resp []byte, err error) {
+			errs <- err
+		})
+	}
+	d.Close()
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != ErrClosed {
+			t.Fatalf("want ErrClosed got %v", err)
+		}
+	}
+	if _, err := c.RemoteInvokeSync(context.Background(), 1, 2, 3, nil); err != ErrClosed {
+		t.Fatalf("invoke after close want ErrClosed got %v", err)
+	}
+}
+
+func TestOnTraffic_BadFrameClosesConnection(t *testing.T) {
+	c, d := newFakeClient(func(h protocol.Header, body []byte) [][]byte { return nil })
+	done := make(chan error, 1)
+	c.RemoteInvokeAsync(context.Background(), 1, 2, 3, nil, func(resp []byte, err error) { done <- err })
+	garbage := make([]byte, protocol.HeaderLen)
+	garbage[4] = 99
+	d.deliver([][]byte{garbage})
+	if err := <-done; err != ErrClosed {
+		t.Fatalf("want ErrClosed got %v", err)
+	}
+}
+
+func BenchmarkRemoteInvokeSync(b *testing.B) {
+	c, _ := newFakeClient(echo)
+	arg := make([]byte, 128)
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.RemoteInvokeSync(ctx, 1, 2, 3, arg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReconnect_Rebind(t *testing.T) {
+	var binds []protocol.Header
+	record := func(h protocol.Header, body []byte) [][]byte {
+		if h.Cmd == zrpc.BindDelegate {
+			binds = append(binds, h)
+		}
+		return nil
+	}
+	c, d := newFakeClient(record)
+	var got []string
+	if err := c.BindDelegate(1, 2, 3, func(arg []byte, err error) {
+		if err != nil {
+			got = append(got, err.Error())
+			return
+		}
+		got = append(got, string(arg))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Attach(d); err != ErrConnected {
+		t.Fatalf("attach on open connection want ErrConnected got %v", err)
+	}
+
+	d.Close()
+	d2 := &fakeDriver{in: zbuffer.NewCombinesBuffer(64), serve: record, client: c}
+	if err := c.Attach(d2); err != nil {
+		t.Fatal(err)
+	}
+	if len(binds) != 2 || binds[1].ServiceType != 1 || binds[1].FuncHash != 2 || binds[1].EntityID != 3 {
+		t.Fatalf("delegate not re-bound after reconnect: %+v", binds)
+	}
+	d2.deliver(protocol.EncodeFrame(protocol.Header{Cmd: zrpc.ExecuteDelegate, Flags: protocol.FlagOneway, ServiceType: 1, FuncHash: 2, EntityID: 3}, []byte("event")))
+	if len(got) != 2 || got[0] != ErrClosed.Error() || got[1] != "event" {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestRemoteInvokeAsync_NoGoroutinePerCall(t *testing.T) {
+	c, _ := newFakeClient(func(h protocol.Header, body []byte) [][]byte { return nil })
+	before := runtime.NumGoroutine()
+	const n = 1000
+	errs := make(chan error, n)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	for i := 0; i < n; i++ {
+		c.RemoteInvokeAsync(ctx, 1, 2, int64(i), nil, func(resp []byte, err error) { errs <- err })
+	}
+	if g := runtime.NumGoroutine(); g > before+n/10 {
+		t.Fatalf("goroutines %d -> %d", before, g)
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != context.DeadlineExceeded {
+			t.Fatalf("want DeadlineExceeded got %v", err)
+		}
+	}
+	if c.Pending() != 0 {
+		t.Fatalf("pending %d", c.Pending())
+	}
+}
+
+func TestRemoteInvokeSync_Cancel(t *testing.T) {
+	c, _ := newFakeClient(func(h protocol.Header, body []byte) [][]byte { return nil })
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := c.RemoteInvokeSync(ctx, 1, 2, 3, nil); err != context.Canceled {
+		t.Fatalf("want Canceled got %v", err)
+	}
+	if time.Since(start) > DefaultTimeout/2 {
+		t.Fatal("cancel did not end the call")
+	}
+	if c.Pending() != 0 {
+		t.Fatalf("pending %d", c.Pending())
+	}
+}
@@ -0,0 +1,185 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jiangshuai341/zbus/discovery"
+	"github.com/jiangshuai341/zbus/logger"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/client"
+	"github.com/jiangshuai341/zbus/zrpc/server"
+	"strings"
+	"sync"
+)
+
+// 集群接入 Bus/Mesh 两种模式由配置切换 生成的实体代理和应用代码不变
+//   bus  所有调用经gproxy转发 服务进程连接gproxy并注册为无状态服务
+//   mesh 调用方按服务发现直连服务实例 服务进程监听端口并把提供的服务写入注册信息
+
+var log = logger.GetLogger("zrpc")
+
+const (
+	ModeBus  = "bus"
+	ModeMesh = "mesh"
+)
+
+// TagServices 网格模式下实例提供的rpc服务名 逗号分隔 没有该tag的实例不参与网格路由
+const TagServices = "zrpc.services"
+
+// ProxyServiceName gproxy在服务发现中的服务名
+const ProxyServiceName = "gproxy"
+
+const defaultPoolSize = 2
+
+var (
+	ErrNoProxy    = errors.New("zrpc cluster: no serving gproxy found")
+	ErrNoInstance = errors.New("zrpc cluster: no instance serves the service")
+)
+
+type Config struct {
+	Mode     string `json:"Mode"`     // bus / mesh 默认bus
+	Proxy    string `json:"Proxy"`    // 总线模式的gproxy地址 为空时从服务发现中选择
+	Listen   string `json:"Listen"`   // 网格模式服务进程的监听地址 如 tcp://0.0.0.0:9000
+	Report   string `json:"Report"`   // 网格模式注册到服务发现的地址 为空时使用Listen
+	PoolSize int    `json:"PoolSize"` // 网格模式到每个实例的连接数 默认2
+}
+
+func (c *Config) Validate() error {
+	switch c.Mode {
+	case "", ModeBus, ModeMesh:
+	default:
+		return fmt.Errorf("invalid Mode %q", c.Mode)
+	}
+	if c.PoolSize < 0 {
+		return fmt.Errorf("invalid PoolSize %d", c.PoolSize)
+	}
+	return nil
+}
+
+func (c *Config) mode() string {
+	if c.Mode == "" {
+		return ModeBus
+	}
+	return c.Mode
+}
+
+func (c *Config) poolSize() int {
+	if c.PoolSize == 0 {
+		return defaultPoolSize
+	}
+	return c.PoolSize
+}
+
+// Client 调用方 传给生成的 NewXxxEntityProxy
+type Client interface {
+	client.Caller
+	Close()
+}
+
+// Dial 按模式创建调用方 总线模式连接gproxy 网格模式按 disc 直连各服务实例
+func Dial(cfg *Config, r *reactor.Reactor, disc discovery.Discovery) (Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.mode() == ModeMesh {
+		return NewMesh(r, disc, cfg.poolSize()), nil
+	}
+	url, err := proxyUrl(cfg, disc)
+	if err != nil {
+		return nil, err
+	}
+	return newPeer(r, url, nil), nil
+}
+
+func proxyUrl(cfg *Config, disc discovery.Discovery) (string, error) {
+	if cfg.Proxy != "" {
+		return cfg.Proxy, nil
+	}
+	for _, v := range disc.ListServing(ProxyServiceName) {
+		if len(v.Urls) > 0 {
+			return v.Urls[0], nil
+		}
+	}
+	return "", ErrNoProxy
+}
+
+// Service 服务进程在集群中的接入点 实现 server.Broadcaster 传给生成的 NewXxxEntity
+type Service struct {
+	broadcaster server.Broadcaster
+	close       func()
+	closeOnce   sync.Once
+}
+
+var _ server.Broadcaster = (*Service)(nil)
+
+// Serve 让 s 处理集群中对 services 的调用
+// 总线模式连接gproxy 并把 services 注册为无状态服务
+// 网格模式在 cfg.Listen 监听 并以 instance 注册到 registry 实例的地址和 TagServices 由Serve填写
+func Serve(ctx context.Context, cfg *Config, r *reactor.Reactor, s *server.Server, registry discovery.Backend, instance discovery.ServiceInstance, services []string) (*Service, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.mode() == ModeMesh {
+		return serveMesh(ctx, cfg, r, s, registry, instance, services)
+	}
+
+	url, err := proxyUrl(cfg, registry)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.ConnectProxy(r, url)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range services {
+		if err = p.RegistService(ctx, zrpc.ServiceType(name)); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+	return &Service{broadcaster: p, close: p.Close}, nil
+}
+
+func serveMesh(ctx context.Context, cfg *Config, r *reactor.Reactor, s *server.Server, registry discovery.Backend, instance discovery.ServiceInstance, services []string) (*Service, error) {
+	a, err := s.Listen(r, cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	report := cfg.Report
+	if report == "" {
+		report = cfg.Listen
+	}
+	instance.Urls = []string{report}
+	instance.Protocol = "zrpc"
+	tags := make(map[string]string, len(instance.Tags)+1)
+	for k, v := range instance.Tags {
+		tags[k] = v
+	}
+	tags[TagServices] = strings.Join(services, ",")
+	instance.Tags = tags
+	if err = registry.Register(ctx, instance); err != nil {
+		a.Close()
+		return nil, err
+	}
+	return &Service{
+		broadcaster: s,
+		close: func() {
+			if err := registry.Deregister(context.Background()); err != nil {
+				log.Warnf("[zrpc cluster] deregister %s failed err:%s", instance.Key(), err.Error())
+			}
+			a.Close()
+		},
+	}, nil
+}
+
+// Broadcast 总线模式交给gproxy扇出 网格模式由本进程扇出给直连的订阅者
+func (s *Service) Broadcast(serviceType int32, funcHash int32, entityID int64, arg []byte) error {
+	return s.broadcaster.Broadcast(serviceType, funcHash, entityID, arg)
+}
+
+// Close 总线模式断开gproxy 网格模式注销实例并停止监听 已建立的连接由调用方在实例下线后关闭
+func (s *Service) Close() {
+	s.closeOnce.Do(s.close)
+}
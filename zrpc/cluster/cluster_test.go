@@ -0,0 +1,368 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/app/gproxy"
+	"github.com/jiangshuai341/zbus/config"
+	"github.com/jiangshuai341/zbus/discovery"
+	"github.com/jiangshuai341/zbus/toolkit"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/client"
+	"github.com/jiangshuai341/zbus/zrpc/server"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	lobby  = zrpc.ServiceType("Lobby")
+	whoami = zrpc.FuncHash("Lobby", "Whoami")
+	notify = zrpc.FuncHash("Lobby", "Notify")
+	push   = zrpc.FuncHash("Lobby", "Push")
+	onPush = zrpc.FuncHash("Lobby", "OnPush")
+)
+
+type testCluster struct {
+	cfg      *Config
+	memory   *discovery.Memory
+	r        *reactor.Reactor
+	services map[string]*Service
+	notified chan string
+}
+
+func newReactor(t *testing.T) *reactor.Reactor {
+	r, err := reactor.NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// startCluster 两个提供 Lobby 服务的进程 a b 总线模式同时启动gproxy
+func startCluster(t *testing.T, mode string) *testCluster {
+	c := &testCluster{
+		cfg:      &Config{Mode: mode},
+		memory:   discovery.NewMemory(),
+		r:        newReactor(t),
+		services: make(map[string]*Service),
+		notified: make(chan string, 16),
+	}
+	if mode == ModeBus {
+		cfg, err := config.New[gproxy.Config]([]byte(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		registry := c.memory.NewClient()
+		proxy := gproxy.NewServer(cfg, registry)
+		t.Cleanup(func() {
+			proxy.Close()
+			registry.Close()
+		})
+	}
+	for i, name := range []string{"a", "b"} {
+		c.startService(t, name, int32(i+1))
+	}
+	return c
+}
+
+// startService Lobby.Whoami 返回进程名和entityID Lobby.Push 广播 Lobby.OnPush
+func (c *testCluster) startService(t *testing.T, name string, id int32) {
+	var (
+		mu  sync.Mutex
+		svc *Service
+	)
+	s := server.NewServer(nil)
+	_ = s.Register("Lobby", "Whoami", func(entityID int64, arg []byte) ([]byte, error) {
+		return []byte(name + ":" + strconv.FormatInt(entityID, 10)), nil
+	})
+	_ = s.Register("Lobby", "Notify", func(entityID int64, arg []byte) ([]byte, error) {
+		c.notified <- name + ":" + string(arg)
+		return nil, nil
+	})
+	_ = s.Register("Lobby", "Push", func(entityID int64, arg []byte) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return nil, svc.Broadcast(lobby, onPush, entityID, arg)
+	})
+
+	cfg := *c.cfg
+	if cfg.Mode == ModeMesh {
+		port, err := toolkit.GetFreePort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Listen = "tcp://127.0.0.1:" + strconv.Itoa(port)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	registry := c.memory.NewClient()
+	instance := discovery.ServiceInstance{AppName: "zbus", ServiceName: "lobby", ServiceVersion: 1, ServiceID: id}
+	mu.Lock()
+	defer mu.Unlock()
+	var err error
+	if svc, err = Serve(ctx, &cfg, c.r, s, registry, instance, []string{"Lobby"}); err != nil {
+		t.Fatal(err)
+	}
+	c.services[name] = svc
+	t.Cleanup(func() {
+		svc.Close()
+		registry.Close()
+	})
+}
+
+func (c *testCluster) dial(t *testing.T) Client {
+	cli, err := Dial(c.cfg, c.r, c.memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cli.Close)
+	return cli
+}
+
+// eventually 服务发现和注册都是异步的 等待条件成立
+func eventually(t *testing.T, what string, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	for _, mode := range []string{ModeBus, ModeMesh} {
+		t.Run(mode, func(t *testing.T) {
+			testSuite(t, mode)
+		})
+	}
+}
+
+// testSuite 两种模式跑同一组用例 应用代码只依赖 client.Caller 和 server.Broadcaster
+func testSuite(t *testing.T, mode string) {
+	c := startCluster(t, mode)
+	cli := c.dial(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	call := func(entityID int64) (string, error) {
+		resp, err := cli.RemoteInvokeSync(ctx, lobby, whoami, entityID, nil)
+		return string(resp), err
+	}
+	eventually(t, "both instances routable", func() bool {
+		seen := make(map[string]bool)
+		for id := int64(1); id <= 32; id++ {
+			if resp, err := call(id); err == nil {
+				seen[resp[:1]] = true
+			}
+		}
+		return seen["a"] && seen["b"]
+	})
+
+	// 同一entityID总是落在同一实例
+	owner := make(map[int64]string)
+	for id := int64(1); id <= 32; id++ {
+		first, err := call(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, _ := call(id)
+		if first != again || !strings.HasSuffix(first, ":"+strconv.FormatInt(id, 10)) {
+			t.Fatalf("entity %d got %q then %q", id, first, again)
+		}
+		owner[id] = first[:1]
+	}
+
+	// 异步调用
+	done := make(chan string, 1)
+	cli.RemoteInvokeAsync(ctx, lobby, whoami, 7, nil, func(resp []byte, err error) {
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		done <- string(resp)
+	})
+	if got := <-done; got != owner[7]+":7" {
+		t.Fatalf("async got %q", got)
+	}
+
+	// 未注册的方法返回远端错误
+	var remote *client.RemoteError
+	if _, err := cli.RemoteInvokeSync(ctx, lobby, zrpc.FuncHash("Lobby", "Missing"), 7, nil); !errors.As(err, &remote) {
+		t.Fatalf("want RemoteError got %v", err)
+	}
+
+	// 单向调用
+	if err := cli.Notify(lobby, notify, 7, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-c.notified:
+		if got != owner[7]+":hi" {
+			t.Fatalf("notify got %q", got)
+		}
+	case <-ctx.Done():
+		t.Fatal("notify not delivered")
+	}
+
+	// delegate 由实体所在的实例广播
+	pushed := make(chan string, 4)
+	if err := cli.BindDelegate(lobby, onPush, 7, func(arg []byte, err error) {
+		if err == nil {
+			pushed <- string(arg)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "delegate pushed", func() bool {
+		if _, err := cli.RemoteInvokeSync(ctx, lobby, push, 7, []byte("news")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-pushed:
+			return got == "news"
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	})
+
+	// 实例下线后 它的实体迁移到剩余实例 订阅跟随迁移
+	gone := owner[7]
+	c.services[gone].Close()
+	eventually(t, "entities moved off "+gone, func() bool {
+		for id := int64(1); id <= 32; id++ {
+			if resp, err := call(id); err != nil || resp[:1] == gone {
+				return false
+			}
+		}
+		return true
+	})
+	eventually(t, "delegate pushed after failover", func() bool {
+		if _, err := cli.RemoteInvokeSync(ctx, lobby, push, 7, []byte("again")); err != nil {
+			return false
+		}
+		for {
+			select {
+			case got := <-pushed:
+				if got == "again" {
+					return true
+				}
+			case <-time.After(50 * time.Millisecond):
+				return false
+			}
+		}
+	})
+}
+
+func TestConfig_Validate(t *testing.T) {
+	for _, cfg := range []Config{{Mode: "p2p"}, {PoolSize: -1}} {
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("%+v should be invalid", cfg)
+		}
+	}
+	if _, err := Dial(&Config{}, nil, discovery.NewMemory()); err != ErrNoProxy {
+		t.Fatalf("want ErrNoProxy got %v", err)
+	}
+}
+
+// TestMesh_Drain draining的实例不再接收新的调用 已发出的调用正常完成
+func TestMesh_Drain(t *testing.T) {
+	memory := discovery.NewMemory()
+	r := newReactor(t)
+	release := make(chan struct{})
+	executor := server.NewKeyedExecutor(2)
+	t.Cleanup(executor.Close)
+	s := server.NewServer(executor)
+	_ = s.Register("Lobby", "Whoami", func(entityID int64, arg []byte) ([]byte, error) {
+		<-release
+		return []byte("a"), nil
+	})
+	port, err := toolkit.GetFreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{Mode: ModeMesh, Listen: "tcp://127.0.0.1:" + strconv.Itoa(port)}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	registry := memory.NewClient()
+	instance := discovery.ServiceInstance{AppName: "zbus", ServiceName: "lobby", ServiceVersion: 1, ServiceID: 1}
+	svc, err := Serve(ctx, &cfg, r, s, registry, instance, []string{"Lobby"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		svc.Close()
+		registry.Close()
+	})
+
+	m := NewMesh(r, memory, 1)
+	t.Cleanup(m.Close)
+	eventually(t, "instance routable", func() bool {
+		_, err := m.route(lobby, 1)
+		return err == nil
+	})
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.RemoteInvokeSync(ctx, lobby, whoami, 1, nil)
+		done <- err
+	}()
+	p, _ := m.route(lobby, 1)
+	eventually(t, "call in flight", func() bool {
+		return p.Pending() > 0
+	})
+
+	if err = registry.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "drained instance removed", func() bool {
+		_, err := m.route(lobby, 1)
+		return errors.Is(err, ErrNoInstance)
+	})
+	close(release)
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("in-flight call failed after drain: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("in-flight call not finished")
+	}
+}
+
+// TestMesh_WeightRebalance 只修改权重时 订阅跟随实体迁移到新的实例
+func TestMesh_WeightRebalance(t *testing.T) {
+	c := startCluster(t, ModeMesh)
+	m := NewMesh(c.r, c.memory, 1)
+	t.Cleanup(m.Close)
+	eventually(t, "both instances routable", func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.instances) == 2
+	})
+	for id := int64(1); id <= 64; id++ {
+		if err := m.BindDelegate(lobby, onPush, id, func(arg []byte, err error) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, ok := c.memory.Get("lobby", 2)
+	if !ok {
+		t.Fatal("instance b not found")
+	}
+	heavy := *info
+	heavy.Weight = 20
+	m.OnServiceStatusChange(&heavy, discovery.EventPut)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, b := range m.bindings {
+		if target := m.pick(key.serviceType, key.entityID); b.peer != target {
+			t.Fatalf("entity %d binding not moved after weight change", key.entityID)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package cluster
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/jiangshuai341/zbus/discovery"
+	"github.com/jiangshuai341/zbus/hash"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/client"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ringVirtual = 100
+	// retireGrace 实例离开serving后等待已发出的调用完成的最长时间
+	retireGrace = client.DefaultTimeout
+	retirePoll  = 50 * time.Millisecond
+)
+
+// instance 一个服务实例 到它的连接池和它提供的服务
+type instance struct {
+	key      string
+	url      string
+	services []int32
+	weight   int
+	peers    []*peer
+}
+
+// GetID 实现 hash.INode
+func (i *instance) GetID() []byte {
+	return []byte(i.key)
+}
+
+func (i *instance) close() {
+	for _, p := range i.peers {
+		p.Close()
+	}
+}
+
+func (i *instance) pending() int {
+	n := 0
+	for _, p := range i.peers {
+		n += p.Pending()
+	}
+	return n
+}
+
+// retire 已从路由中摘除 连接上的调用全部完成或超过 retireGrace 后关闭 stop 关闭时立即关闭
+func (i *instance) retire(stop <-chan struct{}) {
+	deadline := time.Now().Add(retireGrace)
+	for i.pending() > 0 && time.Now().Before(deadline) {
+		select {
+		case <-stop:
+			i.close()
+			return
+		case <-time.After(retirePoll):
+		}
+	}
+	i.close()
+}
+
+type delegateKey struct {
+	serviceType int32
+	funcHash    int32
+	entityID    int64
+}
+
+// binding 调用方的一个delegate订阅 peer 为当前订阅所在的连接 为nil时等待实例可用后订阅
+type binding struct {
+	callback func(arg []byte, err error)
+	peer     *peer
+}
+
+// Mesh 网格模式的调用方 实现 client.Caller
+// 只把serving状态且带 TagServices 的实例放入哈希环 同一服务的实例按entityID一致性哈希
+// 到每个实例维持 poolSize 条连接 同一实体的调用总是走同一条连接 保证顺序
+// 实例变化后delegate订阅跟随实体迁移到新的实例
+// 实例draining或下线时只从哈希环摘除 已发出的调用在原连接上完成后再关闭连接
+type Mesh struct {
+	r        *reactor.Reactor
+	disc     discovery.Discovery
+	watcher  discovery.Watcher
+	poolSize int
+
+	mu        sync.Mutex
+	instances map[string]*instance
+	rings     map[int32]*hash.HashRing[*instance]
+	bindings  map[delegateKey]*binding
+	closed    bool
+	stop      chan struct{} // Close 时关闭 正在retire的实例立即关闭连接
+}
+
+var _ client.Caller = (*Mesh)(nil)
+
+// NewMesh 服务发现的事件异步到达 刚创建时可能还没有可用的实例
+func NewMesh(r *reactor.Reactor, disc discovery.Discovery, poolSize int) *Mesh {
+	if poolSize <= 0 {
+		poolSize = defaultPoolSize
+	}
+	m := &Mesh{
+		r:         r,
+		disc:      disc,
+		poolSize:  poolSize,
+		instances: make(map[string]*instance),
+		rings:     make(map[int32]*hash.HashRing[*instance]),
+		bindings:  make(map[delegateKey]*binding),
+		stop:      make(chan struct{}),
+	}
+	// 不能用 discovery.ServingOnly 它把draining转换为 EventDelete 无法区分是否还有调用在途
+	m.watcher = m
+	disc.WatchAll(m.watcher)
+	return m
+}
+
+// OnServiceStatusChange 实现 discovery.Watcher
+func (m *Mesh) OnServiceStatusChange(info *discovery.ServiceInstance, eventType discovery.EventType) {
+	key := info.Key()
+	if eventType == discovery.EventDelete || !info.Serving() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if old, ok := m.instances[key]; ok {
+			m.remove(old)
+			m.rebalance()
+			go old.retire(m.stop)
+		}
+		return
+	}
+
+	services := parseServices(info.Tags[TagServices])
+	if len(services) == 0 || len(info.Urls) == 0 {
+		return
+	}
+	m.mu.Lock()
+	old, ok := m.instances[key]
+	if ok && old.url == info.Urls[0] && reflect.DeepEqual(old.services, services) {
+		old.weight = info.GetWeight()
+		m.addToRings(old)
+		// 权重变化会改变实体的归属 订阅需要跟随迁移
+		m.rebalance()
+		m.mu.Unlock()
+		return
+	}
+	m.mu.Unlock()
+
+	// 在锁外建立连接
+	inst := &instance{
+		key:      key,
+		url:      info.Urls[0],
+		services: services,
+		weight:   info.GetWeight(),
+		peers:    make([]*peer, m.poolSize),
+	}
+	for i := range inst.peers {
+		inst.peers[i] = newPeer(m.r, inst.url, m.onPeerUp)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		inst.close()
+		return
+	}
+	if old, ok = m.instances[key]; ok {
+		m.remove(old)
+		go old.retire(m.stop)
+	}
+	m.instances[key] = inst
+	m.addToRings(inst)
+	m.rebalance()
+	log.Infof("[zrpc cluster] mesh add %s %s", key, inst.url)
+}
+
+func parseServices(tag string) []int32 {
+	var ret []int32
+	for _, name := range strings.Split(tag, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ret = append(ret, zrpc.ServiceType(name))
+		}
+	}
+	return ret
+}
+
+func (m *Mesh) addToRings(inst *instance) {
+	for _, serviceType := range inst.services {
+		ring, ok := m.rings[serviceType]
+		if !ok {
+			ring = hash.NewHashRing[*instance]("mesh-"+strconv.Itoa(int(serviceType)), ringVirtual)
+			m.rings[serviceType] = ring
+		}
+		ring.AddWeighted(inst, inst.weight)
+	}
+}
+
+// remove 从路由中摘除 订阅迁移走之后再关闭连接 避免订阅方收到 ErrClosed
+func (m *Mesh) remove(inst *instance) {
+	for _, serviceType := range inst.services {
+		if ring, ok := m.rings[serviceType]; ok {
+			ring.Del(inst)
+			if ring.Len() == 0 {
+				delete(m.rings, serviceType)
+			}
+		}
+	}
+	delete(m.instances, inst.key)
+	log.Infof("[zrpc cluster] mesh remove %s %s", inst.key, inst.url)
+}
+
+// pick 持有 mu 时调用
+func (m *Mesh) pick(serviceType int32, entityID int64) *peer {
+	ring, ok := m.rings[serviceType]
+	if !ok {
+		return nil
+	}
+	var key [8]byte
+	binary.LittleEndian.PutUint64(key[:], uint64(entityID))
+	inst := ring.Get(key[:])
+	if inst == nil {
+		return nil
+	}
+	return inst.peers[uint64(entityID)%uint64(len(inst.peers))]
+}
+
+func (m *Mesh) route(serviceType int32, entityID int64) (*peer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, client.ErrClosed
+	}
+	if p := m.pick(serviceType, entityID); p != nil {
+		return p, nil
+	}
+	return nil, ErrNoInstance
+}
+
+func (m *Mesh) RemoteInvokeSync(ctx context.Context, serviceType int32, funcHash int32, entityID int64, arg []byte) ([]byte, error) {
+	p, err := m.route(serviceType, entityID)
+	if err != nil {
+		return nil, err
+	}
+	return p.RemoteInvokeSync(ctx, serviceType, funcHash, entityID, arg)
+}
+
+func (m *Mesh) RemoteInvokeAsync(ctx context.Context, serviceType int32, funcHash int32, entityID int64, arg []byte, callback func(resp []byte, err error)) {
+	p, err := m.route(serviceType, entityID)
+	if err != nil {
+		callback(nil, err)
+		return
+	}
+	p.RemoteInvokeAsync(ctx, serviceType, funcHash, entityID, arg, callback)
+}
+
+func (m *Mesh) Notify(serviceType int32, funcHash int32, entityID int64, arg []byte) error {
+	p, err := m.route(serviceType, entityID)
+	if err != nil {
+		return err
+	}
+	return p.Notify(serviceType, funcHash, entityID, arg)
+}
+
+// BindDelegate 订阅在实体所在的实例上 实例暂不可用时返回错误 但订阅保留 实例可用后自动订阅
+func (m *Mesh) BindDelegate(serviceType int32, funcHash int32, entityID int64, callback func(arg []byte, err error)) error {
+	key := delegateKey{serviceType, funcHash, entityID}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return client.ErrClosed
+	}
+	b, ok := m.bindings[key]
+	if !ok {
+		b = &binding{}
+		m.bindings[key] = b
+	}
+	b.callback = callback
+	return m.bind(key, b, true)
+}
+
+func (m *Mesh) UnbindDelegate(serviceType int32, funcHash int32, entityID int64) error {
+	key := delegateKey{serviceType, funcHash, entityID}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.bindings[key]
+	delete(m.bindings, key)
+	if !ok || b.peer == nil {
+		return nil
+	}
+	return b.peer.UnbindDelegate(serviceType, funcHash, entityID)
+}
+
+// bind 把订阅放到实体当前所在的连接上 force 为false时已在该连接上则跳过
+func (m *Mesh) bind(key delegateKey, b *binding, force bool) error {
+	target := m.pick(key.serviceType, key.entityID)
+	if target == b.peer && !force {
+		return nil
+	}
+	if b.peer != nil && b.peer != target {
+		_ = b.peer.UnbindDelegate(key.serviceType, key.funcHash, key.entityID)
+		b.peer = nil
+	}
+	if target == nil {
+		return ErrNoInstance
+	}
+	if err := target.BindDelegate(key.serviceType, key.funcHash, key.entityID, b.callback); err != nil {
+		return err
+	}
+	b.peer = target
+	return nil
+}
+
+// rebalance 实例变化或重连后 把订阅迁移到实体当前所在的连接
+func (m *Mesh) rebalance() {
+	for key, b := range m.bindings {
+		if err := m.bind(key, b, false); err != nil {
+			log.Debugf("[zrpc cluster] rebind service:%d func:%d entity:%d failed err:%s", key.serviceType, key.funcHash, key.entityID, err.Error())
+		}
+	}
+}
+
+// onPeerUp 连接恢复 重试之前因连接断开而失败的订阅
+func (m *Mesh) onPeerUp() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.rebalance()
+	}
+}
+
+// Close 停止关注服务发现并关闭所有连接
+func (m *Mesh) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	close(m.stop)
+	instances := m.instances
+	m.instances = make(map[string]*instance)
+	m.rings = make(map[int32]*hash.HashRing[*instance])
+	m.bindings = make(map[delegateKey]*binding)
+	m.mu.Unlock()
+
+	m.disc.Unwatch("", m.watcher)
+	for _, inst := range instances {
+		inst.close()
+	}
+}
@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zrpc/client"
+	"sync"
+	"time"
+)
+
+const (
+	minReconnectBackoff = 100 * time.Millisecond
+	maxReconnectBackoff = 5 * time.Second
+)
+
+// peer 到一个地址的连接 断开后按退避重连直到Close 重连后由 client.Client 重新订阅delegate
+type peer struct {
+	*client.Client
+	r    *reactor.Reactor
+	url  string
+	onUp func() // 重连成功后执行
+
+	kick     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newPeer 先同步连接一次 失败时在后台重连
+func newPeer(r *reactor.Reactor, url string, onUp func()) *peer {
+	p := &peer{
+		Client: client.NewClient(nil),
+		r:      r,
+		url:    url,
+		onUp:   onUp,
+		kick:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+	p.SetCloseCallback(p.reconnectLater)
+	if err := p.Reconnect(r, url); err != nil && err != client.ErrConnected {
+		log.Warnf("[zrpc cluster] connect %s failed, retry later err:%s", url, err.Error())
+		p.reconnectLater()
+	}
+	go p.run()
+	return p
+}
+
+func (p *peer) reconnectLater() {
+	select {
+	case p.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (p *peer) run() {
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-p.kick:
+		}
+		for backoff := minReconnectBackoff; ; backoff = nextBackoff(backoff) {
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(backoff):
+			}
+			err := p.Reconnect(p.r, p.url)
+			if err == nil || err == client.ErrConnected {
+				break
+			}
+			log.Debugf("[zrpc cluster] reconnect %s failed err:%s", p.url, err.Error())
+		}
+		select {
+		case <-p.stop:
+			// 重连过程中被关闭
+			p.Client.Close()
+			return
+		default:
+		}
+		log.Infof("[zrpc cluster] reconnected %s", p.url)
+		if p.onUp != nil {
+			p.onUp()
+		}
+	}
+}
+
+func nextBackoff(cur time.Duration) time.Duration {
+	if cur *= 2; cur > maxReconnectBackoff {
+		return maxReconnectBackoff
+	}
+	return cur
+}
+
+// Close 停止重连并关闭连接 等待中的调用以 client.ErrClosed 结束
+func (p *peer) Close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+	p.Client.Close()
+}
@@ -0,0 +1,13 @@
+package zrpc
+
+import "github.com/jiangshuai341/zbus/hash"
+
+// ServiceType 由服务名计算 生成代码与运行时必须使用同一算法 改动会导致新旧版本无法互通
+func ServiceType(service string) int32 {
+	return int32(hash.FNV1a([]byte(service)))
+}
+
+// FuncHash 由 "服务名.方法名" 计算 同一服务内方法名冲突时注册会失败
+func FuncHash(service string, method string) int32 {
+	return int32(hash.FNV1a([]byte(service + "." + method)))
+}
@@ -0,0 +1,19 @@
+package zrpc
+
+import "github.com/jiangshuai341/zbus/flatbuffers"
+
+// Packer flatc --gen-object-api 生成的 XxxT 实现了该接口
+type Packer interface {
+	Pack(builder *flatbuffers.Builder) flatbuffers.UOffsetT
+}
+
+// Pack 把对象序列化为独立的flatbuffer 供生成代码使用 v 为nil时返回nil
+func Pack(v Packer) []byte {
+	b := flatbuffers.NewBuilder(0)
+	root := v.Pack(b)
+	if root == 0 {
+		return nil
+	}
+	b.Finish(root)
+	return b.FinishedBytes()
+}
@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"errors"
+	"github.com/jiangshuai341/zbus/zbuffer"
+)
+
+var ErrIncompleteFrame = errors.New("zrpc frame: waiting for more data")
+
+// gatherHeader 把分散在多个切片中的header拷贝到连续内存
+func gatherHeader(bufs [][]byte, dst *[HeaderLen]byte) bool {
+	n := 0
+	for _, b := range bufs {
+		n += copy(dst[n:], b)
+		if n == HeaderLen {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeHeaderVec 从分散的切片(如 CombinesBuffer.PeekData 的结果)中解析header
+func DecodeHeaderVec(bufs [][]byte) (Header, error) {
+	var temp [HeaderLen]byte
+	if !gatherHeader(bufs, &temp) {
+		return Header{}, ErrShortHeader
+	}
+	return DecodeHeader(temp[:])
+}
+
+// PeekHeader 只查看不消费 数据不够header长度时返回 ErrShortHeader
+func PeekHeader(buf *zbuffer.CombinesBuffer) (Header, error) {
+	if buf.LengthData() < HeaderLen {
+		return Header{}, ErrShortHeader
+	}
+	return DecodeHeaderVec(*buf.PeekData(HeaderLen))
+}
+
+// ReadFrame 从 buf 中取出一个完整的帧 数据不完整时返回 ErrIncompleteFrame 且不消费数据
+// 其他错误表示连接上的数据已经错乱 应关闭连接
+// body 来自 slicepool 使用完后可以 slicepool.PutBuffer 归还
+func ReadFrame(buf *zbuffer.CombinesBuffer) (Header, []byte, error) {
+	h, err := PeekHeader(buf)
+	if err == ErrShortHeader {
+		return h, nil, ErrIncompleteFrame
+	}
+	if err != nil {
+		return h, nil, err
+	}
+	if buf.LengthData() < h.FrameLen() {
+		return h, nil, ErrIncompleteFrame
+	}
+	buf.Discard(HeaderLen)
+	if h.BodyLen == 0 {
+		return h, nil, nil
+	}
+	return h, buf.PopData(int(h.BodyLen)), nil
+}
+
+// EncodeFrame 组装帧 header在第一个切片 body不拷贝 可直接用于 SendSafeZeroCopy
+// h.BodyLen 按body的总长度设置
+func EncodeFrame(h Header, body ...[]byte) [][]byte {
+	h.BodyLen = 0
+	for _, b := range body {
+		h.BodyLen += uint32(len(b))
+	}
+	ret := make([][]byte, 0, len(body)+1)
+	ret = append(ret, h.AppendTo(make([]byte, 0, HeaderLen)))
+	for _, b := range body {
+		if len(b) > 0 {
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
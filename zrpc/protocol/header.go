@@ -0,0 +1,186 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// zrpc 帧格式 所有字段小端序(与 zbuffer.CombinesBuffer.PeekInt 在x86/arm上的结果一致)
+/*
+0       2       4   5   6       8 (BYTE)
++-------+-------+---+---+-------+
+|     pakLen    |ver|flg|  cmd  |
++---------------+---+---+-------+   8
+|  serviceType  |    funcHash   |
++---------------+---------------+  16
+|            entityID           |
++-------------------------------+  24
+|            sequence           |
++-------------------------------+  32
+|                               |
+|              DATA             |
+|                               |
++-------------------------------+
+pakLen 不包含自身的4字节 即 HeaderLen-4+len(DATA)
+*/
+
+const (
+	Version1       = 1
+	CurrentVersion = Version1
+
+	HeaderLen   = 32
+	pakLenBytes = 4
+
+	// MaxFrameLen 单帧上限 超过时认为连接上的数据已经错乱
+	MaxFrameLen = 16 << 20
+)
+
+var (
+	ErrShortHeader        = errors.New("zrpc header: data not enough")
+	ErrUnsupportedVersion = errors.New("zrpc header: unsupported version")
+	ErrBadPakLen          = errors.New("zrpc header: pakLen out of range")
+)
+
+type Cmd uint16
+
+// 总线模式: 服务通过 CreateEntity/DestroyEntity 注册有状态实体 RegistService 注册无状态服务
+// gproxy 按 (serviceType, entityID) 转发 RemoteInvoke 响应按sequence送回调用方
+// delegate 流程: 调用方 BindDelegate 订阅 实体 BroadcastDelegate 发起广播
+// 持有订阅的一方(gproxy或直连的服务端)以 ExecuteDelegate 推送给每个订阅者
+// TransferEntity 实体的拥有者把实体转给另一条连接 DATA为目标连接拥有的实体(serviceType int32 + entityID int64)
+// DrainService 服务停机前通知gproxy 不再把无状态服务的新请求路由给本连接 已有实体的路由不变
+// QueryEntity 查询实体的拥有者 响应DATA为1字节 1表示本连接 2表示同一gproxy上的其他连接 0表示没有或在其他gproxy上
+// RevokeEntity gproxy通知拥有者实体已不再路由到本连接 单向 不同gproxy上并发创建同一实体时落败的一方收到
+// gproxy之间: 建立连接后先发 ProxyHello(EntityID为gproxy的serviceID DATA为共享密钥) 之后以 RouteDelta 同步路由表
+// 新增命令只能追加在末尾
+const (
+	BindDelegate Cmd = iota
+	RemoteInvoke
+
+	CreateEntity
+	DeclareDelegate
+	RegistService
+	ExecuteDelegate
+	BroadcastDelegate
+	UnbindDelegate
+	DestroyEntity
+	ProxyHello
+	RouteDelta
+	TransferEntity
+	DrainService
+	QueryEntity
+	RevokeEntity
+)
+
+func (c Cmd) String() string {
+	switch c {
+	case BindDelegate:
+		return "BindDelegate"
+	case RemoteInvoke:
+		return "RemoteInvoke"
+	case CreateEntity:
+		return "CreateEntity"
+	case DeclareDelegate:
+		return "DeclareDelegate"
+	case RegistService:
+		return "RegistService"
+	case ExecuteDelegate:
+		return "ExecuteDelegate"
+	case BroadcastDelegate:
+		return "BroadcastDelegate"
+	case UnbindDelegate:
+		return "UnbindDelegate"
+	case DestroyEntity:
+		return "DestroyEntity"
+	case ProxyHello:
+		return "ProxyHello"
+	case RouteDelta:
+		return "RouteDelta"
+	case TransferEntity:
+		return "TransferEntity"
+	case DrainService:
+		return "DrainService"
+	case QueryEntity:
+		return "QueryEntity"
+	case RevokeEntity:
+		return "RevokeEntity"
+	default:
+		return "Cmd(" + strconv.Itoa(int(c)) + ")"
+	}
+}
+
+type Flags uint8
+
+const (
+	FlagOneway     Flags = 1 << iota // 不需要响应
+	FlagResponse                     // 响应帧 sequence与请求相同
+	FlagError                        // 响应帧 DATA为错误信息
+	FlagCompressed                   // DATA已压缩
+)
+
+func (f Flags) Has(flag Flags) bool {
+	return f&flag != 0
+}
+
+type Header struct {
+	Version     uint8
+	Flags       Flags
+	Cmd         Cmd
+	ServiceType int32
+	FuncHash    int32
+	EntityID    int64
+	Sequence    uint64 // 请求id 响应帧用来匹配请求
+	BodyLen     uint32 // DATA的长度 由pakLen换算 不单独编码
+}
+
+// FrameLen 整帧长度 包含header
+func (h *Header) FrameLen() int {
+	return HeaderLen + int(h.BodyLen)
+}
+
+// Encode 写入 dst[:HeaderLen] Version为0时写入 CurrentVersion
+func (h *Header) Encode(dst []byte) {
+	_ = dst[HeaderLen-1]
+	version := h.Version
+	if version == 0 {
+		version = CurrentVersion
+	}
+	binary.LittleEndian.PutUint32(dst[0:], uint32(HeaderLen-pakLenBytes)+h.BodyLen)
+	dst[4] = version
+	dst[5] = byte(h.Flags)
+	binary.LittleEndian.PutUint16(dst[6:], uint16(h.Cmd))
+	binary.LittleEndian.PutUint32(dst[8:], uint32(h.ServiceType))
+	binary.LittleEndian.PutUint32(dst[12:], uint32(h.FuncHash))
+	binary.LittleEndian.PutUint64(dst[16:], uint64(h.EntityID))
+	binary.LittleEndian.PutUint64(dst[24:], h.Sequence)
+}
+
+func (h *Header) AppendTo(dst []byte) []byte {
+	var buf [HeaderLen]byte
+	h.Encode(buf[:])
+	return append(dst, buf[:]...)
+}
+
+// DecodeHeader 从 src 的开头解析header 不要求 src 包含完整的DATA
+func DecodeHeader(src []byte) (h Header, err error) {
+	if len(src) < HeaderLen {
+		return h, ErrShortHeader
+	}
+	pakLen := binary.LittleEndian.Uint32(src[0:])
+	if pakLen < HeaderLen-pakLenBytes || pakLen > MaxFrameLen-pakLenBytes {
+		return h, ErrBadPakLen
+	}
+	h.Version = src[4]
+	if h.Version != Version1 {
+		return h, ErrUnsupportedVersion
+	}
+	h.BodyLen = pakLen - (HeaderLen - pakLenBytes)
+	h.Flags = Flags(src[5])
+	h.Cmd = Cmd(binary.LittleEndian.Uint16(src[6:]))
+	h.ServiceType = int32(binary.LittleEndian.Uint32(src[8:]))
+	h.FuncHash = int32(binary.LittleEndian.Uint32(src[12:]))
+	h.EntityID = int64(binary.LittleEndian.Uint64(src[16:]))
+	h.Sequence = binary.LittleEndian.Uint64(src[24:])
+	return h, nil
+}
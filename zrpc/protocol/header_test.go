@@ -0,0 +1,129 @@
+package protocol
+
+import (
+	"bytes"
+	"github.com/jiangshuai341/zbus/zbuffer"
+	"testing"
+)
+
+func TestHeader_RoundTrip(t *testing.T) {
+	h := Header{
+		Flags:       FlagResponse | FlagError,
+		Cmd:         ExecuteDelegate,
+		ServiceType: -3,
+		FuncHash:    0x7abcdef1,
+		EntityID:    -1,
+		Sequence:    1<<63 + 5,
+		BodyLen:     11,
+	}
+	var buf [HeaderLen]byte
+	h.Encode(buf[:])
+	got, err := DecodeHeader(buf[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Version = CurrentVersion
+	if got != h {
+		t.Fatalf("got %+v want %+v", got, h)
+	}
+	// pakLen 不包含自身 与gproxy的 PeekInt(0,4) 约定一致
+	if buf[0] != HeaderLen-4+11 || buf[4] != Version1 {
+		t.Fatalf("unexpected wire bytes % x", buf[:8])
+	}
+}
+
+func TestDecodeHeader_Errors(t *testing.T) {
+	var buf [HeaderLen]byte
+	(&Header{}).Encode(buf[:])
+	if _, err := DecodeHeader(buf[:HeaderLen-1]); err != ErrShortHeader {
+		t.Fatalf("want ErrShortHeader got %v", err)
+	}
+	bad := buf
+	bad[4] = 2
+	if _, err := DecodeHeader(bad[:]); err != ErrUnsupportedVersion {
+		t.Fatalf("want ErrUnsupportedVersion got %v", err)
+	}
+	bad = buf
+	bad[0] = 3
+	if _, err := DecodeHeader(bad[:]); err != ErrBadPakLen {
+		t.Fatalf("want ErrBadPakLen got %v", err)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	frame := EncodeFrame(Header{Cmd: RemoteInvoke, Sequence: 9}, []byte("hello "), []byte("zrpc"))
+	var wire []byte
+	for _, b := range frame {
+		wire = append(wire, b...)
+	}
+
+	buf := zbuffer.NewCombinesBuffer(16)
+	// 按字节分散推入 header跨越多个切片
+	for i := 0; i < len(wire); i++ {
+		h, body, err := ReadFrame(buf)
+		if err != ErrIncompleteFrame {
+			t.Fatalf("partial frame at %d: %+v %q %v", i, h, body, err)
+		}
+		temp := [][]byte{{wire[i]}}
+		buf.PushsNoCopy(&temp)
+	}
+	h, body, err := ReadFrame(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Cmd != RemoteInvoke || h.Sequence != 9 || string(body) != "hello zrpc" || buf.LengthData() != 0 {
+		t.Fatalf("unexpected %+v %q left:%d", h, body, buf.LengthData())
+	}
+}
+
+func FuzzDecodeHeader(f *testing.F) {
+	var seed [HeaderLen]byte
+	(&Header{Cmd: CreateEntity, EntityID: 42, BodyLen: 3}).Encode(seed[:])
+	f.Add(seed[:])
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, err := DecodeHeader(data)
+		if err != nil {
+			return
+		}
+		// 能解析的header重新编码后应与原始字节一致
+		var buf [HeaderLen]byte
+		h.Encode(buf[:])
+		if !bytes.Equal(buf[:], data[:HeaderLen]) {
+			t.Fatalf("re-encode mismatch\n% x\n% x", buf[:], data[:HeaderLen])
+		}
+		split := len(data) / 2
+		vh, err := DecodeHeaderVec([][]byte{data[:split], data[split:]})
+		if err != nil || vh != h {
+			t.Fatalf("vec decode mismatch %+v %+v %v", vh, h, err)
+		}
+	})
+}
+
+func FuzzReadFrame(f *testing.F) {
+	frame := EncodeFrame(Header{Cmd: RemoteInvoke}, []byte("abc"))
+	f.Add(append(append([]byte(nil), frame[0]...), frame[1]...), 5)
+	f.Fuzz(func(t *testing.T, data []byte, chunk int) {
+		if chunk <= 0 {
+			chunk = 1
+		}
+		buf := zbuffer.NewCombinesBuffer(64)
+		for i := 0; i < len(data); i += chunk {
+			end := i + chunk
+			if end > len(data) {
+				end = len(data)
+			}
+			temp := [][]byte{data[i:end]}
+			buf.PushsNoCopy(&temp)
+		}
+		for {
+			h, body, err := ReadFrame(buf)
+			if err != nil {
+				return
+			}
+			if len(body) != int(h.BodyLen) {
+				t.Fatalf("body len %d want %d", len(body), h.BodyLen)
+			}
+		}
+	})
+}
@@ -1,39 +1,33 @@
 package zrpc
 
-import "github.com/jiangshuai341/zbus/zpool/coroutinepool"
+import "github.com/jiangshuai341/zbus/zrpc/protocol"
 
-type Cmd int32
+// Cmd 帧格式与编解码见 zrpc/protocol
+type Cmd = protocol.Cmd
 
 const (
-	BindDelegate Cmd = iota
-	RemoteInvoke
-
-	CreateEntity
-	DeclareDelegate
-	RegistService
-	ExecuteDelegate
-	BroadcastDelegate
+	BindDelegate = protocol.BindDelegate
+	RemoteInvoke = protocol.RemoteInvoke
+
+	CreateEntity      = protocol.CreateEntity
+	DeclareDelegate   = protocol.DeclareDelegate
+	RegistService     = protocol.RegistService
+	ExecuteDelegate   = protocol.ExecuteDelegate
+	BroadcastDelegate = protocol.BroadcastDelegate
+	UnbindDelegate    = protocol.UnbindDelegate
+	DestroyEntity     = protocol.DestroyEntity
+	ProxyHello        = protocol.ProxyHello
+	RouteDelta        = protocol.RouteDelta
+	TransferEntity    = protocol.TransferEntity
+	DrainService      = protocol.DrainService
+	QueryEntity       = protocol.QueryEntity
+	RevokeEntity      = protocol.RevokeEntity
 )
 
+// NetDriver 发送帧的连接 *reactor.Connection 实现了该接口
 type NetDriver interface {
-}
-
-func (r *RpcProcessor) RemoteInvokeSync(hashKey int64, fun int32, req []byte, resp *[]byte, driver NetDriver) {
-
-}
-func (r *RpcProcessor) RemoteInvokeAsync(hashKey int64, fun int32, req []byte, resp *[]byte, driver NetDriver, callback func(bytes []byte, err error)) {
-
-}
-func (r *RpcProcessor) ParseMsg(hashKey int64, fun int32, req []byte, resp *[]byte, driver NetDriver) {
-
-}
-
-type RpcProcessor struct {
-	routinePool coroutinepool.RoutinePool
-	driver      NetDriver
-}
-
-func testA() {
-
-	//routinePool := coroutinepool.New()
+	// SendSafeZeroCopy 线程安全 data 发送完成前不能修改
+	SendSafeZeroCopy(data ...[]byte) error
+	// Close 非线程安全 只在IO线程中(OnTraffic内)调用
+	Close()
 }
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"github.com/jiangshuai341/zbus/zpool/slicepool"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/protocol"
+	"sync"
+)
+
+// Broadcaster 实体推送delegate的方式
+// 调用方直连服务时使用 *Server 由服务端自己扇出
+// 经gproxy转发时使用 NewProxyBroadcaster 由gproxy扇出
+type Broadcaster interface {
+	Broadcast(serviceType int32, funcHash int32, entityID int64, arg []byte) error
+}
+
+type proxyBroadcaster struct {
+	driver zrpc.NetDriver
+}
+
+// NewProxyBroadcaster 把广播交给 driver 另一端的gproxy
+func NewProxyBroadcaster(driver zrpc.NetDriver) Broadcaster {
+	return proxyBroadcaster{driver: driver}
+}
+
+func (b proxyBroadcaster) Broadcast(serviceType int32, funcHash int32, entityID int64, arg []byte) error {
+	h := protocol.Header{
+		Flags:       protocol.FlagOneway,
+		Cmd:         zrpc.BroadcastDelegate,
+		ServiceType: serviceType,
+		FuncHash:    funcHash,
+		EntityID:    entityID,
+	}
+	return b.driver.SendSafeZeroCopy(protocol.EncodeFrame(h, arg)...)
+}
+
+type delegateKey struct {
+	serviceType int32
+	funcHash    int32
+	entityID    int64
+}
+
+// DelegateHub 记录每个delegate的订阅连接 广播时扇出
+// 连接断开时必须调用 RemoveSubscriber 否则会一直向已关闭的连接推送
+type DelegateHub struct {
+	mu    sync.RWMutex
+	subs  map[delegateKey]map[zrpc.NetDriver]struct{}
+	bySub map[zrpc.NetDriver]map[delegateKey]struct{} // 断开时按连接清理
+}
+
+func NewDelegateHub() *DelegateHub {
+	return &DelegateHub{
+		subs:  make(map[delegateKey]map[zrpc.NetDriver]struct{}),
+		bySub: make(map[zrpc.NetDriver]map[delegateKey]struct{}),
+	}
+}
+
+// Bind 重复订阅只记录一次
+func (h *DelegateHub) Bind(serviceType int32, funcHash int32, entityID int64, sub zrpc.NetDriver) {
+	key := delegateKey{serviceType, funcHash, entityID}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs := h.subs[key]
+	if subs == nil {
+		subs = make(map[zrpc.NetDriver]struct{})
+		h.subs[key] = subs
+	}
+	subs[sub] = struct{}{}
+	keys := h.bySub[sub]
+	if keys == nil {
+		keys = make(map[delegateKey]struct{})
+		h.bySub[sub] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func (h *DelegateHub) Unbind(serviceType int32, funcHash int32, entityID int64, sub zrpc.NetDriver) {
+	key := delegateKey{serviceType, funcHash, entityID}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unbind(key, sub)
+	if keys := h.bySub[sub]; keys != nil {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(h.bySub, sub)
+		}
+	}
+}
+
+func (h *DelegateHub) unbind(key delegateKey, sub zrpc.NetDriver) {
+	if subs := h.subs[key]; subs != nil {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(h.subs, key)
+		}
+	}
+}
+
+// RemoveSubscriber 连接断开时清理它的所有订阅 返回清理的数量
+func (h *DelegateHub) RemoveSubscriber(sub zrpc.NetDriver) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := h.bySub[sub]
+	for key := range keys {
+		h.unbind(key, sub)
+	}
+	delete(h.bySub, sub)
+	return len(keys)
+}
+
+// Subscribers 订阅该delegate的连接数
+func (h *DelegateHub) Subscribers(serviceType int32, funcHash int32, entityID int64) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs[delegateKey{serviceType, funcHash, entityID}])
+}
+
+// Broadcast 以 ExecuteDelegate 推送给所有订阅者 单个连接发送失败不影响其他连接
+// 连接发送完成后会把切片归还 slicepool 所以每个连接使用独立的拷贝
+func (h *DelegateHub) Broadcast(serviceType int32, funcHash int32, entityID int64, arg []byte) error {
+	key := delegateKey{serviceType, funcHash, entityID}
+	h.mu.RLock()
+	subs := make([]zrpc.NetDriver, 0, len(h.subs[key]))
+	for sub := range h.subs[key] {
+		subs = append(subs, sub)
+	}
+	h.mu.RUnlock()
+	if len(subs) == 0 {
+		return nil
+	}
+
+	header := protocol.Header{
+		Flags:       protocol.FlagOneway,
+		Cmd:         zrpc.ExecuteDelegate,
+		ServiceType: serviceType,
+		FuncHash:    funcHash,
+		EntityID:    entityID,
+		BodyLen:     uint32(len(arg)),
+	}
+	for _, sub := range subs {
+		frame := slicepool.GetBuffer2(header.FrameLen())
+		header.Encode(frame)
+		copy(frame[protocol.HeaderLen:], arg)
+		if err := sub.SendSafeZeroCopy(frame); err != nil {
+			log.Warnf("[zrpc delegate] push service:%d func:%d entity:%d failed err:%s", serviceType, funcHash, entityID, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/jiangshuai341/zbus/zbuffer"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/epoll"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/client"
+	"github.com/jiangshuai341/zbus/zrpc/protocol"
+	"sync"
+)
+
+// ProxyConn 总线模式下服务到gproxy的连接
+// gproxy转发来的请求由 Server 处理 同时可以作为 client.Client 经gproxy调用其他服务
+type ProxyConn struct {
+	*client.Client
+	server *Server
+	driver zrpc.NetDriver
+	close  func()
+
+	mu       sync.Mutex
+	onRevoke func(serviceType int32, entityID int64)
+}
+
+var _ Broadcaster = (*ProxyConn)(nil)
+
+// ConnectProxy 连接gproxy 连接断开后需要重新连接并重新注册实体
+func (s *Server) ConnectProxy(r *reactor.Reactor, url string) (*ProxyConn, error) {
+	conn := reactor.Dial(url)
+	if conn == nil {
+		return nil, client.ErrDial
+	}
+	p := s.NewProxyConn(conn)
+	p.close = func() {
+		_ = r.DoTaskInIoThread(func(*epoll.Epoller) {
+			conn.Close()
+		})
+	}
+	conn.INetHandle = p
+	if err := r.AddConn(conn); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewProxyConn driver 的数据需要交给返回值的 OnTraffic/OnClose
+func (s *Server) NewProxyConn(driver zrpc.NetDriver) *ProxyConn {
+	return &ProxyConn{
+		Client: client.NewClient(driver),
+		server: s,
+		driver: driver,
+		close:  driver.Close,
+	}
+}
+
+// Close 线程安全 gproxy会清理本连接注册的实体和服务
+func (p *ProxyConn) Close() {
+	p.close()
+}
+
+// RegistService 注册无状态服务 gproxy按entityID在同类实例间做一致性哈希
+func (p *ProxyConn) RegistService(ctx context.Context, serviceType int32) error {
+	_, err := p.Invoke(ctx, zrpc.RegistService, serviceType, 0, 0, nil)
+	return err
+}
+
+// CreateEntity 声明本服务拥有该实体 实体已被其他连接拥有时返回错误
+func (p *ProxyConn) CreateEntity(ctx context.Context, serviceType int32, entityID int64) error {
+	_, err := p.Invoke(ctx, zrpc.CreateEntity, serviceType, 0, entityID, nil)
+	return err
+}
+
+func (p *ProxyConn) DestroyEntity(ctx context.Context, serviceType int32, entityID int64) error {
+	_, err := p.Invoke(ctx, zrpc.DestroyEntity, serviceType, 0, entityID, nil)
+	return err
+}
+
+// TransferEntity 把本服务拥有的实体转给拥有 (toServiceType, toEntityID) 的连接 用于实体迁移
+// 两条连接需要连在同一个gproxy上
+func (p *ProxyConn) TransferEntity(ctx context.Context, serviceType int32, entityID int64, toServiceType int32, toEntityID int64) error {
+	var target [12]byte
+	binary.LittleEndian.PutUint32(target[:], uint32(toServiceType))
+	binary.LittleEndian.PutUint64(target[4:], uint64(toEntityID))
+	_, err := p.Invoke(ctx, zrpc.TransferEntity, serviceType, 0, entityID, target[:])
+	return err
+}
+
+// OwnsEntity 实体当前是否由本连接拥有
+func (p *ProxyConn) OwnsEntity(ctx context.Context, serviceType int32, entityID int64) (bool, error) {
+	resp, err := p.Invoke(ctx, zrpc.QueryEntity, serviceType, 0, entityID, nil)
+	if err != nil {
+		return false, err
+	}
+	return len(resp) == 1 && resp[0] == 1, nil
+}
+
+// SetRevokeCallback gproxy收回本连接拥有的实体时回调 在IO线程中执行 不要阻塞
+// 其他gproxy上并发创建了同一实体并且胜出时发生 之后该实体不再路由到本连接 entity.Manager 会设置该回调
+func (p *ProxyConn) SetRevokeCallback(fn func(serviceType int32, entityID int64)) {
+	p.mu.Lock()
+	p.onRevoke = fn
+	p.mu.Unlock()
+}
+
+// LocalEntity 实体是否由连在同一gproxy上的连接拥有 包括本连接 迁移的目标需要满足
+func (p *ProxyConn) LocalEntity(ctx context.Context, serviceType int32, entityID int64) (bool, error) {
+	resp, err := p.Invoke(ctx, zrpc.QueryEntity, serviceType, 0, entityID, nil)
+	if err != nil {
+		return false, err
+	}
+	return len(resp) == 1 && resp[0] != 0, nil
+}
+
+// Drain 停机前调用 gproxy把本连接移出所有无状态服务的哈希环 新请求路由到其他实例
+// 本连接拥有的实体仍然路由到这里 需要由服务迁移或销毁 之后不能再 RegistService
+// 同时使用服务发现时还需调用 discovery.Backend.Drain 发布draining状态
+func (p *ProxyConn) Drain(ctx context.Context) error {
+	_, err := p.Invoke(ctx, zrpc.DrainService, 0, 0, 0, nil)
+	return err
+}
+
+// Broadcast 交给gproxy扇出
+func (p *ProxyConn) Broadcast(serviceType int32, funcHash int32, entityID int64, arg []byte) error {
+	return proxyBroadcaster{driver: p.driver}.Broadcast(serviceType, funcHash, entityID, arg)
+}
+
+// OnTraffic 响应和delegate推送交给client 其余交给server
+func (p *ProxyConn) OnTraffic(inboundBuffer *zbuffer.CombinesBuffer) {
+	for {
+		h, body, err := protocol.ReadFrame(inboundBuffer)
+		if err == protocol.ErrIncompleteFrame {
+			return
+		}
+		if err != nil {
+			log.Errorf("[zrpc proxy conn] bad frame err:%s", err.Error())
+			p.driver.Close()
+			return
+		}
+		if h.Flags.Has(protocol.FlagResponse) || h.Cmd == zrpc.ExecuteDelegate {
+			p.Client.Dispatch(h, body)
+			continue
+		}
+		if h.Cmd == zrpc.RevokeEntity {
+			p.mu.Lock()
+			fn := p.onRevoke
+			p.mu.Unlock()
+			log.Warnf("[zrpc proxy conn] entity service:%d id:%d revoked by gproxy", h.ServiceType, h.EntityID)
+			if fn != nil {
+				fn(h.ServiceType, h.EntityID)
+			}
+			continue
+		}
+		p.server.handleFrame(h, body, p.driver)
+	}
+}
+
+func (p *ProxyConn) OnClose() {
+	p.server.delegates.RemoveSubscriber(p.driver)
+	p.Client.OnClose()
+}
@@ -1,47 +1,310 @@
 package server
 
-/*
-0       2       4       6       8 (BYTE)
-+-------+-------+-------+-------+
-|     pakLen    |       cmd     |
-+---------------+---------------+   8
-|  serviceType  |    funcHash   |
-+---------------+---------------+  16
-|            entityID           |
-+---------------+---------------+  24
-|                               |
-|              DATA             |
-|                               |
-+-------------------------------+
-*/
+import (
+	"errors"
+	"fmt"
+	"github.com/jiangshuai341/zbus/logger"
+	"github.com/jiangshuai341/zbus/zbuffer"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zpool/coroutinepool"
+	"github.com/jiangshuai341/zbus/zpool/slicepool"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/protocol"
+	"runtime/debug"
+	"sync"
+)
 
-func init() {
+// 帧格式见 zrpc/protocol
+
+var log = logger.GetLogger("zrpc")
+
+var (
+	ErrDuplicateHandler = errors.New("zrpc server: handler already registered")
+	ErrUnknownFunc      = errors.New("zrpc server: unknown function")
+	ErrEntityNotFound   = errors.New("zrpc server: entity not found")
+	ErrServerBusy       = errors.New("zrpc server: server busy")
+)
+
+// Handler 返回的错误会作为 FlagError 响应发回调用方
+// arg 来自 slicepool handler 返回后不能再持有 需要保留时拷贝一份
+// 返回的 resp 所有权交给连接 以零拷贝方式发送后归还 slicepool 不能返回之后还会使用的切片
+type Handler func(entityID int64, arg []byte) ([]byte, error)
+
+// ServiceHandler 处理一个服务的所有方法 用于需要在分发前拦截调用的场景 如实体管理
+type ServiceHandler func(funcHash int32, entityID int64, arg []byte) ([]byte, error)
+
+// AsyncServiceHandler 同 ServiceHandler 结果通过 done 返回 可以在返回之后、在其他协程中调用 done
+// 用于需要暂存调用而不占用executor协程的场景 如迁移中冻结的实体
+// done 恰好调用一次 arg 在调用 done 之前有效 handler panic 时以错误响应 panic 之前不能已经调用 done
+type AsyncServiceHandler func(funcHash int32, entityID int64, arg []byte, done func(resp []byte, err error))
+
+// Executor 执行handler的调度器 决定handler在哪个协程执行
+// 返回错误表示 fn 不会执行 调用方收到 ErrServerBusy
+type Executor interface {
+	Execute(entityID int64, fn func()) error
 }
 
-func ConnectToProxy() {
-	//zrpccommon.RegisterServiceToETCD()
+// InlineExecutor 直接在IO线程中执行 只适合不阻塞的handler
+type InlineExecutor struct{}
+
+func (InlineExecutor) Execute(_ int64, fn func()) error {
+	fn()
+	return nil
 }
 
-func CreateEntity(serviceType int32, key int32, entityID int64, imp any) {
+// KeyedExecutor 同一实体的调用串行执行 不同实体并发执行
+// entityID 为0的调用(无状态服务)不串行 由任意空闲worker执行
+type KeyedExecutor struct {
+	*coroutinepool.KeyedExecutor
+}
 
+func NewKeyedExecutor(workerNum int) KeyedExecutor {
+	return KeyedExecutor{coroutinepool.NewKeyedExecutor(workerNum, 0, nil)}
 }
 
-func DeclareDelegate(serviceType int32, funcHashVal int32, entityID int64) {
+// Execute 队列已满或已关闭时返回 coroutinepool.ErrMailboxFull/ErrPoolClosed
+func (e KeyedExecutor) Execute(entityID int64, fn func()) error {
+	if entityID == 0 {
+		return e.Submit(fn)
+	}
+	return e.SubmitKeyed(entityID, fn)
+}
 
+type handlerKey struct {
+	serviceType int32
+	funcHash    int32
 }
 
-func RegistService(serviceType int32, funcHashVal int32, entityID int64) {
+type handlerEntry struct {
+	name    string
+	handler func(entityID int64, arg []byte, done func(resp []byte, err error))
+}
 
+// Server 按 (serviceType, funcHash) 分发请求
+type Server struct {
+	mu        sync.RWMutex
+	handlers  map[handlerKey]handlerEntry
+	services  map[int32]AsyncServiceHandler
+	executor  Executor
+	delegates *DelegateHub
 }
 
-func ExecuteSync(serviceType int32, funcHash int32, entityID int64, arg []byte) {
+// NewServer executor 为nil时使用 InlineExecutor
+func NewServer(executor Executor) *Server {
+	if executor == nil {
+		executor = InlineExecutor{}
+	}
+	return &Server{
+		handlers:  make(map[handlerKey]handlerEntry),
+		services:  make(map[int32]AsyncServiceHandler),
+		executor:  executor,
+		delegates: NewDelegateHub(),
+	}
+}
 
+// Register 以服务名和方法名注册 serviceType/funcHash 见 zrpc.ServiceType zrpc.FuncHash
+func (s *Server) Register(service string, method string, handler Handler) error {
+	return s.register(zrpc.ServiceType(service), zrpc.FuncHash(service, method), service+"."+method, handler)
 }
 
-func ExecuteAsync(serviceType int32, funcHash int32, entityID int64, arg []byte, callback func([]byte, error)) {
+// RegisterHash 直接以哈希值注册 供生成代码使用
+func (s *Server) RegisterHash(serviceType int32, funcHash int32, handler Handler) error {
+	return s.register(serviceType, funcHash, fmt.Sprintf("%d.%d", serviceType, funcHash), handler)
+}
 
+func (s *Server) register(serviceType int32, funcHash int32, name string, handler Handler) error {
+	key := handlerKey{serviceType, funcHash}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if exist, ok := s.handlers[key]; ok {
+		return fmt.Errorf("%w: %s conflicts with %s", ErrDuplicateHandler, name, exist.name)
+	}
+	s.handlers[key] = handlerEntry{
+		name: name,
+		handler: func(entityID int64, arg []byte, done func(resp []byte, err error)) {
+			done(handler(entityID, arg))
+		},
+	}
+	return nil
 }
 
-func Broadcast(serviceType int32, funcHash int32, entityID int64, arg []byte, callback func([]byte, error)) {
+func (s *Server) Unregister(serviceType int32, funcHash int32) {
+	s.mu.Lock()
+	delete(s.handlers, handlerKey{serviceType, funcHash})
+	s.mu.Unlock()
+}
+
+// RegisterService 该服务中没有按funcHash单独注册的方法都交给 handler
+func (s *Server) RegisterService(serviceType int32, handler ServiceHandler) error {
+	return s.RegisterAsyncService(serviceType, func(funcHash int32, entityID int64, arg []byte, done func(resp []byte, err error)) {
+		done(handler(funcHash, entityID, arg))
+	})
+}
+
+// RegisterAsyncService 同 RegisterService handler 异步返回结果
+func (s *Server) RegisterAsyncService(serviceType int32, handler AsyncServiceHandler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.services[serviceType]; ok {
+		return fmt.Errorf("%w: service %d", ErrDuplicateHandler, serviceType)
+	}
+	s.services[serviceType] = handler
+	return nil
+}
+
+func (s *Server) UnregisterService(serviceType int32) {
+	s.mu.Lock()
+	delete(s.services, serviceType)
+	s.mu.Unlock()
+}
+
+func (s *Server) lookup(serviceType int32, funcHash int32) (handlerEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if h, ok := s.handlers[handlerKey{serviceType, funcHash}]; ok {
+		return h, true
+	}
+	service, ok := s.services[serviceType]
+	if !ok {
+		return handlerEntry{}, false
+	}
+	return handlerEntry{
+		name: fmt.Sprintf("%d.%d", serviceType, funcHash),
+		handler: func(entityID int64, arg []byte, done func(resp []byte, err error)) {
+			service(funcHash, entityID, arg, done)
+		},
+	}, true
+}
+
+// Listen 监听 url 新连接加入 r 管理
+func (s *Server) Listen(r *reactor.Reactor, url string) (*reactor.Accepter, error) {
+	a, err := reactor.NewListener(&accepter{server: s, reactor: r})
+	if err != nil {
+		return nil, err
+	}
+	if err = a.ListenUrl(url); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+type accepter struct {
+	server  *Server
+	reactor *reactor.Reactor
+}
+
+func (a *accepter) OnAccept(conn *reactor.Connection) {
+	conn.INetHandle = a.server.NewConnHandler(conn)
+	if err := a.reactor.AddConn(conn); err != nil {
+		log.Errorf("[zrpc server] add conn failed err:%s", err.Error())
+	}
+}
+
+// NewConnHandler 处理一条连接上的请求 响应通过 driver 发回
+func (s *Server) NewConnHandler(driver zrpc.NetDriver) reactor.INetHandle {
+	return &connHandler{server: s, driver: driver}
+}
+
+type connHandler struct {
+	server *Server
+	driver zrpc.NetDriver
+}
+
+func (c *connHandler) OnTraffic(inboundBuffer *zbuffer.CombinesBuffer) {
+	for {
+		h, body, err := protocol.ReadFrame(inboundBuffer)
+		if err == protocol.ErrIncompleteFrame {
+			return
+		}
+		if err != nil {
+			log.Errorf("[zrpc server] bad frame err:%s", err.Error())
+			c.driver.Close()
+			return
+		}
+		c.server.handleFrame(h, body, c.driver)
+	}
+}
+
+// handleFrame 处理调用方发来的请求和订阅
+func (s *Server) handleFrame(h protocol.Header, body []byte, driver zrpc.NetDriver) {
+	if h.Flags.Has(protocol.FlagResponse) {
+		log.Warnf("[zrpc server] unexpected response cmd:%s seq:%d", h.Cmd, h.Sequence)
+		return
+	}
+	switch h.Cmd {
+	case zrpc.RemoteInvoke:
+		s.Dispatch(h, body, driver)
+	case zrpc.BindDelegate:
+		s.delegates.Bind(h.ServiceType, h.FuncHash, h.EntityID, driver)
+	case zrpc.UnbindDelegate:
+		s.delegates.Unbind(h.ServiceType, h.FuncHash, h.EntityID, driver)
+	default:
+		log.Warnf("[zrpc server] unexpected cmd:%s seq:%d", h.Cmd, h.Sequence)
+	}
+}
+
+func (c *connHandler) OnClose() {
+	c.server.delegates.RemoveSubscriber(c.driver)
+}
+
+// Broadcast 推送给直连本服务并订阅了该delegate的连接 实现 Broadcaster
+func (s *Server) Broadcast(serviceType int32, funcHash int32, entityID int64, arg []byte) error {
+	return s.delegates.Broadcast(serviceType, funcHash, entityID, arg)
+}
+
+// Delegates 订阅表 供测试和监控使用
+func (s *Server) Delegates() *DelegateHub {
+	return s.delegates
+}
+
+// Dispatch 在executor中执行handler 并把结果以原请求的sequence发回
+// 未注册的函数、handler panic 和executor拒绝执行都会以错误响应返回 单向调用不响应
+func (s *Server) Dispatch(h protocol.Header, body []byte, driver zrpc.NetDriver) {
+	entry, ok := s.lookup(h.ServiceType, h.FuncHash)
+	if !ok {
+		log.Warnf("[zrpc server] unknown function service:%d func:%d", h.ServiceType, h.FuncHash)
+		reply(h, nil, fmt.Errorf("%w service:%d func:%d", ErrUnknownFunc, h.ServiceType, h.FuncHash), driver)
+		return
+	}
+	err := s.executor.Execute(h.EntityID, func() {
+		invoke(entry, h.EntityID, body, func(resp []byte, err error) {
+			reply(h, resp, err, driver)
+		})
+	})
+	if err != nil {
+		log.Errorf("[zrpc server] submit %s entity:%d failed err:%s", entry.name, h.EntityID, err.Error())
+		slicepool.PutBuffer(body)
+		reply(h, nil, fmt.Errorf("%w: %s", ErrServerBusy, err.Error()), driver)
+	}
+}
+
+func invoke(entry handlerEntry, entityID int64, arg []byte, done func(resp []byte, err error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("[zrpc server] %s entity:%d panic:%v\n%s", entry.name, entityID, r, debug.Stack())
+			done(nil, fmt.Errorf("%s panic: %v", entry.name, r))
+		}
+	}()
+	entry.handler(entityID, arg, done)
+}
 
+func reply(req protocol.Header, resp []byte, err error, driver zrpc.NetDriver) {
+	if req.Flags.Has(protocol.FlagOneway) {
+		return
+	}
+	h := protocol.Header{
+		Flags:       protocol.FlagResponse,
+		Cmd:         req.Cmd,
+		ServiceType: req.ServiceType,
+		FuncHash:    req.FuncHash,
+		EntityID:    req.EntityID,
+		Sequence:    req.Sequence,
+	}
+	if err != nil {
+		h.Flags |= protocol.FlagError
+		resp = []byte(err.Error())
+	}
+	if sendErr := driver.SendSafeZeroCopy(protocol.EncodeFrame(h, resp)...); sendErr != nil {
+		log.Errorf("[zrpc server] reply seq:%d failed err:%s", req.Sequence, sendErr.Error())
+	}
 }
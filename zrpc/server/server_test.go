@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/jiangshuai341/zbus/zbuffer"
+	"github.com/jiangshuai341/zbus/znet/tcp-linux/reactor"
+	"github.com/jiangshuai341/zbus/zrpc"
+	"github.com/jiangshuai341/zbus/zrpc/client"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipe 把客户端和服务端的handler直接连在一起
+type pipe struct {
+	mu     sync.Mutex
+	in     *zbuffer.CombinesBuffer
+	handle reactor.INetHandle
+}
+
+func (p *pipe) SendSafeZeroCopy(data ...[]byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.in.PushsNoCopy(&data)
+	p.handle.OnTraffic(p.in)
+	return nil
+}
+
+func (p *pipe) Close() {
+	p.handle.OnClose()
+}
+
+// connect 返回客户端和服务端的连接handler 断开连接时两端都需要 OnClose
+func connect(s *Server) (*client.Client, reactor.INetHandle) {
+	toServer := &pipe{in: zbuffer.NewCombinesBuffer(64)}
+	toClient := &pipe{in: zbuffer.NewCombinesBuffer(64)}
+	c := client.NewClient(toServer)
+	h := s.NewConnHandler(toClient)
+	toServer.handle, toClient.handle = h, c
+	return c, h
+}
+
+func TestDispatch(t *testing.T) {
+	s := NewServer(NewKeyedExecutor(2))
+	if err := s.Register("Lobby", "Echo", func(entityID int64, arg []byte) ([]byte, error) {
+		return append([]byte(strings.Repeat("x", int(entityID))), arg...), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Register("Lobby", "Fail", func(int64, []byte) ([]byte, error) { return nil, errors.New("boom") })
+	_ = s.Register("Lobby", "Panic", func(int64, []byte) ([]byte, error) { panic("oops") })
+	if err := s.Register("Lobby", "Echo", nil); !errors.Is(err, ErrDuplicateHandler) {
+		t.Fatalf("want ErrDuplicateHandler got %v", err)
+	}
+
+	c, _ := connect(s)
+	ctx := context.Background()
+	lobby := zrpc.ServiceType("Lobby")
+	resp, err := c.RemoteInvokeSync(ctx, lobby, zrpc.FuncHash("Lobby", "Echo"), 2, []byte("hi"))
+	if err != nil || string(resp) != "xxhi" {
+		t.Fatalf("echo got %q %v", resp, err)
+	}
+
+	var remote *client.RemoteError
+	for method, want := range map[string]string{"Fail": "boom", "Panic": "panic", "Missing": "unknown function"} {
+		_, err = c.RemoteInvokeSync(ctx, lobby, zrpc.FuncHash("Lobby", method), 1, nil)
+		if !errors.As(err, &remote) || !strings.Contains(remote.Msg, want) {
+			t.Errorf("%s: want error containing %q got %v", method, want, err)
+		}
+	}
+}
+
+// executor拒绝执行时立即回复错误 调用方不需要等到超时
+func TestDispatch_Busy(t *testing.T) {
+	executor := NewKeyedExecutor(1)
+	s := NewServer(executor)
+	_ = s.Register("Lobby", "Echo", func(entityID int64, arg []byte) ([]byte, error) { return arg, nil })
+	executor.Close()
+
+	c, _ := connect(s)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var remote *client.RemoteError
+	_, err := c.RemoteInvokeSync(ctx, zrpc.ServiceType("Lobby"), zrpc.FuncHash("Lobby", "Echo"), 1, nil)
+	if !errors.As(err, &remote) || !strings.Contains(remote.Msg, ErrServerBusy.Error()) {
+		t.Fatalf("want ErrServerBusy got %v", err)
+	}
+}
+
+// 无状态服务的调用(entityID为0)并发执行 不会挤在同一个key上串行
+func TestDispatch_StatelessParallel(t *testing.T) {
+	const n = 2
+	s := NewServer(NewKeyedExecutor(n))
+	var wg sync.WaitGroup
+	wg.Add(n)
+	started := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(started)
+	}()
+	_ = s.Register("Login", "Auth", func(entityID int64, arg []byte) ([]byte, error) {
+		wg.Done()
+		select {
+		case <-started:
+			return arg, nil
+		case <-time.After(time.Second):
+			return nil, errors.New("calls serialized")
+		}
+	})
+
+	c, _ := connect(s)
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		c.RemoteInvokeAsync(context.Background(), zrpc.ServiceType("Login"), zrpc.FuncHash("Login", "Auth"), 0, nil, func(resp []byte, err error) {
+			errs <- err
+		})
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDelegate_FanOutAndCleanup(t *testing.T) {
+	s := NewServer(nil)
+	st, fh := zrpc.ServiceType("Lobby"), zrpc.FuncHash("Lobby", "HpChange")
+	c1, h1 := connect(s)
+	c2, _ := connect(s)
+
+	var got1, got2 []string
+	if err := c1.BindDelegate(st, fh, 7, func(arg []byte, err error) {
+		if err == nil {
+			got1 = append(got1, string(arg))
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_ = c2.BindDelegate(st, fh, 7, func(arg []byte, err error) { got2 = append(got2, string(arg)) })
+	_ = c2.BindDelegate(st, fh, 8, func(arg []byte, err error) { t.Error("entity 8 should not receive entity 7's broadcast") })
+	if n := s.Delegates().Subscribers(st, fh, 7); n != 2 {
+		t.Fatalf("want 2 subscribers got %d", n)
+	}
+
+	_ = s.Broadcast(st, fh, 7, []byte("hp=90"))
+	_ = c2.UnbindDelegate(st, fh, 7)
+	_ = s.Broadcast(st, fh, 7, []byte("hp=80"))
+	if strings.Join(got1, ",") != "hp=90,hp=80" || strings.Join(got2, ",") != "hp=90" {
+		t.Fatalf("got1=%v got2=%v", got1, got2)
+	}
+
+	// 连接断开后订阅被清理
+	h1.OnClose()
+	c1.OnClose()
+	if n := s.Delegates().Subscribers(st, fh, 7); n != 0 {
+		t.Fatalf("want 0 subscribers after disconnect got %d", n)
+	}
+	if n := s.Delegates().Subscribers(st, fh, 8); n != 1 {
+		t.Fatalf("want entity 8 still subscribed got %d", n)
+	}
+}
+
+func TestFuncHash_Deterministic(t *testing.T) {
+	// 哈希算法变化会导致新旧版本无法互通
+	if zrpc.FuncHash("LobbyPlayerService", "Store") != 651452558 {
+		t.Fatalf("FuncHash changed: %d", zrpc.FuncHash("LobbyPlayerService", "Store"))
+	}
+}